@@ -6,8 +6,15 @@ const ApplicationVersion = "0.2 beta"
 const Version = byte(0x00)
 const AddressChecksumLen = 4
 
-const PaymentForBlockMade = 10
+// All amounts are int64 numbers of smallest units. One coin has CoinUnits of smallest units
+const CoinUnits = 100000000
+const CoinDecimals = 8
 
-const InitialNodesList = "http://democoin.NlaakStudios.com/initialnodes.json"
+// Layout of a serialized block header. Version, prev block hash, Merkle root, timestamp, bits and nonce
+// Numbers have 8 bytes, hashes have 32 bytes. Hash of a block is the hash of its header
+const BlockHeaderNumberLength = 8
+const BlockHeaderHashLength = 32
+const BlockHeaderLength = 4*BlockHeaderNumberLength + 2*BlockHeaderHashLength
+const BlockHeaderMerkleRootOffset = BlockHeaderNumberLength + BlockHeaderHashLength
 
-const SmallestUnit = 0.00000001
+const InitialNodesList = "http://democoin.NlaakStudios.com/initialnodes.json"
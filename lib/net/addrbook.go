@@ -0,0 +1,437 @@
+package net
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	mrand "math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Address book of other nodes. Addresses are kept in 2 groups of buckets:
+// new - addresses received from other nodes and not checked yet
+// tried - addresses of nodes this node was connected to successfully
+// A bucket of an address depends on a network group of the address (and a group of a source
+// for new addresses). So, one network or one source can not fill all the book
+const AddrNewBucketsCount = 64
+const AddrTriedBucketsCount = 16
+const AddrBucketSize = 64
+
+// Number of buckets addresses from one source group can be placed to
+const addrNewBucketsPerSource = 8
+
+// Number of tried buckets addresses of one network group can be placed to
+const addrTriedBucketsPerGroup = 4
+
+// Address is not used if connection failed so many times since last success
+const AddrMaxFailures = 3
+
+// Address which was not seen during this time is not used and is removed first
+const AddrHorizon = 30 * 24 * 60 * 60 // seconds
+
+// Last seen time is saved not often than this
+const AddrSeenSaveInterval = 20 * 60 // seconds
+
+// Max number of addresses sent in one addr command
+const AddrGossipMax = 1000
+
+// Address of other node with a history of connections to it
+type KnownAddress struct {
+	Addr        NodeAddr
+	Source      string // group of a host the address was received from. Empty if added by a user
+	Tried       bool
+	LastSeen    int64 // unix time
+	LastAttempt int64
+	LastSuccess int64
+	Attempts    int // failed attempts since last success
+
+	saved int64 // last seen time saved to a storage
+}
+
+// Address should not be used. Many connection failures or it was not seen too long
+func (ka *KnownAddress) IsTerrible(now int64) bool {
+	if ka.LastAttempt > now-60 {
+		// don't remove addresses tried in last minute
+		return false
+	}
+
+	if ka.LastSeen > 0 && ka.LastSeen < now-AddrHorizon {
+		return true
+	}
+
+	return ka.Attempts >= AddrMaxFailures
+}
+
+type addrBook struct {
+	key      []byte // random key to place addresses to buckets. Is different on every start
+	addrs    map[string]*KnownAddress
+	newBkts  [AddrNewBucketsCount]map[string]bool
+	triedBkt [AddrTriedBucketsCount]map[string]bool
+}
+
+func newAddrBook() *addrBook {
+	b := &addrBook{}
+	b.key = make([]byte, 32)
+	rand.Read(b.key)
+	b.reset()
+
+	return b
+}
+
+func (b *addrBook) reset() {
+	b.addrs = make(map[string]*KnownAddress)
+
+	for i := range b.newBkts {
+		b.newBkts[i] = make(map[string]bool)
+	}
+	for i := range b.triedBkt {
+		b.triedBkt[i] = make(map[string]bool)
+	}
+}
+
+// Returns network group of a host. Addresses from same group are likely controlled by one owner
+// IPv4 - /16 network, IPv6 - /32 network. Names are grouped by themselves
+func GetAddressGroup(host string) string {
+	host = strings.ToLower(strings.Trim(host, " "))
+
+	if host == "" {
+		return ""
+	}
+
+	if host == "localhost" {
+		return "local"
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return host
+	}
+
+	if ip.IsLoopback() {
+		return "local"
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.Itoa(int(ip4[0])) + "." + strconv.Itoa(int(ip4[1]))
+	}
+
+	return ip[:4].String()
+}
+
+// Checks if a host is loopback or unspecified address. Such address is usable only on same machine
+func isLocalHost(host string) bool {
+	if GetAddressGroup(host) == "local" {
+		return true
+	}
+
+	ip := net.ParseIP(strings.Trim(host, " "))
+
+	return ip != nil && ip.IsUnspecified()
+}
+
+// Returns true if a host can be reached from any network. Names are expected to be public
+func IsRoutable(host string) bool {
+	host = strings.Trim(host, " ")
+
+	if host == "" || isLocalHost(host) {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return true
+	}
+
+	return !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsMulticast()
+}
+
+// Returns true if a node with given host can be reached by a node with the host from.
+// Local addresses are reachable only from same machine, private addresses only from private networks
+func IsReachableFrom(host string, from string) bool {
+	if strings.Trim(host, " ") == "" {
+		return false
+	}
+
+	if from == "" || IsRoutable(host) {
+		return true
+	}
+
+	if isLocalHost(host) {
+		return isLocalHost(from)
+	}
+
+	return !IsRoutable(from)
+}
+
+// Returns host to connect from same machine to a node listening on given interface
+func GetLocalHost(bindHost string) string {
+	if bindHost == "" || isLocalHost(bindHost) {
+		return "localhost"
+	}
+	return bindHost
+}
+
+func (b *addrBook) hash(parts ...string) uint64 {
+	h := sha256.New()
+	h.Write(b.key)
+
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil)[:8])
+}
+
+func (b *addrBook) getNewBucket(ka *KnownAddress) int {
+	group := GetAddressGroup(ka.Addr.Host)
+
+	sub := b.hash(group, ka.Source) % addrNewBucketsPerSource
+
+	return int(b.hash(ka.Source, strconv.FormatUint(sub, 10)) % AddrNewBucketsCount)
+}
+
+func (b *addrBook) getTriedBucket(ka *KnownAddress) int {
+	group := GetAddressGroup(ka.Addr.Host)
+
+	sub := b.hash(ka.Addr.NodeAddrToString()) % addrTriedBucketsPerGroup
+
+	return int(b.hash(group, strconv.FormatUint(sub, 10)) % AddrTriedBucketsCount)
+}
+
+// Adds an address. Returns addresses moved from tried to new and addresses removed
+// from the book to free space
+func (b *addrBook) add(ka *KnownAddress, now int64) ([]*KnownAddress, []*KnownAddress) {
+	key := ka.Addr.NodeAddrToString()
+
+	b.addrs[key] = ka
+
+	if ka.Tried {
+		return b.placeTried(ka, now)
+	}
+	return nil, b.placeNew(ka, now)
+}
+
+// Puts an address to new bucket. Removes the worst address if the bucket is full
+func (b *addrBook) placeNew(ka *KnownAddress, now int64) []*KnownAddress {
+	ka.Tried = false
+
+	bucket := b.newBkts[b.getNewBucket(ka)]
+	removed := []*KnownAddress{}
+
+	if len(bucket) >= AddrBucketSize {
+		worst := b.findWorst(bucket, now, func(a *KnownAddress) int64 { return a.LastSeen })
+
+		delete(bucket, worst.Addr.NodeAddrToString())
+		delete(b.addrs, worst.Addr.NodeAddrToString())
+
+		removed = append(removed, worst)
+	}
+	bucket[ka.Addr.NodeAddrToString()] = true
+
+	return removed
+}
+
+// Puts an address to tried bucket. If the bucket is full, the oldest address is moved back to new
+// Returns moved and removed addresses
+func (b *addrBook) placeTried(ka *KnownAddress, now int64) ([]*KnownAddress, []*KnownAddress) {
+	ka.Tried = true
+
+	bucket := b.triedBkt[b.getTriedBucket(ka)]
+	moved := []*KnownAddress{}
+	removed := []*KnownAddress{}
+
+	if len(bucket) >= AddrBucketSize {
+		worst := b.findWorst(bucket, now, func(a *KnownAddress) int64 { return a.LastSuccess })
+
+		delete(bucket, worst.Addr.NodeAddrToString())
+
+		removed = b.placeNew(worst, now)
+		moved = append(moved, worst)
+	}
+	bucket[ka.Addr.NodeAddrToString()] = true
+
+	return moved, removed
+}
+
+// Returns terrible address from a bucket or the address with lowest time
+func (b *addrBook) findWorst(bucket map[string]bool, now int64, getTime func(a *KnownAddress) int64) *KnownAddress {
+	var worst *KnownAddress
+
+	for key := range bucket {
+		ka := b.addrs[key]
+
+		if ka.IsTerrible(now) {
+			return ka
+		}
+
+		if worst == nil || getTime(ka) < getTime(worst) {
+			worst = ka
+		}
+	}
+	return worst
+}
+
+// Removes an address from buckets
+func (b *addrBook) remove(addr NodeAddr) *KnownAddress {
+	key := addr.NodeAddrToString()
+
+	ka, ok := b.addrs[key]
+
+	if !ok {
+		return nil
+	}
+
+	delete(b.addrs, key)
+
+	for _, bucket := range b.newBkts {
+		delete(bucket, key)
+	}
+	for _, bucket := range b.triedBkt {
+		delete(bucket, key)
+	}
+	return ka
+}
+
+// Finds an address. localhost and 127.0.0.1 are same
+func (b *addrBook) find(addr NodeAddr) *KnownAddress {
+	if ka, ok := b.addrs[addr.NodeAddrToString()]; ok {
+		return ka
+	}
+
+	for _, ka := range b.addrs {
+		if ka.Addr.CompareToAddress(addr) {
+			return ka
+		}
+	}
+	return nil
+}
+
+// Moves an address to tried bucket after successful connection
+// Returns moved and removed addresses
+func (b *addrBook) markGood(ka *KnownAddress, now int64) ([]*KnownAddress, []*KnownAddress) {
+	ka.LastSuccess = now
+	ka.LastSeen = now
+	ka.LastAttempt = now
+	ka.Attempts = 0
+
+	if ka.Tried {
+		return nil, nil
+	}
+
+	key := ka.Addr.NodeAddrToString()
+
+	for _, bucket := range b.newBkts {
+		delete(bucket, key)
+	}
+	return b.placeTried(ka, now)
+}
+
+// Returns all addresses ordered: tried first, then by address
+func (b *addrBook) getAll() []*KnownAddress {
+	list := make([]*KnownAddress, 0, len(b.addrs))
+
+	for _, ka := range b.addrs {
+		list = append(list, ka)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Tried != list[j].Tried {
+			return list[i].Tried
+		}
+		return list[i].Addr.NodeAddrToString() < list[j].Addr.NodeAddrToString()
+	})
+	return list
+}
+
+// Returns random good addresses to send to other nodes
+func (b *addrBook) getForGossip(max int, now int64, forHost string) []NodeAddr {
+	list := []NodeAddr{}
+
+	for _, ka := range b.addrs {
+		// last connection failed. don't tell about it till it works again
+		if ka.IsTerrible(now) || ka.Attempts > 0 {
+			continue
+		}
+
+		if IsReachableFrom(ka.Addr.Host, forHost) {
+			list = append(list, ka.Addr)
+		}
+	}
+
+	mrand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+
+	if len(list) > max {
+		list = list[:max]
+	}
+	return list
+}
+
+// Selects addresses to connect to. Tried addresses are preferred but new addresses are used too,
+// so the book is checked over time. Addresses are taken from different network groups first
+func (b *addrBook) selectOutbound(count int, exclude NodeAddr, now int64) []NodeAddr {
+	tried := []*KnownAddress{}
+	fresh := []*KnownAddress{}
+
+	for _, ka := range b.addrs {
+		if ka.IsTerrible(now) || ka.Addr.CompareToAddress(exclude) {
+			continue
+		}
+		if ka.Tried {
+			tried = append(tried, ka)
+		} else {
+			fresh = append(fresh, ka)
+		}
+	}
+
+	mrand.Shuffle(len(tried), func(i, j int) { tried[i], tried[j] = tried[j], tried[i] })
+	mrand.Shuffle(len(fresh), func(i, j int) { fresh[i], fresh[j] = fresh[j], fresh[i] })
+
+	// take 2 tried addresses for every new one
+	candidates := []*KnownAddress{}
+
+	for len(tried) > 0 || len(fresh) > 0 {
+		for i := 0; i < 2 && len(tried) > 0; i++ {
+			candidates = append(candidates, tried[0])
+			tried = tried[1:]
+		}
+		if len(fresh) > 0 {
+			candidates = append(candidates, fresh[0])
+			fresh = fresh[1:]
+		}
+	}
+
+	result := []NodeAddr{}
+	groups := map[string]bool{}
+	used := map[string]bool{}
+
+	// first pass takes one address from a group. second pass fills the rest
+	for pass := 0; pass < 2 && len(result) < count; pass++ {
+		for _, ka := range candidates {
+			if len(result) >= count {
+				break
+			}
+
+			key := ka.Addr.NodeAddrToString()
+			group := GetAddressGroup(ka.Addr.Host)
+
+			if used[key] || (pass == 0 && groups[group]) {
+				continue
+			}
+
+			used[key] = true
+			groups[group] = true
+			result = append(result, ka.Addr)
+		}
+	}
+	return result
+}
+
+func nowUnix() int64 {
+	return time.Now().Unix()
+}
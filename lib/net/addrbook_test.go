@@ -0,0 +1,178 @@
+package net
+
+import (
+	"strconv"
+	"testing"
+)
+
+type testAddrStorage struct {
+	nodes map[string]KnownAddress
+}
+
+func (s *testAddrStorage) GetNodes() ([]KnownAddress, error) {
+	list := []KnownAddress{}
+
+	for _, ka := range s.nodes {
+		list = append(list, ka)
+	}
+	return list, nil
+}
+
+func (s *testAddrStorage) AddNodeToKnown(addr KnownAddress) {
+	s.nodes[addr.Addr.NodeAddrToString()] = addr
+}
+
+func (s *testAddrStorage) RemoveNodeFromKnown(addr NodeAddr) {
+	delete(s.nodes, addr.NodeAddrToString())
+}
+
+func (s *testAddrStorage) GetCountOfKnownNodes() (int, error) {
+	return len(s.nodes), nil
+}
+
+func TestAddrBook(t *testing.T) {
+	storage := &testAddrStorage{map[string]KnownAddress{}}
+
+	n := NodeNetwork{}
+	n.Init()
+	n.SetExtraManager(storage)
+
+	// one source can not fill the book
+	addrs := []NodeAddr{}
+
+	for i := 0; i < 5000; i++ {
+		addrs = append(addrs, NodeAddr{Host: "10." + strconv.Itoa(i/250) + "." + strconv.Itoa(i%250) + ".1", Port: 20000})
+	}
+
+	added := n.AddAddresses(addrs, "192.168.1.1")
+
+	if len(added) == 0 {
+		t.Fatalf("Addresses were not added")
+	}
+
+	if n.GetCountOfKnownNodes() > addrNewBucketsPerSource*AddrBucketSize {
+		t.Fatalf("Too many addresses from one source: %d", n.GetCountOfKnownNodes())
+	}
+
+	if len(storage.nodes) != n.GetCountOfKnownNodes() {
+		t.Fatalf("Storage has %d addresses, the book has %d", len(storage.nodes), n.GetCountOfKnownNodes())
+	}
+
+	// addresses from other source are added. its bucket can be one of the full buckets
+	// of the first source, then the worst address is replaced
+	count := n.GetCountOfKnownNodes()
+
+	n.AddAddresses([]NodeAddr{{Host: "172.16.0.1", Port: 20000}}, "172.20.0.1")
+
+	if !n.CheckIsKnown(NodeAddr{Host: "172.16.0.1", Port: 20000}) || n.GetCountOfKnownNodes() < count {
+		t.Fatalf("Address from other source was not added")
+	}
+
+	// successful connection moves an address to tried
+	good := NodeAddr{Host: "172.16.0.1", Port: 20000}
+
+	n.MarkGood(good)
+
+	if !storage.nodes[good.NodeAddrToString()].Tried {
+		t.Fatalf("Tried address is not saved")
+	}
+
+	if !n.GetKnownAddresses()[0].Addr.CompareToAddress(good) {
+		t.Fatalf("Tried address is not first")
+	}
+
+	// address is not used after failures
+	bad := added[0]
+
+	for i := 0; i < AddrMaxFailures; i++ {
+		n.MarkAttempt(bad)
+	}
+
+	n.lock.Lock()
+	n.book.find(bad).LastAttempt -= 120
+	n.lock.Unlock()
+
+	for _, addr := range n.SelectOutbound(n.GetCountOfKnownNodes(), NodeAddr{}) {
+		if addr.CompareToAddress(bad) {
+			t.Fatalf("Failed address is selected")
+		}
+	}
+
+	for _, addr := range n.GetAddressesForGossip(AddrGossipMax, "") {
+		if addr.CompareToAddress(bad) {
+			t.Fatalf("Failed address is sent to other nodes")
+		}
+	}
+
+	// different networks are selected first
+	selected := n.SelectOutbound(8, NodeAddr{})
+	groups := map[string]bool{}
+
+	for _, addr := range selected {
+		groups[GetAddressGroup(addr.Host)] = true
+	}
+
+	if len(selected) != 8 || len(groups) != 8 {
+		t.Fatalf("Selected %d addresses from %d groups", len(selected), len(groups))
+	}
+
+	// the book is restored from the storage
+	n2 := NodeNetwork{}
+	n2.Init()
+	n2.SetExtraManager(storage)
+	n2.LoadNodes()
+
+	if n2.GetCountOfKnownNodes() != len(storage.nodes) {
+		t.Fatalf("Loaded %d addresses, expected %d", n2.GetCountOfKnownNodes(), len(storage.nodes))
+	}
+
+	if !n2.GetKnownAddresses()[0].Tried {
+		t.Fatalf("Tried state is not loaded")
+	}
+
+	n2.RemoveNodeFromKnown(good)
+
+	if n2.CheckIsKnown(good) {
+		t.Fatalf("Address was not removed")
+	}
+
+	if _, ok := storage.nodes[good.NodeAddrToString()]; ok {
+		t.Fatalf("Address was not removed from the storage")
+	}
+}
+
+func TestAddressReachability(t *testing.T) {
+	cases := []struct {
+		host, from string
+		reachable  bool
+	}{
+		{"8.8.8.8", "127.0.0.1", true},
+		{"seed.democoin.test", "8.8.4.4", true},
+		{"localhost", "127.0.0.1", true},
+		{"127.0.0.1", "8.8.4.4", false},
+		{"192.168.1.5", "10.0.0.2", true},
+		{"192.168.1.5", "8.8.4.4", false},
+		{"0.0.0.0", "8.8.4.4", false},
+		{"", "", false},
+	}
+
+	for _, c := range cases {
+		if IsReachableFrom(c.host, c.from) != c.reachable {
+			t.Fatalf("Reachability of %s from %s must be %v", c.host, c.from, c.reachable)
+		}
+	}
+
+	// local addresses are not sent to other networks
+	n := NodeNetwork{}
+	n.Init()
+	n.AddAddresses([]NodeAddr{{Host: "127.0.0.1", Port: 20000}, {Host: "8.8.8.8", Port: 20000}}, "127.0.0.1")
+
+	if len(n.GetAddressesForGossip(AddrGossipMax, "8.8.4.4")) != 1 ||
+		len(n.GetAddressesForGossip(AddrGossipMax, "127.0.0.1")) != 2 {
+		t.Fatalf("Wrong addresses for gossip")
+	}
+
+	if GetLocalHost("") != "localhost" || GetLocalHost("0.0.0.0") != "localhost" || GetLocalHost("10.0.0.1") != "10.0.0.1" {
+		t.Fatalf("Wrong local host")
+	}
+}
@@ -0,0 +1,232 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sources of initial nodes addresses. They are used when a node doesn't know other nodes
+// Every source tells a genesis hash of a network its nodes belong to, if it is known.
+// Nodes of other networks are not used
+
+// Time to wait for a network source
+const BootstrapTimeout = 2 * time.Second
+
+// Nodes list in a data dir. Has same format as the list loaded from a URL
+const SeedFileName = "seednodes.json"
+
+// DNS seeds publish a genesis hash in TXT record with this prefix
+const BootstrapDNSGenesisPrefix = "genesis="
+
+type BootstrapSource interface {
+	// Returns addresses and a genesis hash. The hash is nil if the source doesn't tell it
+	GetNodes() ([]NodeAddr, []byte, error)
+	GetName() string
+}
+
+// Nodes list in JSON format. It is loaded from a URL or from a seed file
+type HTTPBootstrap struct {
+	URL string
+}
+
+type FileBootstrap struct {
+	Path string
+}
+
+// Resolves a name to IP addresses of nodes. All nodes listen on same port
+// If Server is set, DNS requests are sent to that host:port instead of system resolvers
+type DNSBootstrap struct {
+	Seed   string // host:port
+	Server string
+}
+
+// Nodes known from a config. Genesis can be empty if it is not set in the config
+type StaticBootstrap struct {
+	Name    string
+	Nodes   []NodeAddr
+	Genesis string
+}
+
+func (b HTTPBootstrap) GetName() string {
+	return b.URL
+}
+
+func (b HTTPBootstrap) GetNodes() ([]NodeAddr, []byte, error) {
+	client := http.Client{Timeout: BootstrapTimeout}
+
+	response, err := client.Get(b.URL)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	jsondoc, err := ioutil.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseNodesListJSON(jsondoc)
+}
+
+func (b FileBootstrap) GetName() string {
+	return b.Path
+}
+
+func (b FileBootstrap) GetNodes() ([]NodeAddr, []byte, error) {
+	jsondoc, err := ioutil.ReadFile(b.Path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseNodesListJSON(jsondoc)
+}
+
+func (b DNSBootstrap) GetName() string {
+	return "dns:" + b.Seed
+}
+
+func (b DNSBootstrap) GetNodes() ([]NodeAddr, []byte, error) {
+	seed := NodeAddr{}
+
+	err := seed.LoadFromString(b.Seed)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolver := net.DefaultResolver
+
+	if b.Server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, b.Server)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), BootstrapTimeout)
+	defer cancel()
+
+	hosts, err := resolver.LookupHost(ctx, seed.Host)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var genesis []byte
+
+	// genesis record is optional
+	records, err := resolver.LookupTXT(ctx, seed.Host)
+
+	if err == nil {
+		for _, r := range records {
+			if !strings.HasPrefix(r, BootstrapDNSGenesisPrefix) {
+				continue
+			}
+			genesis, err = hex.DecodeString(strings.TrimPrefix(r, BootstrapDNSGenesisPrefix))
+
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	nodes := []NodeAddr{}
+
+	for _, host := range hosts {
+		nodes = append(nodes, NodeAddr{Host: host, Port: seed.Port})
+	}
+	return nodes, genesis, nil
+}
+
+func (b StaticBootstrap) GetName() string {
+	return b.Name
+}
+
+func (b StaticBootstrap) GetNodes() ([]NodeAddr, []byte, error) {
+	genesis, err := DecodeGenesisHash(b.Genesis)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.Nodes, genesis, nil
+}
+
+// Parses nodes list. Genesis is optional
+func parseNodesListJSON(jsondoc []byte) ([]NodeAddr, []byte, error) {
+	nodes := NodesListJSON{}
+
+	err := json.Unmarshal(jsondoc, &nodes)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range nodes.Nodes {
+		nodes.Nodes[i].Host = strings.Trim(nodes.Nodes[i].Host, " ")
+	}
+
+	genesis, err := DecodeGenesisHash(nodes.Genesis)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodes.Nodes, genesis, nil
+}
+
+// Decodes a genesis hash from a config. Returns nil if the hash is not set
+func DecodeGenesisHash(hash string) ([]byte, error) {
+	if hash == "" {
+		return nil, nil
+	}
+
+	genesis, err := hex.DecodeString(hash)
+
+	if err != nil {
+		return nil, errors.New("Wrong genesis hash " + hash + ": " + err.Error())
+	}
+	return genesis, nil
+}
+
+// Loads nodes from a source and checks they are from the network with given genesis hash
+// The hash can be nil if it is not known yet. Then nodes are not checked
+func LoadBootstrapNodes(source BootstrapSource, genesisHash []byte) ([]NodeAddr, error) {
+	nodes, genesis, err := source.GetNodes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if genesisHash != nil && genesis != nil && !bytes.Equal(genesis, genesisHash) {
+		return nil, errors.New(fmt.Sprintf("Nodes from %s are from other network with genesis %x",
+			source.GetName(), genesis))
+	}
+
+	valid := []NodeAddr{}
+
+	for _, node := range nodes {
+		if node.Host == "" || node.Port < 1 || node.Port > 65535 {
+			continue
+		}
+		valid = append(valid, node)
+	}
+
+	if len(valid) == 0 && len(nodes) > 0 {
+		return nil, errors.New("No valid addresses in " + source.GetName())
+	}
+	return valid, nil
+}
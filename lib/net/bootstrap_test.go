@@ -0,0 +1,190 @@
+package net
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// Answers A and TXT requests for any name. Other requests get empty answer
+func startDNSStub(t *testing.T, ips []string, txt string) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("DNS stub error: %s", err.Error())
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+
+			if err != nil {
+				return
+			}
+
+			// skip the name in the question
+			pos := 12
+
+			for pos < n && buf[pos] != 0 {
+				pos += int(buf[pos]) + 1
+			}
+			if pos+5 > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(buf[pos+1:])
+			question := buf[12 : pos+5]
+
+			answers := [][]byte{}
+
+			if qtype == 1 {
+				for _, ip := range ips {
+					answers = append(answers, append([]byte{0, 1, 0, 1, 0, 0, 0, 60, 0, 4}, net.ParseIP(ip).To4()...))
+				}
+			} else if qtype == 16 && txt != "" {
+				rdata := append([]byte{byte(len(txt))}, []byte(txt)...)
+				answer := []byte{0, 16, 0, 1, 0, 0, 0, 60, 0, byte(len(rdata))}
+				answers = append(answers, append(answer, rdata...))
+			}
+
+			response := []byte{buf[0], buf[1], 0x81, 0x80, 0, 1, 0, byte(len(answers)), 0, 0, 0, 0}
+			response = append(response, question...)
+
+			for _, answer := range answers {
+				// pointer to the name in the question
+				response = append(response, 0xc0, 12)
+				response = append(response, answer...)
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func TestBootstrapSources(t *testing.T) {
+	genesis, _ := hex.DecodeString("00aa")
+	other, _ := hex.DecodeString("00bb")
+
+	dir, _ := ioutil.TempDir("", "bootstrap")
+	defer os.RemoveAll(dir)
+
+	seedFile := dir + "/" + SeedFileName
+
+	ioutil.WriteFile(seedFile, []byte(`{"Genesis":"00aa","Nodes":[{"Host":" 10.0.0.1","Port":20000},{"Host":"","Port":1}]}`), 0600)
+
+	nodes, err := LoadBootstrapNodes(FileBootstrap{Path: seedFile}, genesis)
+
+	if err != nil {
+		t.Fatalf("Seed file error: %s", err.Error())
+	}
+
+	if len(nodes) != 1 || nodes[0].NodeAddrToString() != "10.0.0.1:20000" {
+		t.Fatalf("Wrong nodes from seed file: %v", nodes)
+	}
+
+	_, err = LoadBootstrapNodes(FileBootstrap{Path: seedFile}, other)
+
+	if err == nil {
+		t.Fatalf("Nodes of other network are accepted")
+	}
+
+	// genesis is not known yet. nodes are not checked
+	_, err = LoadBootstrapNodes(FileBootstrap{Path: seedFile}, nil)
+
+	if err != nil {
+		t.Fatalf("Seed file error: %s", err.Error())
+	}
+
+	static := StaticBootstrap{Name: "config", Nodes: []NodeAddr{{Host: "10.0.0.2", Port: 20000}}, Genesis: "00bb"}
+
+	_, err = LoadBootstrapNodes(static, genesis)
+
+	if err == nil {
+		t.Fatalf("Nodes of other network are accepted from config")
+	}
+
+	// DNS seed
+	server, stop := startDNSStub(t, []string{"10.0.1.1", "10.0.2.1"}, BootstrapDNSGenesisPrefix+"00aa")
+	defer stop()
+
+	dns := DNSBootstrap{Seed: "seed.democoin.test:20000", Server: server}
+
+	nodes, err = LoadBootstrapNodes(dns, genesis)
+
+	if err != nil {
+		t.Fatalf("DNS seed error: %s", err.Error())
+	}
+
+	if len(nodes) != 2 || nodes[0].Port != 20000 {
+		t.Fatalf("Wrong nodes from DNS seed: %v", nodes)
+	}
+
+	_, err = LoadBootstrapNodes(dns, other)
+
+	if err == nil {
+		t.Fatalf("Nodes of other network are accepted from DNS seed")
+	}
+
+	// sources are used in order till nodes are found
+	n := NodeNetwork{}
+	n.Init()
+	n.Bootstrap = []BootstrapSource{
+		StaticBootstrap{Name: "empty"},
+		static,
+		FileBootstrap{Path: dir + "/missing.json"},
+		dns,
+		FileBootstrap{Path: seedFile}}
+
+	err = n.LoadInitialNodes(genesis)
+
+	if err != nil {
+		t.Fatalf("Bootstrap error: %s", err.Error())
+	}
+
+	if n.GetCountOfKnownNodes() != 2 || n.CheckIsKnown(NodeAddr{Host: "10.0.0.1", Port: 20000}) {
+		t.Fatalf("Wrong nodes are loaded: %v", n.GetNodes())
+	}
+}
+
+func TestBootstrapConfiguredGenesis(t *testing.T) {
+	genesis, err := DecodeGenesisHash("00aa")
+
+	if err != nil || hex.EncodeToString(genesis) != "00aa" {
+		t.Fatalf("Wrong genesis hash decoded: %x", genesis)
+	}
+
+	genesis, err = DecodeGenesisHash("")
+
+	if err != nil || genesis != nil {
+		t.Fatalf("Empty genesis hash must be nil")
+	}
+
+	_, err = DecodeGenesisHash("xyz")
+
+	if err == nil {
+		t.Fatalf("Wrong genesis hash is accepted")
+	}
+
+	// the chain doesn't exist yet. the genesis hash is from a config
+	genesis, _ = DecodeGenesisHash("00aa")
+
+	n := NodeNetwork{}
+	n.Init()
+	n.Bootstrap = []BootstrapSource{
+		StaticBootstrap{Name: "other network", Nodes: []NodeAddr{{Host: "10.0.0.2", Port: 20000}}, Genesis: "00bb"}}
+
+	err = n.LoadInitialNodes(genesis)
+
+	if err == nil {
+		t.Fatalf("Nodes of other network are accepted")
+	}
+
+	if n.GetCountOfKnownNodes() != 0 {
+		t.Fatalf("Nodes of other network are added: %v", n.GetNodes())
+	}
+}
@@ -0,0 +1,34 @@
+package net
+
+// Errors returned by a node when a request is refused. They are sent to a client
+// with ResponseTypedError first byte and the client gets same error back
+
+// First byte of a response
+const ResponseError = 0
+const ResponseSuccess = 1
+const ResponseTypedError = 2
+
+const RequestErrorTooLarge = "toolarge"
+const RequestErrorTimeout = "timeout"
+const RequestErrorMalformed = "malformed"
+const RequestErrorBusy = "busy"
+const RequestErrorBanned = "banned"
+const RequestErrorRateLimit = "ratelimit"
+
+// Fields are exported to be encoded with gob
+type RequestError struct {
+	Message string
+	Kind    string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func (e *RequestError) GetKind() string {
+	return e.Kind
+}
+
+func NewRequestError(err string, kind string) error {
+	return &RequestError{err, kind}
+}
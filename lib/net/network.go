@@ -7,10 +7,36 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/NlaakStudios/democoin/lib"
 )
 
 const Protocol = "tcp"
-const NodeVersion = 1
+
+// Version of the protocol. Nodes with version less than MinNodeVersion are refused
+// Version 1 nodes don't send service bits and genesis hash
+const NodeVersion = 2
+const MinNodeVersion = 1
+
+// Service bits. A node tells in version command what features it supports
+const (
+	ServiceNodeBlocks    = 1 << iota // has full blockchain and returns blocks
+	ServiceHeaders                   // supports getheaders and getblock commands (header-first sync)
+	ServiceSessions                  // supports persistent sessions
+	ServiceEncryption                // accepts encrypted connections
+	ServiceAddrGossip                // supports getaddr command
+	ServiceCompactBlocks             // supports cmpctblock and getblocktxn commands
+)
+
+// Services of nodes of version 1
+const LegacyNodeServices = ServiceNodeBlocks
+
+// Services supported by this node. ServiceEncryption is added if transport is enabled
+const NodeServices = ServiceNodeBlocks | ServiceHeaders | ServiceSessions | ServiceAddrGossip | ServiceCompactBlocks
+
+// Name and version of the software. Is sent to other nodes for information
+const NodeUserAgent = lib.ApplicationTitle + ":" + lib.ApplicationVersion
+
 const CommandLength = 12
 const AuthStringLength = 20
 
@@ -1,34 +1,32 @@
 package net
 
 import (
-	"encoding/hex"
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
+	"errors"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/NlaakStudios/democoin/lib"
 	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
-// INterface for extra storage for a nodes.
-// TODO
-// This is not used yet
+// Interface for extra storage for a nodes. Addresses are saved with a history of connections
+// so the address book is restored after restart
 type NodeNetworkStorage interface {
-	GetNodes() ([]NodeAddr, error)
-	AddNodeToKnown(addr NodeAddr)
+	GetNodes() ([]KnownAddress, error)
+	AddNodeToKnown(addr KnownAddress)
 	RemoveNodeFromKnown(addr NodeAddr)
 	GetCountOfKnownNodes() (int, error)
 }
 
 // This manages list of known nodes by a node
+// Clones of a node share same address book, see UseBookOf
 type NodeNetwork struct {
 	Logger  *utils.LoggerMan
-	Nodes   []NodeAddr
 	Storage NodeNetworkStorage
-	lock    *sync.Mutex
+	// sources of nodes used when no nodes are known
+	Bootstrap []BootstrapSource
+	book      *addrBook
+	lock      *sync.Mutex
 }
 
 type NodesListJSON struct {
@@ -39,6 +37,13 @@ type NodesListJSON struct {
 // Init nodes network object
 func (n *NodeNetwork) Init() {
 	n.lock = &sync.Mutex{}
+	n.book = newAddrBook()
+}
+
+// Use address book of other object. Is used by clones of a node
+func (n *NodeNetwork) UseBookOf(other *NodeNetwork) {
+	n.lock = other.lock
+	n.book = other.book
 }
 
 // Set extra storage for a nodes
@@ -52,18 +57,37 @@ func (n *NodeNetwork) LoadNodes() error {
 		return nil
 	}
 
-	n.lock.Lock()
-	defer n.lock.Unlock()
-
 	nodes, err := n.Storage.GetNodes()
 
 	if err != nil {
 		return err
 	}
 
+	now := nowUnix()
+	moved := []*KnownAddress{}
+	removed := []*KnownAddress{}
+
+	n.lock.Lock()
+
 	for _, node := range nodes {
-		n.Nodes = append(n.Nodes, node)
+		ka := node
+
+		if ka.LastSeen == 0 {
+			// saved by older version. only address is known
+			ka.LastSeen = now
+		}
+		ka.saved = ka.LastSeen
+
+		m, r := n.book.add(&ka, now)
+
+		moved = append(moved, m...)
+		removed = append(removed, r...)
 	}
+	moved = copyAddresses(moved)
+
+	n.lock.Unlock()
+
+	n.saveChanges(moved, removed)
 
 	return nil
 }
@@ -71,94 +95,98 @@ func (n *NodeNetwork) LoadNodes() error {
 // Set nodes list. This can be used to do initial nodes loading from  config or so
 func (n *NodeNetwork) SetNodes(nodes []NodeAddr, replace bool) {
 	n.lock.Lock()
-	defer n.lock.Unlock()
 
 	if replace {
-		n.Nodes = nodes
-	} else {
-		n.Nodes = append(n.Nodes, nodes...)
+		n.book.reset()
 	}
+	added, removed := n.addAddresses(nodes, "")
 
-	if n.Storage != nil {
-		// remember what is not yet remembered
-		for _, node := range nodes {
-			n.Storage.AddNodeToKnown(node)
-		}
-	}
+	n.lock.Unlock()
+
+	// remember what is not yet remembered
+	n.saveChanges(added, removed)
 }
 
-// If n any known nodes then it will be loaded from the url on a host
-// Accepts genesis block hash. It will be compared to the hash in JSON doc
+// If n any known nodes then they are loaded from bootstrap sources. Sources are used in order
+// till one of them returns nodes. The url on a host is used if no sources are set
+// Accepts genesis block hash. Nodes of other networks are not added
 func (n *NodeNetwork) LoadInitialNodes(geenesisHash []byte) error {
-	timeout := time.Duration(2 * time.Second)
+	sources := n.Bootstrap
 
-	client := http.Client{Timeout: timeout}
+	if len(sources) == 0 {
+		sources = []BootstrapSource{HTTPBootstrap{URL: lib.InitialNodesList}}
+	}
 
-	response, err := client.Get(lib.InitialNodesList)
+	errs := []string{}
 
-	if err != nil {
-		return err
-	}
+	for _, source := range sources {
+		nodes, err := LoadBootstrapNodes(source, geenesisHash)
 
-	jsondoc, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
 
-	if err != nil {
-		return err
-	}
+		if len(nodes) == 0 {
+			continue
+		}
 
-	nodes := NodesListJSON{}
+		n.lock.Lock()
+		added, removed := n.addAddresses(nodes, "")
+		n.lock.Unlock()
 
-	err = json.Unmarshal(jsondoc, &nodes)
+		// remember loaded nodes in local storage
+		n.saveChanges(added, removed)
 
-	if err != nil {
-		return err
+		return nil
 	}
 
-	if geenesisHash != nil && nodes.Genesis != "" {
-		gh := hex.EncodeToString(geenesisHash)
-
-		if gh != nodes.Genesis {
-			// don't add
-			return nil
-		}
+	if len(errs) > 0 {
+		return errors.New("Bootstrap error: " + strings.Join(errs, "; "))
 	}
+	return nil
+}
+
+// Returns all known nodes. Nodes this node was connected to are first
+func (n *NodeNetwork) GetNodes() []NodeAddr {
 	n.lock.Lock()
 	defer n.lock.Unlock()
-	n.Nodes = append(n.Nodes, nodes.Nodes...)
 
-	if n.Storage != nil {
-		// remember loaded nodes in local storage
-		for _, node := range nodes.Nodes {
-			node.Host = strings.Trim(node.Host, " ")
-			n.Storage.AddNodeToKnown(node)
-		}
-	}
+	nodes := []NodeAddr{}
 
-	return nil
+	for _, ka := range n.book.getAll() {
+		nodes = append(nodes, ka.Addr)
+	}
+	return nodes
 }
-func (n *NodeNetwork) GetNodes() []NodeAddr {
-	return n.Nodes
+
+// Returns copies of all records of the address book
+func (n *NodeNetwork) GetKnownAddresses() []KnownAddress {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	list := []KnownAddress{}
+
+	for _, ka := range n.book.getAll() {
+		list = append(list, *ka)
+	}
+	return list
 }
 
 // Returns number of known nodes
 func (n *NodeNetwork) GetCountOfKnownNodes() int {
-	l := len(n.Nodes)
+	n.lock.Lock()
+	defer n.lock.Unlock()
 
-	return l
+	return len(n.book.addrs)
 }
 
 // Check if node address is known
 func (n *NodeNetwork) CheckIsKnown(addr NodeAddr) bool {
-	exists := false
-
-	for _, node := range n.Nodes {
-		if node.CompareToAddress(addr) {
-			exists = true
-			break
-		}
-	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
 
-	return exists
+	return n.book.find(addr) != nil
 }
 
 /*
@@ -166,44 +194,187 @@ func (n *NodeNetwork) CheckIsKnown(addr NodeAddr) bool {
 * Returns true if was added
  */
 func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
+	return len(n.AddAddresses([]NodeAddr{addr}, "")) > 0
+}
+
+// Adds addresses received from other node. Source is a host of that node. Empty source
+// means addresses are added by a user or from a config. Returns addresses which were not known
+func (n *NodeNetwork) AddAddresses(addrs []NodeAddr, source string) []NodeAddr {
 	n.lock.Lock()
-	defer n.lock.Unlock()
+	added, removed := n.addAddresses(addrs, GetAddressGroup(source))
+	n.lock.Unlock()
 
-	exists := false
+	n.saveChanges(added, removed)
 
-	for _, node := range n.Nodes {
-		if node.CompareToAddress(addr) {
-			exists = true
+	list := []NodeAddr{}
+
+	for _, ka := range added {
+		list = append(list, ka.Addr)
+	}
+	return list
+}
+
+// Removes a node from known
+func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
+	n.lock.Lock()
+
+	removed := []*KnownAddress{}
+
+	for {
+		ka := n.book.find(addr)
+
+		if ka == nil {
 			break
 		}
+		removed = append(removed, n.book.remove(ka.Addr))
 	}
-	if !exists {
-		n.Nodes = append(n.Nodes, addr)
+
+	n.lock.Unlock()
+
+	if len(removed) == 0 {
+		// it could be saved with other host name
+		removed = append(removed, &KnownAddress{Addr: addr})
 	}
+	n.saveChanges(nil, removed)
+}
+
+// Remembers successful connection to a node. The node is moved to tried addresses
+// Does nothing if the address is not known
+func (n *NodeNetwork) MarkGood(addr NodeAddr) {
+	now := nowUnix()
+
+	n.lock.Lock()
 
-	if n.Storage != nil {
-		n.Storage.AddNodeToKnown(addr)
+	ka := n.book.find(addr)
+
+	if ka == nil {
+		n.lock.Unlock()
+		return
+	}
+
+	wasTried := ka.Tried
+	moved, removed := n.book.markGood(ka, now)
+
+	changed := moved
+
+	// last seen time is saved not on every connection
+	if !wasTried || ka.saved < now-AddrSeenSaveInterval {
+		ka.saved = now
+		changed = append(changed, ka)
 	}
+	changed = copyAddresses(changed)
+
+	n.lock.Unlock()
 
-	return !exists
+	n.saveChanges(changed, removed)
 }
 
-// Removes a node from known
-func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
+// Remembers failed connection to a node
+// Does nothing if the address is not known
+func (n *NodeNetwork) MarkAttempt(addr NodeAddr) {
+	now := nowUnix()
+
+	n.lock.Lock()
+
+	ka := n.book.find(addr)
+
+	if ka == nil {
+		n.lock.Unlock()
+		return
+	}
+
+	ka.LastAttempt = now
+	ka.Attempts++
+
+	changed := []*KnownAddress{}
+
+	if ka.Attempts == AddrMaxFailures {
+		// the address will not be used now. save it
+		changed = copyAddresses([]*KnownAddress{ka})
+	}
+
+	n.lock.Unlock()
+
+	n.saveChanges(changed, nil)
+}
+
+// Returns random good addresses to send to a node with given host. Addresses the node
+// can not reach (local or private networks) are skipped. All are returned if the host is empty
+func (n *NodeNetwork) GetAddressesForGossip(max int, forHost string) []NodeAddr {
 	n.lock.Lock()
 	defer n.lock.Unlock()
 
-	updatedlist := []NodeAddr{}
+	return n.book.getForGossip(max, nowUnix(), forHost)
+}
+
+// Selects up to count nodes to send data to. Addresses from different networks are preferred
+// An address of this node is passed in exclude
+func (n *NodeNetwork) SelectOutbound(count int, exclude NodeAddr) []NodeAddr {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.book.selectOutbound(count, exclude, nowUnix())
+}
+
+// Adds addresses to the book. Returns added addresses and addresses removed to free space
+// Must be called under the lock
+func (n *NodeNetwork) addAddresses(addrs []NodeAddr, source string) ([]*KnownAddress, []*KnownAddress) {
+	now := nowUnix()
+
+	added := []*KnownAddress{}
+	removed := []*KnownAddress{}
+
+	for _, addr := range addrs {
+		if addr.Host == "" || addr.Port == 0 || n.book.find(addr) != nil {
+			continue
+		}
+
+		ka := &KnownAddress{}
+		ka.Addr = addr
+		ka.Source = source
+		ka.LastSeen = now
+		ka.saved = now
+
+		_, r := n.book.add(ka, now)
+
+		removed = append(removed, r...)
+		added = append(added, ka)
+	}
+
+	// some added addresses could be removed to free space for next ones
+	list := []*KnownAddress{}
 
-	for _, node := range n.Nodes {
-		if !node.CompareToAddress(addr) {
-			updatedlist = append(updatedlist, node)
+	for _, ka := range added {
+		if n.book.addrs[ka.Addr.NodeAddrToString()] == ka {
+			list = append(list, ka)
 		}
 	}
+	return copyAddresses(list), removed
+}
+
+// Saves changes of the address book to the storage. Must be called without the lock
+// because the storage can wait for DB lock
+func (n *NodeNetwork) saveChanges(changed []*KnownAddress, removed []*KnownAddress) {
+	if n.Storage == nil {
+		return
+	}
+
+	for _, ka := range removed {
+		n.Storage.RemoveNodeFromKnown(ka.Addr)
+	}
+
+	for _, ka := range changed {
+		n.Storage.AddNodeToKnown(*ka)
+	}
+}
 
-	n.Nodes = updatedlist
+// Copies records so they can be used without the lock
+func copyAddresses(list []*KnownAddress) []*KnownAddress {
+	copies := []*KnownAddress{}
 
-	if n.Storage != nil {
-		n.Storage.RemoveNodeFromKnown(addr)
+	for _, ka := range list {
+		c := *ka
+		copies = append(copies, &c)
 	}
+	return copies
 }
@@ -0,0 +1,72 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Persistent peer sessions. A session is opened with the SessionCommand sent as usual command.
+// If other node accepts it then it responds with SessionFrameVerack frame and the connection
+// is used for many requests. Every request and response is sent in a frame:
+// request ID (4 bytes), kind (1 byte), length of a body (4 bytes), body
+// Body of a request is same data as a command sent without a session
+// Body of a response is same data as a response sent without a session
+const SessionCommand = "sessversion"
+const SessionProtocolVersion = 1
+
+const SessionFrameRequest = 1         // request without response
+const SessionFrameRequestResponse = 2 // request that waits for response
+const SessionFrameResponse = 3
+const SessionFrameVerack = 4
+
+const SessionFrameHeaderLength = 9
+
+// Max size of a frame body. Protects from allocating memory for broken data
+const SessionMaxFrameLength = 64 * 1024 * 1024
+
+// Writes a frame to a connection. Frame must be written with single call to not mix with other frames
+func WriteSessionFrame(w io.Writer, id uint32, kind byte, body []byte) error {
+	frame := make([]byte, SessionFrameHeaderLength, SessionFrameHeaderLength+len(body))
+
+	binary.LittleEndian.PutUint32(frame[0:4], id)
+	frame[4] = kind
+	binary.LittleEndian.PutUint32(frame[5:9], uint32(len(body)))
+
+	frame = append(frame, body...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// Reads next frame from a connection. Frame body can not be longer than maxLength
+// Memory for a body is allocated while data are received
+func ReadSessionFrame(r io.Reader, maxLength int) (uint32, byte, []byte, error) {
+	header := make([]byte, SessionFrameHeaderLength)
+
+	_, err := io.ReadFull(r, header)
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	id := binary.LittleEndian.Uint32(header[0:4])
+	kind := header[4]
+	length := binary.LittleEndian.Uint32(header[5:9])
+
+	if uint64(length) > uint64(maxLength) || length > SessionMaxFrameLength {
+		return 0, 0, nil, NewRequestError(fmt.Sprintf("Session frame is too big: %d bytes", length), RequestErrorTooLarge)
+	}
+
+	body := new(bytes.Buffer)
+
+	_, err = io.CopyN(body, r, int64(length))
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return id, kind, body.Bytes(), nil
+}
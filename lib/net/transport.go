@@ -0,0 +1,417 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const NodeKeyFileName = "nodekey.pem"
+const PeerKeysFileName = "peerkeys.json"
+
+// First byte of TLS handshake. Commands of plain protocol start from a letter
+const tlsHandshakeRecordType = 0x16
+
+// Node that doesn't support encryption is connected without it during this time
+const plainPeerRetryInterval = 10 * time.Minute
+
+const transportHandshakeTimeout = 10 * time.Second
+
+// Encrypted transport between nodes and from wallets to nodes. It is TLS with a static key of a node
+// Certificate is self-signed and is made from the key on start. Nodes are identified by a key,
+// not by a certificate. Key of other node is remembered on first connection and must not change later
+// Connections without encryption are accepted and made only if encryption is not required.
+// A key is trusted on first connection, an attacker between nodes at that moment can present own key.
+// When encryption is not required, first connection to a node falls back to plain protocol if the node
+// doesn't answer as TLS server. An attacker can break the handshake this way and keep the connection plain.
+// The fallback is not done after the node was connected with encryption once. Use RequireEncryption
+// to never make plain connections. A server doesn't authenticate clients, any client is accepted.
+// Nodes are public, encryption only hides traffic from other parties
+type Transport struct {
+	DataDir           string
+	RequireEncryption bool
+	ID                string // fingerprint of the public key of this node
+
+	certificate tls.Certificate
+
+	lock       sync.Mutex
+	peerKeys   map[string]string // address of a node -> fingerprint of its key
+	plainPeers map[string]time.Time
+}
+
+// Loads a key of a node from data dir. New key is created if there is no yet
+func NewTransport(datadir string, requireEncryption bool) (*Transport, error) {
+	t := &Transport{}
+	t.DataDir = datadir
+	t.RequireEncryption = requireEncryption
+	t.plainPeers = make(map[string]time.Time)
+
+	key, err := t.loadKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.certificate, err = makeCertificate(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.ID, err = GetKeyFingerprint(&key.PublicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t.peerKeys, err = t.loadPeerKeys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Returns fingerprint of a public key. It is hex of SHA256 of the key
+func GetKeyFingerprint(key interface{}) (string, error) {
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(keyBytes)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Connects to a node. Tries encrypted connection first. Falls back to plain connection if
+// other node doesn't support encryption, it is not required and there was no encrypted connection before
+func (t *Transport) Dial(addr NodeAddr, timeout time.Duration) (net.Conn, error) {
+	key := addr.NodeAddrToString()
+
+	if t.isPlainPeer(key) {
+		return net.DialTimeout(Protocol, key, timeout)
+	}
+
+	conn, err := net.DialTimeout(Protocol, key, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, t.getClientConfig(key))
+
+	tlsConn.SetDeadline(time.Now().Add(transportHandshakeTimeout))
+
+	err = tlsConn.Handshake()
+
+	if err == nil {
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+	conn.Close()
+
+	if t.RequireEncryption || t.getPeerKey(key) != "" || !isNotTLSPeerError(err) {
+		// node was connected with encryption before or it is TLS server which refused us. don't allow to downgrade
+		return nil, errors.New(fmt.Sprintf("Encrypted connection to %s failed: %s", key, err.Error()))
+	}
+
+	// other node doesn't know encryption
+	t.setPlainPeer(key)
+
+	return net.DialTimeout(Protocol, key, timeout)
+}
+
+// Checks if a handshake failed because other side doesn't speak TLS. It answers not TLS data
+// or closes the connection after the ClientHello. Other errors are not a reason to fall back to plain
+// connection. A timeout or a reset connection can be made by anyone between nodes to force a downgrade
+func isNotTLSPeerError(err error) bool {
+	if _, ok := err.(tls.RecordHeaderError); ok {
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// Prepares accepted connection. If a client starts TLS handshake then encrypted connection is returned
+// Plain connection is returned as is if encryption is not required
+func (t *Transport) Accept(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(transportHandshakeTimeout))
+
+	first := make([]byte, 1)
+
+	_, err := conn.Read(first)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pconn := &prefixedConn{conn, first}
+
+	if first[0] != tlsHandshakeRecordType {
+		if t.RequireEncryption {
+			return nil, errors.New("Encrypted connection is required")
+		}
+		conn.SetReadDeadline(time.Time{})
+
+		return pconn, nil
+	}
+
+	tlsConn := tls.Server(pconn, t.getServerConfig())
+
+	err = tlsConn.Handshake()
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+// Client can present own certificate. It is only checked to be self-signed, clients are not authenticated
+func (t *Transport) getServerConfig() *tls.Config {
+	return &tls.Config{
+		Certificates:          []tls.Certificate{t.certificate},
+		ClientAuth:            tls.RequestClientCert,
+		MinVersion:            tls.VersionTLS12,
+		VerifyPeerCertificate: verifySelfSignedCertificate,
+	}
+}
+
+// Certificates are self-signed so usual verification is replaced with check of a key of a node
+func (t *Transport) getClientConfig(addr string) *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{t.certificate},
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			err := verifySelfSignedCertificate(rawCerts, nil)
+
+			if err != nil {
+				return err
+			}
+
+			cert, _ := x509.ParseCertificate(rawCerts[0])
+
+			return t.checkPeerKey(addr, cert.PublicKey)
+		},
+	}
+}
+
+// Checks a key of other node is same as on previous connections. Key is remembered on first connection
+func (t *Transport) checkPeerKey(addr string, key interface{}) error {
+	fingerprint, err := GetKeyFingerprint(key)
+
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	known, ok := t.peerKeys[addr]
+
+	if ok && known != fingerprint {
+		return &PeerKeyError{addr, known, fingerprint}
+	}
+
+	if !ok {
+		t.peerKeys[addr] = fingerprint
+
+		return t.savePeerKeys()
+	}
+	return nil
+}
+
+func (t *Transport) getPeerKey(addr string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.peerKeys[addr]
+}
+
+func (t *Transport) isPlainPeer(addr string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.RequireEncryption {
+		return false
+	}
+
+	if tm, ok := t.plainPeers[addr]; ok {
+		if time.Since(tm) < plainPeerRetryInterval {
+			return true
+		}
+		delete(t.plainPeers, addr)
+	}
+	return false
+}
+
+func (t *Transport) setPlainPeer(addr string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.plainPeers[addr] = time.Now()
+}
+
+// Loads the key of this node. Creates new key if the file doesn't exist
+func (t *Transport) loadKey() (*ecdsa.PrivateKey, error) {
+	keyfile := t.DataDir + NodeKeyFileName
+
+	data, err := ioutil.ReadFile(keyfile)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		block, _ := pem.Decode(data)
+
+		if block == nil {
+			return nil, errors.New("Node key file has wrong format")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	err = ioutil.WriteFile(keyfile, data, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (t *Transport) loadPeerKeys() (map[string]string, error) {
+	keys := make(map[string]string)
+
+	data, err := ioutil.ReadFile(t.DataDir + PeerKeysFileName)
+
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &keys)
+
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Must be called under the lock
+func (t *Transport) savePeerKeys() error {
+	data, err := json.Marshal(t.peerKeys)
+
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.DataDir+PeerKeysFileName, data, 0600)
+}
+
+// Makes self-signed certificate for the key
+func makeCertificate(key *ecdsa.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "democoin node"},
+		NotBefore:    time.Now().Add(-24 * time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{certBytes}, PrivateKey: key}, nil
+}
+
+// Checks a certificate is signed by own key. Certificate is optional for clients
+func verifySelfSignedCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+
+	if err != nil {
+		return err
+	}
+
+	return cert.CheckSignatureFrom(cert)
+}
+
+// Key of other node is not same as it was on first connection
+type PeerKeyError struct {
+	Addr     string
+	Expected string
+	Received string
+}
+
+func (e *PeerKeyError) Error() string {
+	return fmt.Sprintf("Key of %s is changed. Expected %s, got %s", e.Addr, e.Expected, e.Received)
+}
+
+// Connection with some bytes already read from it
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixedConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,145 @@
+package net
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "transport")
+	defer os.RemoveAll(dir)
+
+	serverDir := dir + "/server/"
+	clientDir := dir + "/client/"
+	os.Mkdir(serverDir, 0700)
+	os.Mkdir(clientDir, 0700)
+
+	server, err := NewTransport(serverDir, true)
+
+	if err != nil {
+		t.Fatalf("Server transport error: %s", err.Error())
+	}
+
+	// key is loaded from a file on next start
+	server2, err := NewTransport(serverDir, true)
+
+	if err != nil {
+		t.Fatalf("Server transport error: %s", err.Error())
+	}
+
+	if server.ID != server2.ID {
+		t.Fatalf("Key is changed after restart: %s != %s", server.ID, server2.ID)
+	}
+
+	client, err := NewTransport(clientDir, true)
+
+	if err != nil {
+		t.Fatalf("Client transport error: %s", err.Error())
+	}
+
+	listener, err := net.Listen(Protocol, "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen error: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				tconn, err := server.Accept(conn)
+
+				if err != nil {
+					return
+				}
+				data := make([]byte, 4)
+				tconn.Read(data)
+				tconn.Write(data)
+			}()
+		}
+	}()
+
+	addr := NodeAddr{}
+	addr.Host = "127.0.0.1"
+	addr.Port = listener.Addr().(*net.TCPAddr).Port
+
+	conn, err := client.Dial(addr, time.Second)
+
+	if err != nil {
+		t.Fatalf("Dial error: %s", err.Error())
+	}
+
+	conn.Write([]byte("ping"))
+
+	data := make([]byte, 4)
+	_, err = conn.Read(data)
+	conn.Close()
+
+	if err != nil || string(data) != "ping" {
+		t.Fatalf("Wrong echo %s", string(data))
+	}
+
+	if client.getPeerKey(addr.NodeAddrToString()) != server.ID {
+		t.Fatalf("Key of the server is not remembered")
+	}
+
+	// plain connection is refused when encryption is required
+	plain, err := net.DialTimeout(Protocol, addr.NodeAddrToString(), time.Second)
+
+	if err != nil {
+		t.Fatalf("Dial error: %s", err.Error())
+	}
+	plain.Write([]byte("ping"))
+	plain.SetReadDeadline(time.Now().Add(time.Second))
+
+	_, err = plain.Read(data)
+	plain.Close()
+
+	if err == nil {
+		t.Fatalf("Plain connection was accepted")
+	}
+
+	// server with other key is not accepted
+	client.peerKeys[addr.NodeAddrToString()] = "otherkey"
+
+	_, err = client.Dial(addr, time.Second)
+
+	if err == nil {
+		t.Fatalf("Connection with changed key was accepted")
+	}
+}
+
+func TestPlainFallbackErrors(t *testing.T) {
+	tests := []struct {
+		err      error
+		fallback bool
+	}{
+		{tls.RecordHeaderError{Msg: "not TLS"}, true},
+		{io.EOF, true},
+		// can be made by anyone between nodes
+		{&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, false},
+		{&net.OpError{Op: "read", Err: errors.New("i/o timeout")}, false},
+		// TLS server with other key or refused our certificate
+		{&PeerKeyError{"127.0.0.1:1", "a", "b"}, false},
+		{errors.New("remote error: tls: bad certificate"), false},
+	}
+
+	for _, test := range tests {
+		if isNotTLSPeerError(test.err) != test.fallback {
+			t.Fatalf("Error %s. Expected fallback %t", test.err.Error(), test.fallback)
+		}
+	}
+}
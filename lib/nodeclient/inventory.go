@@ -0,0 +1,191 @@
+package nodeclient
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	netlib "github.com/NlaakStudios/democoin/lib/net"
+)
+
+// Transactions are not pushed to other nodes. IDs are announced with inv command and
+// nodes request transactions they don't have yet. Announcements are collected for every node
+// and sent after random delays, so a transaction is not relayed by all nodes at same time
+
+// Average delay before queued transactions are announced to a node
+const InvTrickleInterval = 2 * time.Second
+
+// Max number of IDs in one announcement
+const InvMaxItems = 1000
+
+// Number of IDs remembered for every node. Oldest are forgotten first
+const InvMaxKnown = 5000
+
+// Time to wait for a requested transaction before it is requested from other node
+const InvRequestTimeout = 30 * time.Second
+
+type peerInventory struct {
+	addr       netlib.NodeAddr
+	known      map[string]bool
+	knownOrder []string
+	queue      [][]byte
+	next       time.Time // time when the queue can be announced
+}
+
+// Transactions IDs to announce to a node
+type InvAnnouncement struct {
+	Addr  netlib.NodeAddr
+	Items [][]byte
+}
+
+// Inventory of other nodes. Same object is shared by all clients of a node
+type InventoryRelay struct {
+	lock      sync.Mutex
+	peers     map[string]*peerInventory
+	requested map[string]time.Time
+}
+
+func NewInventoryRelay() *InventoryRelay {
+	r := &InventoryRelay{}
+	r.peers = make(map[string]*peerInventory)
+	r.requested = make(map[string]time.Time)
+
+	return r
+}
+
+func (r *InventoryRelay) getPeer(addr netlib.NodeAddr) *peerInventory {
+	key := addr.NodeAddrToString()
+
+	p, ok := r.peers[key]
+
+	if !ok {
+		p = &peerInventory{addr: addr, known: make(map[string]bool)}
+		p.next = time.Now().Add(trickleDelay())
+		r.peers[key] = p
+	}
+	return p
+}
+
+func (p *peerInventory) addKnown(id []byte) {
+	key := string(id)
+
+	if p.known[key] {
+		return
+	}
+
+	if len(p.knownOrder) >= InvMaxKnown {
+		delete(p.known, p.knownOrder[0])
+		p.knownOrder = p.knownOrder[1:]
+	}
+	p.known[key] = true
+	p.knownOrder = append(p.knownOrder, key)
+}
+
+// Random delay with exponential distribution. Times of announcements can not be used to find
+// a node where a transaction was created
+func trickleDelay() time.Duration {
+	delay := time.Duration(rand.ExpFloat64() * float64(InvTrickleInterval))
+
+	if delay > 4*InvTrickleInterval {
+		delay = 4 * InvTrickleInterval
+	}
+	return delay
+}
+
+// Remembers that a node has a transaction. It is not announced to the node
+func (r *InventoryRelay) AddKnown(addr netlib.NodeAddr, id []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.getPeer(addr).addKnown(id)
+}
+
+// Checks if a node has a transaction
+func (r *InventoryRelay) IsKnown(addr netlib.NodeAddr, id []byte) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, ok := r.peers[addr.NodeAddrToString()]
+
+	return ok && p.known[string(id)]
+}
+
+// Adds a transaction to announcements for nodes. Nodes that have it are skipped
+func (r *InventoryRelay) Queue(addrs []netlib.NodeAddr, id []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, addr := range addrs {
+		p := r.getPeer(addr)
+
+		if p.known[string(id)] {
+			continue
+		}
+		// don't announce it twice
+		p.addKnown(id)
+		p.queue = append(p.queue, id)
+	}
+}
+
+// Returns announcements which delay is over. Next delay for every returned node is started
+func (r *InventoryRelay) TakeReady() []InvAnnouncement {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	result := []InvAnnouncement{}
+
+	for _, p := range r.peers {
+		if len(p.queue) == 0 || now.Before(p.next) {
+			continue
+		}
+
+		count := len(p.queue)
+
+		if count > InvMaxItems {
+			count = InvMaxItems
+		}
+		result = append(result, InvAnnouncement{p.addr, p.queue[:count]})
+		p.queue = p.queue[count:]
+		p.next = now.Add(trickleDelay())
+	}
+	return result
+}
+
+// Returns true if a transaction should be requested. False is returned if it was requested
+// from other node recently and the response can be still received
+func (r *InventoryRelay) Request(id []byte) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+
+	for key, t := range r.requested {
+		if now.Sub(t) > InvRequestTimeout {
+			delete(r.requested, key)
+		}
+	}
+
+	if _, ok := r.requested[string(id)]; ok {
+		return false
+	}
+	r.requested[string(id)] = now
+
+	return true
+}
+
+// Forgets a request of a transaction when it is received
+func (r *InventoryRelay) Received(id []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.requested, string(id))
+}
+
+// Forgets a node. It is done when a node is refused
+func (r *InventoryRelay) Remove(addr netlib.NodeAddr) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.peers, addr.NodeAddrToString())
+}
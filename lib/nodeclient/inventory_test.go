@@ -0,0 +1,72 @@
+package nodeclient
+
+import (
+	"testing"
+	"time"
+
+	netlib "github.com/NlaakStudios/democoin/lib/net"
+)
+
+func TestInventoryRelay(t *testing.T) {
+	r := NewInventoryRelay()
+
+	a := netlib.NodeAddr{Host: "10.0.0.1", Port: 20000}
+	b := netlib.NodeAddr{Host: "10.0.0.2", Port: 20000}
+
+	// a announced the transaction to us. it is not announced back
+	r.AddKnown(a, []byte{1})
+	r.Queue([]netlib.NodeAddr{a, b}, []byte{1})
+	r.Queue([]netlib.NodeAddr{a, b}, []byte{2})
+	r.Queue([]netlib.NodeAddr{a, b}, []byte{2})
+
+	// nothing is sent till delays are over
+	for _, p := range r.peers {
+		p.next = time.Now().Add(time.Hour)
+	}
+
+	if len(r.TakeReady()) != 0 {
+		t.Fatalf("Announcements are sent before delay")
+	}
+
+	for _, p := range r.peers {
+		p.next = time.Now()
+	}
+
+	items := map[string]int{}
+
+	for _, ann := range r.TakeReady() {
+		items[ann.Addr.NodeAddrToString()] = len(ann.Items)
+	}
+
+	if items[a.NodeAddrToString()] != 1 || items[b.NodeAddrToString()] != 2 {
+		t.Fatalf("Wrong announcements %v", items)
+	}
+
+	if !r.IsKnown(b, []byte{2}) {
+		t.Fatalf("Announced transaction is not known by the node")
+	}
+
+	if len(r.TakeReady()) != 0 {
+		t.Fatalf("Announcements are sent twice")
+	}
+
+	// transaction is requested only from one node
+	if !r.Request([]byte{3}) || r.Request([]byte{3}) {
+		t.Fatalf("Transaction is requested twice")
+	}
+
+	r.Received([]byte{3})
+
+	if !r.Request([]byte{3}) {
+		t.Fatalf("Received transaction can not be requested again")
+	}
+
+	// old IDs are forgotten
+	for i := 0; i < InvMaxKnown; i++ {
+		r.AddKnown(a, []byte{byte(i), byte(i >> 8), 5})
+	}
+
+	if r.IsKnown(a, []byte{1}) {
+		t.Fatalf("Known inventory is not limited")
+	}
+}
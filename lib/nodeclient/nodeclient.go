@@ -26,6 +26,10 @@ type NodeClient struct {
 	Logger      *utils.LoggerMan
 	NodeNet     *netlib.NodeNetwork
 	NodeAuthStr string
+	Sessions    *PeerSessions     // persistent connections to other nodes. If nil, new connection is used for every command
+	Transport   *netlib.Transport // encrypted connections. If nil, plain TCP is used
+	Peers       *PeersInfo        // features of other nodes. If nil, all features are expected
+	Inventory   *InventoryRelay   // transactions known by other nodes. If nil, transactions are announced at once
 }
 
 type ComBlock struct {
@@ -33,6 +37,13 @@ type ComBlock struct {
 	Block    []byte
 }
 
+// Request for transactions missing to rebuild a compact block. Indexes are positions in the block
+type ComGetBlockTransactions struct {
+	AddrFrom  netlib.NodeAddr
+	BlockHash []byte
+	Indexes   []int
+}
+
 // this struct can be used for 2 commands. to get blocks starting from some block to down or to up
 type ComGetBlocks struct {
 	AddrFrom  netlib.NodeAddr
@@ -54,9 +65,9 @@ type ComGetData struct {
 
 // Wallet Balance response
 type ComWalletBalance struct {
-	Total    float64
-	Approved float64
-	Pending  float64
+	Total    int64
+	Approved int64
+	Pending  int64
 }
 
 // Request for a wallet balance
@@ -83,7 +94,8 @@ type ComNewTransactionData struct {
 type ComRequestTransaction struct {
 	PubKey    []byte
 	To        string
-	Amount    float64
+	Amount    int64
+	Fee       int64  // what is not returned as a change goes to a miner
 	Signature []byte // to confirm request is from owner of PubKey (TODO)
 }
 
@@ -104,7 +116,7 @@ type ComGetUnspentTransactions struct {
 type ComUnspentTransaction struct {
 	TXID   []byte
 	Vout   int
-	Amount float64
+	Amount int64
 	IsBase bool
 	From   string
 }
@@ -115,18 +127,22 @@ type ComUnspentTransactions struct {
 	LastBlock    []byte
 }
 
-// Request for history of transactions
+// Request for history of transactions. Records are returned from the top of the chain.
+// Only blocks below BeforeHeight are used if it is not 0. 0 MaxCount means full history
 type ComGetHistoryTransactions struct {
-	Address string
+	Address      string
+	BeforeHeight int
+	MaxCount     int
 }
 
 // Record of transaction in list of history transactions
 type ComHistoryTransaction struct {
 	IOType bool // In (false) or Out (true)
 	TXID   []byte
-	Amount float64
+	Amount int64
 	From   string
 	To     string
+	Height int
 }
 
 // Request for inventory. It can be used to get blocks and transactions from other node
@@ -144,9 +160,19 @@ type ComTx struct {
 
 // Version mesage to other nodes
 type ComVersion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   netlib.NodeAddr
+	Version     int
+	BestHeight  int
+	AddrFrom    netlib.NodeAddr
+	AddrRecv    netlib.NodeAddr // address of the receiver used by the sender. Is used to find external address
+	GenesisHash []byte
+	Services    uint64
+	UserAgent   string
+}
+
+// Handshake of a persistent session. Sent by both sides. Other node responds with same structure
+type ComSessionVersion struct {
+	Version  int
+	AddrFrom netlib.NodeAddr
 }
 
 // To send nodes manage command.
@@ -154,6 +180,36 @@ type ComManageNode struct {
 	Node netlib.NodeAddr
 }
 
+// Score and ban status of a node. Port is 0 if a banned host is not in the list of nodes
+type ComNodeInfo struct {
+	Node       netlib.NodeAddr
+	Score      int
+	BannedTill int64 // unix time. 0 if the node is not banned
+}
+
+// Request for headers of blocks in main chain. Locator is a list of hashes of blocks
+// known to a requester. Headers after first found block are returned
+type ComGetHeaders struct {
+	AddrFrom netlib.NodeAddr
+	Locator  [][]byte
+	MaxCount int
+}
+
+// Request for a proof that a transaction is in a block
+type ComGetTransactionProof struct {
+	TXID      []byte
+	BlockHash []byte
+}
+
+// Merkle proof of a transaction. Header is empty for old blocks without the Merkle root in a header
+type ComTransactionProof struct {
+	TXData     []byte // transaction data hashed as a leaf of the Merkle tree. Starts with TXID
+	Proof      []utils.MerkleProofStep
+	MerkleRoot []byte
+	Header     []byte // serialized block header. Hash of it is the block hash
+	Height     int
+}
+
 // To get node state
 type ComGetNodeState struct {
 	Host                  string
@@ -193,7 +249,17 @@ func (c *NodeClient) SetNodeAddress(address netlib.NodeAddr) {
 func (c *NodeClient) SendVoid(address netlib.NodeAddr) error {
 	request := netlib.CommandToBytes("viod")
 
-	return c.SendData(address, request)
+	// must be new plain connection to unblock waiting for connections
+	conn, err := net.DialTimeout(netlib.Protocol, address.NodeAddrToString(), 1*time.Second)
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(request)
+
+	return err
 }
 
 // Send list of nodes addresses to other node
@@ -207,6 +273,25 @@ func (c *NodeClient) SendAddrList(address netlib.NodeAddr, addresses []netlib.No
 	return c.SendData(address, request)
 }
 
+// Request random addresses of nodes known by other node
+func (c *NodeClient) SendGetAddr(address netlib.NodeAddr) ([]netlib.NodeAddr, error) {
+	request, err := c.BuildCommandData("getaddr", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := []netlib.NodeAddr{}
+
+	err = c.SendDataWaitResponse(address, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Addr Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
 // Send block to other node
 func (c *NodeClient) SendBlock(addr netlib.NodeAddr, BlockSerialised []byte) error {
 	data := ComBlock{c.NodeAddress, BlockSerialised}
@@ -219,6 +304,18 @@ func (c *NodeClient) SendBlock(addr netlib.NodeAddr, BlockSerialised []byte) err
 	return c.SendData(addr, request)
 }
 
+// Send compact block to other node. It contains header and short IDs of transactions
+func (c *NodeClient) SendCompactBlock(addr netlib.NodeAddr, compactBlock []byte) error {
+	data := ComBlock{c.NodeAddress, compactBlock}
+	request, err := c.BuildCommandData("cmpctblock", &data)
+
+	if err != nil {
+		return err
+	}
+
+	return c.SendData(addr, request)
+}
+
 // Send inventory. Blocks hashes or transactions IDs
 func (c *NodeClient) SendInv(address netlib.NodeAddr, kind string, items [][]byte) error {
 	data := ComInv{c.NodeAddress, kind, items}
@@ -304,9 +401,41 @@ func (c *NodeClient) SendTx(addr netlib.NodeAddr, tnxserialised []byte) error {
 	return c.SendData(addr, request)
 }
 
-// Send own version and blockchain state to other node
-func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int) error {
-	data := ComVersion{netlib.NodeVersion, bestHeight, c.NodeAddress}
+// Returns service bits of this node
+func (c *NodeClient) GetServices() uint64 {
+	services := uint64(netlib.NodeServices)
+
+	if c.Transport != nil {
+		services |= netlib.ServiceEncryption
+	}
+	return services
+}
+
+// Checks if other node supports a feature. If the node didn't send version yet then
+// it is expected to support. Command will fail if it doesn't
+func (c *NodeClient) PeerSupports(addr netlib.NodeAddr, service uint64) bool {
+	if c.Peers == nil {
+		return true
+	}
+
+	info, ok := c.Peers.Get(addr)
+
+	if !ok {
+		return true
+	}
+	return info.HasService(service)
+}
+
+// Send own version, features and blockchain state to other node
+func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int, genesisHash []byte) error {
+	data := ComVersion{}
+	data.Version = netlib.NodeVersion
+	data.BestHeight = bestHeight
+	data.AddrFrom = c.NodeAddress
+	data.AddrRecv = addr
+	data.GenesisHash = genesisHash
+	data.Services = c.GetServices()
+	data.UserAgent = netlib.NodeUserAgent
 
 	request, err := c.BuildCommandData("version", &data)
 
@@ -318,8 +447,9 @@ func (c *NodeClient) SendVersion(addr netlib.NodeAddr, bestHeight int) error {
 }
 
 // Request for history of transaction from a wallet
-func (c *NodeClient) SendGetHistory(addr netlib.NodeAddr, address string) ([]ComHistoryTransaction, error) {
-	data := ComGetHistoryTransactions{address}
+// Records of a block are not split, so a next page can be requested with height of last record
+func (c *NodeClient) SendGetHistory(addr netlib.NodeAddr, address string, beforeHeight int, maxCount int) ([]ComHistoryTransaction, error) {
+	data := ComGetHistoryTransactions{address, beforeHeight, maxCount}
 
 	request, err := c.BuildCommandData("gethistory", &data)
 
@@ -389,12 +519,13 @@ func (c *NodeClient) SendNewTransactionData(addr netlib.NodeAddr, from string, t
 // It returns a transaction without signature.
 // Wallet has to sign it and then use SendNewTransaction to send completed transaction
 func (c *NodeClient) SendRequestNewTransaction(addr netlib.NodeAddr,
-	PubKey []byte, to string, amount float64) ([]byte, [][]byte, error) {
+	PubKey []byte, to string, amount int64, fee int64) ([]byte, [][]byte, error) {
 
 	data := ComRequestTransaction{}
 	data.PubKey = PubKey
 	data.To = to
 	data.Amount = amount
+	data.Fee = fee
 
 	request, err := c.BuildCommandData("txrequest", &data)
 
@@ -449,6 +580,92 @@ func (c *NodeClient) SendGetBalance(addr netlib.NodeAddr, address string) (ComWa
 	return datapayload, nil
 }
 
+// Request for headers of blocks following a common block. Returns list of serialised headers
+// It is used on header-first sync
+func (c *NodeClient) SendGetHeaders(addr netlib.NodeAddr, locator [][]byte, maxcount int) ([][]byte, error) {
+	data := ComGetHeaders{c.NodeAddress, locator, maxcount}
+
+	request, err := c.BuildCommandData("getheaders", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := [][]byte{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload, nil
+}
+
+// Request for full block data. Unlike getdata the block is returned as a response
+func (c *NodeClient) SendGetBlock(addr netlib.NodeAddr, hash []byte) ([]byte, error) {
+	data := ComGetData{c.NodeAddress, "block", hash}
+
+	request, err := c.BuildCommandData("getblock", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := []byte{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload, nil
+}
+
+// Request for transactions of a block by indexes. Returns serialized transactions in same order
+func (c *NodeClient) SendGetBlockTransactions(addr netlib.NodeAddr, hash []byte, indexes []int) ([][]byte, error) {
+	data := ComGetBlockTransactions{c.NodeAddress, hash, indexes}
+
+	request, err := c.BuildCommandData("getblocktxn", &data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := [][]byte{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return datapayload, nil
+}
+
+// Request for Merkle proof of a transaction in a block
+// The proof must be checked with Verify. A wallet should not trust a node
+func (c *NodeClient) SendGetTransactionProof(addr netlib.NodeAddr, txID []byte, blockHash []byte) (ComTransactionProof, error) {
+	data := ComGetTransactionProof{txID, blockHash}
+
+	request, err := c.BuildCommandData("gettxproof", &data)
+
+	if err != nil {
+		return ComTransactionProof{}, err
+	}
+
+	datapayload := ComTransactionProof{}
+
+	err = c.SendDataWaitResponse(addr, request, &datapayload)
+
+	if err != nil {
+		return ComTransactionProof{}, err
+	}
+
+	return datapayload, nil
+}
+
 // Request for list of nodes in contacts
 func (c *NodeClient) SendGetNodes() ([]netlib.NodeAddr, error) {
 	request, err := c.BuildCommandData("getnodes", nil)
@@ -464,6 +681,25 @@ func (c *NodeClient) SendGetNodes() ([]netlib.NodeAddr, error) {
 	return datapayload, nil
 }
 
+// Request for list of nodes with scores and bans
+func (c *NodeClient) SendGetNodesInfo() ([]ComNodeInfo, error) {
+	request, err := c.BuildCommandDataWithAuth("getnodesinfo", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	datapayload := []ComNodeInfo{}
+
+	err = c.SendDataWaitResponse(c.NodeAddress, request, &datapayload)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Get Nodes Info Response Error: %s", err.Error()))
+	}
+
+	return datapayload, nil
+}
+
 // Request to add new node to contacts
 func (c *NodeClient) SendAddNode(node netlib.NodeAddr) error {
 	data := ComManageNode{node}
@@ -556,6 +792,7 @@ func (c *NodeClient) doBuildCommandData(command string, data interface{}, extra
 }
 
 // Sends prepared command to a node. This doesn't wait any response
+// Persistent session is used if it is enabled and other node supports it
 func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 	err := c.CheckNodeAddress(addr)
 
@@ -563,19 +800,32 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 		return err
 	}
 
+	if c.Sessions != nil && c.PeerSupports(addr, netlib.ServiceSessions) {
+		_, err := c.Sessions.Send(addr, c.NodeAddress, data, false)
+
+		if err != ErrSessionNotSupported {
+			c.updateAddress(addr, err == nil)
+			return err
+		}
+	}
+
+	err = c.sendDataDirect(addr, data)
+
+	c.updateAddress(addr, err == nil)
+
+	return err
+}
+
+// Sends prepared command to a node with new connection. This doesn't wait any response
+func (c *NodeClient) sendDataDirect(addr netlib.NodeAddr, data []byte) error {
 	c.Logger.Trace.Printf("Sending %d bytes to %s", len(data), addr.NodeAddrToString())
-	conn, err := net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), 1*time.Second)
+	conn, err := c.dial(addr, 1*time.Second)
 
 	if err != nil {
 		c.Logger.Error.Println(err.Error())
 		c.Logger.Trace.Println("Error: ", err.Error())
 
-		// we can not connect.
-		// we could remove this node from known
-		// but this is not always good. we need somethign more smart here
-		// TODO this needs analysis . if removing of a node is good idea
-		//c.NodeNet.RemoveNodeFromKnown(addr)
-
+		// we can not connect. SendData remembers the failure in the address book
 		return errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
 	}
 	defer conn.Close()
@@ -591,6 +841,7 @@ func (c *NodeClient) SendData(addr netlib.NodeAddr, data []byte) error {
 }
 
 // Send data to a node and wait for response
+// Persistent session is used if it is enabled and other node supports it
 func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, datapayload interface{}) error {
 
 	err := c.CheckNodeAddress(addr)
@@ -600,20 +851,31 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 		return err
 	}
 
+	if c.Sessions != nil && c.PeerSupports(addr, netlib.ServiceSessions) {
+		response, err := c.Sessions.Send(addr, c.NodeAddress, data, true)
+
+		if err == nil {
+			c.updateAddress(addr, true)
+			return c.parseResponse(response, datapayload)
+		}
+
+		if err != ErrSessionNotSupported {
+			c.updateAddress(addr, false)
+			return err
+		}
+	}
+
 	c.Logger.Trace.Println("Sending data to " + addr.NodeAddrToString() + " and waiting response")
 
 	// connect
-	conn, err := net.Dial(netlib.Protocol, addr.NodeAddrToString())
+	conn, err := c.dial(addr, 0)
 
 	if err != nil {
 		c.Logger.Error.Println(err.Error())
 		c.Logger.Trace.Println("Error: ", err.Error())
 
-		// we can not connect.
-		// we could remove this node from known
-		// but this is not always good. we need somethign more smart here
-		// TODO this needs analysis . if removing of a node is good idea
-		//c.NodeNet.RemoveNodeFromKnown(addr)
+		// we can not connect. the node is not used after few failures
+		c.updateAddress(addr, false)
 
 		return errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
 	}
@@ -640,6 +902,35 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 		return err
 	}
 
+	c.updateAddress(addr, true)
+
+	return c.parseResponse(response, datapayload)
+}
+
+// Remembers result of a connection to a node in the address book
+func (c *NodeClient) updateAddress(addr netlib.NodeAddr, success bool) {
+	if c.NodeNet == nil {
+		return
+	}
+
+	if success {
+		c.NodeNet.MarkGood(addr)
+	} else {
+		c.NodeNet.MarkAttempt(addr)
+	}
+}
+
+// Connects to a node. Connection is encrypted if the transport is set and other node supports it
+func (c *NodeClient) dial(addr netlib.NodeAddr, timeout time.Duration) (net.Conn, error) {
+	if c.Transport != nil {
+		return c.Transport.Dial(addr, timeout)
+	}
+	return net.DialTimeout(netlib.Protocol, addr.NodeAddrToString(), timeout)
+}
+
+// Decodes a response of a node to provided structure. First byte of a response is 1 if
+// request was success. Otherwise the response contains an error message
+func (c *NodeClient) parseResponse(response []byte, datapayload interface{}) error {
 	if len(response) == 0 {
 		err := errors.New("Received 0 bytes as a response. Expected at least 1 byte")
 		c.Logger.Error.Println(err.Error())
@@ -654,7 +945,20 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 	buff.Write(response[1:])
 	dec := gob.NewDecoder(&buff)
 
-	if response[0] != 1 {
+	if response[0] == netlib.ResponseTypedError {
+		// request was refused. error kind is known
+		payload := netlib.RequestError{}
+
+		err := dec.Decode(&payload)
+
+		if err != nil {
+			return err
+		}
+
+		return &payload
+	}
+
+	if response[0] != netlib.ResponseSuccess {
 		// fail
 
 		var payload string
@@ -669,7 +973,7 @@ func (c *NodeClient) SendDataWaitResponse(addr netlib.NodeAddr, data []byte, dat
 	}
 
 	if datapayload != nil {
-		err = dec.Decode(datapayload)
+		err := dec.Decode(datapayload)
 
 		if err != nil {
 			return err
@@ -0,0 +1,67 @@
+package nodeclient
+
+import (
+	"sync"
+	"time"
+
+	netlib "github.com/NlaakStudios/democoin/lib/net"
+)
+
+// What other node told about itself in version command
+type PeerInfo struct {
+	Version   int
+	Services  uint64
+	UserAgent string
+	Updated   time.Time
+}
+
+// Checks if a node supports a feature
+func (p PeerInfo) HasService(service uint64) bool {
+	return p.Services&service == service
+}
+
+// Features of other nodes. Same object is shared by all clients of a node
+type PeersInfo struct {
+	lock  sync.Mutex
+	peers map[string]PeerInfo
+}
+
+func NewPeersInfo() *PeersInfo {
+	p := &PeersInfo{}
+	p.peers = make(map[string]PeerInfo)
+
+	return p
+}
+
+// Remembers features of a node. Returns false if nothing was known about the node before
+func (p *PeersInfo) Set(addr netlib.NodeAddr, info PeerInfo) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := addr.NodeAddrToString()
+
+	_, known := p.peers[key]
+
+	info.Updated = time.Now()
+	p.peers[key] = info
+
+	return known
+}
+
+// Returns features of a node. Second value is false if the node didn't send version yet
+func (p *PeersInfo) Get(addr netlib.NodeAddr) (PeerInfo, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	info, ok := p.peers[addr.NodeAddrToString()]
+
+	return info, ok
+}
+
+// Forgets a node. It is done when a node is refused
+func (p *PeersInfo) Remove(addr netlib.NodeAddr) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.peers, addr.NodeAddrToString())
+}
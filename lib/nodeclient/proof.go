@@ -0,0 +1,40 @@
+package nodeclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/NlaakStudios/democoin/lib"
+	"github.com/NlaakStudios/democoin/lib/utils"
+)
+
+// Checks the proof of a transaction. The transaction must be the leaf of Merkle tree,
+// the tree root must be in the block header and the header must have the block hash
+func (p *ComTransactionProof) Verify(txID []byte, blockHash []byte) error {
+	if !bytes.HasPrefix(p.TXData, txID) {
+		return errors.New("Proof is for other transaction")
+	}
+
+	if !utils.VerifyMerkleProof(p.TXData, p.Proof, p.MerkleRoot) {
+		return errors.New("Merkle branch doesn't match the root")
+	}
+
+	if len(p.Header) != lib.BlockHeaderLength {
+		return errors.New("Block has no header with Merkle root")
+	}
+
+	headerHash := sha256.Sum256(p.Header)
+
+	if !bytes.Equal(headerHash[:], blockHash) {
+		return errors.New("Block header doesn't match the block hash")
+	}
+
+	merkleRoot := p.Header[lib.BlockHeaderMerkleRootOffset : lib.BlockHeaderMerkleRootOffset+lib.BlockHeaderHashLength]
+
+	if !bytes.Equal(merkleRoot, p.MerkleRoot) {
+		return errors.New("Merkle root is not in the block header")
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package nodeclient
+
+import (
+	"testing"
+
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+func TestTransactionProofVerify(t *testing.T) {
+	b := &structures.Block{}
+
+	for i := 0; i < 5; i++ {
+		tx := &transaction.Transaction{}
+		tx.ID = []byte{byte(i + 1)}
+		tx.Vin = []transaction.TXInput{}
+		tx.Vout = []transaction.TXOutput{transaction.TXOutput{Value: int64(i), PubKeyHash: []byte{byte(i)}}}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	err := b.PrepareNewBlock(b.Transactions, []byte{}, 0)
+
+	if err != nil {
+		t.Fatalf("Prepare error: %s", err.Error())
+	}
+
+	b.Hash = b.GetHeader().Hash()
+
+	p := ComTransactionProof{}
+
+	p.TXData, p.Proof, p.MerkleRoot, err = b.GetTransactionProof([]byte{4})
+
+	if err != nil {
+		t.Fatalf("Proof error: %s", err.Error())
+	}
+
+	p.Header = b.GetHeader().Serialize()
+
+	err = p.Verify([]byte{4}, b.Hash)
+
+	if err != nil {
+		t.Fatalf("Proof must be valid: %s", err.Error())
+	}
+
+	if p.Verify([]byte{4}, []byte{1, 2, 3}) == nil {
+		t.Fatalf("Proof must not fit other block")
+	}
+
+	p.Header = p.Header[:len(p.Header)-1]
+
+	if p.Verify([]byte{4}, b.Hash) == nil {
+		t.Fatalf("Proof with broken header must fail")
+	}
+}
@@ -0,0 +1,320 @@
+package nodeclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	netlib "github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/lib/utils"
+)
+
+// Other node doesn't support sessions. Command must be sent with new connection
+var ErrSessionNotSupported = errors.New("Persistent session is not supported by the node")
+
+const sessionDialTimeout = 3 * time.Second
+const sessionWriteTimeout = 30 * time.Second
+const sessionResponseTimeout = 2 * time.Minute
+
+// Node that doesn't support sessions is not asked again during this time
+const sessionRetryInterval = 10 * time.Minute
+
+// Persistent connections to other nodes. Same object is shared by all clients of a node
+// Every connection is used for many requests. Requests are sent without waiting responses
+// of previous requests. Responses are matched to requests by request ID
+type PeerSessions struct {
+	Logger    *utils.LoggerMan
+	Transport *netlib.Transport
+
+	lock        sync.Mutex
+	sessions    map[string]*peerSession
+	notSupports map[string]time.Time
+}
+
+// Connection to one node
+type peerSession struct {
+	addr      netlib.NodeAddr
+	logger    *utils.LoggerMan
+	transport *netlib.Transport
+
+	connectLock sync.Mutex // only one routine opens a connection
+	writeLock   sync.Mutex
+
+	lock    sync.Mutex
+	conn    net.Conn
+	nextID  uint32
+	pending map[uint32]chan sessionResponse
+}
+
+type sessionResponse struct {
+	data []byte
+	err  error
+}
+
+func NewPeerSessions(logger *utils.LoggerMan, transport *netlib.Transport) *PeerSessions {
+	p := &PeerSessions{}
+	p.Logger = logger
+	p.Transport = transport
+	p.sessions = make(map[string]*peerSession)
+	p.notSupports = make(map[string]time.Time)
+
+	return p
+}
+
+// Sends a command to a node using a session. Opens new session if there is no yet
+// Returns response data if waitResponse is true
+// ErrSessionNotSupported is returned if the node can not work with sessions
+func (p *PeerSessions) Send(addr netlib.NodeAddr, from netlib.NodeAddr, data []byte, waitResponse bool) ([]byte, error) {
+	s, err := p.getSession(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// connection could be closed by other side since last use. try again with new connection
+	for attempt := 0; ; attempt++ {
+		err = s.connect(from)
+
+		if err == ErrSessionNotSupported {
+			p.setNotSupported(addr)
+			return nil, err
+		}
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s is not available", addr.NodeAddrToString()))
+		}
+
+		response, sent, err := s.request(data, waitResponse)
+
+		if err != nil && !sent && attempt == 0 {
+			continue
+		}
+		return response, err
+	}
+}
+
+// Closes all sessions. It is done when a node stops
+func (p *PeerSessions) CloseAll() {
+	p.lock.Lock()
+	sessions := p.sessions
+	p.sessions = make(map[string]*peerSession)
+	p.lock.Unlock()
+
+	for _, s := range sessions {
+		s.close(nil, errors.New("Session closed"))
+	}
+}
+
+// Returns session object for a node. Connection is not opened here
+func (p *PeerSessions) getSession(addr netlib.NodeAddr) (*peerSession, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := addr.NodeAddrToString()
+
+	if t, ok := p.notSupports[key]; ok {
+		if time.Since(t) < sessionRetryInterval {
+			return nil, ErrSessionNotSupported
+		}
+		delete(p.notSupports, key)
+	}
+
+	s, ok := p.sessions[key]
+
+	if !ok {
+		s = &peerSession{addr: addr, logger: p.Logger, transport: p.Transport}
+		p.sessions[key] = s
+	}
+	return s, nil
+}
+
+func (p *PeerSessions) setNotSupported(addr netlib.NodeAddr) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	key := addr.NodeAddrToString()
+
+	p.notSupports[key] = time.Now()
+	delete(p.sessions, key)
+}
+
+// Opens a connection and does handshake if the session is not connected
+func (s *peerSession) connect(from netlib.NodeAddr) error {
+	s.connectLock.Lock()
+	defer s.connectLock.Unlock()
+
+	s.lock.Lock()
+	connected := s.conn != nil
+	s.lock.Unlock()
+
+	if connected {
+		return nil
+	}
+
+	client := NodeClient{Logger: s.logger, Transport: s.transport}
+
+	conn, err := client.dial(s.addr, sessionDialTimeout)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.handshake(conn, from)
+
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.lock.Lock()
+	s.conn = conn
+	s.pending = make(map[uint32]chan sessionResponse)
+	s.lock.Unlock()
+
+	go s.readResponses(conn)
+
+	s.logger.Trace.Printf("Session opened with %s", s.addr.NodeAddrToString())
+
+	return nil
+}
+
+// Sends version of a session protocol and waits for verack frame
+// Node that doesn't know sessions closes connection without a response
+func (s *peerSession) handshake(conn net.Conn, from netlib.NodeAddr) error {
+	client := NodeClient{Logger: s.logger}
+
+	request, err := client.BuildCommandData(netlib.SessionCommand, &ComSessionVersion{netlib.SessionProtocolVersion, from})
+
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(sessionDialTimeout))
+
+	_, err = conn.Write(request)
+
+	if err != nil {
+		return err
+	}
+
+	_, kind, body, err := netlib.ReadSessionFrame(conn, netlib.SessionMaxFrameLength)
+
+	if err != nil || kind != netlib.SessionFrameVerack {
+		return ErrSessionNotSupported
+	}
+
+	version := ComSessionVersion{}
+
+	err = client.parseResponse(body, &version)
+
+	if err != nil {
+		return err
+	}
+
+	if version.Version < 1 {
+		return ErrSessionNotSupported
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return nil
+}
+
+// Sends a request. If response is needed then waits for it
+// Returns false as second value if the request was not sent
+func (s *peerSession) request(data []byte, waitResponse bool) ([]byte, bool, error) {
+	s.lock.Lock()
+
+	conn := s.conn
+
+	if conn == nil {
+		s.lock.Unlock()
+		return nil, false, errors.New(fmt.Sprintf("%s is not available", s.addr.NodeAddrToString()))
+	}
+
+	s.nextID++
+	id := s.nextID
+
+	kind := byte(netlib.SessionFrameRequest)
+	var responseChan chan sessionResponse
+
+	if waitResponse {
+		kind = netlib.SessionFrameRequestResponse
+		responseChan = make(chan sessionResponse, 1)
+		s.pending[id] = responseChan
+	}
+	s.lock.Unlock()
+
+	s.writeLock.Lock()
+	conn.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+	err := netlib.WriteSessionFrame(conn, id, kind, data)
+	s.writeLock.Unlock()
+
+	if err != nil {
+		s.close(conn, err)
+		return nil, false, err
+	}
+
+	if !waitResponse {
+		return nil, true, nil
+	}
+
+	select {
+	case r := <-responseChan:
+		return r.data, true, r.err
+	case <-time.After(sessionResponseTimeout):
+		s.lock.Lock()
+		delete(s.pending, id)
+		s.lock.Unlock()
+
+		return nil, true, errors.New(fmt.Sprintf("No response from %s", s.addr.NodeAddrToString()))
+	}
+}
+
+// Reads responses and passes them to waiting requests. Works till a connection is closed
+func (s *peerSession) readResponses(conn net.Conn) {
+	for {
+		id, kind, body, err := netlib.ReadSessionFrame(conn, netlib.SessionMaxFrameLength)
+
+		if err != nil {
+			s.close(conn, err)
+			return
+		}
+
+		if kind != netlib.SessionFrameResponse {
+			continue
+		}
+
+		s.lock.Lock()
+		responseChan, ok := s.pending[id]
+		delete(s.pending, id)
+		s.lock.Unlock()
+
+		if ok {
+			responseChan <- sessionResponse{body, nil}
+		}
+	}
+}
+
+// Closes a connection. All waiting requests get an error. Next request opens new connection
+// Nothing is done if the session already uses other connection
+func (s *peerSession) close(conn net.Conn, reason error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil || (conn != nil && s.conn != conn) {
+		return
+	}
+
+	s.logger.Trace.Printf("Session with %s closed: %s", s.addr.NodeAddrToString(), reason.Error())
+
+	s.conn.Close()
+	s.conn = nil
+
+	for id, responseChan := range s.pending {
+		responseChan <- sessionResponse{nil, reason}
+		delete(s.pending, id)
+	}
+}
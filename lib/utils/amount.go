@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/NlaakStudios/democoin/lib"
+)
+
+// Amounts are stored as int64 numbers of smallest units. See lib.CoinUnits
+// Conversion to/from decimal presentation is done only when a user enters or sees an amount
+
+// Parses decimal amount like "1.5" to number of smallest units
+func ParseAmount(amount string) (int64, error) {
+	amount = strings.TrimSpace(amount)
+
+	if amount == "" {
+		return 0, nil
+	}
+
+	negative := false
+
+	if amount[0] == '-' {
+		negative = true
+		amount = amount[1:]
+	}
+
+	parts := strings.Split(amount, ".")
+
+	if len(parts) > 2 || (parts[0] == "" && (len(parts) == 1 || parts[1] == "")) {
+		return 0, errors.New(fmt.Sprintf("Wrong amount format: %s", amount))
+	}
+
+	var whole int64
+
+	if parts[0] != "" {
+		w, err := strconv.ParseUint(parts[0], 10, 63)
+
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Wrong amount format: %s", amount))
+		}
+		whole = int64(w)
+	}
+
+	var fraction int64
+
+	if len(parts) == 2 && parts[1] != "" {
+		f := parts[1]
+
+		if len(f) > lib.CoinDecimals {
+			return 0, errors.New(fmt.Sprintf("Amount can have max %d decimal places", lib.CoinDecimals))
+		}
+
+		f = f + strings.Repeat("0", lib.CoinDecimals-len(f))
+
+		fr, err := strconv.ParseUint(f, 10, 63)
+
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Wrong amount format: %s", amount))
+		}
+		fraction = int64(fr)
+	}
+
+	if whole > (1<<63-1-fraction)/lib.CoinUnits {
+		return 0, errors.New("Amount is too big")
+	}
+
+	value := whole*lib.CoinUnits + fraction
+
+	if negative {
+		value = -value
+	}
+
+	return value, nil
+}
+
+// Formats number of smallest units as decimal amount with 8 decimal places
+func FormatAmount(value int64) string {
+	sign := ""
+
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	return fmt.Sprintf("%s%d.%08d", sign, value/lib.CoinUnits, value%lib.CoinUnits)
+}
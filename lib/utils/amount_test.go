@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmount(t *testing.T) {
+	good := map[string]int64{
+		"0":            0,
+		"1":            100000000,
+		"1.5":          150000000,
+		"0.00000001":   1,
+		".25":          25000000,
+		"12.":          1200000000,
+		"-2.1":         -210000000,
+		" 3.00000010 ": 300000010,
+	}
+
+	for s, expected := range good {
+		v, err := ParseAmount(s)
+
+		assert.NoError(t, err, "Parse "+s)
+		assert.Equal(t, expected, v, "Value of "+s)
+	}
+
+	bad := []string{"1.2.3", "abc", "1.000000001", ".", "1e5", "99999999999999999999"}
+
+	for _, s := range bad {
+		_, err := ParseAmount(s)
+
+		assert.Error(t, err, "Parse "+s)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	assert.Equal(t, "0.00000000", FormatAmount(0))
+	assert.Equal(t, "1.50000000", FormatAmount(150000000))
+	assert.Equal(t, "0.00000001", FormatAmount(1))
+	assert.Equal(t, "-2.10000000", FormatAmount(-210000000))
+
+	v, _ := ParseAmount(FormatAmount(123456789012))
+
+	assert.Equal(t, int64(123456789012), v)
+}
@@ -1,12 +1,22 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"errors"
 )
 
+// Max number of levels a proof step can hash a node with own copy
+const maxMerkleSelfLevels = 1 << 20
+
 // MerkleTree represent a Merkle tree
+// When the top of the tree is reached it is built further by hashing the top node with own copy
+// till number of levels is half of number of leaves. Roots of existing blocks depend on it
 type MerkleTree struct {
 	RootNode *MerkleNode
+	leaves   int // number of data elements
+	depth    int // number of levels above leaves
+	top      int // number of levels till the top node. Levels above it hash the node with own copy
 }
 
 // MerkleNode represent a Merkle tree node
@@ -16,10 +26,20 @@ type MerkleNode struct {
 	Data  []byte
 }
 
+// MerkleProofStep is one step of a Merkle branch. It is a hash of a sibling node
+// Step without a hash is a number of levels where a node is hashed with own copy
+type MerkleProofStep struct {
+	Hash       []byte
+	Left       bool // sibling is on the left side
+	SelfLevels int
+}
+
 // NewMerkleTree creates a new Merkle tree from a sequence of data
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
+	leaves := len(data)
+
 	if len(data)%2 != 0 {
 
 		data = append(data, data[len(data)-1])
@@ -45,11 +65,78 @@ func NewMerkleTree(data [][]byte) *MerkleTree {
 		nodes = newLevel
 	}
 
-	mTree := MerkleTree{&nodes[0]}
+	top := 0
+
+	for n := len(data); n > 1; n = (n + 1) / 2 {
+		top++
+	}
+
+	mTree := MerkleTree{&nodes[0], leaves, len(data) / 2, top}
 
 	return &mTree
 }
 
+// GetProof returns the Merkle branch for a data element with given index
+// Steps are ordered from the leaf to the root. Levels above the top node are one step,
+// so length of a proof is logarithmic
+func (t *MerkleTree) GetProof(index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, errors.New("Index is out of the tree")
+	}
+
+	proof := make([]MerkleProofStep, t.top)
+
+	node := t.RootNode
+
+	// levels above the top node. left and right nodes are same
+	for level := t.depth - 1; level >= t.top; level-- {
+		node = node.Left
+	}
+
+	if t.depth > t.top {
+		proof = append(proof, MerkleProofStep{SelfLevels: t.depth - t.top})
+	}
+
+	// go down from the top node. every bit of the index tells a side
+	for level := t.top - 1; level >= 0; level-- {
+		if (index>>uint(level))&1 == 0 {
+			proof[level] = MerkleProofStep{Hash: node.Right.Data}
+			node = node.Left
+		} else {
+			proof[level] = MerkleProofStep{Hash: node.Left.Data, Left: true}
+			node = node.Right
+		}
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks if the branch connects a data element to the root
+func VerifyMerkleProof(data []byte, proof []MerkleProofStep, root []byte) bool {
+	hash := sha256.Sum256(data)
+
+	for _, step := range proof {
+		if len(step.Hash) == 0 {
+			if step.SelfLevels < 1 || step.SelfLevels > maxMerkleSelfLevels {
+				return false
+			}
+
+			for i := 0; i < step.SelfLevels; i++ {
+				hash = sha256.Sum256(bytes.Join([][]byte{hash[:], hash[:]}, []byte{}))
+			}
+			continue
+		}
+
+		if step.Left {
+			hash = sha256.Sum256(bytes.Join([][]byte{step.Hash, hash[:]}, []byte{}))
+		} else {
+			hash = sha256.Sum256(bytes.Join([][]byte{hash[:], step.Hash}, []byte{}))
+		}
+	}
+
+	return bytes.Equal(hash[:], root)
+}
+
 // NewMerkleNode creates a new Merkle tree node
 func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	mNode := MerkleNode{}
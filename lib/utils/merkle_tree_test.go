@@ -98,3 +98,53 @@ func TestCases(t *testing.T) {
 	}
 
 }
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		data := [][]byte{}
+
+		for i := 0; i < size; i++ {
+			data = append(data, []byte(fmt.Sprintf("node%d", i)))
+		}
+
+		mTree := NewMerkleTree(data)
+
+		for i := 0; i < size; i++ {
+			proof, err := mTree.GetProof(i)
+
+			assert.Nil(t, err, "Proof is created")
+			assert.True(t, VerifyMerkleProof(data[i], proof, mTree.RootNode.Data),
+				fmt.Sprintf("Proof for %d of %d is correct", i, size))
+			assert.False(t, VerifyMerkleProof([]byte("other"), proof, mTree.RootNode.Data),
+				fmt.Sprintf("Proof for %d of %d doesn't fit other data", i, size))
+		}
+
+		_, err := mTree.GetProof(size)
+
+		assert.NotNil(t, err, "No proof out of the tree")
+	}
+}
+
+func TestMerkleProofLength(t *testing.T) {
+	data := [][]byte{}
+
+	for i := 0; i < 1000; i++ {
+		data = append(data, []byte(fmt.Sprintf("node%d", i)))
+	}
+
+	mTree := NewMerkleTree(data)
+
+	for _, i := range []int{0, 511, 999} {
+		proof, err := mTree.GetProof(i)
+
+		assert.Nil(t, err, "Proof is created")
+		// 10 levels of the tree and one step for levels above the top node
+		assert.Equal(t, 11, len(proof), "Proof length is logarithmic")
+		assert.True(t, VerifyMerkleProof(data[i], proof, mTree.RootNode.Data), "Proof is correct")
+	}
+
+	proof, _ := mTree.GetProof(1)
+	proof[len(proof)-1].SelfLevels++
+
+	assert.False(t, VerifyMerkleProof(data[1], proof, mTree.RootNode.Data), "Wrong number of levels doesn't fit the root")
+}
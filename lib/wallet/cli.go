@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/NlaakStudios/democoin/lib"
 	"github.com/NlaakStudios/democoin/lib/net"
 	"github.com/NlaakStudios/democoin/lib/nodeclient"
 	"github.com/NlaakStudios/democoin/lib/utils"
@@ -17,12 +18,16 @@ type AppInput struct {
 	Command   string
 	Address   string
 	ToAddress string
-	Amount    float64
+	Amount    int64
+	Fee       int64
 	NodePort  int
 	NodeHost  string
 	DataDir   string
 	Nodes     []net.NodeAddr
 	LogDest   string
+	Genesis   string // hex of genesis block hash. Nodes of other networks are not used
+	// connect to nodes only with encryption. there is no fallback to plain connection
+	RequireEncryption bool
 }
 
 type WalletCLI struct {
@@ -38,16 +43,22 @@ type WalletCLI struct {
 
 // Init wallet client object. This will manage execution
 // of tasks related to a wallet
-func (wc *WalletCLI) Init(logger *utils.LoggerMan, input AppInput) {
+func (wc *WalletCLI) Init(logger *utils.LoggerMan, input AppInput) error {
 	wc.Logger = logger
 	wc.Input = input
 	wc.DataDir = input.DataDir
 
-	wc.initNodeClient()
+	err := wc.initNodeClient()
+
+	if err != nil {
+		return err
+	}
 	wc.initWallets()
 
 	wc.Node.Port = wc.Input.NodePort
 	wc.Node.Host = wc.Input.NodeHost
+
+	return nil
 }
 
 // Creates Wallets object and fills it from a file if it exists
@@ -70,18 +81,37 @@ func (wc *WalletCLI) initWallets() error {
 }
 
 // Inits nodeclient object. It is used to communicate with a node
-func (wc *WalletCLI) initNodeClient() {
+func (wc *WalletCLI) initNodeClient() error {
 	if wc.NodeCLI != nil {
-		return
+		return nil
 	}
 	client := nodeclient.NodeClient{}
 
 	client.Logger = wc.Logger
 	nt := net.NodeNetwork{}
 	nt.Init()
+	// nodes from a seed file in the data dir are used before the list on a host
+	nt.Bootstrap = []net.BootstrapSource{
+		net.FileBootstrap{Path: wc.DataDir + net.SeedFileName},
+		net.HTTPBootstrap{URL: lib.InitialNodesList}}
 	client.NodeNet = &nt
 
+	// use encryption if a node supports it. if encryption is required, plain connections are not done
+	transport, err := net.NewTransport(wc.DataDir, wc.Input.RequireEncryption)
+
+	if err != nil {
+		if wc.Input.RequireEncryption {
+			// without transport connections are not encrypted
+			return errors.New("Transport init error: " + err.Error())
+		}
+		wc.Logger.Error.Println("Transport init error: ", err.Error())
+	} else {
+		client.Transport = transport
+	}
+
 	wc.NodeCLI = &client
+
+	return nil
 }
 func (wc *WalletCLI) checkNodeAddress() {
 	if wc.NodeMode {
@@ -90,17 +120,29 @@ func (wc *WalletCLI) checkNodeAddress() {
 	// only if this is wallet mode
 	if wc.Node.Host == "" {
 		// if node address is not set, we can load it from special source
-		wc.NodeCLI.NodeNet.LoadInitialNodes(nil)
+		genesisHash, err := net.DecodeGenesisHash(wc.Input.Genesis)
+
+		if err == nil {
+			err = wc.NodeCLI.NodeNet.LoadInitialNodes(genesisHash)
+		}
+
+		if err != nil {
+			wc.Logger.Trace.Println(err.Error())
+		}
 
 		if wc.NodeCLI.NodeNet.GetCountOfKnownNodes() > 0 {
-			wc.Node = wc.NodeCLI.NodeNet.Nodes[0]
+			wc.Node = wc.NodeCLI.NodeNet.GetNodes()[0]
 		}
 	}
 }
 
 // Executes command based on input arguments
 func (wc *WalletCLI) ExecuteCommand() error {
-	wc.initNodeClient()
+	err := wc.initNodeClient()
+
+	if err != nil {
+		return err
+	}
 
 	if wc.Input.Command != "createwallet" &&
 		wc.Input.Command != "listaddresses" {
@@ -175,7 +217,8 @@ func (wc *WalletCLI) commandListAddressesExt() error {
 			return err
 		}
 
-		fmt.Printf("%s: %.8f (Approved - %.8f, Pending - %.8f)\n", address, balance.Total, balance.Approved, balance.Pending)
+		fmt.Printf("%s: %s (Approved - %s, Pending - %s)\n", address,
+			utils.FormatAmount(balance.Total), utils.FormatAmount(balance.Approved), utils.FormatAmount(balance.Pending))
 	}
 
 	return nil
@@ -190,7 +233,7 @@ func (wc *WalletCLI) commandShowHistory() error {
 	}
 
 	// the wallet has to connect to node to execute this operation
-	list, err := wc.NodeCLI.SendGetHistory(wc.Node, wc.Input.Address)
+	list, err := wc.NodeCLI.SendGetHistory(wc.Node, wc.Input.Address, 0, 0)
 
 	if err != nil {
 		return err
@@ -200,9 +243,9 @@ func (wc *WalletCLI) commandShowHistory() error {
 
 	for _, rec := range list {
 		if rec.IOType {
-			fmt.Printf("%f\t In from\t%s\n", rec.Amount, rec.From)
+			fmt.Printf("%s\t In from\t%s\n", utils.FormatAmount(rec.Amount), rec.From)
 		} else {
-			fmt.Printf("%f\t Out To  \t%s\n", rec.Amount, rec.To)
+			fmt.Printf("%s\t Out To  \t%s\n", utils.FormatAmount(rec.Amount), rec.To)
 		}
 
 	}
@@ -225,15 +268,15 @@ func (wc *WalletCLI) commandUnspentTransactions() error {
 		return err
 	}
 
-	balance := float64(0)
+	balance := int64(0)
 
 	for _, tx := range list.Transactions {
 
-		fmt.Printf("%f\t from\t%s in transaction %s output #%d\n", tx.Amount, tx.From, hex.EncodeToString(tx.TXID), tx.Vout)
+		fmt.Printf("%s\t from\t%s in transaction %s output #%d\n", utils.FormatAmount(tx.Amount), tx.From, hex.EncodeToString(tx.TXID), tx.Vout)
 		balance += tx.Amount
 	}
 
-	fmt.Printf("\nBalance - %f\n", balance)
+	fmt.Printf("\nBalance - %s\n", utils.FormatAmount(balance))
 
 	return nil
 }
@@ -252,9 +295,9 @@ func (wc *WalletCLI) commandGetBalance() error {
 		return err
 	}
 
-	fmt.Printf("Balance of '%s': \nTotal - %.8f\n", wc.Input.Address, balance.Total)
-	fmt.Printf("Approved - %.8f\n", balance.Approved)
-	fmt.Printf("Pending - %.8f\n", balance.Pending)
+	fmt.Printf("Balance of '%s': \nTotal - %s\n", wc.Input.Address, utils.FormatAmount(balance.Total))
+	fmt.Printf("Approved - %s\n", utils.FormatAmount(balance.Approved))
+	fmt.Printf("Pending - %s\n", utils.FormatAmount(balance.Pending))
 
 	return nil
 }
@@ -274,6 +317,10 @@ func (wc *WalletCLI) commandSend() error {
 		return errors.New("The amount of transaction must be more 0")
 	}
 
+	if wc.Input.Fee < 0 {
+		return errors.New("The fee of transaction can not be negative")
+	}
+
 	wc.Logger.Trace.Printf("Prepare wallet %s to send data to node %s", wc.Input.Address, wc.Node.NodeAddrToString())
 
 	// load wallet object for this address
@@ -286,7 +333,7 @@ func (wc *WalletCLI) commandSend() error {
 	// Prepares new transaction without signatures
 	// This is just request to a node and it returns prepared transaction
 	TXBytes, DataToSign, err := wc.NodeCLI.SendRequestNewTransaction(wc.Node,
-		walletobj.GetPublicKey(), wc.Input.ToAddress, wc.Input.Amount)
+		walletobj.GetPublicKey(), wc.Input.ToAddress, wc.Input.Amount, wc.Input.Fee)
 
 	if err != nil {
 		return err
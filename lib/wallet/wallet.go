@@ -21,9 +21,9 @@ type Wallet struct {
 }
 
 type WalletBalance struct {
-	Total    float64
-	Approved float64
-	Pending  float64
+	Total    int64
+	Approved int64
+	Pending  int64
 }
 
 // MakeWallet creates Wallet. It generates new keys pair and assign to the object
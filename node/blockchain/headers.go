@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"github.com/NlaakStudios/democoin/node/structures"
+)
+
+// Returns list of hashes of blocks in the main chain to find a common block with other node
+// First 10 hashes go from the top one by one, then step is doubled every time. Genesis is always last
+func (bc *Blockchain) GetBlockLocator() ([][]byte, error) {
+	locator := [][]byte{}
+
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, height, err := bc.GetState()
+
+	if err != nil {
+		return nil, err
+	}
+
+	step := 1
+
+	// hashes are found by the heights index. blocks are not loaded
+	for h := height; h > 0; h -= step {
+		hash, err := bcdb.GetHashAtHeight(h)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hash != nil {
+			locator = append(locator, hash)
+		}
+
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+
+	// genesis block
+	genesisHash, err := bc.GetGenesisBlockHash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(locator, genesisHash), nil
+}
+
+// Returns first hash from the locator which is in the main chain. If nothing is found
+// then the genesis hash is returned
+func (bc *Blockchain) FindLocatorInChain(locator [][]byte) ([]byte, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range locator {
+		inchain, err := bcdb.BlockInChain(hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if inchain {
+			return hash, nil
+		}
+	}
+
+	return bc.GetGenesisBlockHash()
+}
+
+// Returns headers of blocks in the main chain following the block with given hash
+func (bc *Blockchain) GetHeadersAfter(hash []byte, maxcount int) ([]*structures.BlockHeaderInfo, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	headers := []*structures.BlockHeaderInfo{}
+
+	for len(headers) < maxcount {
+		_, _, nextHash, err := bcdb.GetLocationInChain(hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(nextHash) == 0 {
+			break
+		}
+
+		block, err := bc.GetBlock(nextHash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		headers = append(headers, block.GetHeaderInfo())
+
+		hash = nextHash
+	}
+
+	return headers, nil
+}
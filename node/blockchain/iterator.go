@@ -1,7 +1,6 @@
 package blockchain
 
 import (
-	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/node/database"
 	"github.com/NlaakStudios/democoin/node/structures"
 	"github.com/NlaakStudios/democoin/node/structures/transaction"
@@ -74,63 +73,9 @@ func (i *BlockchainIterator) GetAddressHistory(pubKeyHash []byte, address string
 		block, _ := i.Next()
 
 		for _, tx := range block.Transactions {
-
-			income := float64(0)
-
-			spent := false
-			spentaddress := ""
-
-			// we presume all inputs in tranaction are always from same wallet
-			for _, in := range tx.Vin {
-				spentaddress, _ = utils.PubKeyToAddres(in.PubKey)
-
-				if in.UsesKey(pubKeyHash) {
-					spent = true
-					break
-				}
-			}
-
-			if spent {
-				// find how many spent , part of out can be exchange to same address
-
-				spentvalue := float64(0)
-				totalvalue := float64(0) // we need to know total if wallet sent to himself
-
-				destaddress := ""
-
-				// we agree that there can be only one destination in transaction. we don't support scripts
-				for _, out := range tx.Vout {
-					if !out.IsLockedWithKey(pubKeyHash) {
-						spentvalue += out.Value
-						destaddress, _ = utils.PubKeyHashToAddres(out.PubKeyHash)
-					}
-				}
-
-				if spentvalue > 0 {
-					result = append(result, transaction.TransactionsHistory{false, tx.ID, destaddress, spentvalue})
-				} else {
-					// spent to himself. this should not be usual case
-					result = append(result, transaction.TransactionsHistory{false, tx.ID, address, totalvalue})
-					result = append(result, transaction.TransactionsHistory{true, tx.ID, address, totalvalue})
-				}
-			} else if tx.IsCoinbase() {
-
-				if tx.Vout[0].IsLockedWithKey(pubKeyHash) {
-					spentaddress = "Coin base"
-					income = tx.Vout[0].Value
-				}
-			} else {
-
-				for _, out := range tx.Vout {
-
-					if out.IsLockedWithKey(pubKeyHash) {
-						income += out.Value
-					}
-				}
-			}
-
-			if income > 0 {
-				result = append(result, transaction.TransactionsHistory{true, tx.ID, spentaddress, income})
+			for _, rec := range tx.GetHistory(pubKeyHash, address) {
+				rec.Height = block.Height
+				result = append(result, rec)
 			}
 		}
 
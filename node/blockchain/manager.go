@@ -48,7 +48,7 @@ func NewBlockchainManager(DB database.DBManager, Logger *utils.LoggerMan) (*Bloc
 	BCBAddState_error              = 0 not added to the chain. Because of error
 	BCBAddState_addedToTop         = 1 added to the top of current chain
 	BCBAddState_addedToParallelTop = 2 added to the top, but on other branch. Other branch becomes primary now
-	BCBAddState_addedToParallel    = 3 added but not in main branch and heigh i lower then main branch
+	BCBAddState_addedToParallel    = 3 added but not in main branch and total work is not more then in main branch
 	BCBAddState_notAddedNoPrev     = 4 previous not found
 	BCBAddState_notAddedExists     = 5 already in blockchain
 *
@@ -115,11 +115,24 @@ func (bc *Blockchain) AddBlock(block *structures.Block) (uint, error) {
 		return BCBAddState_error, err
 	}
 
-	bc.Logger.Trace.Printf("Current BC state %d , %x\n", lastBlock.Height, lastHash)
-	bc.Logger.Trace.Printf("New block height %d\n", block.Height)
+	// primary chain is the chain with most work done. not just longest
+	blockWork, err := bc.saveChainWork(block)
 
-	if block.Height > lastBlock.Height {
-		// the block becomes highest and is top of he blockchain
+	if err != nil {
+		return BCBAddState_error, err
+	}
+
+	lastWork, err := bc.GetChainWork(lastHash)
+
+	if err != nil {
+		return BCBAddState_error, err
+	}
+
+	bc.Logger.Trace.Printf("Current BC state %d , %x , work %s\n", lastBlock.Height, lastHash, lastWork.String())
+	bc.Logger.Trace.Printf("New block height %d , work %s\n", block.Height, blockWork.String())
+
+	if blockWork.Cmp(lastWork) > 0 {
+		// the block has most work now and is top of he blockchain
 		err = bcdb.SaveTopHash(block.Hash)
 
 		if err != nil {
@@ -316,33 +329,31 @@ func (bc *Blockchain) GetTransactionFromBlock(txID []byte, blockHash []byte) (*t
 	return nil, errors.New("Transaction is not found")
 }
 
-// Returns a block with specified height in current blockchain
-// TODO can be optimized using blocks index
+// Returns a block with specified height in current blockchain. Uses the heights index of the chain
 func (bc *Blockchain) GetBlockAtHeight(height int) (*structures.Block, error) {
-	// finds a block with this height
-
-	bci, err := NewBlockchainIterator(bc.DB)
+	bcdb, err := bc.DB.GetBlockchainObject()
 
 	if err != nil {
 		return nil, err
 	}
 
-	for {
-		block, _ := bci.Next()
+	hash, err := bcdb.GetHashAtHeight(height)
 
-		if block.Height == height {
-			return block, nil
-		}
+	if err != nil {
+		return nil, err
+	}
 
-		if block.Height < height {
-			return nil, errors.New("Block with the heigh doesn't exist")
-		}
+	if hash == nil {
+		return nil, errors.New("Block with the heigh doesn't exist")
+	}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	block, err := bc.GetBlock(hash)
+
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("Block with the heigh doesn't exist")
+
+	return &block, nil
 }
 
 // GetBestHeight returns the height of the latest block
@@ -534,45 +545,16 @@ func (bc *Blockchain) GetFirstBlocks(maxcount int) ([]*structures.Block, int, er
 		return localError(err)
 	}
 
-	genesisHash, err := bc.GetGenesisBlockHash()
-
-	if err != nil {
-		bc.Logger.Trace.Println("err 2")
-		return localError(err)
-	}
-
-	bcdb, err := bc.DB.GetBlockchainObject()
-
-	if err != nil {
-		return localError(err)
-	}
-
 	blocks := []*structures.Block{}
 
-	hash := genesisHash[:]
-
-	for {
-		_, _, nextHash, err := bcdb.GetLocationInChain(hash)
-
-		if err != nil {
-			return localError(err)
-		}
-
-		block, err := bc.GetBlock(hash)
+	for h := 0; h <= height && len(blocks) < maxcount; h++ {
+		block, err := bc.GetBlockAtHeight(h)
 
 		if err != nil {
 			return localError(err)
 		}
 
-		blocks = append(blocks, &block)
-
-		if len(blocks) >= maxcount {
-			break
-		}
-		if len(nextHash) == 0 {
-			break
-		}
-		hash = nextHash[:]
+		blocks = append(blocks, block)
 	}
 	return blocks, height, nil
 }
@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"math/big"
+
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/structures"
+)
+
+// Returns target bits used to make a block. Blocks made before target bits were stored
+// in a block have it defined by the height
+func GetBlockTargetBits(b *structures.Block) int {
+	if b.Bits > 0 {
+		return b.Bits
+	}
+
+	if b.Height >= 1000 {
+		return config.TargetBits_2
+	}
+	return config.TargetBits
+}
+
+// Returns amount of work needed to make a block with given target bits.
+// Hash must be less 2^(256-bits) so it is 2^bits hashes in average
+func GetWorkForBits(bits int) *big.Int {
+	work := big.NewInt(1)
+	work.Lsh(work, uint(bits))
+	return work
+}
+
+// Returns total work of a chain ending with the block. Work is stored for each block when
+// it is added. If it is missed (DB created before) then it is calculated and saved now
+func (bc *Blockchain) GetChainWork(hash []byte) (*big.Int, error) {
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// go back till a block with known work. remember blocks to calculate for
+	blocks := []*structures.Block{}
+
+	work := big.NewInt(0)
+
+	for len(hash) > 0 {
+		workData, err := bcdb.GetBlockWork(hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(workData) > 0 {
+			work.SetBytes(workData)
+			break
+		}
+
+		block, err := bc.GetBlock(hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, &block)
+
+		hash = block.PrevBlockHash
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		work.Add(work, GetWorkForBits(GetBlockTargetBits(blocks[i])))
+
+		err = bcdb.PutBlockWork(blocks[i].Hash, work.Bytes())
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
+
+// Calculates and saves total work of a chain ending with new block
+func (bc *Blockchain) saveChainWork(block *structures.Block) (*big.Int, error) {
+	work, err := bc.GetChainWork(block.PrevBlockHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	work.Add(work, GetWorkForBits(GetBlockTargetBits(block)))
+
+	bcdb, err := bc.DB.GetBlockchainObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcdb.PutBlockWork(block.Hash, work.Bytes())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return work, nil
+}
@@ -9,7 +9,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/NlaakStudios/democoin/lib"
 	"github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/node/database"
 )
 
@@ -23,7 +25,8 @@ type AllPossibleArgs struct {
 	NodePort    int
 	NodeHost    string
 	Genesis     string
-	Amount      float64
+	Amount      int64
+	Fee         int64
 	LogDest     string
 	Transaction string
 	View        string
@@ -41,6 +44,34 @@ type AppInput struct {
 	Nodes         []net.NodeAddr
 	Args          AllPossibleArgs
 	Database      database.DatabaseConfig
+	// connections to other nodes must be encrypted. Not encrypted connections are not accepted
+	RequireEncryption bool
+	// time of a ban of misbehaving node, in seconds
+	BanTime int
+	// sources of nodes addresses used when no other nodes are known
+	Genesis   GenesisConfig
+	Bootstrap BootstrapConfig
+	// interface to listen on. All interfaces if empty
+	BindHost string
+	// address advertised to other nodes. Host is found from other nodes if it is not set
+	ExternalHost string
+	ExternalPort int
+}
+
+// Network this node belongs to. Nodes of the network are used to find other nodes
+type GenesisConfig struct {
+	Hash  string // hex of genesis block hash
+	Nodes []net.NodeAddr
+	// default schedule is used if it is not set
+	Emission EmissionConfig
+}
+
+// Sources of nodes addresses used when no other nodes are known
+type BootstrapConfig struct {
+	SeedFile  string   // JSON nodes list. Default is seednodes.json in the data dir
+	DNSSeeds  []string // host:port names resolved to addresses of nodes
+	DNSServer string   // host:port of DNS server for DNS seeds. System resolvers are used if empty
+	URL       string   // nodes list URL. Default is lib.InitialNodesList. "no" disables it
 }
 
 type AppConfig struct {
@@ -50,6 +81,16 @@ type AppConfig struct {
 	Nodes    []net.NodeAddr
 	Logs     []string
 	Database database.DatabaseConfig
+
+	RequireEncryption bool
+	BanTime           int
+
+	Genesis   GenesisConfig
+	Bootstrap BootstrapConfig
+
+	BindHost     string
+	ExternalHost string
+	ExternalPort int
 }
 
 // Parses inout and config file. Command line arguments ovverride config file options
@@ -76,10 +117,17 @@ func GetAppInput() (AppInput, error) {
 	cmd.StringVar(&input.Args.NodeHost, "nodehost", "", "Remote Node Server Host")
 	cmd.IntVar(&input.Args.Port, "port", 0, "Node Server port")
 	cmd.IntVar(&input.Args.NodePort, "nodeport", 0, "Remote Node Server port")
-	cmd.Float64Var(&input.Args.Amount, "amount", 0, "Amount money to send")
+	// amounts are parsed from decimal format to smallest units
+	amountStr := cmd.String("amount", "", "Amount money to send")
+	feeStr := cmd.String("fee", "", "Fee for a miner")
 	cmd.StringVar(&input.Args.LogDest, "logdest", "file", "Destination of logs. file or stdout")
 	cmd.StringVar(&input.Args.View, "view", "", "View format")
 	cmd.BoolVar(&input.Args.Clean, "clean", false, "Clean data/cache")
+	cmd.BoolVar(&input.RequireEncryption, "requireencryption", false, "Accept and make only encrypted connections")
+	cmd.IntVar(&input.BanTime, "bantime", 0, "Time of a ban of misbehaving node, seconds")
+	cmd.StringVar(&input.BindHost, "bindhost", "", "Interface to listen on. All interfaces by default")
+	cmd.StringVar(&input.ExternalHost, "externalhost", "", "Host advertised to other nodes")
+	cmd.IntVar(&input.ExternalPort, "externalport", 0, "Port advertised to other nodes. Same as port by default")
 
 	datadirPtr := cmd.String("datadir", "", "Location of data files, config, DB etc")
 	err := cmd.Parse(os.Args[2:])
@@ -88,6 +136,18 @@ func GetAppInput() (AppInput, error) {
 		return input, err
 	}
 
+	input.Args.Amount, err = utils.ParseAmount(*amountStr)
+
+	if err != nil {
+		return input, err
+	}
+
+	input.Args.Fee, err = utils.ParseAmount(*feeStr)
+
+	if err != nil {
+		return input, err
+	}
+
 	if *datadirPtr != "" {
 		input.DataDir = *datadirPtr
 		if input.DataDir[len(input.DataDir)-1:] != "/" {
@@ -138,12 +198,45 @@ func GetAppInput() (AppInput, error) {
 			input.Logs = strings.Join(config.Logs, ",")
 		}
 
+		if config.RequireEncryption {
+			input.RequireEncryption = true
+		}
+
+		if input.BanTime < 1 && config.BanTime > 0 {
+			input.BanTime = config.BanTime
+		}
+
+		if input.BindHost == "" {
+			input.BindHost = config.BindHost
+		}
+
+		if input.ExternalHost == "" {
+			input.ExternalHost = config.ExternalHost
+		}
+
+		if input.ExternalPort < 1 {
+			input.ExternalPort = config.ExternalPort
+		}
+
+		input.Genesis = config.Genesis
+		input.Bootstrap = config.Bootstrap
+
 		input.Database = config.Database
 	} else {
 		input.Database.SetDefault()
 	}
 	input.Database.DataDir = input.DataDir
 
+	if input.Genesis.Emission.IsEmpty() {
+		input.Genesis.Emission.SetDefault()
+	}
+
+	err = input.Genesis.Emission.Validate()
+
+	if err != nil {
+		return input, err
+	}
+
 	if input.Host == "" {
 		input.Host = "localhost"
 	}
@@ -179,6 +272,39 @@ func (c AppInput) GetConfig() (*AppConfig, error) {
 
 	return &config, nil
 }
+
+// Returns sources of nodes in order of use: nodes of the genesis config, seed file, DNS seeds, URL
+func (c AppInput) GetBootstrapSources() []net.BootstrapSource {
+	sources := []net.BootstrapSource{}
+
+	if len(c.Genesis.Nodes) > 0 {
+		sources = append(sources, net.StaticBootstrap{Name: "genesis config", Nodes: c.Genesis.Nodes, Genesis: c.Genesis.Hash})
+	}
+
+	seedFile := c.Bootstrap.SeedFile
+
+	if seedFile == "" {
+		seedFile = c.DataDir + net.SeedFileName
+	}
+	if _, err := os.Stat(seedFile); err == nil {
+		sources = append(sources, net.FileBootstrap{Path: seedFile})
+	}
+
+	for _, seed := range c.Bootstrap.DNSSeeds {
+		sources = append(sources, net.DNSBootstrap{Seed: seed, Server: c.Bootstrap.DNSServer})
+	}
+
+	if c.Bootstrap.URL != "no" {
+		url := c.Bootstrap.URL
+
+		if url == "" {
+			url = lib.InitialNodesList
+		}
+		sources = append(sources, net.HTTPBootstrap{URL: url})
+	}
+	return sources
+}
+
 func (c AppInput) CheckNeedsHelp() bool {
 	if c.Command == "help" || c.Command == "" {
 		return true
@@ -238,6 +364,23 @@ func (c AppInput) UpdateConfig() error {
 		config.Nodes = []net.NodeAddr{}
 	}
 
+	if c.RequireEncryption {
+		config.RequireEncryption = true
+	}
+
+	if c.BanTime > 0 {
+		config.BanTime = c.BanTime
+	}
+	if c.BindHost != "" {
+		config.BindHost = c.BindHost
+	}
+	if c.ExternalHost != "" {
+		config.ExternalHost = c.ExternalHost
+	}
+	if c.ExternalPort > 0 {
+		config.ExternalPort = c.ExternalPort
+	}
+
 	if c.Logs != "" {
 		if c.Logs == "no" {
 			config.Logs = []string{}
@@ -282,6 +425,7 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  dropblock\n\t- Delete last block fro the block chain. All transaction are returned back to unapproved state")
 	fmt.Println("  reindexcache\n\t- Rebuilds the database of unspent transactions outputs and transaction pointers")
 	fmt.Println("  showunspent -address ADDRESS\n\t- Print the list of all unspent transactions and balance")
+	fmt.Println("  showsupply\n\t- Print current supply of coins and the emission schedule")
 	fmt.Println("  unapprovedtransactions [-clean]\n\t- Print the list of transactions not included in any block yet. If the option -clean provided then cleans the cache")
 
 	fmt.Println("  getbalance -address ADDRESS\n\t- Get balance of ADDRESS")
@@ -289,16 +433,16 @@ func (c AppInput) PrintUsage() {
 	fmt.Println("  getbalances\n\t- Lists all addresses from the wallet file and show balance for each")
 	fmt.Println("  addrhistory -address ADDRESS\n\t- Shows all transactions for a wallet address")
 
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT\n\t- Send AMOUNT of coins from FROM address to TO. ")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT [-fee FEE]\n\t- Send AMOUNT of coins from FROM address to TO. FEE goes to a miner")
 	fmt.Println("  canceltransaction -transaction TRANSACTIONID\n\t- Cancel unapproved transaction. NOTE!. This cancels only from local cache!")
 
-	fmt.Println("  startnode [-minter ADDRESS] [-host HOST] [-port PORT]\n\t- Start a node server. -minter defines minting address, -host - hostname of the node server and -port - listening port")
-	fmt.Println("  startintnode [-minter ADDRESS] [-port PORT]\n\t- Start a node server in interactive mode (no deamon). -minter defines minting address and -port - listening port")
+	fmt.Println("  startnode [-minter ADDRESS] [-host HOST] [-port PORT] [-bindhost HOST] [-externalhost HOST] [-externalport PORT]\n\t- Start a node server. -minter defines minting address, -host - hostname of the node server and -port - listening port. -bindhost - interface to listen on, -externalhost and -externalport - address advertised to other nodes if it differs from the listening address (NAT). If the host is not set, it is found from other nodes")
+	fmt.Println("  startintnode [-minter ADDRESS] [-port PORT] [-bindhost HOST] [-externalhost HOST] [-externalport PORT]\n\t- Start a node server in interactive mode (no deamon). -minter defines minting address and -port - listening port")
 	fmt.Println("  stopnode\n\t- Stop runnning node")
 	fmt.Println("  nodestate\n\t- Print state of the node process")
-	fmt.Println("  updateconfig [-minter ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port")
+	fmt.Println("  updateconfig [-minter ADDRESS] [-host HOST] [-port PORT] [-nodehost HOST] [-nodeport PORT] [-requireencryption] [-bantime SECONDS] [-bindhost HOST] [-externalhost HOST] [-externalport PORT]\n\t- Update config file. Allows to set this node minter address, host and port and remote node host and port. -bindhost, -externalhost and -externalport set listening interface and advertised address. -requireencryption allows only encrypted connections. -bantime sets time of a ban of misbehaving nodes")
 
-	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive")
+	fmt.Println("  shownodes\n\t- Display list of nodes addresses, including inactive, with score and ban status")
 	fmt.Println("  addnode -nodehost HOST -nodeport PORT\n\t- Adds new node to list of connections")
 	fmt.Println("  removenode -nodehost HOST -nodeport PORT\n\t- Removes a node from list of connections")
 }
@@ -1,13 +1,115 @@
 package config
 
+import (
+	"github.com/NlaakStudios/democoin/lib"
+)
+
 // ==========================================================
 // this can be altered to experiment with blockchain
 
 // this defines how strong miming is needed. 16 is simple mining less 5 sec in simple desktop
 // 24 will need 30 seconds in average
+// TargetBits is the difficulty of first blocks. Later it is recalculated from blocks times
 const TargetBits = 16
+
+// Blocks made before difficulty was stored in a block used this value starting from the height 1000
 const TargetBits_2 = 24
 
+// Difficulty retargeting. Every RetargetInterval blocks target bits are recalculated
+// to keep average time between blocks close to TargetBlockSpacing
+const RetargetInterval = 10
+const TargetBlockSpacing = 10 // seconds
+
+// Max change of target bits per one retarget. Every bit makes mining 2 times harder or easier
+const MaxRetargetStepBits = 2
+
+// Range of allowed target bits
+const MinTargetBits = 8
+const MaxTargetBits = 64
+
+// Default emission schedule. Reward for a block (subsidy) starts from InitialBlockSubsidy and is halved
+// every SubsidyHalvingInterval blocks. Total emission never exceeds MaxSupply
+// Amounts are in smallest units. A network can set other values in the genesis config
+const InitialBlockSubsidy = 10 * lib.CoinUnits
+const SubsidyHalvingInterval = 100000
+const MaxSupply = 2000000 * lib.CoinUnits
+
+// Header-first sync. Headers are loaded by batches. Blocks are loaded in parallel from different nodes
+// but not further than SyncDownloadWindow blocks from the last added block
+const SyncHeadersBatchSize = 2000
+const SyncDownloadWindow = 128
+
+// A node is not used for sync after this number of failed requests
+const SyncMaxNodeFailures = 3
+
+// Header-first sync is started if other node has more blocks by this number
+const SyncMinHeightDifference = 100
+
+// Block timestamp must be greater than median time of MedianTimePastBlocks previous blocks
+// and can not be ahead of local time more than MaxFutureBlockTime
+const MedianTimePastBlocks = 11
+const MaxFutureBlockTime = 2 * 60 * 60 // seconds
+
+// Transaction time can not be ahead of local time (or time of a block) more than this
+const MaxFutureTransactionTime = 2 * 60 * 60 // seconds
+
+// Peer scoring. Every misbehaviour of other node adds points to its score. A node is banned
+// when the score reaches PeerBanScore. Score is decreased by 1 every PeerScoreDecayTime seconds
+const PeerBanScore = 100
+const PeerScoreDecayTime = 60
+
+// invalid block can be relayed by an honest node on other branch, so one block doesn't ban a node
+const PeerScoreInvalidBlock = 50
+const PeerScoreFutureBlock = 20
+const PeerScoreBadSignature = 50
+const PeerScoreInvalidTransaction = 10
+const PeerScoreMalformedData = 20
+const PeerScoreFlood = 1
+
+// Default time of a ban. Can be changed with -bantime option
+const DefaultBanTime = 24 * 60 * 60 // seconds
+
+// Requests rate limit for a host. A host can send PeerRequestsBurst requests at once
+// and then PeerRequestsPerSecond requests per second
+const PeerRequestsPerSecond = 100
+const PeerRequestsBurst = 1000
+
+// Network requests limits. Size of a request payload is checked before it is read
+// Commands not listed in the server use MaxRequestPayload
+const MaxRequestPayload = 64 * 1024
+const MaxBlockPayload = 32 * 1024 * 1024
+const MaxTransactionPayload = 1024 * 1024
+const MaxListPayload = 1024 * 1024 // lists of nodes or hashes
+const MaxRequestExtraData = 256
+
+// A request must be read completely during this time
+const RequestReadTimeout = 60 // seconds
+// Session is closed if no requests are received during this time
+const SessionIdleTimeout = 10 * 60 // seconds
+
+// Max number of connections handled at same time. New connection waits for a free slot
+// not longer than ConnectionWaitTimeout and then is refused
+const MaxConnections = 128
+const ConnectionWaitTimeout = 5 // seconds
+
+// Number of nodes new blocks, transactions and versions are sent to
+const MaxOutboundNodes = 8
+
+// Addresses of other nodes are requested from a random node with this interval
+// and when the address book has less addresses than AddrBookMinSize
+const AddrGossipInterval = 10 * 60 // seconds
+const AddrBookMinSize = 100
+
+// Addr commands with not more new addresses are relayed to AddrRelayNodes nodes
+const AddrRelayMax = 10
+const AddrRelayNodes = 2
+
+// Host of this node reported by this number of other nodes is advertised if external host is not set
+const ExternalAddressMinReports = 2
+
+// How often queued transactions announcements are checked. Announcements are sent after random delays
+const InvTrickleTick = 200 // milliseconds
+
 // Max and Min number of transactions per block
 // If number of block in a chain is less this umber then it is a minimum. if more then
 // this number is  a minimum unmber of TX
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"errors"
+)
+
+// Emission schedule of a network. All nodes of a network must use same values, in other case
+// they don't accept blocks of each other. Amounts are in smallest units
+type EmissionConfig struct {
+	InitialSubsidy  int64
+	HalvingInterval int // blocks
+	MaxSupply       int64
+}
+
+func (ec *EmissionConfig) IsEmpty() bool {
+	if ec.InitialSubsidy == 0 && ec.HalvingInterval == 0 && ec.MaxSupply == 0 {
+		return true
+	}
+	return false
+}
+
+func (ec *EmissionConfig) SetDefault() error {
+	ec.InitialSubsidy = InitialBlockSubsidy
+	ec.HalvingInterval = SubsidyHalvingInterval
+	ec.MaxSupply = MaxSupply
+	return nil
+}
+
+func (ec *EmissionConfig) Validate() error {
+	if ec.InitialSubsidy < 0 || ec.MaxSupply < 0 {
+		return errors.New("Emission amounts can not be negative")
+	}
+
+	if ec.HalvingInterval < 1 {
+		return errors.New("Emission halving interval must be positive")
+	}
+	return nil
+}
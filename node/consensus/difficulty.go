@@ -0,0 +1,102 @@
+package consensus
+
+import (
+	"math"
+
+	"github.com/NlaakStudios/democoin/node/blockchain"
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/structures"
+)
+
+// Returns header of a block by hash. It is used to check a block against previous blocks of its chain
+// Blocks can be in the blockchain or only in headers loaded from other node
+type HeaderGetter func(hash []byte) (*structures.BlockHeaderInfo, error)
+
+// Returns header getter for blocks of the blockchain
+func (n *NodeBlockMaker) getBlockchainHeaders() HeaderGetter {
+	bcm := n.getBlockchainManager()
+
+	return func(hash []byte) (*structures.BlockHeaderInfo, error) {
+		block, err := bcm.GetBlock(hash)
+
+		if err != nil {
+			return nil, err
+		}
+		return block.GetHeaderInfo(), nil
+	}
+}
+
+// Returns target bits expected for a block following the block with given hash
+func (n *NodeBlockMaker) getExpectedTargetBits(prevBlockHash []byte) (int, error) {
+	return getExpectedTargetBitsInChain(prevBlockHash, n.getBlockchainHeaders())
+}
+
+// Returns target bits expected for a block following the block with given hash
+// Every RetargetInterval blocks bits are recalculated based on time spent to make
+// previous RetargetInterval blocks. Between retargets bits are same as in previous block
+func getExpectedTargetBitsInChain(prevBlockHash []byte, getHeader HeaderGetter) (int, error) {
+	if len(prevBlockHash) == 0 {
+		// genesis block
+		return config.TargetBits, nil
+	}
+
+	prevHeader, err := getHeader(prevBlockHash)
+
+	if err != nil {
+		return 0, err
+	}
+
+	height := prevHeader.Height + 1
+	prevBits := GetHeaderTargetBits(prevHeader)
+
+	if height < config.RetargetInterval || height%config.RetargetInterval != 0 {
+		return prevBits, nil
+	}
+
+	// find first block of the interval. Go back in the branch of the previous block
+	firstHeader := prevHeader
+
+	for firstHeader.Height > height-config.RetargetInterval {
+		firstHeader, err = getHeader(firstHeader.Header.PrevBlockHash)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	actualTimespan := prevHeader.Header.Timestamp - firstHeader.Header.Timestamp
+	expectedTimespan := int64((config.RetargetInterval - 1) * config.TargetBlockSpacing)
+
+	return calculateNextTargetBits(prevBits, actualTimespan, expectedTimespan), nil
+}
+
+// Returns target bits of a block by its header. Old blocks don't have bits in the header
+func GetHeaderTargetBits(hi *structures.BlockHeaderInfo) int {
+	return blockchain.GetBlockTargetBits(&structures.Block{Bits: hi.Header.Bits, Height: hi.Height})
+}
+
+// Calculates new target bits from time spent on last interval. Change is clamped to
+// MaxRetargetStepBits in both directions and to allowed range of bits
+func calculateNextTargetBits(prevBits int, actualTimespan int64, expectedTimespan int64) int {
+	if actualTimespan < 1 {
+		actualTimespan = 1
+	}
+
+	// every bit is 2 times change of difficulty
+	change := int(math.Floor(math.Log2(float64(expectedTimespan)/float64(actualTimespan)) + 0.5))
+
+	if change > config.MaxRetargetStepBits {
+		change = config.MaxRetargetStepBits
+	} else if change < -config.MaxRetargetStepBits {
+		change = -config.MaxRetargetStepBits
+	}
+
+	bits := prevBits + change
+
+	if bits < config.MinTargetBits {
+		bits = config.MinTargetBits
+	} else if bits > config.MaxTargetBits {
+		bits = config.MaxTargetBits
+	}
+	return bits
+}
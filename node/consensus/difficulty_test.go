@@ -0,0 +1,34 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/NlaakStudios/democoin/node/config"
+)
+
+func TestCalculateNextTargetBits(t *testing.T) {
+	expected := int64(100)
+
+	tests := []struct {
+		prevBits int
+		actual   int64
+		result   int
+	}{
+		{20, 100, 20},                            // on time
+		{20, 50, 21},                             // 2 times faster
+		{20, 200, 19},                            // 2 times slower
+		{20, 1, 20 + config.MaxRetargetStepBits}, // clamped up
+		{20, 100000, 20 - config.MaxRetargetStepBits}, // clamped down
+		{20, 0, 20 + config.MaxRetargetStepBits},      // broken timestamps
+		{config.MinTargetBits, 1000, config.MinTargetBits},
+		{config.MaxTargetBits, 10, config.MaxTargetBits},
+	}
+
+	for _, test := range tests {
+		bits := calculateNextTargetBits(test.prevBits, test.actual, expected)
+
+		if bits != test.result {
+			t.Fatalf("For bits %d and timespan %d got %d, expected %d", test.prevBits, test.actual, bits, test.result)
+		}
+	}
+}
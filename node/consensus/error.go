@@ -0,0 +1,35 @@
+package consensus
+
+// Custom errors
+
+import (
+	"fmt"
+)
+
+const BlockVerifyErrorTimeTooOld = "timetooold"
+const BlockVerifyErrorTimeTooNew = "timetoonew"
+const BlockVerifyErrorBits = "bits"
+const BlockVerifyErrorVersion = "version"
+const BlockVerifyErrorMerkleRoot = "merkleroot"
+const BlockVerifyErrorHash = "hash"
+const BlockVerifyErrorTXNumber = "txnumber"
+const BlockVerifyErrorTransaction = "transaction"
+const BlockVerifyErrorCoinbase = "coinbase"
+
+type BlockVerifyError struct {
+	err   string
+	kind  string
+	Block []byte
+}
+
+func (e *BlockVerifyError) Error() string {
+	return fmt.Sprintf("Block verify failed: %s, for block %x", e.err, e.Block)
+}
+
+func (e *BlockVerifyError) GetKind() string {
+	return e.kind
+}
+
+func NewBlockVerifyError(err string, kind string, block []byte) error {
+	return &BlockVerifyError{err, kind, block}
+}
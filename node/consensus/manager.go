@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"time"
@@ -133,8 +134,9 @@ func (n *NodeBlockMaker) doPrepareNewBlock() error {
 		if count > max {
 			count = max
 		}
-		// get unapproved transactions
-		txs, err := n.getTransactionsManager().GetUnapprovedTransactionsForNewBlock(count)
+		// get unapproved transactions. Transactions with best fee rate go first
+		// and not more than max allowed in a block
+		txs, fees, err := n.getTransactionsManager().GetUnapprovedTransactionsForNewBlock(count)
 
 		if err != nil {
 			return err
@@ -146,7 +148,7 @@ func (n *NodeBlockMaker) doPrepareNewBlock() error {
 
 		n.Logger.Trace.Printf("Minting: All good. New block assigned to address %s\n", n.MinterAddress)
 
-		newBlock, err := n.makeNewBlockFromTransactions(txs)
+		newBlock, err := n.makeNewBlockFromTransactions(txs, fees)
 
 		if err != nil {
 			return err
@@ -179,6 +181,16 @@ func (n *NodeBlockMaker) CompleteBlock() (*structures.Block, error) {
 
 	starttime := time.Now()
 
+	if b.Bits == 0 {
+		// genesis block is prepared outside. set difficulty for it
+		bits, err := n.getExpectedTargetBits(b.PrevBlockHash)
+
+		if err != nil {
+			return nil, err
+		}
+		b.Bits = bits
+	}
+
 	pow := NewProofOfWork(b)
 
 	nonce, hash, err := pow.Run()
@@ -203,8 +215,8 @@ func (n *NodeBlockMaker) CompleteBlock() (*structures.Block, error) {
 }
 
 // this builds a block object from given transactions list
-// adds coinbase transacion (prize for miner)
-func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []*transaction.Transaction) (*structures.Block, error) {
+// adds coinbase transacion (prize for miner). Miner gets a reward and fees of all transactions
+func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []*transaction.Transaction, fees int64) (*structures.Block, error) {
 	// get last block info
 	lastHash, lastHeight, err := n.getBlockchainManager().GetState()
 
@@ -215,7 +227,7 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []*transactio
 	// add transaction - prize for miner
 	cbTx := &transaction.Transaction{}
 
-	errc := cbTx.MakeCoinbaseTX(n.MinterAddress, "")
+	errc := cbTx.MakeCoinbaseTX(n.MinterAddress, "", GetBlockSubsidy(lastHeight+1)+fees)
 
 	if errc != nil {
 		return nil, errc
@@ -237,6 +249,23 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []*transactio
 		return nil, err
 	}
 
+	newblock.Bits, err = n.getExpectedTargetBits(lastHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// time must be after median time of previous blocks. it can be not so if blocks are made fast
+	medianTimePast, err := n.getMedianTimePast(lastHash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if newblock.Timestamp <= medianTimePast {
+		newblock.Timestamp = medianTimePast + 1
+	}
+
 	return &newblock, nil
 }
 
@@ -251,7 +280,50 @@ func (n *NodeBlockMaker) makeNewBlockFromTransactions(transactions []*transactio
 // 4. all inputs must be in blockchain (correct unspent inputs)
 // 5. Additionally verify each transaction agains signatures, total amount, balance etc
 // 6. Verify hash is correc agains rules
+// 8. Coinbase transaction can not claim more than block subsidy plus fees of transactions in the block
+// 7. Difficulty bits must be same as expected for this position in a chain
+// 9. Block version must be known. Merkle root in the header must match transactions
+// 10. Block time must be after median time of previous blocks and not too far in the future
+// 11. Transactions time must be set and not too far after the block time
+// Legacy blocks were made before difficulty and time rules. Their bits are defined by height,
+// PoW is checked with them. Median time past and retarget are not checked for them
 func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
+	//10. Verify timestamp. 7. Verify difficulty
+	err := checkBlockInChain(block, n.getBlockchainHeaders())
+
+	if err != nil {
+		return err
+	}
+	//11. Verify transactions time
+	err = checkBlockTransactionsTime(block)
+
+	if err != nil {
+		return err
+	}
+	//9. Verify version and Merkle root
+	err = checkBlockVersion(block)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkBlockTransactionsFormat(block)
+
+	if err != nil {
+		return err
+	}
+
+	if block.Version > 0 {
+		merkleRoot, err := block.HashTransactions()
+
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(merkleRoot, block.MerkleRoot) {
+			return NewBlockVerifyError("Block Merkle root doesn't match transactions", BlockVerifyErrorMerkleRoot, block.Hash)
+		}
+	}
 	//6. Verify hash
 
 	pow := NewProofOfWork(block)
@@ -263,7 +335,7 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 	}
 
 	if !valid {
-		return errors.New("Block hash is not valid")
+		return NewBlockVerifyError("Block hash is not valid", BlockVerifyErrorHash, block.Hash)
 	}
 	n.Logger.Trace.Println("block hash verified")
 	// 2. check number of TX
@@ -276,40 +348,160 @@ func (n *NodeBlockMaker) VerifyBlock(block *structures.Block) error {
 	}
 
 	if txnum < min {
-		return errors.New("Number of transactions is too low")
+		return NewBlockVerifyError("Number of transactions is too low", BlockVerifyErrorTXNumber, block.Hash)
 	}
 
 	if txnum > max {
-		return errors.New("Number of transactions is too high")
+		return NewBlockVerifyError("Number of transactions is too high", BlockVerifyErrorTXNumber, block.Hash)
 	}
 
 	// 1
 	coinbaseused := false
+	coinbaseValue := int64(0)
+	fees := int64(0)
 
 	prevTXs := []*transaction.Transaction{}
 
 	for _, tx := range block.Transactions {
 		if tx.IsCoinbase() {
 			if coinbaseused {
-				return errors.New("2 coin base TX in the block")
+				return NewBlockVerifyError("2 coin base TX in the block", BlockVerifyErrorCoinbase, block.Hash)
 			}
 			coinbaseused = true
+			coinbaseValue = tx.Vout[0].Value
+		}
+		vtx, fee, err := n.getTransactionsManager().VerifyTransactionWithFee(tx, prevTXs, block.PrevBlockHash)
+
+		if err, ok := err.(*transactions.TXVerifyError); ok {
+			return NewBlockVerifyError(err.Error(), BlockVerifyErrorTransaction, block.Hash)
 		}
-		vtx, err := n.getTransactionsManager().VerifyTransaction(tx, prevTXs, block.PrevBlockHash)
 
 		if err != nil {
 			return err
 		}
 
 		if !vtx {
-			return errors.New(fmt.Sprintf("Transaction in a block is not valid: %x", tx.ID))
+			return NewBlockVerifyError(fmt.Sprintf("Transaction in a block is not valid: %x", tx.ID), BlockVerifyErrorTransaction, block.Hash)
 		}
 
+		fees += fee
+
 		prevTXs = append(prevTXs, tx)
 	}
 	// 1.
 	if !coinbaseused {
-		return errors.New("No coinbase TX in the block")
+		return NewBlockVerifyError("No coinbase TX in the block", BlockVerifyErrorCoinbase, block.Hash)
+	}
+	// 8.
+	height := 0
+
+	if len(block.PrevBlockHash) > 0 {
+		// subsidy depends on real position of the block in the chain
+		prevBlock, err := n.getBlockchainManager().GetBlock(block.PrevBlockHash)
+
+		if err != nil {
+			return err
+		}
+		height = prevBlock.Height + 1
+	}
+
+	reward := GetBlockSubsidy(height) + fees
+
+	if coinbaseValue > reward {
+		return NewBlockVerifyError(fmt.Sprintf("Coinbase value %d is more than block subsidy and fees %d", coinbaseValue, reward),
+			BlockVerifyErrorCoinbase, block.Hash)
+	}
+	return nil
+}
+
+// Checks time and difficulty bits of a block against previous blocks of its chain
+func checkBlockInChain(block *structures.Block, getHeader HeaderGetter) error {
+	now := time.Now().Unix()
+
+	if block.IsLegacy() {
+		err := checkLegacyBlockInChain(block, getHeader)
+
+		if err != nil {
+			return err
+		}
+		// only the future time limit. old blocks were not checked against median time
+		return checkBlockTimestamp(block, 0, now)
+	}
+
+	medianTimePast, err := getMedianTimePastInChain(block.PrevBlockHash, getHeader)
+
+	if err != nil {
+		return err
+	}
+
+	err = checkBlockTimestamp(block, medianTimePast, now)
+
+	if err != nil {
+		return err
+	}
+
+	expectedBits, err := getExpectedTargetBitsInChain(block.PrevBlockHash, getHeader)
+
+	if err != nil {
+		return err
+	}
+
+	if block.Bits != expectedBits {
+		return NewBlockVerifyError(fmt.Sprintf("Block difficulty bits %d are wrong. Expected %d", block.Bits, expectedBits),
+			BlockVerifyErrorBits, block.Hash)
+	}
+	return nil
+}
+
+// Legacy blocks can be only in the beginning of a chain. A chain can't continue with them after
+// a block with bits. Their bits are defined by height, the height must be real position in the chain
+func checkLegacyBlockInChain(block *structures.Block, getHeader HeaderGetter) error {
+	height := 0
+
+	if len(block.PrevBlockHash) > 0 {
+		prevHeader, err := getHeader(block.PrevBlockHash)
+
+		if err != nil {
+			return err
+		}
+
+		if prevHeader.Header.Version != 0 || prevHeader.Header.Bits != 0 {
+			return NewBlockVerifyError("Legacy block can not follow a block with difficulty bits", BlockVerifyErrorVersion, block.Hash)
+		}
+		height = prevHeader.Height + 1
+	}
+
+	if block.Height != height {
+		return NewBlockVerifyError(fmt.Sprintf("Legacy block height %d is wrong. Expected %d", block.Height, height),
+			BlockVerifyErrorBits, block.Hash)
+	}
+	return nil
+}
+
+// Checks transactions of a new block are not in legacy format. Legacy transactions are only in old blocks
+func checkBlockTransactionsFormat(block *structures.Block) error {
+	if block.Version == 0 {
+		return nil
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.IsLegacy() {
+			return NewBlockVerifyError(fmt.Sprintf("Transaction in legacy format in a new block: %x", tx.ID),
+				BlockVerifyErrorTransaction, block.Hash)
+		}
+	}
+	return nil
+}
+
+// Checks version of a block. Version 0 is allowed only for blocks made before difficulty bits were
+// stored in blocks. All new blocks have bits, they must have the current version
+func checkBlockVersion(block *structures.Block) error {
+	if block.Version > structures.CurrentBlockVersion {
+		return NewBlockVerifyError(fmt.Sprintf("Block version %d is not supported", block.Version), BlockVerifyErrorVersion, block.Hash)
+	}
+
+	if block.Version < structures.CurrentBlockVersion && !block.IsLegacy() {
+		return NewBlockVerifyError(fmt.Sprintf("Block version %d is too old", block.Version), BlockVerifyErrorVersion, block.Hash)
 	}
 	return nil
 }
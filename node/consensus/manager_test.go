@@ -0,0 +1,160 @@
+package consensus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/database/databasetest"
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+	"github.com/NlaakStudios/democoin/node/transactions"
+)
+
+const testFolderName = "testdata"
+
+// Makes a block in old format without version and bits. PoW is done with bits defined by height
+func makeTestLegacyBlock(t *testing.T, prev *structures.Block, timestamp int64, txs []*transaction.Transaction) *structures.Block {
+	b := &structures.Block{Timestamp: timestamp, Transactions: txs, PrevBlockHash: []byte{}}
+
+	if prev != nil {
+		b.PrevBlockHash = prev.Hash
+		b.Height = prev.Height + 1
+	}
+
+	nonce, hash, err := NewProofOfWork(b).Run()
+
+	if err != nil {
+		t.Fatalf("PoW error: %s", err.Error())
+	}
+	b.Nonce = nonce
+	b.Hash = hash
+
+	return b
+}
+
+func TestVerifyLegacyChain(t *testing.T) {
+	os.RemoveAll(testFolderName)
+	defer os.RemoveAll(testFolderName)
+
+	db, err := databasetest.NewDBManager(testFolderName+"/", "")
+
+	if err != nil {
+		t.Fatalf("Can not prepare DB: %s", err.Error())
+	}
+	defer db.CloseConnection()
+
+	logger := utils.CreateLogger()
+
+	privKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pubKey := append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
+	address, _ := utils.PubKeyToAddres(pubKey)
+
+	now := time.Now().Unix()
+
+	cbTX := &transaction.Transaction{}
+	cbTX.MakeCoinbaseTX(address, "", GetBlockSubsidy(0))
+
+	genesis := makeTestLegacyBlock(t, nil, now-1000, []*transaction.Transaction{cbTX})
+
+	bcdb, _ := db.GetBlockchainObject()
+	genesisData, _ := genesis.Serialize()
+
+	bcdb.PutBlockOnTop(genesis.Hash, genesisData)
+	bcdb.SaveFirstHash(genesis.Hash)
+	bcdb.AddToChain(genesis.Hash, []byte{})
+
+	err = transactions.NewManager(db, logger).BlockAdded(genesis, true)
+
+	if err != nil {
+		t.Fatalf("Genesis caches error: %s", err.Error())
+	}
+
+	// spends the genesis coinbase
+	spendTX := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: cbTX.ID, Vout: 0, PubKey: pubKey}},
+		Vout: []transaction.TXOutput{*transaction.NewTXOutput(cbTX.Vout[0].Value, address)},
+		Time: (now - 3000) * int64(time.Second),
+	}
+
+	signData, err := spendTX.PrepareSignData(map[int]*transaction.Transaction{0: cbTX})
+
+	if err != nil {
+		t.Fatalf("Sign data error: %s", err.Error())
+	}
+	spendTX.SignData(*privKey, pubKey, signData)
+
+	cbTX1 := &transaction.Transaction{}
+	cbTX1.MakeCoinbaseTX(address, "", GetBlockSubsidy(1))
+
+	bm := &NodeBlockMaker{DB: db, Logger: logger}
+
+	// bits are 0. time is before the previous block, median time past was not checked for old blocks
+	block := makeTestLegacyBlock(t, genesis, now-2000, []*transaction.Transaction{cbTX1, spendTX})
+
+	err = bm.VerifyBlock(block)
+
+	if err != nil {
+		t.Fatalf("Legacy block must be valid: %s", err.Error())
+	}
+
+	// bits of legacy block are defined by height. it must be real height in the chain
+	block.Height = 1000
+
+	err = bm.VerifyBlock(block)
+
+	if verr, ok := err.(*BlockVerifyError); !ok || verr.GetKind() != BlockVerifyErrorBits {
+		t.Fatalf("Legacy block with wrong height must be not valid, got: %v", err)
+	}
+}
+
+func TestLegacyBlockAfterNewBlock(t *testing.T) {
+	genesis := makeTestHeader(nil, time.Now().Unix()-1000, 8)
+
+	getHeader := func(hash []byte) (*structures.BlockHeaderInfo, error) {
+		return genesis, nil
+	}
+
+	block := &structures.Block{PrevBlockHash: genesis.Hash, Height: 1, Timestamp: time.Now().Unix()}
+
+	err := checkBlockInChain(block, getHeader)
+
+	if verr, ok := err.(*BlockVerifyError); !ok || verr.GetKind() != BlockVerifyErrorVersion {
+		t.Fatalf("Legacy block after a block with bits must be not valid, got: %v", err)
+	}
+}
+
+func TestLegacyTransactionInNewBlock(t *testing.T) {
+	cbTX := &transaction.Transaction{
+		Vin:  []transaction.TXInput{{Txid: []byte{}, Vout: -1}},
+		Vout: []transaction.TXOutput{{Value: GetBlockSubsidy(0), PubKeyHash: []byte{1}}},
+	}
+
+	tx := &transaction.Transaction{
+		ID:           []byte{1},
+		Vin:          []transaction.TXInput{{Txid: []byte{2}, Vout: 0}},
+		Vout:         []transaction.TXOutput{{Value: 100, PubKeyHash: []byte{1}}},
+		LegacyValues: []float64{0.000001},
+	}
+
+	block := &structures.Block{Transactions: []*transaction.Transaction{cbTX, tx}}
+
+	err := checkBlockTransactionsFormat(block)
+
+	if err != nil {
+		t.Fatalf("Legacy block can have legacy transactions: %s", err.Error())
+	}
+
+	block.Version = structures.CurrentBlockVersion
+	block.Bits = 16
+
+	err = checkBlockTransactionsFormat(block)
+
+	if verr, ok := err.(*BlockVerifyError); !ok || verr.GetKind() != BlockVerifyErrorTransaction {
+		t.Fatalf("Legacy transaction in new block must be not valid, got: %v", err)
+	}
+}
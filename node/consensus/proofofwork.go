@@ -3,10 +3,13 @@ package consensus
 import (
 	"bytes"
 	"crypto/sha256"
+	"fmt"
 	"math"
 	"math/big"
+	"time"
 
 	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/blockchain"
 	"github.com/NlaakStudios/democoin/node/config"
 	"github.com/NlaakStudios/democoin/node/structures"
 )
@@ -26,13 +29,7 @@ type ProofOfWork struct {
 func NewProofOfWork(b *structures.Block) *ProofOfWork {
 	target := big.NewInt(1)
 
-	var tb int
-
-	if b.Height >= 1000 {
-		tb = config.TargetBits_2
-	} else {
-		tb = config.TargetBits
-	}
+	tb := blockchain.GetBlockTargetBits(b)
 
 	target.Lsh(target, uint(256-tb))
 
@@ -42,20 +39,35 @@ func NewProofOfWork(b *structures.Block) *ProofOfWork {
 }
 
 // Prepares data for next iteration of PoW
-// this will be hashed
+// this will be hashed. For blocks with the header it is the serialized header
 func (pow *ProofOfWork) prepareData() ([]byte, error) {
+	if pow.block.Version > 0 {
+		return pow.block.GetHeader().SerializeWithoutNonce(), nil
+	}
+	return pow.prepareLegacyData()
+}
+
+// Prepares data for blocks of version 0. Merkle root was calculated here and not stored
+func (pow *ProofOfWork) prepareLegacyData() ([]byte, error) {
 	txshash, err := pow.block.HashTransactions()
 
 	if err != nil {
 		return nil, err
 	}
 
+	// blocks without stored bits always hashed the initial value
+	databits := pow.block.Bits
+
+	if databits == 0 {
+		databits = config.TargetBits
+	}
+
 	data := bytes.Join(
 		[][]byte{
 			pow.block.PrevBlockHash,
 			txshash,
 			utils.IntToHex(pow.block.Timestamp),
-			utils.IntToHex(int64(config.TargetBits)),
+			utils.IntToHex(int64(databits)),
 		},
 		[]byte{},
 	)
@@ -102,6 +114,7 @@ func (pow *ProofOfWork) Run() (int, []byte, error) {
 
 // Validate validates block's PoW
 // It calculates hash from same data and check if it is equal to block hash
+// Blocks of version 0 are checked only against the target
 func (pow *ProofOfWork) Validate() (bool, error) {
 	var hashInt big.Int
 
@@ -117,5 +130,86 @@ func (pow *ProofOfWork) Validate() (bool, error) {
 
 	isValid := hashInt.Cmp(pow.target) == -1
 
+	if isValid && pow.block.Version > 0 {
+		isValid = bytes.Equal(hash[:], pow.block.Hash)
+	}
+
 	return isValid, nil
 }
+
+// ValidateHeader checks a header of other chain before its block is loaded. PoW must match bits
+// of the header, bits must be as expected for this position in the chain and time must be after
+// median time past and not far in the future. Previous blocks of the chain are got with the function
+// Legacy headers are checked with same rules as legacy blocks. Their PoW data includes transactions,
+// so only the hash is checked against the target here. All is checked again when blocks are added
+func ValidateHeader(hi *structures.BlockHeaderInfo, getHeader HeaderGetter) error {
+	h := &hi.Header
+
+	block := &structures.Block{Version: h.Version, Bits: h.Bits, Timestamp: h.Timestamp, Hash: hi.Hash,
+		PrevBlockHash: h.PrevBlockHash, Height: hi.Height}
+
+	if block.IsLegacy() {
+		if !checkHashBelowTarget(hi.Hash, blockchain.GetBlockTargetBits(block)) {
+			return NewBlockVerifyError("Block hash is not valid", BlockVerifyErrorHash, hi.Hash)
+		}
+		return checkBlockInChain(block, getHeader)
+	}
+
+	err := checkBlockVersion(block)
+
+	if err != nil {
+		return err
+	}
+
+	if !checkHeaderProofOfWork(h, hi.Hash) {
+		return NewBlockVerifyError("Block hash is not valid", BlockVerifyErrorHash, hi.Hash)
+	}
+
+	expectedBits, err := getExpectedTargetBitsInChain(h.PrevBlockHash, getHeader)
+
+	if err != nil {
+		return err
+	}
+
+	if h.Bits != expectedBits {
+		return NewBlockVerifyError(fmt.Sprintf("Block difficulty bits %d are wrong. Expected %d", h.Bits, expectedBits),
+			BlockVerifyErrorBits, hi.Hash)
+	}
+
+	medianTimePast, err := getMedianTimePastInChain(h.PrevBlockHash, getHeader)
+
+	if err != nil {
+		return err
+	}
+
+	return checkBlockTimestamp(block, medianTimePast, time.Now().Unix())
+}
+
+// Checks PoW of a block by its header only. Hash must be the hash of the header
+// and must be below the target defined by header bits
+func checkHeaderProofOfWork(h *structures.BlockHeader, hash []byte) bool {
+	if h.Bits < config.MinTargetBits || h.Bits > config.MaxTargetBits {
+		return false
+	}
+
+	if !bytes.Equal(h.Hash(), hash) {
+		return false
+	}
+
+	return checkHashBelowTarget(hash, h.Bits)
+}
+
+// Checks if a hash is below the target defined by bits
+func checkHashBelowTarget(hash []byte, bits int) bool {
+	if len(hash) != 32 {
+		return false
+	}
+
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-bits))
+
+	var hashInt big.Int
+	hashInt.SetBytes(hash)
+
+	return hashInt.Cmp(target) == -1
+}
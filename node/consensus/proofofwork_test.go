@@ -0,0 +1,421 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/config"
+
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+func TestProofOfWorkOverHeader(t *testing.T) {
+	cbtx := &transaction.Transaction{}
+
+	err := cbtx.MakeCoinbaseTX("1C8Yn8gMoxBYGcVZJc2kpXBL4cYi6HSzeV", "test", 1)
+
+	if err != nil {
+		t.Fatalf("Coinbase error: %s", err.Error())
+	}
+
+	b := &structures.Block{}
+
+	err = b.PrepareNewBlock([]*transaction.Transaction{cbtx}, []byte{}, 0)
+
+	if err != nil {
+		t.Fatalf("Prepare error: %s", err.Error())
+	}
+	b.Bits = 8
+
+	nonce, hash, err := NewProofOfWork(b).Run()
+
+	if err != nil {
+		t.Fatalf("PoW error: %s", err.Error())
+	}
+	b.Nonce = nonce
+	b.Hash = hash
+
+	valid, _ := NewProofOfWork(b).Validate()
+
+	if !valid {
+		t.Fatalf("Block must be valid")
+	}
+
+	// merkle root is covered by PoW
+	b.MerkleRoot[0] ^= 0xff
+
+	valid, _ = NewProofOfWork(b).Validate()
+
+	if valid {
+		t.Fatalf("Block with changed Merkle root must be not valid")
+	}
+}
+
+// block in format used before amounts became int64. same fields as the legacy block of structures
+type testLegacyBlock struct {
+	Timestamp     int64
+	Transactions  []*transaction.LegacyTransaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+	Bits          int
+}
+
+// Merkle data of a transaction as it was calculated with float values
+func getTestLegacyTXBytes(ltx *transaction.LegacyTransaction) []byte {
+	buff := new(bytes.Buffer)
+
+	binary.Write(buff, binary.BigEndian, ltx.ID)
+
+	for _, vin := range ltx.Vin {
+		b, _ := vin.ToBytes()
+		binary.Write(buff, binary.BigEndian, b)
+	}
+
+	for _, vout := range ltx.Vout {
+		binary.Write(buff, binary.BigEndian, vout.Value)
+		binary.Write(buff, binary.BigEndian, vout.PubKeyHash)
+	}
+
+	binary.Write(buff, binary.BigEndian, ltx.Time)
+
+	return buff.Bytes()
+}
+
+func TestLegacyBlockVerifiesAfterUpgrade(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Key error: %s", err.Error())
+	}
+	pubKey := append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
+	pubKeyHash, _ := utils.HashPubKey(pubKey)
+
+	// float values which are not exact in int units
+	prevTX := &transaction.LegacyTransaction{
+		ID:   []byte{1, 2, 3},
+		Vin:  []transaction.TXInput{transaction.TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte("prev")}},
+		Vout: []transaction.LegacyTXOutput{transaction.LegacyTXOutput{Value: 0.1 + 0.2, PubKeyHash: pubKeyHash}},
+		Time: 1,
+	}
+
+	spendTX := &transaction.LegacyTransaction{
+		ID:   []byte{4, 5, 6},
+		Vin:  []transaction.TXInput{transaction.TXInput{Txid: prevTX.ID, Vout: 0, PubKey: pubKey}},
+		Vout: []transaction.LegacyTXOutput{transaction.LegacyTXOutput{Value: 0.1 + 0.2 - 0.1, PubKeyHash: []byte{7, 8, 9}}},
+		Time: 2,
+	}
+
+	// old signing. trimmed copy with a hash of the key of the spent output
+	signCopy := transaction.LegacyTransaction{
+		ID:   []byte{},
+		Vin:  []transaction.TXInput{transaction.TXInput{Txid: prevTX.ID, Vout: 0, PubKey: pubKeyHash}},
+		Vout: spendTX.Vout,
+		Time: spendTX.Time,
+	}
+	signData := []byte(fmt.Sprintf("%x\n", signCopy))
+
+	for {
+		spendTX.Vin[0].Signature, err = utils.SignData(*privKey, signData)
+
+		if err != nil {
+			t.Fatalf("Sign error: %s", err.Error())
+		}
+
+		if v, _ := utils.VerifySignature(spendTX.Vin[0].Signature, signData, pubKey); v {
+			break
+		}
+	}
+
+	cbTX := &transaction.LegacyTransaction{
+		ID:   []byte{10, 11, 12},
+		Vin:  []transaction.TXInput{transaction.TXInput{Txid: []byte{}, Vout: -1, PubKey: []byte("coinbase")}},
+		Vout: []transaction.LegacyTXOutput{transaction.LegacyTXOutput{Value: 10.0, PubKeyHash: pubKeyHash}},
+		Time: 3,
+	}
+
+	lb := testLegacyBlock{
+		Timestamp:     100,
+		Transactions:  []*transaction.LegacyTransaction{cbTX, spendTX},
+		PrevBlockHash: []byte{13, 14, 15},
+		Height:        1,
+		Bits:          8,
+	}
+
+	root := utils.NewMerkleTree([][]byte{getTestLegacyTXBytes(cbTX), getTestLegacyTXBytes(spendTX)}).RootNode.Data
+
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-lb.Bits))
+
+	for {
+		data := bytes.Join([][]byte{
+			lb.PrevBlockHash,
+			root,
+			utils.IntToHex(lb.Timestamp),
+			utils.IntToHex(int64(lb.Bits)),
+			utils.IntToHex(int64(lb.Nonce)),
+		}, []byte{})
+
+		hash := sha256.Sum256(data)
+
+		if new(big.Int).SetBytes(hash[:]).Cmp(target) == -1 {
+			lb.Hash = hash[:]
+			break
+		}
+		lb.Nonce++
+	}
+
+	var buff bytes.Buffer
+
+	err = gob.NewEncoder(&buff).Encode(lb)
+
+	if err != nil {
+		t.Fatalf("Encode error: %s", err.Error())
+	}
+
+	b := &structures.Block{}
+
+	err = b.DeserializeBlock(buff.Bytes())
+
+	if err != nil {
+		t.Fatalf("Legacy block decode error: %s", err.Error())
+	}
+
+	// the upgrade saves the block in new format
+	data, err := b.Serialize()
+
+	if err != nil {
+		t.Fatalf("Serialize error: %s", err.Error())
+	}
+
+	b = &structures.Block{}
+
+	err = b.DeserializeBlock(data)
+
+	if err != nil {
+		t.Fatalf("Upgraded block decode error: %s", err.Error())
+	}
+
+	if b.Transactions[1].Vout[0].Value != transaction.LegacyAmountToUnits(0.1+0.2-0.1) {
+		t.Fatalf("Wrong converted amount %d", b.Transactions[1].Vout[0].Value)
+	}
+
+	valid, err := NewProofOfWork(b).Validate()
+
+	if err != nil || !valid {
+		t.Fatalf("Upgraded legacy block must pass PoW check")
+	}
+
+	prevTXs := map[int]*transaction.Transaction{0: prevTX.ToTransaction()}
+
+	err = b.Transactions[1].Verify(prevTXs)
+
+	if err != nil {
+		t.Fatalf("Upgraded legacy transaction must be valid: %s", err.Error())
+	}
+
+	hash, _ := b.Transactions[1].Hash()
+
+	if !bytes.Equal(hash, spendTX.ID) {
+		t.Fatalf("Legacy transaction ID must be kept")
+	}
+
+	// int amount can not be changed without breaking the check
+	b.Transactions[1].Vout[0].Value++
+
+	err = b.Transactions[1].Verify(prevTXs)
+
+	if err == nil {
+		t.Fatalf("Transaction with changed amount must be not valid")
+	}
+}
+
+func TestBlockVersionCheck(t *testing.T) {
+	tests := []struct {
+		version int
+		bits    int
+		valid   bool
+	}{
+		{0, 0, true},
+		{0, 16, false},
+		{structures.CurrentBlockVersion, 16, true},
+		{structures.CurrentBlockVersion + 1, 16, false},
+	}
+
+	for _, test := range tests {
+		b := &structures.Block{Version: test.version, Bits: test.bits}
+
+		err := checkBlockVersion(b)
+
+		if (err == nil) != test.valid {
+			t.Fatalf("Version %d with bits %d. Expected valid %t, got error %v", test.version, test.bits, test.valid, err)
+		}
+	}
+}
+
+// Makes a header following the previous one and finds its nonce
+func makeTestHeader(prev *structures.BlockHeaderInfo, timestamp int64, bits int) *structures.BlockHeaderInfo {
+	hi := &structures.BlockHeaderInfo{}
+	hi.Header.Version = structures.CurrentBlockVersion
+	hi.Header.MerkleRoot = bytes.Repeat([]byte{1}, 32)
+	hi.Header.Timestamp = timestamp
+	hi.Header.Bits = bits
+
+	if prev != nil {
+		hi.Header.PrevBlockHash = prev.Hash
+		hi.Height = prev.Height + 1
+	}
+
+	for {
+		hi.Hash = hi.Header.Hash()
+
+		if checkHeaderProofOfWork(&hi.Header, hi.Hash) {
+			return hi
+		}
+		hi.Header.Nonce++
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	known := map[string]*structures.BlockHeaderInfo{}
+
+	getHeader := func(hash []byte) (*structures.BlockHeaderInfo, error) {
+		if h, ok := known[string(hash)]; ok {
+			return h, nil
+		}
+		return nil, errors.New("Header not found")
+	}
+
+	now := time.Now().Unix()
+
+	genesis := makeTestHeader(nil, now-1000, config.TargetBits)
+
+	err := ValidateHeader(genesis, getHeader)
+
+	if err != nil {
+		t.Fatalf("Genesis header must be valid: %s", err.Error())
+	}
+	known[string(genesis.Hash)] = genesis
+
+	next := makeTestHeader(genesis, now-900, config.TargetBits)
+
+	err = ValidateHeader(next, getHeader)
+
+	if err != nil {
+		t.Fatalf("Header must be valid: %s", err.Error())
+	}
+
+	tests := []struct {
+		header *structures.BlockHeaderInfo
+		kind   string
+	}{
+		// PoW is done but bits are not expected for this position
+		{makeTestHeader(genesis, now-900, config.TargetBits-1), BlockVerifyErrorBits},
+		// not after median time past
+		{makeTestHeader(genesis, now-1000, config.TargetBits), BlockVerifyErrorTimeTooOld},
+		{makeTestHeader(genesis, now+config.MaxFutureBlockTime+100, config.TargetBits), BlockVerifyErrorTimeTooNew},
+	}
+
+	for _, test := range tests {
+		err = ValidateHeader(test.header, getHeader)
+
+		if err == nil {
+			t.Fatalf("Header must be not valid. Expected %s error", test.kind)
+		}
+
+		if verr, ok := err.(*BlockVerifyError); !ok || verr.GetKind() != test.kind {
+			t.Fatalf("Expected %s error, got: %s", test.kind, err.Error())
+		}
+	}
+
+	// PoW doesn't match the hash
+	next.Header.Nonce++
+
+	if ValidateHeader(next, getHeader) == nil {
+		t.Fatalf("Header with wrong hash must be not valid")
+	}
+}
+
+// Makes a header of legacy block. Its hash can not be checked without transactions
+func makeTestLegacyHeader(prev *structures.BlockHeaderInfo, timestamp int64, hash []byte) *structures.BlockHeaderInfo {
+	hi := &structures.BlockHeaderInfo{Hash: hash}
+	hi.Header.Timestamp = timestamp
+
+	if prev != nil {
+		hi.Header.PrevBlockHash = prev.Hash
+		hi.Height = prev.Height + 1
+	}
+	return hi
+}
+
+func TestValidateLegacyHeader(t *testing.T) {
+	known := map[string]*structures.BlockHeaderInfo{}
+
+	getHeader := func(hash []byte) (*structures.BlockHeaderInfo, error) {
+		if h, ok := known[string(hash)]; ok {
+			return h, nil
+		}
+		return nil, errors.New("Header not found")
+	}
+
+	now := time.Now().Unix()
+
+	// below the target of legacy blocks at low height
+	goodHash := append([]byte{0, 0}, bytes.Repeat([]byte{1}, 30)...)
+
+	genesis := makeTestLegacyHeader(nil, now-1000, goodHash)
+
+	err := ValidateHeader(genesis, getHeader)
+
+	if err != nil {
+		t.Fatalf("Legacy genesis header must be valid: %s", err.Error())
+	}
+	known[string(genesis.Hash)] = genesis
+
+	// time before the previous block was allowed for legacy blocks
+	next := makeTestLegacyHeader(genesis, now-2000, append([]byte{0, 0}, bytes.Repeat([]byte{2}, 30)...))
+
+	err = ValidateHeader(next, getHeader)
+
+	if err != nil {
+		t.Fatalf("Legacy header must be valid: %s", err.Error())
+	}
+
+	// hash is good for any height, but the height is not the position in the chain
+	wrongHeight := makeTestLegacyHeader(genesis, now-900, append([]byte{0, 0, 0}, bytes.Repeat([]byte{1}, 29)...))
+	wrongHeight.Height = 1000
+
+	newGenesis := makeTestHeader(nil, now-1000, config.TargetBits)
+	known[string(newGenesis.Hash)] = newGenesis
+
+	tests := []struct {
+		header *structures.BlockHeaderInfo
+		kind   string
+	}{
+		{makeTestLegacyHeader(genesis, now-900, bytes.Repeat([]byte{1}, 32)), BlockVerifyErrorHash},
+		{wrongHeight, BlockVerifyErrorBits},
+		{makeTestLegacyHeader(newGenesis, now-900, goodHash), BlockVerifyErrorVersion},
+		{makeTestLegacyHeader(genesis, now+config.MaxFutureBlockTime+100, goodHash), BlockVerifyErrorTimeTooNew},
+	}
+
+	for _, test := range tests {
+		err = ValidateHeader(test.header, getHeader)
+
+		if verr, ok := err.(*BlockVerifyError); !ok || verr.GetKind() != test.kind {
+			t.Fatalf("Expected %s error, got: %v", test.kind, err)
+		}
+	}
+}
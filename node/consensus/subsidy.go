@@ -0,0 +1,124 @@
+package consensus
+
+import (
+	"github.com/NlaakStudios/democoin/node/config"
+)
+
+// Emission schedule of the network. It is set from the genesis config when a node starts
+var emission = getDefaultEmission()
+
+// Returns the default schedule from constants
+func getDefaultEmission() config.EmissionConfig {
+	ec := config.EmissionConfig{}
+	ec.SetDefault()
+
+	return ec
+}
+
+// Sets the emission schedule. It must be done before blocks are made or verified
+func SetEmissionConfig(ec config.EmissionConfig) {
+	emission = ec
+}
+
+func GetEmissionConfig() config.EmissionConfig {
+	return emission
+}
+
+// One period of the emission schedule. All blocks in the period have same subsidy
+type EmissionPeriod struct {
+	StartHeight int
+	EndHeight   int
+	Subsidy     int64
+	// total supply after the last block of the period
+	Supply int64
+}
+
+// Returns the subsidy without the supply cap for a block on given height
+func getScheduledSubsidy(height int) int64 {
+	halvings := uint(height / emission.HalvingInterval)
+
+	if halvings >= 63 {
+		return 0
+	}
+	return emission.InitialSubsidy >> halvings
+}
+
+// Returns total amount of coins made by blocks below given height. Supply cap is not applied
+func getScheduledSupplyBefore(height int) int64 {
+	supply := int64(0)
+
+	for start := 0; start < height; start += emission.HalvingInterval {
+		subsidy := getScheduledSubsidy(start)
+
+		if subsidy == 0 {
+			break
+		}
+
+		count := emission.HalvingInterval
+
+		if start+count > height {
+			count = height - start
+		}
+
+		if supply+subsidy*int64(count) >= emission.MaxSupply {
+			return emission.MaxSupply
+		}
+		supply += subsidy * int64(count)
+	}
+	return supply
+}
+
+// Returns total supply of coins after a block with given height is added
+// Genesis block is on height 0 and has a subsidy too
+func GetSupplyAtHeight(height int) int64 {
+	if height < 0 {
+		return 0
+	}
+	supply := getScheduledSupplyBefore(height + 1)
+
+	if supply > emission.MaxSupply {
+		return emission.MaxSupply
+	}
+	return supply
+}
+
+// Returns the reward a miner can get for a block on given height. Fees are not included
+// When the supply cap is reached there is only part of a subsidy or nothing
+func GetBlockSubsidy(height int) int64 {
+	if height < 0 {
+		return 0
+	}
+	subsidy := getScheduledSubsidy(height)
+	left := emission.MaxSupply - GetSupplyAtHeight(height-1)
+
+	if subsidy > left {
+		return left
+	}
+	return subsidy
+}
+
+// Returns the emission curve. List of periods between halvings till subsidy becomes 0
+func GetEmissionSchedule() []EmissionPeriod {
+	periods := []EmissionPeriod{}
+
+	for start := 0; ; start += emission.HalvingInterval {
+		end := start + emission.HalvingInterval - 1
+
+		subsidy := GetBlockSubsidy(start)
+
+		if subsidy == 0 {
+			break
+		}
+
+		left := emission.MaxSupply - GetSupplyAtHeight(start-1)
+
+		if left < subsidy*int64(emission.HalvingInterval) {
+			// supply cap is reached inside of this period. last block can get only a part
+			count := (left + subsidy - 1) / subsidy
+			end = start + int(count) - 1
+		}
+
+		periods = append(periods, EmissionPeriod{start, end, subsidy, GetSupplyAtHeight(end)})
+	}
+	return periods
+}
@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+func TestGetBlockSubsidy(t *testing.T) {
+	tests := []struct {
+		height  int
+		subsidy int64
+	}{
+		{0, config.InitialBlockSubsidy},
+		{config.SubsidyHalvingInterval - 1, config.InitialBlockSubsidy},
+		{config.SubsidyHalvingInterval, config.InitialBlockSubsidy / 2},
+		{config.SubsidyHalvingInterval * 2, config.InitialBlockSubsidy / 4},
+		{config.SubsidyHalvingInterval * 64, 0},
+	}
+
+	for _, test := range tests {
+		subsidy := GetBlockSubsidy(test.height)
+
+		if subsidy != test.subsidy {
+			t.Fatalf("For height %d got subsidy %d, expected %d", test.height, subsidy, test.subsidy)
+		}
+	}
+}
+
+func TestSupplyCap(t *testing.T) {
+	supply := int64(0)
+
+	for _, p := range GetEmissionSchedule() {
+		if p.StartHeight > p.EndHeight {
+			t.Fatalf("Wrong period %d - %d", p.StartHeight, p.EndHeight)
+		}
+		if GetBlockSubsidy(p.StartHeight) != p.Subsidy {
+			t.Fatalf("Period subsidy %d doesn't match block subsidy %d", p.Subsidy, GetBlockSubsidy(p.StartHeight))
+		}
+		if p.Supply < supply || p.Supply > config.MaxSupply {
+			t.Fatalf("Wrong supply %d after period %d - %d", p.Supply, p.StartHeight, p.EndHeight)
+		}
+		supply = p.Supply
+	}
+
+	last := config.SubsidyHalvingInterval * 70
+
+	if GetSupplyAtHeight(last) != supply {
+		t.Fatalf("Supply %d after the schedule end doesn't match %d", GetSupplyAtHeight(last), supply)
+	}
+
+	// supply is sum of subsidies
+	height := config.SubsidyHalvingInterval + 5
+
+	if GetSupplyAtHeight(height)-GetSupplyAtHeight(height-1) != GetBlockSubsidy(height) {
+		t.Fatalf("Supply change doesn't match the subsidy")
+	}
+}
+
+func TestCustomEmission(t *testing.T) {
+	defer SetEmissionConfig(getDefaultEmission())
+
+	SetEmissionConfig(config.EmissionConfig{InitialSubsidy: 100, HalvingInterval: 10, MaxSupply: 1500})
+
+	if GetBlockSubsidy(9) != 100 || GetBlockSubsidy(10) != 50 {
+		t.Fatalf("Subsidy doesn't follow the custom schedule")
+	}
+
+	// 10 blocks by 100 and 10 blocks by 50
+	if GetSupplyAtHeight(19) != 1500 || GetBlockSubsidy(20) != 0 {
+		t.Fatalf("Supply cap of the custom schedule is not applied")
+	}
+
+	// when subsidy is over a block without fees has zero coinbase
+	cbtx := &transaction.Transaction{}
+
+	err := cbtx.MakeCoinbaseTX("1C8Yn8gMoxBYGcVZJc2kpXBL4cYi6HSzeV", "test", GetBlockSubsidy(20))
+
+	if err != nil {
+		t.Fatalf("Coinbase error: %s", err.Error())
+	}
+
+	err = cbtx.Verify(nil)
+
+	if err != nil {
+		t.Fatalf("Zero coinbase must be valid: %s", err.Error())
+	}
+}
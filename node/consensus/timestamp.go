@@ -0,0 +1,81 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/transactions"
+)
+
+// Returns median timestamp of last MedianTimePastBlocks blocks of the branch ending with given block
+// Returns 0 for genesis block (no previous blocks)
+func (n *NodeBlockMaker) getMedianTimePast(prevBlockHash []byte) (int64, error) {
+	return getMedianTimePastInChain(prevBlockHash, n.getBlockchainHeaders())
+}
+
+// Returns median timestamp of last MedianTimePastBlocks blocks of a chain ending with given block
+func getMedianTimePastInChain(prevBlockHash []byte, getHeader HeaderGetter) (int64, error) {
+	timestamps := []int64{}
+
+	hash := prevBlockHash
+
+	for len(hash) > 0 && len(timestamps) < config.MedianTimePastBlocks {
+		header, err := getHeader(hash)
+
+		if err != nil {
+			return 0, err
+		}
+
+		timestamps = append(timestamps, header.Header.Timestamp)
+		hash = header.Header.PrevBlockHash
+	}
+
+	return medianTimestamp(timestamps), nil
+}
+
+// Returns median of timestamps. Order of the list is not important
+func medianTimestamp(timestamps []int64) int64 {
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sorted := make([]int64, len(timestamps))
+	copy(sorted, timestamps)
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[len(sorted)/2]
+}
+
+// Checks timestamp of a block. It must be greater than median time past of previous blocks
+// and not too far in the future comparing to local time. now is in seconds
+func checkBlockTimestamp(block *structures.Block, medianTimePast int64, now int64) error {
+	if block.Timestamp <= medianTimePast {
+		return NewBlockVerifyError(fmt.Sprintf("Block time %d is not after median time of previous blocks %d",
+			block.Timestamp, medianTimePast), BlockVerifyErrorTimeTooOld, block.Hash)
+	}
+
+	maxTime := now + config.MaxFutureBlockTime
+
+	if block.Timestamp > maxTime {
+		return NewBlockVerifyError(fmt.Sprintf("Block time %d is too far in the future. Max %d",
+			block.Timestamp, maxTime), BlockVerifyErrorTimeTooNew, block.Hash)
+	}
+	return nil
+}
+
+// Checks times of transactions in a block. Transactions can not be made much later than the block
+func checkBlockTransactionsTime(block *structures.Block) error {
+	blockTime := block.Timestamp * int64(time.Second)
+
+	for _, tx := range block.Transactions {
+		err := transactions.CheckTransactionTime(tx, blockTime)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+	"github.com/NlaakStudios/democoin/node/transactions"
+)
+
+func TestMedianTimestamp(t *testing.T) {
+	tests := []struct {
+		timestamps []int64
+		result     int64
+	}{
+		{[]int64{}, 0},
+		{[]int64{5}, 5},
+		{[]int64{3, 1, 2}, 2},
+		{[]int64{10, 40, 20, 30}, 30},
+		{[]int64{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 6},
+	}
+
+	for _, test := range tests {
+		median := medianTimestamp(test.timestamps)
+
+		if median != test.result {
+			t.Fatalf("For %v got median %d, expected %d", test.timestamps, median, test.result)
+		}
+	}
+}
+
+func TestCheckBlockTimestamp(t *testing.T) {
+	now := int64(1000000)
+
+	tests := []struct {
+		timestamp int64
+		median    int64
+		kind      string
+	}{
+		{now, now - 100, ""},
+		{now - 99, now - 100, ""},
+		{now - 100, now - 100, BlockVerifyErrorTimeTooOld},
+		{now - 200, now - 100, BlockVerifyErrorTimeTooOld},
+		{now + config.MaxFutureBlockTime, now - 100, ""},
+		{now + config.MaxFutureBlockTime + 1, now - 100, BlockVerifyErrorTimeTooNew},
+	}
+
+	for _, test := range tests {
+		block := &structures.Block{Timestamp: test.timestamp}
+
+		err := checkBlockTimestamp(block, test.median, now)
+
+		if test.kind == "" {
+			if err != nil {
+				t.Fatalf("Block time %d should be valid. Got error %s", test.timestamp, err.Error())
+			}
+			continue
+		}
+
+		verr, ok := err.(*BlockVerifyError)
+
+		if !ok {
+			t.Fatalf("Block time %d should fail with verify error", test.timestamp)
+		}
+
+		if verr.GetKind() != test.kind {
+			t.Fatalf("Block time %d failed with kind %s, expected %s", test.timestamp, verr.GetKind(), test.kind)
+		}
+	}
+}
+
+func TestCheckBlockTransactionsTime(t *testing.T) {
+	now := time.Now().Unix()
+
+	// coinbase transaction has no time
+	cbtx := &transaction.Transaction{}
+	cbtx.Vin = []transaction.TXInput{{Txid: []byte{}, Vout: -1}}
+
+	tx := &transaction.Transaction{}
+	tx.Vin = []transaction.TXInput{{Txid: []byte{1}, Vout: 0}}
+	tx.Time = now * int64(time.Second)
+
+	block := &structures.Block{Timestamp: now}
+	block.Transactions = []*transaction.Transaction{tx, cbtx}
+
+	if err := checkBlockTransactionsTime(block); err != nil {
+		t.Fatalf("Transactions time should be valid. Got error %s", err.Error())
+	}
+
+	tx.Time = (now + config.MaxFutureTransactionTime + 1) * int64(time.Second)
+
+	if err, ok := checkBlockTransactionsTime(block).(*transactions.TXVerifyError); !ok || err.GetKind() != transactions.TXVerifyErrorTime {
+		t.Fatalf("Transaction from future should fail with time error")
+	}
+
+	tx.Time = 0
+
+	if err, ok := checkBlockTransactionsTime(block).(*transactions.TXVerifyError); !ok || err.GetKind() != transactions.TXVerifyErrorTime {
+		t.Fatalf("Transaction without time should fail with time error")
+	}
+}
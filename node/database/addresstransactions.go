@@ -0,0 +1,139 @@
+package database
+
+import (
+	"bytes"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+)
+
+const addressTransactionsBucket = "addresstransactions"
+
+// Index of transactions of addresses in the primary chain. Key is a length of a public key hash,
+// the hash, a height of a block and ID of a transaction. So, records of an address are ordered by height
+type AddressTransactions struct {
+	DB *KVDB
+}
+
+func (at *AddressTransactions) InitDB() error {
+	return at.DB.update(func(tx StorageTx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(addressTransactionsBucket))
+
+		return err
+	})
+}
+
+func (at *AddressTransactions) TruncateDB() error {
+	return at.DB.update(func(tx StorageTx) error {
+		err := tx.DeleteBucket([]byte(addressTransactionsBucket))
+
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(addressTransactionsBucket))
+
+		return err
+	})
+}
+
+// get count of records in the table
+func (at *AddressTransactions) GetCount() (int, error) {
+	// DB created before the index existed has no the bucket
+	err := at.InitDB()
+
+	if err != nil {
+		return 0, err
+	}
+	return at.DB.getCountInBucket(addressTransactionsBucket)
+}
+
+func (at *AddressTransactions) getAddressPrefix(pubKeyHash []byte) []byte {
+	return append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+}
+
+func (at *AddressTransactions) getKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := at.getAddressPrefix(pubKeyHash)
+	key = append(key, utils.IntToHex(int64(height))...)
+
+	return append(key, txID...)
+}
+
+// Save records of a transaction for an address
+func (at *AddressTransactions) PutTransaction(pubKeyHash []byte, height int, txID []byte, txData []byte) error {
+	return at.DB.update(func(tx StorageTx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(addressTransactionsBucket))
+
+		if err != nil {
+			return err
+		}
+		return b.Put(at.getKey(pubKeyHash, height, txID), txData)
+	})
+}
+
+// Delete records of a transaction for an address
+func (at *AddressTransactions) DeleteTransaction(pubKeyHash []byte, height int, txID []byte) error {
+	return at.DB.update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(addressTransactionsBucket))
+
+		if b == nil {
+			return nil
+		}
+		return b.Delete(at.getKey(pubKeyHash, height, txID))
+	})
+}
+
+// Returns records of an address in blocks below given height, from top to bottom.
+// Zero height means from the top. Records of a height are not split between pages, so more than
+// maxCount records can be returned. Zero maxCount means all records
+func (at *AddressTransactions) GetTransactions(pubKeyHash []byte, beforeHeight int, maxCount int) ([][]byte, error) {
+	result := [][]byte{}
+
+	prefix := at.getAddressPrefix(pubKeyHash)
+
+	var seek []byte
+
+	if beforeHeight > 0 {
+		seek = append(utils.CopyBytes(prefix), utils.IntToHex(int64(beforeHeight))...)
+	} else {
+		// next address prefix. it is after all records of the address
+		seek = append(utils.CopyBytes(prefix), bytes.Repeat([]byte{0xff}, 9)...)
+	}
+
+	heightEnd := len(prefix) + 8
+
+	err := at.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(addressTransactionsBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		k, v := c.Seek(seek)
+
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		var lastHeight []byte
+
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(k) >= heightEnd; k, v = c.Prev() {
+			height := k[len(prefix):heightEnd]
+
+			if maxCount > 0 && len(result) >= maxCount && !bytes.Equal(height, lastHeight) {
+				break
+			}
+			lastHeight = utils.CopyBytes(height)
+
+			result = append(result, utils.CopyBytes(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
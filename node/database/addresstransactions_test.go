@@ -0,0 +1,63 @@
+package database
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestAddressTransactionsPaging(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	atdb, err := man.GetAddressTransactionsObject()
+
+	assert.NoError(t, err, "Can not get address transactions object")
+
+	addr1 := []byte{1, 1, 1}
+	addr2 := []byte{1, 1, 1, 2}
+
+	// two transactions on height 2
+	atdb.PutTransaction(addr1, 1, []byte{10}, []byte{1})
+	atdb.PutTransaction(addr1, 2, []byte{20}, []byte{2})
+	atdb.PutTransaction(addr1, 2, []byte{21}, []byte{2})
+	atdb.PutTransaction(addr1, 300, []byte{30}, []byte{3})
+	atdb.PutTransaction(addr2, 2, []byte{20}, []byte{4})
+
+	list, err := atdb.GetTransactions(addr1, 0, 0)
+
+	assert.NoError(t, err, "Get full history")
+	assert.Equal(t, [][]byte{{3}, {2}, {2}, {1}}, list, "Full history from top")
+
+	list, err = atdb.GetTransactions(addr1, 0, 2)
+
+	assert.NoError(t, err, "Get first page")
+	assert.Equal(t, [][]byte{{3}, {2}, {2}}, list, "Records of a block should not be split")
+
+	list, err = atdb.GetTransactions(addr1, 2, 2)
+
+	assert.NoError(t, err, "Get next page")
+	assert.Equal(t, [][]byte{{1}}, list, "Next page should start below given height")
+
+	list, err = atdb.GetTransactions(addr2, 0, 0)
+
+	assert.NoError(t, err, "Get other address history")
+	assert.Equal(t, [][]byte{{4}}, list, "Only records of the address should be returned")
+
+	err = atdb.DeleteTransaction(addr1, 300, []byte{30})
+
+	assert.NoError(t, err, "Delete record")
+
+	list, err = atdb.GetTransactions(addr1, 0, 1)
+
+	assert.NoError(t, err, "Get history after delete")
+	assert.Equal(t, [][]byte{{2}, {2}}, list, "Deleted record should not be returned")
+
+	count, err := atdb.GetCount()
+
+	assert.NoError(t, err, "Get count")
+	assert.Equal(t, 4, count, "Count of records")
+}
@@ -2,21 +2,23 @@ package database
 
 import (
 	"bytes"
+	"encoding/binary"
 
-	"github.com/boltdb/bolt"
 	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
 const blocksBucket = "blocks"
 const blockChainBucket = "blockchain"
+const blocksWorkBucket = "blockswork"
+const chainHeightsBucket = "chainheights"
 
 type Blockchain struct {
-	DB *BoltDB
+	DB *KVDB
 }
 
 // create bucket etc. DB is already inited
 func (bc *Blockchain) InitDB() error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx StorageTx) error {
 		_, err := tx.CreateBucket([]byte(blocksBucket))
 
 		if err != nil {
@@ -24,6 +26,16 @@ func (bc *Blockchain) InitDB() error {
 		}
 		_, err = tx.CreateBucket([]byte(blockChainBucket))
 
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte(blocksWorkBucket))
+
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte(chainHeightsBucket))
+
 		if err != nil {
 			return err
 		}
@@ -47,7 +59,7 @@ func (bc *Blockchain) GetTopBlock() ([]byte, error) {
 func (bc *Blockchain) GetBlock(hash []byte) ([]byte, error) {
 	var blockData []byte
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -97,7 +109,7 @@ func (bc *Blockchain) PutBlockOnTop(hash []byte, blockdata []byte) error {
 
 // Add block record
 func (bc *Blockchain) PutBlock(hash []byte, blockdata []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -111,21 +123,73 @@ func (bc *Blockchain) PutBlock(hash []byte, blockdata []byte) error {
 
 // Delete block record
 func (bc *Blockchain) DeleteBlock(hash []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
 			return NewDBIsNotReadyError()
 		}
 
-		return b.Delete(hash)
+		err := b.Delete(hash)
+
+		if err != nil {
+			return err
+		}
+
+		// work record is not present in DBs created before it was added
+		bw := tx.Bucket([]byte(blocksWorkBucket))
+
+		if bw == nil {
+			return nil
+		}
+		return bw.Delete(hash)
 	})
 	return err
 }
 
+// Save total work of a chain ending with the block. It is big number as bytes
+func (bc *Blockchain) PutBlockWork(hash []byte, work []byte) error {
+	return bc.DB.update(func(tx StorageTx) error {
+		// DB can be created before work was stored. create bucket if missed
+		b, err := tx.CreateBucketIfNotExists([]byte(blocksWorkBucket))
+
+		if err != nil {
+			return err
+		}
+
+		return b.Put(hash, work)
+	})
+}
+
+// Get total work of a chain ending with the block. Returns nil if it was not saved yet
+func (bc *Blockchain) GetBlockWork(hash []byte) ([]byte, error) {
+	var work []byte
+
+	err := bc.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksWorkBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		work = b.Get(hash)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(work) > 0 {
+		work = utils.CopyBytes(work)
+	}
+
+	return work, nil
+}
+
 // Save top level block hash
 func (bc *Blockchain) SaveTopHash(hash []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -142,7 +206,7 @@ func (bc *Blockchain) SaveTopHash(hash []byte) error {
 func (bc *Blockchain) GetTopHash() ([]byte, error) {
 	var topHash []byte
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -167,7 +231,7 @@ func (bc *Blockchain) GetTopHash() ([]byte, error) {
 
 // Save first (or genesis) block hash. It should be called when blockchain is created
 func (bc *Blockchain) SaveFirstHash(hash []byte) error {
-	err := bc.DB.db.Update(func(tx *bolt.Tx) error {
+	err := bc.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -181,7 +245,7 @@ func (bc *Blockchain) SaveFirstHash(hash []byte) error {
 func (bc *Blockchain) GetFirstHash() ([]byte, error) {
 	var firstHash []byte
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
@@ -204,6 +268,55 @@ func (bc *Blockchain) GetFirstHash() ([]byte, error) {
 	return nil, NewNotFoundDBError("firsthash")
 }
 
+// Save version of data format in the DB. It is used to upgrade old DBs
+func (bc *Blockchain) SaveDBVersion(version int) error {
+	err := bc.DB.update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		return b.Put([]byte("v"), utils.IntToHex(int64(version)))
+	})
+	return err
+}
+
+// Returns version of data format in the DB. It is 0 if DB was created before versions were saved
+func (bc *Blockchain) GetDBVersion() (int, error) {
+	version := 0
+
+	err := bc.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+		versionData := b.Get([]byte("v"))
+
+		if len(versionData) == 8 {
+			version = int(binary.BigEndian.Uint64(versionData))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// execute functon for each block record. Special records (top hash etc) are skipped
+func (bc *Blockchain) ForEachBlock(callback ForEachKeyIteratorInterface) error {
+	return bc.DB.forEachInBucket(blocksBucket, func(k, v []byte) error {
+		if len(k) == 1 {
+			// "l", "f", "v". block hash is always longer
+			return nil
+		}
+		return callback(k, v)
+	})
+}
+
 // add block to chain
 func (bc *Blockchain) AddToChain(hash, prevHash []byte) error {
 	length := len(hash)
@@ -214,7 +327,7 @@ func (bc *Blockchain) AddToChain(hash, prevHash []byte) error {
 
 	emptyHash := make([]byte, length)
 
-	return bc.DB.db.Update(func(tx *bolt.Tx) error {
+	return bc.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -255,10 +368,57 @@ func (bc *Blockchain) AddToChain(hash, prevHash []byte) error {
 			copy(hashBytes[0:], prevHash)
 		}
 
+		err := bc.addChainHeight(tx, hash, prevHash)
+
+		if err != nil {
+			return err
+		}
+
 		return b.Put(hash, hashBytes)
 	})
 }
 
+// Puts a hash to the heights index. Previous hash must be the last one in the index.
+// Executed in same transaction as update of chain records
+func (bc *Blockchain) addChainHeight(tx StorageTx, hash, prevHash []byte) error {
+	// DB can be created before the index existed. it is built on upgrade
+	b, err := tx.CreateBucketIfNotExists([]byte(chainHeightsBucket))
+
+	if err != nil {
+		return err
+	}
+
+	height := int64(0)
+
+	if len(prevHash) > 0 {
+		k, v := b.Cursor().Last()
+
+		if k == nil || !bytes.Equal(v, prevHash) {
+			return NewHashDBError("Previous hash is not last in the heights index")
+		}
+		height = int64(binary.BigEndian.Uint64(k)) + 1
+	}
+
+	return b.Put(utils.IntToHex(height), hash)
+}
+
+// Removes a hash from the heights index. Only last hash can be removed
+func (bc *Blockchain) removeChainHeight(tx StorageTx, hash []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(chainHeightsBucket))
+
+	if err != nil {
+		return err
+	}
+
+	k, v := b.Cursor().Last()
+
+	if k == nil || !bytes.Equal(v, hash) {
+		return NewHashDBError("Hash is not last in the heights index")
+	}
+
+	return b.Delete(utils.CopyBytes(k))
+}
+
 // remove block from chain
 func (bc *Blockchain) RemoveFromChain(hash []byte) error {
 	length := len(hash)
@@ -269,7 +429,7 @@ func (bc *Blockchain) RemoveFromChain(hash []byte) error {
 
 	emptyHash := make([]byte, length)
 
-	return bc.DB.db.Update(func(tx *bolt.Tx) error {
+	return bc.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -315,6 +475,12 @@ func (bc *Blockchain) RemoveFromChain(hash []byte) error {
 
 		}
 
+		err := bc.removeChainHeight(tx, hash)
+
+		if err != nil {
+			return err
+		}
+
 		return b.Delete(hash)
 	})
 }
@@ -328,7 +494,7 @@ func (bc *Blockchain) BlockInChain(hash []byte) (bool, error) {
 
 	found := false
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -362,7 +528,7 @@ func (bc *Blockchain) GetLocationInChain(hash []byte) (bool, []byte, []byte, err
 
 	found := false
 
-	err := bc.DB.db.View(func(tx *bolt.Tx) error {
+	err := bc.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(blockChainBucket))
 
 		if b == nil {
@@ -398,3 +564,82 @@ func (bc *Blockchain) GetLocationInChain(hash []byte) (bool, []byte, []byte, err
 
 	return found, prevHash, nextHash, nil
 }
+
+// Returns hash of a block on given height in the chain. Nil if there is no such
+func (bc *Blockchain) GetHashAtHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(chainHeightsBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		hash = utils.CopyBytes(b.Get(utils.IntToHex(int64(height))))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hash) == 0 {
+		return nil, nil
+	}
+	return hash, nil
+}
+
+// Builds the heights index from chain records. Goes from the first hash by next hashes
+func (bc *Blockchain) ReindexChainHeights() error {
+	firstHash, err := bc.GetFirstHash()
+
+	if err != nil {
+		return err
+	}
+
+	return bc.DB.update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blockChainBucket))
+
+		if b == nil {
+			return NewDBIsNotReadyError()
+		}
+
+		err := tx.DeleteBucket([]byte(chainHeightsBucket))
+
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+
+		hb, err := tx.CreateBucket([]byte(chainHeightsBucket))
+
+		if err != nil {
+			return err
+		}
+
+		length := len(firstHash)
+		emptyHash := make([]byte, length)
+
+		hash := firstHash
+
+		for height := int64(0); ; height++ {
+			rec := b.Get(hash)
+
+			if len(rec) < length*2 {
+				return NewHashNotFoundDBError("Hash is not found in the chain")
+			}
+
+			err := hb.Put(utils.IntToHex(height), hash)
+
+			if err != nil {
+				return err
+			}
+
+			if bytes.Equal(rec[length:], emptyHash) {
+				break
+			}
+			hash = utils.CopyBytes(rec[length:])
+		}
+		return nil
+	})
+}
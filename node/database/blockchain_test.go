@@ -10,53 +10,53 @@ import (
 
 const testFolderName = "testdata"
 
-func getTestDBManagerInited() (*BoltDBManager, error) {
-	obj, err := getTestDBManager()
+func getTestDBManagerInited() (*KVDBManager, error) {
+	destroyTestDB(nil)
 
-	if err != nil {
-		return nil, err
-	}
+	return newTestDBManager(testFolderName+"/", "")
+}
 
-	err = obj.InitDatabase()
+// Creates and opens a DB in a directory. Empty backend means the default backend
+func newTestDBManager(dataDir string, backend string) (*KVDBManager, error) {
+	err := os.MkdirAll(dataDir, 0744)
 
 	if err != nil {
 		return nil, err
 	}
 
-	err = obj.OpenConnection("testing")
+	c := DatabaseConfig{}
+	c.SetDefault()
+	c.DataDir = dataDir
 
-	if err != nil {
-		return nil, err
+	if backend != "" {
+		c.Backend = backend
 	}
 
-	return obj, nil
-}
-func getTestDBManager() (*BoltDBManager, error) {
-	destroyTestDB(nil)
+	obj := &KVDBManager{}
+	obj.SetLockerObject(obj.GetLockerObject())
+	obj.SetLogger(utils.CreateLogger())
 
-	err := os.Mkdir(testFolderName, 0744)
+	err = obj.SetConfig(c)
 
 	if err != nil {
 		return nil, err
 	}
 
-	logger := utils.CreateLogger()
-	logger.EnableLogs("")
-	logger.LogToStdout()
+	err = obj.InitDatabase()
 
-	c := DatabaseConfig{}
-	c.SetDefault()
-	c.DataDir = testFolderName + "/"
+	if err != nil {
+		return nil, err
+	}
 
-	obj := &BoltDBManager{}
-	obj.SetLockerObject(obj.GetLockerObject())
-	obj.SetLogger(logger)
-	obj.SetConfig(c)
+	err = obj.OpenConnection("testing")
 
+	if err != nil {
+		return nil, err
+	}
 	return obj, nil
 }
 
-func destroyTestDB(man *BoltDBManager) {
+func destroyTestDB(man *KVDBManager) {
 
 	if man != nil {
 		man.CloseConnection()
@@ -206,3 +206,275 @@ func TestBlockChainRemove(t *testing.T) {
 	assert.True(t, len(prevHash) > 0, "Prev hash should be present for hash2 (3)")
 	assert.True(t, len(nextHash) == 0, "No next hash for hash2")
 }
+
+func TestBlockWork(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	bcm, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Can not get BC object")
+
+	hash1 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+
+	work, err := bcm.GetBlockWork(hash1)
+
+	assert.NoError(t, err, "Get missed work")
+	assert.True(t, len(work) == 0, "Work should not be present")
+
+	err = bcm.PutBlockWork(hash1, []byte{1, 0, 0})
+
+	assert.NoError(t, err, "Put work")
+
+	work, err = bcm.GetBlockWork(hash1)
+
+	assert.NoError(t, err, "Get work")
+	assert.Equal(t, []byte{1, 0, 0}, work, "Work should be same as saved")
+
+	err = bcm.PutBlock(hash1, []byte{1})
+
+	assert.NoError(t, err, "Put block")
+
+	err = bcm.DeleteBlock(hash1)
+
+	assert.NoError(t, err, "Delete block")
+
+	work, err = bcm.GetBlockWork(hash1)
+
+	assert.NoError(t, err, "Get work after delete")
+	assert.True(t, len(work) == 0, "Work should be deleted with a block")
+}
+
+func TestSyncHeaders(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	hdb, err := man.GetSyncHeadersObject()
+
+	assert.NoError(t, err, "Can not get sync headers object")
+
+	first, err := hdb.GetFirstHeader()
+
+	assert.NoError(t, err, "Get first of empty list")
+	assert.Nil(t, first, "No headers expected")
+
+	for h := 5; h <= 300; h++ {
+		err = hdb.PutHeader(h, []byte{byte(h % 256), 1})
+
+		assert.NoError(t, err, "Put header")
+	}
+
+	first, err = hdb.GetFirstHeader()
+
+	assert.NoError(t, err, "Get first header")
+	assert.Equal(t, []byte{5, 1}, first, "First header should be on height 5")
+
+	last, err := hdb.GetLastHeader()
+
+	assert.NoError(t, err, "Get last header")
+	assert.Equal(t, []byte{byte(300 % 256), 1}, last, "Last header should be on height 300")
+
+	err = hdb.DeleteHeader(5)
+
+	assert.NoError(t, err, "Delete header")
+
+	err = hdb.DeleteHeadersFrom(100)
+
+	assert.NoError(t, err, "Delete headers from height")
+
+	count, err := hdb.GetCount()
+
+	assert.NoError(t, err, "Get count")
+	assert.Equal(t, 94, count, "Headers 6-99 should remain")
+
+	header, err := hdb.GetHeader(100)
+
+	assert.NoError(t, err, "Get deleted header")
+	assert.Nil(t, header, "Header should be deleted")
+
+	last, err = hdb.GetLastHeader()
+
+	assert.NoError(t, err, "Get last header")
+	assert.Equal(t, []byte{99, 1}, last, "Last header should be on height 99")
+}
+
+func TestNodeBans(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	ndb, err := man.GetNodesObject()
+
+	assert.NoError(t, err, "Can not get nodes object")
+
+	err = ndb.PutBan([]byte("10.0.0.1"), []byte{1})
+
+	assert.NoError(t, err, "Put ban")
+
+	err = ndb.PutBan([]byte("10.0.0.2"), []byte{2})
+
+	assert.NoError(t, err, "Put ban")
+
+	err = ndb.DeleteBan([]byte("10.0.0.1"))
+
+	assert.NoError(t, err, "Delete ban")
+
+	bans := map[string][]byte{}
+
+	err = ndb.ForEachBan(func(k, v []byte) error {
+		bans[string(k)] = v
+		return nil
+	})
+
+	assert.NoError(t, err, "Iterate bans")
+	assert.Equal(t, map[string][]byte{"10.0.0.2": []byte{2}}, bans, "Only one ban expected")
+
+	count, err := ndb.GetCount()
+
+	assert.NoError(t, err, "Count nodes")
+	assert.Equal(t, 0, count, "Bans are not nodes")
+}
+
+func TestBlockChainHeights(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	bcm, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Can not get BC object")
+
+	hash1 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+	hash2 := []byte{0, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+	hash3 := []byte{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+
+	bcm.SaveFirstHash(hash1)
+	bcm.AddToChain(hash1, nil)
+	bcm.AddToChain(hash2, hash1)
+	bcm.AddToChain(hash3, hash2)
+
+	hash, err := bcm.GetHashAtHeight(2)
+
+	assert.NoError(t, err, "Get hash at height 2")
+	assert.Equal(t, hash3, hash, "Hash3 should be on height 2")
+
+	err = bcm.RemoveFromChain(hash3)
+
+	assert.NoError(t, err, "Remove hash3")
+
+	hash, err = bcm.GetHashAtHeight(2)
+
+	assert.NoError(t, err, "Get hash at height 2 after remove")
+	assert.Nil(t, hash, "Height 2 should be empty after remove")
+
+	// add it back
+	err = bcm.AddToChain(hash3, hash2)
+
+	assert.NoError(t, err, "Add hash3 again")
+
+	hash, err = bcm.GetHashAtHeight(2)
+
+	assert.NoError(t, err, "Get hash at height 2 again")
+	assert.Equal(t, hash3, hash, "Hash3 should be on height 2 again")
+
+	// index is built again from chain records
+	err = bcm.ReindexChainHeights()
+
+	assert.NoError(t, err, "Reindex heights")
+
+	for height, expected := range [][]byte{hash1, hash2, hash3} {
+		hash, err = bcm.GetHashAtHeight(height)
+
+		assert.NoError(t, err, "Get hash after reindex")
+		assert.Equal(t, expected, hash, "Wrong hash after reindex")
+	}
+}
+
+func TestExecuteInTransaction(t *testing.T) {
+	man, err := getTestDBManagerInited()
+
+	defer destroyTestDB(man)
+
+	assert.NoError(t, err, "Can not prepare data")
+
+	bcm, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Can not get BC object")
+
+	uodb, err := man.GetUnspentOutputsObject()
+
+	assert.NoError(t, err, "Can not get unspent outputs object")
+
+	hash1 := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0}
+	hash2 := []byte{0, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	// callback fails. nothing is saved
+	err = man.ExecuteInTransaction(func() error {
+		bcm.PutBlock(hash1, []byte{1})
+		uodb.PutDataForTransaction(hash1, []byte{1})
+
+		exists, _ := bcm.CheckBlockExists(hash1)
+
+		assert.True(t, exists, "Block should be visible inside of the transaction")
+
+		return NewHashDBError("Test error")
+	})
+
+	assert.Error(t, err, "Error of callback should be returned")
+
+	exists, err := bcm.CheckBlockExists(hash1)
+
+	assert.NoError(t, err, "Check block after rollback")
+	assert.False(t, exists, "Block should not be saved after rollback")
+
+	txdata, err := uodb.GetDataForTransaction(hash1)
+
+	assert.NoError(t, err, "Get unspent after rollback")
+	assert.Nil(t, txdata, "Unspent outputs should not be saved after rollback")
+
+	// failed write is ignored by the callback. the transaction is still not saved
+	err = man.ExecuteInTransaction(func() error {
+		bcm.PutBlock(hash1, []byte{1})
+		bcm.AddToChain(hash2, hash1)
+
+		return nil
+	})
+
+	assert.Error(t, err, "Error of failed write should be returned")
+
+	exists, err = bcm.CheckBlockExists(hash1)
+
+	assert.NoError(t, err, "Check block after failed write")
+	assert.False(t, exists, "Block should not be saved after failed write")
+
+	err = man.ExecuteInTransaction(func() error {
+		err := bcm.PutBlock(hash1, []byte{1})
+
+		if err != nil {
+			return err
+		}
+		return uodb.PutDataForTransaction(hash1, []byte{1})
+	})
+
+	assert.NoError(t, err, "Transaction should be saved")
+
+	exists, err = bcm.CheckBlockExists(hash1)
+
+	assert.NoError(t, err, "Check block after commit")
+	assert.True(t, exists, "Block should be saved after commit")
+
+	txdata, err = uodb.GetDataForTransaction(hash1)
+
+	assert.NoError(t, err, "Get unspent after commit")
+	assert.Equal(t, []byte{1}, txdata, "Unspent outputs should be saved after commit")
+}
@@ -4,6 +4,7 @@ type DatabaseConfig struct {
 	DataDir        string
 	BlockchainFile string
 	NodesFile      string
+	Backend        string // storage backend. bolt if empty
 }
 
 func (dbc *DatabaseConfig) IsEmpty() bool {
@@ -16,5 +17,6 @@ func (dbc *DatabaseConfig) IsEmpty() bool {
 func (dbc *DatabaseConfig) SetDefault() error {
 	dbc.BlockchainFile = "blockchain.db"
 	dbc.NodesFile = "nodeslist.db"
+	dbc.Backend = DefaultBackend
 	return nil
 }
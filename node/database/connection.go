@@ -1,26 +1,94 @@
 package database
 
-import (
-	"github.com/boltdb/bolt"
-)
-
-type BoltDB struct {
-	db       *bolt.DB
+// Connection to a storage of a DB file
+type KVDB struct {
+	storage  Storage
+	file     string
+	shared   bool // storage of shared backend. it is released, not closed
 	lockFile string
+	tx       StorageTx // transaction of a running batch. all operations use it while it is set
+	txErr    error     // first failed write in the batch. the batch is not saved if it is set
+	readTx   StorageTx // running read transaction. nested reads use it
 }
 
-func (bdb *BoltDB) Close() error {
-	if bdb.db == nil {
+func (bdb *KVDB) Close() error {
+	if bdb.storage == nil {
 		return nil
 	}
-	bdb.db.Close()
-	bdb.db = nil
+
+	if bdb.shared {
+		releaseSharedStorage(bdb.file)
+	} else {
+		bdb.storage.Close()
+	}
+	bdb.storage = nil
 
 	return nil
 }
 
-func (bdb *BoltDB) forEachInBucket(bucket string, callback ForEachKeyIteratorInterface) error {
-	return bdb.db.View(func(tx *bolt.Tx) error {
+// Executes a read operation. In a batch it sees changes made by the batch
+func (bdb *KVDB) view(fn func(tx StorageTx) error) error {
+	if bdb.tx != nil {
+		return fn(bdb.tx)
+	}
+
+	if bdb.readTx != nil {
+		// read in a callback of iteration. shared storage can not be locked twice
+		return fn(bdb.readTx)
+	}
+
+	return bdb.storage.View(func(tx StorageTx) error {
+		bdb.readTx = tx
+
+		defer func() {
+			bdb.readTx = nil
+		}()
+
+		return fn(tx)
+	})
+}
+
+// Executes a write operation. In a batch it is done in the batch transaction
+func (bdb *KVDB) update(fn func(tx StorageTx) error) error {
+	if bdb.tx == nil {
+		return bdb.storage.Update(fn)
+	}
+
+	err := fn(bdb.tx)
+
+	if err != nil && bdb.txErr == nil {
+		// a caller can ignore the error, but the batch must not be saved partially
+		bdb.txErr = err
+	}
+	return err
+}
+
+// Executes a callback in one write transaction. All changes done by the callback are saved
+// or nothing is saved. Nested batches are part of the outer batch
+func (bdb *KVDB) batch(callback func() error) error {
+	if bdb.tx != nil {
+		return callback()
+	}
+
+	return bdb.storage.Update(func(tx StorageTx) error {
+		bdb.tx = tx
+		bdb.txErr = nil
+
+		defer func() {
+			bdb.tx = nil
+		}()
+
+		err := callback()
+
+		if err != nil {
+			return err
+		}
+		return bdb.txErr
+	})
+}
+
+func (bdb *KVDB) forEachInBucket(bucket string, callback ForEachKeyIteratorInterface) error {
+	return bdb.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(bucket))
 
 		if b == nil {
@@ -47,10 +115,10 @@ func (bdb *BoltDB) forEachInBucket(bucket string, callback ForEachKeyIteratorInt
 	})
 }
 
-func (bdb *BoltDB) getCountInBucket(bucket string) (int, error) {
+func (bdb *KVDB) getCountInBucket(bucket string) (int, error) {
 	count := 0
 
-	err := bdb.db.View(func(tx *bolt.Tx) error {
+	err := bdb.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(bucket))
 
 		if b == nil {
@@ -0,0 +1,49 @@
+// Package databasetest makes DBs for tests of packages using the database package
+package databasetest
+
+import (
+	"os"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/database"
+)
+
+// Creates and opens a DB in a directory. Empty backend means the default backend
+func NewDBManager(dataDir string, backend string) (*database.KVDBManager, error) {
+	err := os.MkdirAll(dataDir, 0744)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := database.DatabaseConfig{}
+	c.SetDefault()
+	c.DataDir = dataDir
+
+	if backend != "" {
+		c.Backend = backend
+	}
+
+	obj := &database.KVDBManager{}
+	obj.SetLockerObject(obj.GetLockerObject())
+	obj.SetLogger(utils.CreateLogger())
+
+	err = obj.SetConfig(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = obj.InitDatabase()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = obj.OpenConnection("testing")
+
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
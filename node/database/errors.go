@@ -63,3 +63,11 @@ func NewHashEmptyDBError() error {
 func NewHashDBError(err string) error {
 	return &DBError{err, DBHashError}
 }
+
+// Errors of storages. They are returned as is, so can be compared with returned error
+var (
+	ErrBucketNotFound = NewBucketNotFoundDBError()
+	ErrBucketExists   = NewDBError("Bucket already exists", "bucket")
+	ErrKeyRequired    = NewDBError("Key is required", "key")
+	ErrTxNotWritable  = NewDBError("Transaction is not writable", "transaction")
+)
@@ -17,11 +17,16 @@ type DBManager interface {
 	CloseConnection() error
 	IsConnectionOpen() bool
 
+	// all changes done by the callback in the blockchain DB are saved or nothing is saved
+	ExecuteInTransaction(callback func() error) error
+
 	GetBlockchainObject() (BlockchainInterface, error)
 	GetTransactionsObject() (TranactionsInterface, error)
 	GetUnapprovedTransactionsObject() (UnapprovedTransactionsInterface, error)
 	GetUnspentOutputsObject() (UnspentOutputsInterface, error)
 	GetNodesObject() (NodesInterface, error)
+	GetSyncHeadersObject() (SyncHeadersInterface, error)
+	GetAddressTransactionsObject() (AddressTransactionsInterface, error)
 }
 
 // locker interface. is empty for now. maybe in future we will have some methods
@@ -46,11 +51,18 @@ type BlockchainInterface interface {
 	GetTopHash() ([]byte, error)
 	SaveFirstHash(hash []byte) error
 	GetFirstHash() ([]byte, error)
+	PutBlockWork(hash []byte, work []byte) error
+	GetBlockWork(hash []byte) ([]byte, error)
+	SaveDBVersion(version int) error
+	GetDBVersion() (int, error)
+	ForEachBlock(callback ForEachKeyIteratorInterface) error
 
 	GetLocationInChain(hash []byte) (bool, []byte, []byte, error)
 	BlockInChain(hash []byte) (bool, error)
 	RemoveFromChain(hash []byte) error
 	AddToChain(hash, prevHash []byte) error
+	GetHashAtHeight(height int) ([]byte, error)
+	ReindexChainHeights() error
 }
 
 type TranactionsInterface interface {
@@ -84,6 +96,34 @@ type UnspentOutputsInterface interface {
 	GetDataForTransaction(txID []byte) ([]byte, error)
 	DeleteDataForTransaction(txID []byte) error
 	PutDataForTransaction(txID []byte, txData []byte) error
+
+	PutBlockUndo(blockHash []byte, undoData []byte) error
+	GetBlockUndo(blockHash []byte) ([]byte, error)
+	DeleteBlockUndo(blockHash []byte) error
+}
+
+type SyncHeadersInterface interface {
+	InitDB() error
+	TruncateDB() error
+	ForEach(callback ForEachKeyIteratorInterface) error
+	GetCount() (int, error)
+
+	PutHeader(height int, headerdata []byte) error
+	GetHeader(height int) ([]byte, error)
+	GetFirstHeader() ([]byte, error)
+	GetLastHeader() ([]byte, error)
+	DeleteHeader(height int) error
+	DeleteHeadersFrom(height int) error
+}
+
+type AddressTransactionsInterface interface {
+	InitDB() error
+	TruncateDB() error
+	GetCount() (int, error)
+
+	PutTransaction(pubKeyHash []byte, height int, txID []byte, txData []byte) error
+	DeleteTransaction(pubKeyHash []byte, height int, txID []byte) error
+	GetTransactions(pubKeyHash []byte, beforeHeight int, maxCount int) ([][]byte, error)
 }
 
 type NodesInterface interface {
@@ -93,4 +133,8 @@ type NodesInterface interface {
 
 	PutNode(nodeID []byte, nodeData []byte) error
 	DeleteNode(nodeID []byte) error
+
+	ForEachBan(callback ForEachKeyIteratorInterface) error
+	PutBan(host []byte, banData []byte) error
+	DeleteBan(host []byte) error
 }
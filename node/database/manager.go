@@ -10,7 +10,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
@@ -20,46 +19,55 @@ const (
 	ClassNameTransactions           = "transactions"
 	ClassNameUnapprovedTransactions = "unapprovedtransactions"
 	ClassNameUnspentOutputs         = "unspentoutputs"
+	ClassNameSyncHeaders            = "syncheaders"
+	ClassNameAddressTransactions    = "addresstransactions"
 )
 
-type BoltDBManager struct {
+// DB manager. Objects are kept in key-value storages of the backend selected in the config
+type KVDBManager struct {
 	Logger     *utils.LoggerMan
 	Config     DatabaseConfig
-	connBC     *BoltDB
-	connNodes  *BoltDB
+	connBC     *KVDB
+	connNodes  *KVDB
 	openedConn bool
-	locker     *BoltDBLocker
+	locker     *KVDBLocker
 	SessID     string
 }
 
-type BoltDBLocker struct {
+// Old name of the manager. It was bolt only before backends were added
+type BoltDBManager = KVDBManager
+
+type KVDBLocker struct {
 	lockBC    *sync.Mutex
 	lockNodes *sync.Mutex
 }
 
-func (bdm *BoltDBManager) GetLockerObject() DatabaseLocker {
-	locker := &BoltDBLocker{}
+func (bdm *KVDBManager) GetLockerObject() DatabaseLocker {
+	locker := &KVDBLocker{}
 	locker.lockBC = &sync.Mutex{}
 	locker.lockNodes = &sync.Mutex{}
 
 	return locker
 }
 
-func (bdm *BoltDBManager) SetLockerObject(lockerobj DatabaseLocker) {
-	bdm.locker = lockerobj.(*BoltDBLocker)
+func (bdm *KVDBManager) SetLockerObject(lockerobj DatabaseLocker) {
+	bdm.locker = lockerobj.(*KVDBLocker)
 }
-func (bdm *BoltDBManager) SetConfig(config DatabaseConfig) error {
+func (bdm *KVDBManager) SetConfig(config DatabaseConfig) error {
 	bdm.Config = config
 
-	return nil
+	// unknown backend is reported here, connection fails with same error later
+	_, err := GetBackend(config.Backend)
+
+	return err
 }
-func (bdm *BoltDBManager) SetLogger(logger *utils.LoggerMan) error {
+func (bdm *KVDBManager) SetLogger(logger *utils.LoggerMan) error {
 	bdm.Logger = logger
 
 	return nil
 }
 
-func (bdm *BoltDBManager) OpenConnection(reason string) error {
+func (bdm *KVDBManager) OpenConnection(reason string) error {
 	//bdm.Logger.Trace.Println("open connection for " + reason)
 	if bdm.openedConn {
 		return nil
@@ -72,19 +80,17 @@ func (bdm *BoltDBManager) OpenConnection(reason string) error {
 
 	return nil
 }
-func (bdm *BoltDBManager) CloseConnection() error {
+func (bdm *KVDBManager) CloseConnection() error {
 	if !bdm.openedConn {
 		return nil
 	}
 
 	if bdm.connBC != nil {
-		bdm.connBC.Close()
-		bdm.unLockDB(bdm.connBC.lockFile)
+		bdm.closeDBConnection(bdm.connBC)
 		bdm.connBC = nil
 	}
 	if bdm.connNodes != nil {
-		bdm.connNodes.Close()
-		bdm.unLockDB(bdm.connNodes.lockFile)
+		bdm.closeDBConnection(bdm.connNodes)
 		bdm.connNodes = nil
 	}
 
@@ -92,12 +98,32 @@ func (bdm *BoltDBManager) CloseConnection() error {
 	return nil
 }
 
-func (bdm *BoltDBManager) IsConnectionOpen() bool {
+func (bdm *KVDBManager) closeDBConnection(conn *KVDB) {
+	conn.Close()
+
+	if !conn.shared {
+		bdm.unLockDB(conn.lockFile)
+	}
+}
+
+func (bdm *KVDBManager) IsConnectionOpen() bool {
 	return bdm.openedConn
 }
 
+// Executes a callback in one transaction of the blockchain DB. Changes of all objects
+// stored in the blockchain DB are saved together when the callback returns no error
+func (bdm *KVDBManager) ExecuteInTransaction(callback func() error) error {
+	conn, err := bdm.getConnectionForObject(ClassNameBlockchain)
+
+	if err != nil {
+		return err
+	}
+
+	return conn.batch(callback)
+}
+
 // create empty database. must create all
-func (bdm *BoltDBManager) InitDatabase() error {
+func (bdm *KVDBManager) InitDatabase() error {
 
 	bdm.OpenConnection("InitBC")
 
@@ -163,6 +189,30 @@ func (bdm *BoltDBManager) InitDatabase() error {
 		return err
 	}
 
+	sh, err := bdm.GetSyncHeadersObject()
+
+	if err != nil {
+		return err
+	}
+
+	err = sh.InitDB()
+
+	if err != nil {
+		return err
+	}
+
+	at, err := bdm.GetAddressTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	err = at.InitDB()
+
+	if err != nil {
+		return err
+	}
+
 	ns, err := bdm.GetNodesObject()
 
 	if err != nil {
@@ -179,7 +229,7 @@ func (bdm *BoltDBManager) InitDatabase() error {
 }
 
 // Check if database was already inited
-func (bdm *BoltDBManager) CheckDBExists() (bool, error) {
+func (bdm *KVDBManager) CheckDBExists() (bool, error) {
 	bc, err := bdm.GetBlockchainObject()
 
 	if err != nil {
@@ -200,7 +250,7 @@ func (bdm *BoltDBManager) CheckDBExists() (bool, error) {
 }
 
 // returns BlockChain Database structure. does al init
-func (bdm *BoltDBManager) GetBlockchainObject() (BlockchainInterface, error) {
+func (bdm *KVDBManager) GetBlockchainObject() (BlockchainInterface, error) {
 	conn, err := bdm.getConnectionForObject(ClassNameBlockchain)
 
 	if err != nil {
@@ -214,7 +264,7 @@ func (bdm *BoltDBManager) GetBlockchainObject() (BlockchainInterface, error) {
 }
 
 // returns Transaction Index Database structure. does al init
-func (bdm *BoltDBManager) GetTransactionsObject() (TranactionsInterface, error) {
+func (bdm *KVDBManager) GetTransactionsObject() (TranactionsInterface, error) {
 	conn, err := bdm.getConnectionForObject(ClassNameTransactions)
 
 	if err != nil {
@@ -228,7 +278,7 @@ func (bdm *BoltDBManager) GetTransactionsObject() (TranactionsInterface, error)
 }
 
 // returns Unapproved Transaction Database structure. does al init
-func (bdm *BoltDBManager) GetUnapprovedTransactionsObject() (UnapprovedTransactionsInterface, error) {
+func (bdm *KVDBManager) GetUnapprovedTransactionsObject() (UnapprovedTransactionsInterface, error) {
 	conn, err := bdm.getConnectionForObject(ClassNameUnspentOutputs)
 
 	if err != nil {
@@ -242,7 +292,7 @@ func (bdm *BoltDBManager) GetUnapprovedTransactionsObject() (UnapprovedTransacti
 }
 
 // returns Unspent Transactions Database structure. does al init
-func (bdm *BoltDBManager) GetUnspentOutputsObject() (UnspentOutputsInterface, error) {
+func (bdm *KVDBManager) GetUnspentOutputsObject() (UnspentOutputsInterface, error) {
 	conn, err := bdm.getConnectionForObject(ClassNameUnapprovedTransactions)
 
 	if err != nil {
@@ -256,7 +306,7 @@ func (bdm *BoltDBManager) GetUnspentOutputsObject() (UnspentOutputsInterface, er
 }
 
 // returns Nodes Database structure. does al init
-func (bdm *BoltDBManager) GetNodesObject() (NodesInterface, error) {
+func (bdm *KVDBManager) GetNodesObject() (NodesInterface, error) {
 	conn, err := bdm.getConnectionForObject(ClassNameNodes)
 
 	if err != nil {
@@ -269,13 +319,41 @@ func (bdm *BoltDBManager) GetNodesObject() (NodesInterface, error) {
 	return &ns, nil
 }
 
+// returns Sync Headers Database structure. does al init
+func (bdm *KVDBManager) GetSyncHeadersObject() (SyncHeadersInterface, error) {
+	conn, err := bdm.getConnectionForObject(ClassNameSyncHeaders)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sh := SyncHeaders{}
+	sh.DB = conn
+
+	return &sh, nil
+}
+
+// returns Address Transactions Database structure. does al init
+func (bdm *KVDBManager) GetAddressTransactionsObject() (AddressTransactionsInterface, error) {
+	conn, err := bdm.getConnectionForObject(ClassNameAddressTransactions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	at := AddressTransactions{}
+	at.DB = conn
+
+	return &at, nil
+}
+
 // returns
-func (bdm *BoltDBManager) getConnectionForObject(name string) (*BoltDB, error) {
+func (bdm *KVDBManager) getConnectionForObject(name string) (*KVDB, error) {
 	return bdm.getConnectionForObjectWithCheck(name, false)
 }
 
 // returns DB connection, creates it if needed .
-func (bdm *BoltDBManager) getConnectionForObjectWithCheck(name string, ignoremissed bool) (*BoltDB, error) {
+func (bdm *KVDBManager) getConnectionForObjectWithCheck(name string, ignoremissed bool) (*KVDB, error) {
 	if !bdm.openedConn {
 		return nil, errors.New("Connection was not inited")
 	}
@@ -289,46 +367,60 @@ func (bdm *BoltDBManager) getConnectionForObjectWithCheck(name string, ignoremis
 		return bdm.connNodes, nil
 	}
 
-	// create new connection
-	boltdbfile, err := bdm.getDBFileForObject(name)
+	backend, err := GetBackend(bdm.Config.Backend)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if bdm.dbExists(boltdbfile) == false && !ignoremissed {
-		return nil, errors.New(fmt.Sprintf("Database file %s not found", boltdbfile))
-	}
-
-	err = bdm.lockDB(name, bdm.SessID)
+	// create new connection
+	dbfile, err := bdm.getDBFileForObject(name)
 
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := bolt.Open(boltdbfile, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if !backend.Exists(dbfile) && !ignoremissed {
+		return nil, errors.New(fmt.Sprintf("Database file %s not found", dbfile))
+	}
+
+	conn := KVDB{file: dbfile, shared: backend.Shared(), lockFile: name}
+
+	if conn.shared {
+		// shared storage is not locked with files. access is controlled by the storage
+		conn.storage, err = openSharedStorage(backend, dbfile)
+	} else {
+		err = bdm.lockDB(name, bdm.SessID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		conn.storage, err = backend.Open(dbfile)
+
+		if err != nil {
+			bdm.unLockDB(name)
+		}
+	}
 
 	if err != nil {
 		bdm.Logger.Trace.Printf("Error opening DB %s for %s", err.Error(), name)
 		return nil, err
 	}
 
-	// if success create object and assign connection
-	boltDB := BoltDB{db, name}
-
 	if bdm.isBCDB(name) {
-		bdm.connBC = &boltDB
+		bdm.connBC = &conn
 	}
 
 	if bdm.isNodesDB(name) {
-		bdm.connNodes = &boltDB
+		bdm.connNodes = &conn
 	}
 
-	return &boltDB, nil
+	return &conn, nil
 }
 
 // Creates a lock file for DB access. We need this to controll parallel access to the DB
-func (bdm *BoltDBManager) lockDB(name string, locksess string) error {
+func (bdm *KVDBManager) lockDB(name string, locksess string) error {
 	if locksess == "" {
 		locksess = utils.RandString(5)
 		//bdm.Logger.Trace.Println(string(debug.Stack()))
@@ -407,7 +499,7 @@ func (bdm *BoltDBManager) lockDB(name string, locksess string) error {
 }
 
 // Removes DB lock file
-func (bdm *BoltDBManager) unLockDB(name string) {
+func (bdm *KVDBManager) unLockDB(name string) {
 
 	var locker *sync.Mutex
 
@@ -445,17 +537,18 @@ func (bdm *BoltDBManager) unLockDB(name string) {
 	}
 	locker.Unlock()
 }
-func (bdm *BoltDBManager) getDBFileForObject(name string) (string, error) {
+func (bdm *KVDBManager) getDBFileForObject(name string) (string, error) {
 	switch name {
 	case ClassNameNodes:
 		return bdm.Config.DataDir + bdm.Config.NodesFile, nil
-	case ClassNameBlockchain, ClassNameTransactions, ClassNameUnapprovedTransactions, ClassNameUnspentOutputs:
+	case ClassNameBlockchain, ClassNameTransactions, ClassNameUnapprovedTransactions, ClassNameUnspentOutputs,
+		ClassNameSyncHeaders, ClassNameAddressTransactions:
 		return bdm.Config.DataDir + bdm.Config.BlockchainFile, nil
 	}
 	return "", errors.New("Unknown DB object name " + name)
 }
 
-func (bdm *BoltDBManager) getDBLockFileForObject(name string) (string, error) {
+func (bdm *KVDBManager) getDBLockFileForObject(name string) (string, error) {
 	dbfileName, err := bdm.getDBFileForObject(name)
 
 	if err != nil {
@@ -468,21 +561,22 @@ func (bdm *BoltDBManager) getDBLockFileForObject(name string) (string, error) {
 	return dbfileName, nil
 }
 
-func (bdm *BoltDBManager) isBCDB(name string) bool {
+func (bdm *KVDBManager) isBCDB(name string) bool {
 	switch name {
-	case ClassNameBlockchain, ClassNameTransactions, ClassNameUnapprovedTransactions, ClassNameUnspentOutputs:
+	case ClassNameBlockchain, ClassNameTransactions, ClassNameUnapprovedTransactions, ClassNameUnspentOutputs,
+		ClassNameSyncHeaders, ClassNameAddressTransactions:
 		return true
 	}
 	return false
 }
-func (bdm *BoltDBManager) isNodesDB(name string) bool {
+func (bdm *KVDBManager) isNodesDB(name string) bool {
 	if ClassNameNodes == name {
 		return true
 	}
 	return false
 }
 
-func (bdm *BoltDBManager) dbExists(dbFile string) bool {
+func (bdm *KVDBManager) dbExists(dbFile string) bool {
 	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
 		return false
 	}
@@ -1,17 +1,14 @@
 package database
 
-import (
-	"github.com/boltdb/bolt"
-)
-
 const nodesBucket = "nodes"
+const nodeBansBucket = "nodebans"
 
 type Nodes struct {
-	DB *BoltDB
+	DB *KVDB
 }
 
 func (ns *Nodes) InitDB() error {
-	err := ns.DB.db.Update(func(tx *bolt.Tx) error {
+	err := ns.DB.update(func(tx StorageTx) error {
 		_, err := tx.CreateBucket([]byte(nodesBucket))
 
 		if err != nil {
@@ -38,7 +35,7 @@ func (ns *Nodes) GetCount() (int, error) {
 
 // Save node info
 func (ns *Nodes) PutNode(nodeID []byte, nodeData []byte) error {
-	return ns.DB.db.Update(func(txDB *bolt.Tx) error {
+	return ns.DB.update(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(nodesBucket))
 
 		if b == nil {
@@ -49,7 +46,7 @@ func (ns *Nodes) PutNode(nodeID []byte, nodeData []byte) error {
 }
 
 func (ns *Nodes) DeleteNode(nodeID []byte) error {
-	return ns.DB.db.Update(func(txDB *bolt.Tx) error {
+	return ns.DB.update(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(nodesBucket))
 
 		if b == nil {
@@ -58,3 +55,41 @@ func (ns *Nodes) DeleteNode(nodeID []byte) error {
 		return b.Delete(nodeID)
 	})
 }
+
+// Bans of misbehaving nodes are kept in the nodes DB too. Key is a host of a node
+// DB created before bans existed has no the bucket
+func (ns *Nodes) ForEachBan(callback ForEachKeyIteratorInterface) error {
+	err := ns.DB.update(func(tx StorageTx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(nodeBansBucket))
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+	return ns.DB.forEachInBucket(nodeBansBucket, callback)
+}
+
+// Save ban of a node
+func (ns *Nodes) PutBan(host []byte, banData []byte) error {
+	return ns.DB.update(func(txDB StorageTx) error {
+		b, err := txDB.CreateBucketIfNotExists([]byte(nodeBansBucket))
+
+		if err != nil {
+			return err
+		}
+		return b.Put(host, banData)
+	})
+}
+
+func (ns *Nodes) DeleteBan(host []byte) error {
+	return ns.DB.update(func(txDB StorageTx) error {
+		b := txDB.Bucket([]byte(nodeBansBucket))
+
+		if b == nil {
+			return nil
+		}
+		return b.Delete(host)
+	})
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	BackendBolt   = "bolt"
+	BackendLSM    = "lsm"
+	BackendMemory = "memory"
+
+	DefaultBackend = BackendBolt
+)
+
+// shared storage is closed when it is not used for this time
+const sharedStorageIdleTime = 5 * time.Second
+
+// Key-value storage of DB objects. Records are grouped in buckets, keys of a bucket are ordered
+type Storage interface {
+	// Executes read only transaction
+	View(fn func(tx StorageTx) error) error
+	// Executes write transaction. Changes are saved only if the function returns no error
+	Update(fn func(tx StorageTx) error) error
+	Close() error
+}
+
+type StorageTx interface {
+	// Returns nil if the bucket doesn't exist
+	Bucket(name []byte) StorageBucket
+	// Returns ErrBucketExists if the bucket exists
+	CreateBucket(name []byte) (StorageBucket, error)
+	CreateBucketIfNotExists(name []byte) (StorageBucket, error)
+	// Returns ErrBucketNotFound if the bucket doesn't exist
+	DeleteBucket(name []byte) error
+}
+
+// Returned values are valid only while the transaction is open
+type StorageBucket interface {
+	Get(key []byte) []byte
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	Cursor() StorageCursor
+}
+
+// Cursor over ordered keys of a bucket. Nil key is returned when there are no more records
+type StorageCursor interface {
+	First() (key []byte, value []byte)
+	Last() (key []byte, value []byte)
+	Next() (key []byte, value []byte)
+	Prev() (key []byte, value []byte)
+	// Moves to the first key not less than the seek key
+	Seek(seek []byte) (key []byte, value []byte)
+}
+
+// Storage backend. It opens storages by a name of a DB file
+type StorageBackend interface {
+	Open(file string) (Storage, error)
+	Exists(file string) bool
+	// Shared storage is opened once in a process and is used by all connections.
+	// Not shared storage is opened for every connection and access to it is serialized with lock files
+	Shared() bool
+}
+
+var backends = map[string]StorageBackend{
+	BackendBolt:   boltBackend{},
+	BackendLSM:    lsmBackend{},
+	BackendMemory: memoryBackend{},
+}
+var backendsLock sync.RWMutex
+
+// Registers a storage backend. It can be selected in the DB config by the name
+func RegisterBackend(name string, backend StorageBackend) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+
+	backends[name] = backend
+}
+
+// Returns a backend by a name. Empty name is the default backend
+func GetBackend(name string) (StorageBackend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	backend, ok := backends[name]
+
+	if !ok {
+		return nil, errors.New("Unknown storage backend " + name)
+	}
+	return backend, nil
+}
+
+// Returns names of registered backends
+func GetBackendNames() []string {
+	backendsLock.RLock()
+	defer backendsLock.RUnlock()
+
+	names := []string{}
+
+	for name := range backends {
+		names = append(names, name)
+	}
+	return names
+}
+
+type sharedStorage struct {
+	storage    Storage
+	users      int
+	closeTimer *time.Timer
+	info       os.FileInfo // the file when the storage was opened. nil if a backend has no file
+}
+
+// storages of shared backends opened in this process, by a file
+var sharedStorages = map[string]*sharedStorage{}
+var sharedStoragesLock sync.Mutex
+
+// Returns a storage of shared backend. It is opened if it is not used yet
+func openSharedStorage(backend StorageBackend, file string) (Storage, error) {
+	sharedStoragesLock.Lock()
+	defer sharedStoragesLock.Unlock()
+
+	ss, ok := sharedStorages[file]
+
+	if ok && ss.users == 0 && !isSameStorageFile(ss.info, file) {
+		// the file was removed and created again while the storage was idle
+		ss.closeTimer.Stop()
+		ss.storage.Close()
+		delete(sharedStorages, file)
+		ok = false
+	}
+
+	if !ok {
+		storage, err := backend.Open(file)
+
+		if err != nil {
+			return nil, err
+		}
+		info, _ := os.Stat(file)
+
+		ss = &sharedStorage{storage: storage, info: info}
+		sharedStorages[file] = ss
+	}
+
+	if ss.closeTimer != nil {
+		ss.closeTimer.Stop()
+		ss.closeTimer = nil
+	}
+	ss.users++
+
+	return ss.storage, nil
+}
+
+// Storage is not used by a connection anymore. It is closed some time later if nobody uses it.
+// So, other processes can use it when this process is idle
+func releaseSharedStorage(file string) {
+	sharedStoragesLock.Lock()
+	defer sharedStoragesLock.Unlock()
+
+	ss, ok := sharedStorages[file]
+
+	if !ok {
+		return
+	}
+	ss.users--
+
+	if ss.users > 0 {
+		return
+	}
+
+	ss.closeTimer = time.AfterFunc(sharedStorageIdleTime, func() {
+		sharedStoragesLock.Lock()
+		defer sharedStoragesLock.Unlock()
+
+		if ss.users > 0 || sharedStorages[file] != ss {
+			return
+		}
+		ss.storage.Close()
+		delete(sharedStorages, file)
+	})
+}
+
+// Checks if the file is the same file which was opened
+func isSameStorageFile(info os.FileInfo, file string) bool {
+	if info == nil {
+		return true
+	}
+	current, err := os.Stat(file)
+
+	return err == nil && os.SameFile(info, current)
+}
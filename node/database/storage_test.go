@@ -0,0 +1,769 @@
+package database
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	assert "github.com/stretchr/testify/require"
+)
+
+// Contracts of DB objects. Every registered backend must pass all of them
+var storageConformanceTests = []struct {
+	name string
+	test func(t *testing.T, man *KVDBManager)
+}{
+	{"blockchain", testConformanceBlockchain},
+	{"chain", testConformanceChain},
+	{"transactions", testConformanceTransactions},
+	{"unapprovedtransactions", testConformanceUnapprovedTransactions},
+	{"unspentoutputs", testConformanceUnspentOutputs},
+	{"nodes", testConformanceNodes},
+	{"executeintransaction", testConformanceExecuteInTransaction},
+}
+
+func TestStorageBackendsConformance(t *testing.T) {
+	destroyTestDB(nil)
+
+	defer destroyTestDB(nil)
+
+	backends := GetBackendNames()
+	sort.Strings(backends)
+
+	for _, backend := range backends {
+		for _, ct := range storageConformanceTests {
+			backend, ct := backend, ct
+
+			t.Run(backend+"/"+ct.name, func(t *testing.T) {
+				man := getTestDBManagerForBackend(t, backend, ct.name)
+
+				defer man.CloseConnection()
+
+				ct.test(t, man)
+			})
+		}
+	}
+}
+
+// Every test has own folder. Storages of shared backends stay open for some time after a test
+func getTestDBManagerForBackend(t *testing.T, backend string, name string) *KVDBManager {
+	obj, err := newTestDBManager(testFolderName+"/"+backend+"-"+name+"/", backend)
+
+	assert.NoError(t, err, "Can not prepare DB")
+
+	return obj
+}
+
+// Returns keys and values of all records in order of iteration
+func getTestRecords(t *testing.T, forEach func(callback ForEachKeyIteratorInterface) error) ([][]byte, [][]byte) {
+	keys := [][]byte{}
+	values := [][]byte{}
+
+	err := forEach(func(k, v []byte) error {
+		keys = append(keys, utils.CopyBytes(k))
+		values = append(values, utils.CopyBytes(v))
+		return nil
+	})
+
+	assert.NoError(t, err, "Iterate records")
+
+	return keys, values
+}
+
+func testConformanceBlockchain(t *testing.T, man *KVDBManager) {
+	bcdb, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Get blockchain object")
+
+	exists, err := man.CheckDBExists()
+
+	assert.NoError(t, err, "Check DB exists")
+	assert.False(t, exists, "DB should be empty before the first block")
+
+	_, err = bcdb.GetTopHash()
+
+	assert.Error(t, err, "Top hash should not be found in empty DB")
+
+	hash1 := []byte{1, 1, 1, 1, 1, 1, 1, 1}
+	hash2 := []byte{2, 2, 2, 2, 2, 2, 2, 2}
+
+	err = bcdb.PutBlock(hash1, []byte{10})
+
+	assert.NoError(t, err, "Put block")
+
+	err = bcdb.SaveFirstHash(hash1)
+
+	assert.NoError(t, err, "Save first hash")
+
+	err = bcdb.PutBlockOnTop(hash2, []byte{20, 21})
+
+	assert.NoError(t, err, "Put block on top")
+
+	exists, err = man.CheckDBExists()
+
+	assert.NoError(t, err, "Check DB exists")
+	assert.True(t, exists, "DB should exist when there is top hash")
+
+	top, err := bcdb.GetTopBlock()
+
+	assert.NoError(t, err, "Get top block")
+	assert.Equal(t, []byte{20, 21}, top, "Top block data")
+
+	first, err := bcdb.GetFirstHash()
+
+	assert.NoError(t, err, "Get first hash")
+	assert.Equal(t, hash1, first, "First hash")
+
+	exists, err = bcdb.CheckBlockExists(hash1)
+
+	assert.NoError(t, err, "Check block exists")
+	assert.True(t, exists, "Block should exist")
+
+	err = bcdb.PutBlockWork(hash2, []byte{5})
+
+	assert.NoError(t, err, "Put block work")
+
+	work, err := bcdb.GetBlockWork(hash2)
+
+	assert.NoError(t, err, "Get block work")
+	assert.Equal(t, []byte{5}, work, "Block work")
+
+	work, err = bcdb.GetBlockWork(hash1)
+
+	assert.NoError(t, err, "Get missed block work")
+	assert.Nil(t, work, "Missed block work should be nil")
+
+	version, err := bcdb.GetDBVersion()
+
+	assert.NoError(t, err, "Get DB version")
+	assert.Equal(t, 0, version, "Version should be 0 when it is not saved")
+
+	err = bcdb.SaveDBVersion(3)
+
+	assert.NoError(t, err, "Save DB version")
+
+	version, err = bcdb.GetDBVersion()
+
+	assert.NoError(t, err, "Get DB version")
+	assert.Equal(t, 3, version, "Saved version")
+
+	hashes, _ := getTestRecords(t, bcdb.ForEachBlock)
+
+	assert.Equal(t, [][]byte{hash1, hash2}, hashes, "Blocks should be iterated in order of hashes without special records")
+
+	err = bcdb.DeleteBlock(hash1)
+
+	assert.NoError(t, err, "Delete block")
+
+	block, err := bcdb.GetBlock(hash1)
+
+	assert.NoError(t, err, "Get deleted block")
+	assert.Nil(t, block, "Deleted block should not be returned")
+}
+
+func testConformanceChain(t *testing.T, man *KVDBManager) {
+	bcdb, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Get blockchain object")
+
+	hash1 := []byte{1, 1, 1, 1}
+	hash2 := []byte{2, 2, 2, 2}
+	hash3 := []byte{3, 3, 3, 3}
+
+	err = bcdb.SaveFirstHash(hash1)
+
+	assert.NoError(t, err, "Save first hash")
+
+	assert.NoError(t, bcdb.AddToChain(hash1, nil), "Add hash1")
+	assert.NoError(t, bcdb.AddToChain(hash2, hash1), "Add hash2")
+	assert.NoError(t, bcdb.AddToChain(hash3, hash2), "Add hash3")
+
+	found, prev, next, err := bcdb.GetLocationInChain(hash2)
+
+	assert.NoError(t, err, "Get location of hash2")
+	assert.True(t, found, "Hash2 should be in the chain")
+	assert.Equal(t, hash1, prev, "Previous of hash2")
+	assert.Equal(t, hash3, next, "Next of hash2")
+
+	for height, hash := range [][]byte{hash1, hash2, hash3} {
+		h, err := bcdb.GetHashAtHeight(height)
+
+		assert.NoError(t, err, "Get hash at height")
+		assert.Equal(t, hash, h, "Hash at height %d", height)
+	}
+
+	err = bcdb.RemoveFromChain(hash3)
+
+	assert.NoError(t, err, "Remove hash3")
+
+	inChain, err := bcdb.BlockInChain(hash3)
+
+	assert.NoError(t, err, "Check hash3 in chain")
+	assert.False(t, inChain, "Hash3 should not be in the chain")
+
+	h, err := bcdb.GetHashAtHeight(2)
+
+	assert.NoError(t, err, "Get hash at removed height")
+	assert.Nil(t, h, "Removed height should be empty")
+
+	found, _, next, err = bcdb.GetLocationInChain(hash2)
+
+	assert.NoError(t, err, "Get location of hash2")
+	assert.True(t, found, "Hash2 should be in the chain")
+	assert.Equal(t, []byte{}, next, "Hash2 should be the top")
+
+	err = bcdb.ReindexChainHeights()
+
+	assert.NoError(t, err, "Reindex heights")
+
+	h, err = bcdb.GetHashAtHeight(1)
+
+	assert.NoError(t, err, "Get hash at height after reindex")
+	assert.Equal(t, hash2, h, "Hash at height 1 after reindex")
+}
+
+func testConformanceTransactions(t *testing.T, man *KVDBManager) {
+	txdb, err := man.GetTransactionsObject()
+
+	assert.NoError(t, err, "Get transactions object")
+
+	assert.NoError(t, txdb.PutTXToBlockLink([]byte{1}, []byte{100}), "Put block link")
+	assert.NoError(t, txdb.PutTXSpentOutputs([]byte{1}, []byte{1, 2, 3}), "Put spent outputs")
+
+	blockHash, err := txdb.GetBlockHashForTX([]byte{1})
+
+	assert.NoError(t, err, "Get block link")
+	assert.Equal(t, []byte{100}, blockHash, "Block hash of transaction")
+
+	outputs, err := txdb.GetTXSpentOutputs([]byte{1})
+
+	assert.NoError(t, err, "Get spent outputs")
+	assert.Equal(t, []byte{1, 2, 3}, outputs, "Spent outputs of transaction")
+
+	assert.NoError(t, txdb.DeleteTXToBlockLink([]byte{1}), "Delete block link")
+
+	blockHash, err = txdb.GetBlockHashForTX([]byte{1})
+
+	assert.NoError(t, err, "Get deleted block link")
+	assert.Nil(t, blockHash, "Deleted block link should be nil")
+
+	assert.NoError(t, txdb.DeleteTXSpentData([]byte{1}), "Delete spent outputs")
+
+	outputs, err = txdb.GetTXSpentOutputs([]byte{1})
+
+	assert.NoError(t, err, "Get deleted spent outputs")
+	assert.Nil(t, outputs, "Deleted spent outputs should be nil")
+
+	assert.NoError(t, txdb.PutTXToBlockLink([]byte{2}, []byte{200}), "Put block link")
+	assert.NoError(t, txdb.TruncateDB(), "Truncate")
+
+	blockHash, err = txdb.GetBlockHashForTX([]byte{2})
+
+	assert.NoError(t, err, "Get block link after truncate")
+	assert.Nil(t, blockHash, "Truncated block link should be nil")
+}
+
+func testConformanceUnapprovedTransactions(t *testing.T, man *KVDBManager) {
+	utdb, err := man.GetUnapprovedTransactionsObject()
+
+	assert.NoError(t, err, "Get unapproved transactions object")
+
+	assert.NoError(t, utdb.PutTransaction([]byte{3}, []byte{30}), "Put transaction 3")
+	assert.NoError(t, utdb.PutTransaction([]byte{1}, []byte{10}), "Put transaction 1")
+	assert.NoError(t, utdb.PutTransaction([]byte{2}, []byte{20}), "Put transaction 2")
+
+	count, err := utdb.GetCount()
+
+	assert.NoError(t, err, "Get count")
+	assert.Equal(t, 3, count, "Count of transactions")
+
+	keys, values := getTestRecords(t, utdb.ForEach)
+
+	assert.Equal(t, [][]byte{{1}, {2}, {3}}, keys, "Transactions should be iterated in order of IDs")
+	assert.Equal(t, [][]byte{{10}, {20}, {30}}, values, "Values of transactions")
+
+	visited := 0
+
+	err = utdb.ForEach(func(k, v []byte) error {
+		visited++
+		return NewDBCursorStopError()
+	})
+
+	assert.NoError(t, err, "Break iteration should not be an error")
+	assert.Equal(t, 1, visited, "Iteration should stop after break")
+
+	txData, err := utdb.GetTransaction([]byte{2})
+
+	assert.NoError(t, err, "Get transaction")
+	assert.Equal(t, []byte{20}, txData, "Transaction data")
+
+	assert.NoError(t, utdb.DeleteTransaction([]byte{2}), "Delete transaction")
+
+	txData, err = utdb.GetTransaction([]byte{2})
+
+	assert.NoError(t, err, "Get deleted transaction")
+	assert.Nil(t, txData, "Deleted transaction should be nil")
+
+	assert.NoError(t, utdb.TruncateDB(), "Truncate")
+
+	count, err = utdb.GetCount()
+
+	assert.NoError(t, err, "Get count after truncate")
+	assert.Equal(t, 0, count, "No transactions after truncate")
+}
+
+func testConformanceUnspentOutputs(t *testing.T, man *KVDBManager) {
+	uodb, err := man.GetUnspentOutputsObject()
+
+	assert.NoError(t, err, "Get unspent outputs object")
+
+	assert.NoError(t, uodb.PutDataForTransaction([]byte{2}, []byte{20}), "Put outputs 2")
+	assert.NoError(t, uodb.PutDataForTransaction([]byte{1}, []byte{10}), "Put outputs 1")
+	assert.NoError(t, uodb.PutDataForTransaction([]byte{1}, []byte{11}), "Replace outputs 1")
+
+	keys, values := getTestRecords(t, uodb.ForEach)
+
+	assert.Equal(t, [][]byte{{1}, {2}}, keys, "Outputs should be iterated in order of IDs")
+	assert.Equal(t, [][]byte{{11}, {20}}, values, "Replaced outputs should be returned")
+
+	assert.NoError(t, uodb.DeleteDataForTransaction([]byte{1}), "Delete outputs")
+
+	data, err := uodb.GetDataForTransaction([]byte{1})
+
+	assert.NoError(t, err, "Get deleted outputs")
+	assert.Nil(t, data, "Deleted outputs should be nil")
+
+	assert.NoError(t, uodb.PutBlockUndo([]byte{100}, []byte{1, 2}), "Put block undo")
+
+	undo, err := uodb.GetBlockUndo([]byte{100})
+
+	assert.NoError(t, err, "Get block undo")
+	assert.Equal(t, []byte{1, 2}, undo, "Block undo data")
+
+	count, err := uodb.GetCount()
+
+	assert.NoError(t, err, "Get count")
+	assert.Equal(t, 1, count, "Undo data should not be counted as outputs")
+
+	assert.NoError(t, uodb.DeleteBlockUndo([]byte{100}), "Delete block undo")
+
+	undo, err = uodb.GetBlockUndo([]byte{100})
+
+	assert.NoError(t, err, "Get deleted block undo")
+	assert.Nil(t, undo, "Deleted block undo should be nil")
+
+	assert.NoError(t, uodb.TruncateDB(), "Truncate")
+
+	count, err = uodb.GetCount()
+
+	assert.NoError(t, err, "Get count after truncate")
+	assert.Equal(t, 0, count, "No outputs after truncate")
+}
+
+func testConformanceNodes(t *testing.T, man *KVDBManager) {
+	ndb, err := man.GetNodesObject()
+
+	assert.NoError(t, err, "Get nodes object")
+
+	assert.NoError(t, ndb.PutNode([]byte("host2:1"), []byte{2}), "Put node 2")
+	assert.NoError(t, ndb.PutNode([]byte("host1:1"), []byte{1}), "Put node 1")
+
+	keys, _ := getTestRecords(t, ndb.ForEach)
+
+	assert.Equal(t, [][]byte{[]byte("host1:1"), []byte("host2:1")}, keys, "Nodes should be iterated in order")
+
+	assert.NoError(t, ndb.DeleteNode([]byte("host1:1")), "Delete node")
+
+	count, err := ndb.GetCount()
+
+	assert.NoError(t, err, "Get count")
+	assert.Equal(t, 1, count, "Count of nodes after delete")
+
+	assert.NoError(t, ndb.PutBan([]byte("host3"), []byte{3}), "Put ban")
+
+	keys, values := getTestRecords(t, ndb.ForEachBan)
+
+	assert.Equal(t, [][]byte{[]byte("host3")}, keys, "Banned hosts")
+	assert.Equal(t, [][]byte{{3}}, values, "Ban data")
+
+	assert.NoError(t, ndb.DeleteBan([]byte("host3")), "Delete ban")
+
+	keys, _ = getTestRecords(t, ndb.ForEachBan)
+
+	assert.Len(t, keys, 0, "No bans after delete")
+
+	// data are kept after the connection is closed
+	man.CloseConnection()
+	man.OpenConnection("testing")
+
+	ndb, err = man.GetNodesObject()
+
+	assert.NoError(t, err, "Get nodes object after reconnect")
+
+	keys, _ = getTestRecords(t, ndb.ForEach)
+
+	assert.Equal(t, [][]byte{[]byte("host2:1")}, keys, "Nodes after reconnect")
+}
+
+func testConformanceExecuteInTransaction(t *testing.T, man *KVDBManager) {
+	bcdb, err := man.GetBlockchainObject()
+
+	assert.NoError(t, err, "Get blockchain object")
+
+	uodb, err := man.GetUnspentOutputsObject()
+
+	assert.NoError(t, err, "Get unspent outputs object")
+
+	err = man.ExecuteInTransaction(func() error {
+		assert.NoError(t, bcdb.PutBlock([]byte{1, 1}, []byte{1}), "Put block in transaction")
+		assert.NoError(t, uodb.PutDataForTransaction([]byte{1}, []byte{1}), "Put outputs in transaction")
+
+		data, err := uodb.GetDataForTransaction([]byte{1})
+
+		assert.NoError(t, err, "Get outputs in transaction")
+		assert.Equal(t, []byte{1}, data, "Changes should be visible in the transaction")
+
+		return NewDBError("Failed", "test")
+	})
+
+	assert.Error(t, err, "Transaction should fail")
+
+	exists, err := bcdb.CheckBlockExists([]byte{1, 1})
+
+	assert.NoError(t, err, "Check block after rollback")
+	assert.False(t, exists, "Block should not be saved when transaction fails")
+
+	data, err := uodb.GetDataForTransaction([]byte{1})
+
+	assert.NoError(t, err, "Get outputs after rollback")
+	assert.Nil(t, data, "Outputs should not be saved when transaction fails")
+
+	err = man.ExecuteInTransaction(func() error {
+		err := bcdb.PutBlock([]byte{1, 1}, []byte{1})
+
+		if err != nil {
+			return err
+		}
+		return uodb.PutDataForTransaction([]byte{1}, []byte{1})
+	})
+
+	assert.NoError(t, err, "Transaction should be saved")
+
+	exists, err = bcdb.CheckBlockExists([]byte{1, 1})
+
+	assert.NoError(t, err, "Check block after commit")
+	assert.True(t, exists, "Block should be saved")
+
+	data, err = uodb.GetDataForTransaction([]byte{1})
+
+	assert.NoError(t, err, "Get outputs after commit")
+	assert.Equal(t, []byte{1}, data, "Outputs should be saved")
+}
+
+// Puts a record to the test bucket. Nil value deletes the record
+func putTestStorageRecord(t *testing.T, s Storage, key, value []byte) {
+	err := s.Update(func(tx StorageTx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("test"))
+
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return b.Delete(key)
+		}
+		return b.Put(key, value)
+	})
+	assert.NoError(t, err, "Update storage")
+}
+
+// Returns records of the test bucket. Every record is a key with a value appended
+func getTestStorageRecords(t *testing.T, s Storage) [][]byte {
+	records := [][]byte{}
+
+	err := s.View(func(tx StorageTx) error {
+		b := tx.Bucket([]byte("test"))
+
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			records = append(records, append(utils.CopyBytes(k), v...))
+		}
+		return nil
+	})
+	assert.NoError(t, err, "View storage")
+
+	return records
+}
+
+// Records are kept in the log, tables and merged tables and are loaded on open
+func TestLSMStorageReopen(t *testing.T) {
+	destroyTestDB(nil)
+
+	defer destroyTestDB(nil)
+
+	file := testFolderName + "/lsm.db"
+
+	storage, err := lsmBackend{}.Open(file)
+
+	assert.NoError(t, err, "Open storage")
+
+	ls := storage.(*lsmStorage)
+
+	putTestStorageRecord(t, ls, []byte{1}, []byte{10})
+	putTestStorageRecord(t, ls, []byte{2}, []byte{20})
+
+	assert.NoError(t, ls.flush(), "Flush memory table")
+
+	putTestStorageRecord(t, ls, []byte{1}, nil)
+	putTestStorageRecord(t, ls, []byte{3}, []byte{30})
+
+	assert.NoError(t, ls.flush(), "Flush memory table")
+
+	putTestStorageRecord(t, ls, []byte{2}, []byte{21})
+
+	expected := [][]byte{{2, 21}, {3, 30}}
+
+	assert.Equal(t, expected, getTestStorageRecords(t, ls), "Records from memory table and tables")
+
+	assert.NoError(t, ls.Close(), "Close storage")
+
+	storage, err = lsmBackend{}.Open(file)
+
+	assert.NoError(t, err, "Reopen storage")
+	assert.Equal(t, expected, getTestStorageRecords(t, storage), "Records after reopen")
+
+	ls = storage.(*lsmStorage)
+
+	assert.NoError(t, ls.compact(), "Compact tables")
+	assert.Len(t, ls.tables, 1, "Tables should be merged")
+	assert.Equal(t, expected, getTestStorageRecords(t, ls), "Records after compaction")
+
+	// incomplete record in the end of the log is a failed write
+	ls.log.Write([]byte{0, 0, 1})
+
+	assert.NoError(t, ls.Close(), "Close storage")
+
+	storage, err = lsmBackend{}.Open(file)
+
+	assert.NoError(t, err, "Reopen storage with broken log")
+	assert.Equal(t, expected, getTestStorageRecords(t, storage), "Records after reopen with broken log")
+
+	storage.Close()
+}
+
+// Log records are written with a length and a checksum. A record cut by a crash or damaged
+// is not applied. It and all after it are removed from the log, new records are written after good ones
+func TestLSMStorageBrokenLogTail(t *testing.T) {
+	destroyTestDB(nil)
+
+	defer destroyTestDB(nil)
+
+	file := testFolderName + "/lsm.db"
+
+	tests := []struct {
+		name   string
+		damage func(record []byte) []byte
+	}{
+		{"truncated", func(record []byte) []byte {
+			return record[:len(record)-2]
+		}},
+		{"corrupt", func(record []byte) []byte {
+			record[len(record)-1] ^= 0xff
+			return record
+		}},
+	}
+
+	for _, test := range tests {
+		storage, err := lsmBackend{}.Open(file)
+
+		assert.NoError(t, err, "Open storage")
+
+		ls := storage.(*lsmStorage)
+
+		putTestStorageRecord(t, ls, []byte{1}, []byte{10})
+
+		logInfo, err := ls.log.Stat()
+
+		assert.NoError(t, err, "Log info")
+
+		goodSize := logInfo.Size()
+
+		putTestStorageRecord(t, ls, []byte{2}, []byte{20})
+
+		assert.NoError(t, ls.Close(), "Close storage")
+
+		data, err := ioutil.ReadFile(file + "/" + lsmLogFile)
+
+		assert.NoError(t, err, "Read log")
+
+		data = append(data[:goodSize], test.damage(data[goodSize:])...)
+
+		assert.NoError(t, ioutil.WriteFile(file+"/"+lsmLogFile, data, 0600), "Write broken log")
+
+		storage, err = lsmBackend{}.Open(file)
+
+		assert.NoError(t, err, "Reopen storage with %s log", test.name)
+		assert.Equal(t, [][]byte{{1, 10}}, getTestStorageRecords(t, storage), "Records after reopen with %s log", test.name)
+
+		putTestStorageRecord(t, storage, []byte{3}, []byte{30})
+
+		assert.NoError(t, storage.Close(), "Close storage")
+
+		storage, err = lsmBackend{}.Open(file)
+
+		assert.NoError(t, err, "Reopen storage")
+		assert.Equal(t, [][]byte{{1, 10}, {3, 30}}, getTestStorageRecords(t, storage),
+			"Records written after %s log should be kept", test.name)
+
+		storage.Close()
+
+		destroyTestDB(nil)
+	}
+}
+
+// A merged table appears when it is complete. Tables it replaces are removed after it.
+// A crash can leave an incomplete merged table or old tables together with the merged table
+func TestLSMStorageCrashInCompaction(t *testing.T) {
+	destroyTestDB(nil)
+
+	defer destroyTestDB(nil)
+
+	file := testFolderName + "/lsm.db"
+
+	storage, err := lsmBackend{}.Open(file)
+
+	assert.NoError(t, err, "Open storage")
+
+	ls := storage.(*lsmStorage)
+
+	putTestStorageRecord(t, ls, []byte{1}, []byte{10})
+	putTestStorageRecord(t, ls, []byte{2}, []byte{20})
+
+	assert.NoError(t, ls.flush(), "Flush memory table")
+
+	putTestStorageRecord(t, ls, []byte{1}, nil)
+	putTestStorageRecord(t, ls, []byte{2}, []byte{21})
+
+	assert.NoError(t, ls.flush(), "Flush memory table")
+
+	expected := [][]byte{{2, 21}}
+
+	oldTables := map[string][]byte{}
+
+	for _, name := range []string{getLSMTableFileName(1, 1), getLSMTableFileName(2, 2)} {
+		data, err := ioutil.ReadFile(file + "/" + name)
+
+		assert.NoError(t, err, "Read table %s", name)
+
+		oldTables[name] = data
+	}
+
+	// crash while the merged table is written
+	tmpFile := file + "/" + getLSMTableFileName(1, 2) + ".tmp"
+
+	assert.NoError(t, ioutil.WriteFile(tmpFile, []byte{1, 2, 3}, 0600), "Write incomplete table")
+	assert.NoError(t, ls.Close(), "Close storage")
+
+	storage, err = lsmBackend{}.Open(file)
+
+	assert.NoError(t, err, "Reopen storage with incomplete merged table")
+	assert.Equal(t, expected, getTestStorageRecords(t, storage), "Records after reopen with incomplete merged table")
+	assert.Len(t, storage.(*lsmStorage).tables, 2, "Old tables should be used")
+
+	_, err = os.Stat(tmpFile)
+
+	assert.True(t, os.IsNotExist(err), "Incomplete table should be removed")
+
+	// crash after the merged table is complete, before old tables are removed
+	ls = storage.(*lsmStorage)
+
+	assert.NoError(t, ls.compact(), "Compact tables")
+	assert.NoError(t, ls.Close(), "Close storage")
+
+	for name, data := range oldTables {
+		assert.NoError(t, ioutil.WriteFile(file+"/"+name, data, 0600), "Restore old table %s", name)
+	}
+
+	storage, err = lsmBackend{}.Open(file)
+
+	assert.NoError(t, err, "Reopen storage with replaced tables")
+	assert.Equal(t, expected, getTestStorageRecords(t, storage), "Records after reopen with replaced tables")
+	assert.Len(t, storage.(*lsmStorage).tables, 1, "Merged table should be used")
+
+	for name := range oldTables {
+		_, err = os.Stat(file + "/" + name)
+
+		assert.True(t, os.IsNotExist(err), "Replaced table %s should be removed", name)
+	}
+
+	storage.Close()
+}
+
+// Memory layer keeps keys ordered after any inserts and removes
+func TestMemLayerOrder(t *testing.T) {
+	ml := newMemLayer()
+	expected := map[string][]byte{}
+
+	for i := 0; i < 2000; i++ {
+		key := strconv.Itoa(rand.Intn(500))
+
+		if rand.Intn(3) == 0 {
+			ml.remove(key)
+			delete(expected, key)
+		} else {
+			ml.set(key, []byte(key))
+			expected[key] = []byte(key)
+		}
+	}
+
+	keys := []string{}
+	size := 0
+
+	for key := range expected {
+		keys = append(keys, key)
+		size += 2 * len(key)
+	}
+	sort.Strings(keys)
+
+	got := []string{}
+
+	ml.forEach(func(key string, value []byte) error {
+		assert.Equal(t, expected[key], value, "Value of %s", key)
+
+		got = append(got, key)
+		return nil
+	})
+
+	assert.Equal(t, keys, got, "Keys should be ordered")
+	assert.Equal(t, len(keys), ml.count(), "Number of records")
+	assert.Equal(t, size, ml.size, "Size of records")
+
+	for i, key := range keys {
+		k, ok := ml.ceil(key)
+
+		assert.True(t, ok && k == key, "Ceil of existing key %s", key)
+
+		k, ok = ml.lower(key)
+
+		if i == 0 {
+			assert.False(t, ok, "No key before the first")
+		} else {
+			assert.True(t, ok && k == keys[i-1], "Lower of key %s", key)
+		}
+
+		k, ok = ml.ceil(key + "\x00")
+
+		if i == len(keys)-1 {
+			assert.False(t, ok, "No key after the last")
+		} else {
+			assert.True(t, ok && k == keys[i+1], "Ceil after key %s", key)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// how long to wait for other process to release the DB file
+const boltOpenTimeout = 30 * time.Second
+
+// Storage in a bolt DB file. Only one process can open the file. The backend is shared, the file
+// is opened once in a process and all connections use it. Bolt allows many readers and one writer.
+// The file is closed when a process doesn't use it for some time, so CLI commands can use the DB of a running node
+type boltBackend struct{}
+
+func (bb boltBackend) Open(file string) (Storage, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+
+	if err != nil {
+		return nil, err
+	}
+	return &boltStorage{db}, nil
+}
+
+func (bb boltBackend) Exists(file string) bool {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+func (bb boltBackend) Shared() bool {
+	return true
+}
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+func (bs *boltStorage) View(fn func(tx StorageTx) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (bs *boltStorage) Update(fn func(tx StorageTx) error) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (bs *boltStorage) Close() error {
+	return bs.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (bt boltTx) Bucket(name []byte) StorageBucket {
+	b := bt.tx.Bucket(name)
+
+	if b == nil {
+		return nil
+	}
+	return boltBucket{b}
+}
+
+func (bt boltTx) CreateBucket(name []byte) (StorageBucket, error) {
+	b, err := bt.tx.CreateBucket(name)
+
+	if err == bolt.ErrBucketExists {
+		return nil, ErrBucketExists
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+func (bt boltTx) CreateBucketIfNotExists(name []byte) (StorageBucket, error) {
+	b, err := bt.tx.CreateBucketIfNotExists(name)
+
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{b}, nil
+}
+
+func (bt boltTx) DeleteBucket(name []byte) error {
+	err := bt.tx.DeleteBucket(name)
+
+	if err == bolt.ErrBucketNotFound {
+		return ErrBucketNotFound
+	}
+	return err
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func (bb boltBucket) Cursor() StorageCursor {
+	return bb.Bucket.Cursor()
+}
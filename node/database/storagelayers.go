@@ -0,0 +1,462 @@
+package database
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// Ordered key space used by memory and LSM storages. Records of all buckets are kept in one key space.
+// A bucket exists if there is a record "b"+name. Records of a bucket have keys "d"+name+0+key
+const (
+	layersBucketPrefix = "b"
+	layersDataPrefix   = "d"
+)
+
+// Ordered set of records. A record with nil value is deleted. It hides the record in older layers
+type storageLayer interface {
+	get(key string) ([]byte, bool, error)
+	// first key not less than the key
+	ceil(key string) (string, bool)
+	// last key less than the key
+	lower(key string) (string, bool)
+}
+
+// max number of levels of the skip list of a memory layer. it is enough for millions of records
+const memLayerMaxLevel = 24
+
+type memLayerNode struct {
+	key  string
+	next []*memLayerNode // next node on every level of the node
+}
+
+// Layer in memory. It keeps changes of a transaction, a memory table of LSM or all data of memory storage.
+// Keys are ordered in a skip list, so a record is inserted and removed in log(n) time
+type memLayer struct {
+	head   *memLayerNode
+	level  int // levels used by nodes
+	values map[string][]byte
+	size   int // size of keys and values in bytes
+}
+
+func newMemLayer() *memLayer {
+	head := &memLayerNode{next: make([]*memLayerNode, memLayerMaxLevel)}
+
+	return &memLayer{head: head, level: 1, values: map[string][]byte{}}
+}
+
+// Returns the last node with a key less than the key. The head is returned if there is no such node.
+// If the update list is given it gets the last such node on every level
+func (ml *memLayer) findLess(key string, update []*memLayerNode) *memLayerNode {
+	n := ml.head
+
+	for l := ml.level - 1; l >= 0; l-- {
+		for n.next[l] != nil && n.next[l].key < key {
+			n = n.next[l]
+		}
+
+		if update != nil {
+			update[l] = n
+		}
+	}
+	return n
+}
+
+// Number of levels of a new node. Every next level has 4 times less nodes
+func (ml *memLayer) randomLevel() int {
+	level := 1
+
+	for level < memLayerMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+func (ml *memLayer) get(key string) ([]byte, bool, error) {
+	value, ok := ml.values[key]
+
+	return value, ok, nil
+}
+
+func (ml *memLayer) ceil(key string) (string, bool) {
+	n := ml.findLess(key, nil).next[0]
+
+	if n == nil {
+		return "", false
+	}
+	return n.key, true
+}
+
+func (ml *memLayer) lower(key string) (string, bool) {
+	n := ml.findLess(key, nil)
+
+	if n == ml.head {
+		return "", false
+	}
+	return n.key, true
+}
+
+// Sets a value of a record. Nil value marks the record deleted
+func (ml *memLayer) set(key string, value []byte) {
+	if old, ok := ml.values[key]; ok {
+		ml.size -= len(old)
+	} else {
+		update := make([]*memLayerNode, memLayerMaxLevel)
+
+		ml.findLess(key, update)
+
+		level := ml.randomLevel()
+
+		for ; ml.level < level; ml.level++ {
+			update[ml.level] = ml.head
+		}
+
+		node := &memLayerNode{key: key, next: make([]*memLayerNode, level)}
+
+		for l := 0; l < level; l++ {
+			node.next[l] = update[l].next[l]
+			update[l].next[l] = node
+		}
+		ml.size += len(key)
+	}
+	ml.values[key] = value
+	ml.size += len(value)
+}
+
+// Removes a record. It is used when there are no older layers, so a deleted mark is not needed
+func (ml *memLayer) remove(key string) {
+	old, ok := ml.values[key]
+
+	if !ok {
+		return
+	}
+	update := make([]*memLayerNode, memLayerMaxLevel)
+
+	node := ml.findLess(key, update).next[0]
+
+	for l := range node.next {
+		update[l].next[l] = node.next[l]
+	}
+	delete(ml.values, key)
+
+	ml.size -= len(key) + len(old)
+}
+
+// Number of records, including deleted records
+func (ml *memLayer) count() int {
+	return len(ml.values)
+}
+
+// Calls the function for records in order of keys. Stops on the first error
+func (ml *memLayer) forEach(fn func(key string, value []byte) error) error {
+	for n := ml.head.next[0]; n != nil; n = n.next[0] {
+		err := fn(n.key, ml.values[n.key])
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Layers from the newest to the oldest
+type layersView []storageLayer
+
+// Returns a value from the newest layer having the record. Nil if the record doesn't exist
+func (lv layersView) get(key string) ([]byte, error) {
+	for _, l := range lv {
+		value, ok, err := l.get(key)
+
+		if err != nil || ok {
+			return value, err
+		}
+	}
+	return nil, nil
+}
+
+// Returns the first existing record with a key not less than the key
+func (lv layersView) ceil(key string) (string, []byte, bool, error) {
+	for {
+		found := false
+		next := ""
+
+		for _, l := range lv {
+			k, ok := l.ceil(key)
+
+			if ok && (!found || k < next) {
+				next = k
+				found = true
+			}
+		}
+
+		if !found {
+			return "", nil, false, nil
+		}
+
+		value, err := lv.get(next)
+
+		if err != nil {
+			return "", nil, false, err
+		}
+
+		if value != nil {
+			return next, value, true, nil
+		}
+		// deleted record. the smallest key after it
+		key = next + "\x00"
+	}
+}
+
+// Returns the last existing record with a key less than the key
+func (lv layersView) lower(key string) (string, []byte, bool, error) {
+	for {
+		found := false
+		prev := ""
+
+		for _, l := range lv {
+			k, ok := l.lower(key)
+
+			if ok && (!found || k > prev) {
+				prev = k
+				found = true
+			}
+		}
+
+		if !found {
+			return "", nil, false, nil
+		}
+
+		value, err := lv.get(prev)
+
+		if err != nil {
+			return "", nil, false, err
+		}
+
+		if value != nil {
+			return prev, value, true, nil
+		}
+		key = prev
+	}
+}
+
+// Executes read only transaction over layers
+func viewLayers(view layersView, fn func(tx StorageTx) error) error {
+	tx := &layersTx{view: view}
+
+	err := fn(tx)
+
+	if err != nil {
+		return err
+	}
+	return tx.err
+}
+
+// Executes write transaction over layers. Changes are passed to the commit function if there are no errors
+func updateLayers(view layersView, fn func(tx StorageTx) error, commit func(changes *memLayer) error) error {
+	changes := newMemLayer()
+
+	tx := &layersTx{view: append(layersView{changes}, view...), changes: changes}
+
+	err := fn(tx)
+
+	if err != nil {
+		return err
+	}
+
+	if tx.err != nil {
+		return tx.err
+	}
+
+	if changes.count() == 0 {
+		return nil
+	}
+	return commit(changes)
+}
+
+type layersTx struct {
+	view    layersView
+	changes *memLayer // nil for read only transaction
+	err     error     // first read error. the transaction fails with it
+}
+
+func (lt *layersTx) fail(err error) {
+	if lt.err == nil {
+		lt.err = err
+	}
+}
+
+func (lt *layersTx) bucketExists(name []byte) (bool, error) {
+	value, err := lt.view.get(layersBucketPrefix + string(name))
+
+	if err != nil {
+		return false, err
+	}
+	return value != nil, nil
+}
+
+func (lt *layersTx) getBucket(name []byte) *layersBucket {
+	return &layersBucket{tx: lt, prefix: layersDataPrefix + string(name) + "\x00"}
+}
+
+func (lt *layersTx) Bucket(name []byte) StorageBucket {
+	exists, err := lt.bucketExists(name)
+
+	if err != nil {
+		lt.fail(err)
+		return nil
+	}
+
+	if !exists {
+		return nil
+	}
+	return lt.getBucket(name)
+}
+
+func (lt *layersTx) CreateBucket(name []byte) (StorageBucket, error) {
+	if lt.changes == nil {
+		return nil, ErrTxNotWritable
+	}
+
+	exists, err := lt.bucketExists(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, ErrBucketExists
+	}
+
+	lt.changes.set(layersBucketPrefix+string(name), []byte{1})
+
+	return lt.getBucket(name), nil
+}
+
+func (lt *layersTx) CreateBucketIfNotExists(name []byte) (StorageBucket, error) {
+	b, err := lt.CreateBucket(name)
+
+	if err == ErrBucketExists {
+		return lt.getBucket(name), nil
+	}
+	return b, err
+}
+
+func (lt *layersTx) DeleteBucket(name []byte) error {
+	if lt.changes == nil {
+		return ErrTxNotWritable
+	}
+
+	exists, err := lt.bucketExists(name)
+
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrBucketNotFound
+	}
+
+	b := lt.getBucket(name)
+	c := b.Cursor()
+
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		lt.changes.set(b.prefix+string(k), nil)
+	}
+
+	lt.changes.set(layersBucketPrefix+string(name), nil)
+
+	return lt.err
+}
+
+type layersBucket struct {
+	tx     *layersTx
+	prefix string
+}
+
+func (lb *layersBucket) Get(key []byte) []byte {
+	value, err := lb.tx.view.get(lb.prefix + string(key))
+
+	if err != nil {
+		lb.tx.fail(err)
+		return nil
+	}
+	return value
+}
+
+func (lb *layersBucket) Put(key []byte, value []byte) error {
+	if lb.tx.changes == nil {
+		return ErrTxNotWritable
+	}
+
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	// value is copied. a caller can reuse the slice. empty value must not become deleted record
+	lb.tx.changes.set(lb.prefix+string(key), append([]byte{}, value...))
+
+	return nil
+}
+
+func (lb *layersBucket) Delete(key []byte) error {
+	if lb.tx.changes == nil {
+		return ErrTxNotWritable
+	}
+	lb.tx.changes.set(lb.prefix+string(key), nil)
+
+	return nil
+}
+
+func (lb *layersBucket) Cursor() StorageCursor {
+	return &layersCursor{bucket: lb}
+}
+
+// Cursor remembers a key of current record, so it is not broken by changes in the transaction
+type layersCursor struct {
+	bucket *layersBucket
+	key    string
+	valid  bool
+}
+
+func (lc *layersCursor) move(key string, value []byte, ok bool, err error) ([]byte, []byte) {
+	if err != nil {
+		lc.bucket.tx.fail(err)
+		ok = false
+	}
+
+	if !ok || !strings.HasPrefix(key, lc.bucket.prefix) {
+		lc.valid = false
+		return nil, nil
+	}
+	lc.key = key
+	lc.valid = true
+
+	return []byte(key[len(lc.bucket.prefix):]), value
+}
+
+func (lc *layersCursor) First() ([]byte, []byte) {
+	return lc.move(lc.bucket.tx.view.ceil(lc.bucket.prefix))
+}
+
+func (lc *layersCursor) Last() ([]byte, []byte) {
+	// prefix ends with 0. keys of the bucket are less than the prefix with 1 in the end
+	end := lc.bucket.prefix[:len(lc.bucket.prefix)-1] + "\x01"
+
+	return lc.move(lc.bucket.tx.view.lower(end))
+}
+
+func (lc *layersCursor) Next() ([]byte, []byte) {
+	if !lc.valid {
+		return nil, nil
+	}
+	return lc.move(lc.bucket.tx.view.ceil(lc.key + "\x00"))
+}
+
+func (lc *layersCursor) Prev() ([]byte, []byte) {
+	if !lc.valid {
+		return nil, nil
+	}
+	return lc.move(lc.bucket.tx.view.lower(lc.key))
+}
+
+func (lc *layersCursor) Seek(seek []byte) ([]byte, []byte) {
+	return lc.move(lc.bucket.tx.view.ceil(lc.bucket.prefix + string(seek)))
+}
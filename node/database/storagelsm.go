@@ -0,0 +1,455 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	lsmLogFile  = "wal.log"
+	lsmLockFile = "LOCK"
+	// memory table is written to a sorted table when it is bigger
+	lsmMemTableSize = 4 << 20
+	// all tables are merged to one when there are more tables
+	lsmMaxTables = 8
+	// how long to wait for other process to release the storage
+	lsmLockTimeout = 30 * time.Second
+)
+
+// Log structured storage. A DB file is a directory. Changes are appended to a log and kept
+// in a memory table. When the memory table is big it is written to a sorted table file.
+// Tables are merged when there are many. A record is searched in the memory table and then
+// in tables from the newest to the oldest. The storage is used by one process at a time
+type lsmBackend struct{}
+
+func (lb lsmBackend) Open(file string) (Storage, error) {
+	storage := &lsmStorage{dir: file + string(os.PathSeparator), memTable: newMemLayer()}
+
+	err := storage.open()
+
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+func (lb lsmBackend) Exists(file string) bool {
+	info, err := os.Stat(file)
+
+	if err != nil {
+		return false
+	}
+	return info.IsDir()
+}
+
+func (lb lsmBackend) Shared() bool {
+	return true
+}
+
+type lsmStorage struct {
+	lock     sync.RWMutex
+	dir      string
+	log      *os.File
+	memTable *memLayer
+	tables   []*lsmTable // from the newest to the oldest
+}
+
+func (ls *lsmStorage) open() error {
+	info, err := os.Stat(ls.dir)
+
+	if err == nil && !info.IsDir() {
+		return errors.New("LSM storage must be a directory. " + ls.dir + " is a file")
+	}
+
+	err = os.MkdirAll(ls.dir, 0700)
+
+	if err != nil {
+		return err
+	}
+
+	err = ls.lockDir()
+
+	if err != nil {
+		return err
+	}
+
+	err = ls.loadTables()
+
+	if err == nil {
+		err = ls.loadLog()
+	}
+
+	if err != nil {
+		ls.Close()
+		return err
+	}
+	return nil
+}
+
+// Creates a lock file with ID of the process. Waits while other running process has the lock
+func (ls *lsmStorage) lockDir() error {
+	lockFile := ls.dir + lsmLockFile
+
+	start := time.Now()
+
+	for {
+		file, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+
+		if err == nil {
+			_, err = file.WriteString(strconv.Itoa(os.Getpid()))
+			file.Close()
+
+			return err
+		}
+
+		if !os.IsExist(err) {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(lockFile)
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+
+		if err != nil || pid == os.Getpid() || !lsmProcessExists(pid) {
+			// the lock is left by a failed process
+			os.Remove(lockFile)
+			continue
+		}
+
+		if time.Since(start) > lsmLockTimeout {
+			return errors.New("Can not open DB. Storage " + ls.dir + " is used by other process")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func lsmProcessExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+
+	if err != nil {
+		return false
+	}
+
+	err = p.Signal(syscall.Signal(0))
+
+	if err == nil {
+		return true
+	}
+	return false
+}
+
+// Opens tables. Tables replaced by a merged table are removed. They are left if a process fails during merge
+func (ls *lsmStorage) loadTables() error {
+	files, err := ioutil.ReadDir(ls.dir)
+
+	if err != nil {
+		return err
+	}
+
+	ranges := [][2]int{}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".sst") {
+			if strings.HasSuffix(f.Name(), ".tmp") {
+				os.Remove(ls.dir + f.Name())
+			}
+			continue
+		}
+
+		parts := strings.Split(strings.TrimSuffix(f.Name(), ".sst"), "-")
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		first, err1 := strconv.Atoi(parts[0])
+		last, err2 := strconv.Atoi(parts[1])
+
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ranges = append(ranges, [2]int{first, last})
+	}
+
+	for _, r := range ranges {
+		replaced := false
+
+		for _, o := range ranges {
+			if o != r && o[0] <= r[0] && r[1] <= o[1] {
+				replaced = true
+				break
+			}
+		}
+
+		if replaced {
+			os.Remove(ls.dir + getLSMTableFileName(r[0], r[1]))
+			continue
+		}
+
+		table, err := openLSMTable(ls.dir, r[0], r[1])
+
+		if err != nil {
+			return err
+		}
+		ls.tables = append(ls.tables, table)
+	}
+
+	sort.Slice(ls.tables, func(i, j int) bool {
+		return ls.tables[i].last > ls.tables[j].last
+	})
+	return nil
+}
+
+// Replays the log to the memory table. Incomplete record in the end is a failed write, it is cut
+func (ls *lsmStorage) loadLog() error {
+	file, err := os.OpenFile(ls.dir+lsmLogFile, os.O_RDWR|os.O_CREATE, 0600)
+
+	if err != nil {
+		return err
+	}
+	ls.log = file
+
+	r := bufio.NewReader(file)
+
+	offset := int64(0)
+
+	for {
+		var header [8]byte
+
+		_, err := io.ReadFull(r, header[:])
+
+		if err != nil {
+			break
+		}
+
+		payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+
+		_, err = io.ReadFull(r, payload)
+
+		if err != nil || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+			break
+		}
+
+		err = ls.applyLogRecord(payload)
+
+		if err != nil {
+			break
+		}
+		offset += int64(len(header) + len(payload))
+	}
+
+	err = file.Truncate(offset)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Seek(offset, io.SeekStart)
+
+	return err
+}
+
+func (ls *lsmStorage) applyLogRecord(payload []byte) error {
+	r := bufio.NewReader(bytes.NewReader(payload))
+
+	for {
+		key, size, err := readLSMRecordHeader(r)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var value []byte
+
+		if size >= 0 {
+			value = make([]byte, size)
+
+			_, err = io.ReadFull(r, value)
+
+			if err != nil {
+				return err
+			}
+		}
+		ls.setRecord(key, value)
+	}
+}
+
+func (ls *lsmStorage) setRecord(key string, value []byte) {
+	if value == nil && len(ls.tables) == 0 {
+		// nothing to hide
+		ls.memTable.remove(key)
+		return
+	}
+	ls.memTable.set(key, value)
+}
+
+func (ls *lsmStorage) getView() layersView {
+	view := layersView{ls.memTable}
+
+	for _, t := range ls.tables {
+		view = append(view, t)
+	}
+	return view
+}
+
+func (ls *lsmStorage) View(fn func(tx StorageTx) error) error {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+
+	return viewLayers(ls.getView(), fn)
+}
+
+func (ls *lsmStorage) Update(fn func(tx StorageTx) error) error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	return updateLayers(ls.getView(), fn, ls.commit)
+}
+
+// Saves changes of a transaction to the log as one record and applies them to the memory table
+func (ls *lsmStorage) commit(changes *memLayer) error {
+	payload := []byte{}
+
+	changes.forEach(func(key string, value []byte) error {
+		payload = appendLSMRecord(payload, key, value)
+		return nil
+	})
+
+	record := make([]byte, 8, len(payload)+8)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	_, err := ls.log.Write(record)
+
+	if err == nil {
+		err = ls.log.Sync()
+	}
+
+	if err != nil {
+		// cut partial record. the transaction is not saved
+		if offset, serr := ls.log.Seek(-int64(len(record)), io.SeekCurrent); serr == nil {
+			ls.log.Truncate(offset)
+		}
+		return err
+	}
+
+	changes.forEach(func(key string, value []byte) error {
+		ls.setRecord(key, value)
+		return nil
+	})
+
+	if ls.memTable.size > lsmMemTableSize {
+		// changes are in the log already. if flush fails it is done on next commit
+		ls.flush()
+	}
+	return nil
+}
+
+// Writes the memory table to a new table and clears the log
+func (ls *lsmStorage) flush() error {
+	num := 1
+
+	if len(ls.tables) > 0 {
+		num = ls.tables[0].last + 1
+	}
+
+	table, err := writeLSMTable(ls.dir, num, num, ls.memTable.forEach)
+
+	if err != nil {
+		return err
+	}
+
+	ls.tables = append([]*lsmTable{table}, ls.tables...)
+	ls.memTable = newMemLayer()
+
+	err = ls.log.Truncate(0)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = ls.log.Seek(0, io.SeekStart)
+
+	if err != nil {
+		return err
+	}
+
+	if len(ls.tables) > lsmMaxTables {
+		return ls.compact()
+	}
+	return nil
+}
+
+// Merges all tables to one. Deleted records are not needed in it because there are no older tables
+func (ls *lsmStorage) compact() error {
+	view := layersView{}
+
+	for _, t := range ls.tables {
+		view = append(view, t)
+	}
+
+	first := ls.tables[len(ls.tables)-1].first
+	last := ls.tables[0].last
+
+	table, err := writeLSMTable(ls.dir, first, last, func(put func(key string, value []byte) error) error {
+		key, value, ok, err := view.ceil("")
+
+		for ; ok && err == nil; key, value, ok, err = view.ceil(key + "\x00") {
+			err = put(key, value)
+
+			if err != nil {
+				return err
+			}
+		}
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, t := range ls.tables {
+		t.remove()
+	}
+	ls.tables = []*lsmTable{table}
+
+	return nil
+}
+
+func (ls *lsmStorage) Close() error {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	if ls.log != nil {
+		ls.log.Close()
+		ls.log = nil
+	}
+
+	for _, t := range ls.tables {
+		t.Close()
+	}
+	ls.tables = nil
+
+	return os.Remove(ls.dir + lsmLockFile)
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Sorted table of LSM storage. It is written once and never changed.
+// The file is a list of records ordered by key. A record is a key length, the key,
+// a value length + 1 and the value. Zero value length means deleted record.
+// Keys are loaded to memory on open, values are read from the file
+type lsmTable struct {
+	file    *os.File
+	first   int // a table replaces older tables with numbers from first to last
+	last    int
+	keys    []string
+	offsets []int64
+	sizes   []int // -1 for deleted record
+}
+
+func getLSMTableFileName(first, last int) string {
+	return fmt.Sprintf("%08d-%08d.sst", first, last)
+}
+
+// Appends a record to a buffer in the format of tables and log
+func appendLSMRecord(buf []byte, key string, value []byte) []byte {
+	var num [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(num[:], uint64(len(key)))
+	buf = append(buf, num[:n]...)
+	buf = append(buf, key...)
+
+	if value == nil {
+		return append(buf, 0)
+	}
+	n = binary.PutUvarint(num[:], uint64(len(value)+1))
+	buf = append(buf, num[:n]...)
+
+	return append(buf, value...)
+}
+
+// Reads a record header. Returns a key and a size of a value. -1 size for deleted record
+func readLSMRecordHeader(r *bufio.Reader) (string, int, error) {
+	keyLen, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	key := make([]byte, keyLen)
+
+	_, err = io.ReadFull(r, key)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	valueLen, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return "", 0, err
+	}
+	return string(key), int(valueLen) - 1, nil
+}
+
+// Writes a table. The write function gets a callback to add records in order of keys
+func writeLSMTable(dir string, first, last int, write func(put func(key string, value []byte) error) error) (*lsmTable, error) {
+	fileName := dir + getLSMTableFileName(first, last)
+
+	// a table appears only when it is complete
+	file, err := os.Create(fileName + ".tmp")
+
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(file)
+
+	buf := []byte{}
+
+	err = write(func(key string, value []byte) error {
+		buf = appendLSMRecord(buf[:0], key, value)
+
+		_, err := w.Write(buf)
+
+		return err
+	})
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	file.Close()
+
+	if err != nil {
+		os.Remove(fileName + ".tmp")
+		return nil, err
+	}
+
+	err = os.Rename(fileName+".tmp", fileName)
+
+	if err != nil {
+		return nil, err
+	}
+	return openLSMTable(dir, first, last)
+}
+
+func openLSMTable(dir string, first, last int) (*lsmTable, error) {
+	file, err := os.Open(dir + getLSMTableFileName(first, last))
+
+	if err != nil {
+		return nil, err
+	}
+
+	table := &lsmTable{file: file, first: first, last: last}
+
+	err = table.load()
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return table, nil
+}
+
+// Reads keys and positions of values
+func (t *lsmTable) load() error {
+	r := bufio.NewReader(t.file)
+
+	offset := int64(0)
+
+	for {
+		key, size, err := readLSMRecordHeader(r)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(t.keys) > 0 && key <= t.keys[len(t.keys)-1] {
+			return errors.New("Keys are not ordered in LSM table " + t.file.Name())
+		}
+
+		offset += int64(uvarintLen(uint64(len(key))) + len(key) + uvarintLen(uint64(size+1)))
+
+		t.keys = append(t.keys, key)
+		t.offsets = append(t.offsets, offset)
+		t.sizes = append(t.sizes, size)
+
+		if size > 0 {
+			_, err = r.Discard(size)
+
+			if err != nil {
+				return err
+			}
+			offset += int64(size)
+		}
+	}
+}
+
+func uvarintLen(x uint64) int {
+	var num [binary.MaxVarintLen64]byte
+
+	return binary.PutUvarint(num[:], x)
+}
+
+func (t *lsmTable) get(key string) ([]byte, bool, error) {
+	i := sort.SearchStrings(t.keys, key)
+
+	if i == len(t.keys) || t.keys[i] != key {
+		return nil, false, nil
+	}
+
+	if t.sizes[i] < 0 {
+		return nil, true, nil
+	}
+
+	value := make([]byte, t.sizes[i])
+
+	_, err := t.file.ReadAt(value, t.offsets[i])
+
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (t *lsmTable) ceil(key string) (string, bool) {
+	i := sort.SearchStrings(t.keys, key)
+
+	if i == len(t.keys) {
+		return "", false
+	}
+	return t.keys[i], true
+}
+
+func (t *lsmTable) lower(key string) (string, bool) {
+	i := sort.SearchStrings(t.keys, key)
+
+	if i == 0 {
+		return "", false
+	}
+	return t.keys[i-1], true
+}
+
+func (t *lsmTable) Close() error {
+	return t.file.Close()
+}
+
+func (t *lsmTable) remove() error {
+	t.file.Close()
+
+	return os.Remove(t.file.Name())
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"sync"
+)
+
+// Storage in memory. Data are kept while the process runs, so all connections to a file
+// see same data. Nothing is saved to disk. It is used for tests
+type memoryBackend struct{}
+
+var memoryStorages = map[string]*memoryStorage{}
+var memoryStoragesLock sync.Mutex
+
+func (mb memoryBackend) Open(file string) (Storage, error) {
+	memoryStoragesLock.Lock()
+	defer memoryStoragesLock.Unlock()
+
+	ms, ok := memoryStorages[file]
+
+	if !ok {
+		ms = &memoryStorage{data: newMemLayer()}
+		memoryStorages[file] = ms
+	}
+	return ms, nil
+}
+
+func (mb memoryBackend) Exists(file string) bool {
+	memoryStoragesLock.Lock()
+	defer memoryStoragesLock.Unlock()
+
+	_, ok := memoryStorages[file]
+
+	return ok
+}
+
+func (mb memoryBackend) Shared() bool {
+	return true
+}
+
+type memoryStorage struct {
+	lock sync.RWMutex
+	data *memLayer
+}
+
+func (ms *memoryStorage) View(fn func(tx StorageTx) error) error {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	return viewLayers(layersView{ms.data}, fn)
+}
+
+func (ms *memoryStorage) Update(fn func(tx StorageTx) error) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	return updateLayers(layersView{ms.data}, fn, func(changes *memLayer) error {
+		return changes.forEach(func(key string, value []byte) error {
+			if value != nil {
+				ms.data.set(key, value)
+			} else {
+				ms.data.remove(key)
+			}
+			return nil
+		})
+	})
+}
+
+// Data are kept after close
+func (ms *memoryStorage) Close() error {
+	return nil
+}
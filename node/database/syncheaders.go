@@ -0,0 +1,175 @@
+package database
+
+import (
+	"github.com/NlaakStudios/democoin/lib/utils"
+)
+
+const syncHeadersBucket = "syncheaders"
+
+// Headers downloaded on header-first sync. Key is a height of a block
+// Records are kept till all blocks are loaded, so sync can continue after restart
+type SyncHeaders struct {
+	DB *KVDB
+}
+
+func (sh *SyncHeaders) InitDB() error {
+	return sh.DB.update(func(tx StorageTx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(syncHeadersBucket))
+
+		return err
+	})
+}
+
+// execute functon for each header. Headers are ordered by height
+func (sh *SyncHeaders) ForEach(callback ForEachKeyIteratorInterface) error {
+	// DB created before sync existed has no the bucket
+	err := sh.InitDB()
+
+	if err != nil {
+		return err
+	}
+	return sh.DB.forEachInBucket(syncHeadersBucket, callback)
+}
+
+// get count of records in the table
+func (sh *SyncHeaders) GetCount() (int, error) {
+	err := sh.InitDB()
+
+	if err != nil {
+		return 0, err
+	}
+	return sh.DB.getCountInBucket(syncHeadersBucket)
+}
+
+func (sh *SyncHeaders) TruncateDB() error {
+	return sh.DB.update(func(tx StorageTx) error {
+		err := tx.DeleteBucket([]byte(syncHeadersBucket))
+
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+
+		_, err = tx.CreateBucket([]byte(syncHeadersBucket))
+
+		return err
+	})
+}
+
+// Save header of a block on given height
+func (sh *SyncHeaders) PutHeader(height int, headerdata []byte) error {
+	return sh.DB.update(func(tx StorageTx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(syncHeadersBucket))
+
+		if err != nil {
+			return err
+		}
+		return b.Put(utils.IntToHex(int64(height)), headerdata)
+	})
+}
+
+// Returns header of a block on given height. Nil if there is no such
+func (sh *SyncHeaders) GetHeader(height int) ([]byte, error) {
+	var headerdata []byte
+
+	err := sh.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(syncHeadersBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		headerdata = utils.CopyBytes(b.Get(utils.IntToHex(int64(height))))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(headerdata) == 0 {
+		return nil, nil
+	}
+	return headerdata, nil
+}
+
+// Returns header with lowest height. Nil if there are no headers
+func (sh *SyncHeaders) GetFirstHeader() ([]byte, error) {
+	return sh.getEdgeHeader(true)
+}
+
+// Returns header with highest height. Nil if there are no headers
+func (sh *SyncHeaders) GetLastHeader() ([]byte, error) {
+	return sh.getEdgeHeader(false)
+}
+
+func (sh *SyncHeaders) getEdgeHeader(first bool) ([]byte, error) {
+	var headerdata []byte
+
+	err := sh.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(syncHeadersBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		var v []byte
+
+		if first {
+			_, v = b.Cursor().First()
+		} else {
+			_, v = b.Cursor().Last()
+		}
+
+		headerdata = utils.CopyBytes(v)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(headerdata) == 0 {
+		return nil, nil
+	}
+	return headerdata, nil
+}
+
+// Delete header of a block on given height
+func (sh *SyncHeaders) DeleteHeader(height int) error {
+	return sh.DB.update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(syncHeadersBucket))
+
+		if b == nil {
+			return nil
+		}
+		return b.Delete(utils.IntToHex(int64(height)))
+	})
+}
+
+// Delete all headers starting from given height
+func (sh *SyncHeaders) DeleteHeadersFrom(height int) error {
+	return sh.DB.update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(syncHeadersBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+
+		keys := [][]byte{}
+
+		for k, _ := c.Seek(utils.IntToHex(int64(height))); k != nil; k, _ = c.Next() {
+			keys = append(keys, utils.CopyBytes(k))
+		}
+
+		for _, k := range keys {
+			err := b.Delete(k)
+
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
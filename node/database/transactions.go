@@ -1,19 +1,19 @@
 package database
 
 import (
-	"github.com/boltdb/bolt"
+	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
 const transactionsBucket = "transactions"
 const transactionsOutputsBucket = "transactionsoutputs"
 
 type Tranactions struct {
-	DB *BoltDB
+	DB *KVDB
 }
 
 // Init database
 func (txs *Tranactions) InitDB() error {
-	err := txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err := txs.DB.update(func(tx StorageTx) error {
 		_, err := tx.CreateBucket([]byte(transactionsBucket))
 
 		if err != nil {
@@ -24,7 +24,7 @@ func (txs *Tranactions) InitDB() error {
 	if err != nil {
 		return err
 	}
-	err = txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err = txs.DB.update(func(tx StorageTx) error {
 		_, err := tx.CreateBucket([]byte(transactionsOutputsBucket))
 
 		if err != nil {
@@ -38,10 +38,10 @@ func (txs *Tranactions) InitDB() error {
 	return nil
 }
 func (txs *Tranactions) TruncateDB() error {
-	err := txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err := txs.DB.update(func(tx StorageTx) error {
 		err := tx.DeleteBucket([]byte(transactionsBucket))
 
-		if err != nil && err != bolt.ErrBucketNotFound {
+		if err != nil && err != ErrBucketNotFound {
 			return err
 		}
 
@@ -55,10 +55,10 @@ func (txs *Tranactions) TruncateDB() error {
 	if err != nil {
 		return err
 	}
-	err = txs.DB.db.Update(func(tx *bolt.Tx) error {
+	err = txs.DB.update(func(tx StorageTx) error {
 		err := tx.DeleteBucket([]byte(transactionsOutputsBucket))
 
-		if err != nil && err != bolt.ErrBucketNotFound {
+		if err != nil && err != ErrBucketNotFound {
 			return err
 		}
 
@@ -77,7 +77,7 @@ func (txs *Tranactions) TruncateDB() error {
 
 // Save link between TX and block hash
 func (txs *Tranactions) PutTXToBlockLink(txID []byte, blockHash []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(transactionsBucket))
 
 		if b == nil {
@@ -91,14 +91,17 @@ func (txs *Tranactions) PutTXToBlockLink(txID []byte, blockHash []byte) error {
 func (txs *Tranactions) GetBlockHashForTX(txID []byte) ([]byte, error) {
 	var blockHash []byte
 
-	err := txs.DB.db.View(func(txDB *bolt.Tx) error {
+	err := txs.DB.view(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(transactionsBucket))
 
 		if b == nil {
 			return NewDBIsNotReadyError()
 		}
 
-		blockHash = b.Get(txID)
+		// values are valid only till end of a transaction
+		if v := b.Get(txID); v != nil {
+			blockHash = utils.CopyBytes(v)
+		}
 
 		return nil
 	})
@@ -110,7 +113,7 @@ func (txs *Tranactions) GetBlockHashForTX(txID []byte) ([]byte, error) {
 
 // Delete link between TX and a block hash
 func (txs *Tranactions) DeleteTXToBlockLink(txID []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(transactionsBucket))
 
 		if b == nil {
@@ -122,7 +125,7 @@ func (txs *Tranactions) DeleteTXToBlockLink(txID []byte) error {
 
 // Save spent outputs for TX
 func (txs *Tranactions) PutTXSpentOutputs(txID []byte, outputs []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(transactionsOutputsBucket))
 
 		if b == nil {
@@ -136,14 +139,17 @@ func (txs *Tranactions) PutTXSpentOutputs(txID []byte, outputs []byte) error {
 func (txs *Tranactions) GetTXSpentOutputs(txID []byte) ([]byte, error) {
 	var outputsData []byte
 
-	err := txs.DB.db.View(func(txDB *bolt.Tx) error {
+	err := txs.DB.view(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(transactionsOutputsBucket))
 
 		if b == nil {
 			return NewDBIsNotReadyError()
 		}
 
-		outputsData = b.Get(txID)
+		// values are valid only till end of a transaction
+		if v := b.Get(txID); v != nil {
+			outputsData = utils.CopyBytes(v)
+		}
 
 		return nil
 	})
@@ -155,7 +161,7 @@ func (txs *Tranactions) GetTXSpentOutputs(txID []byte) ([]byte, error) {
 
 // Delete info about spent outputs for TX
 func (txs *Tranactions) DeleteTXSpentData(txID []byte) error {
-	return txs.DB.db.Update(func(txDB *bolt.Tx) error {
+	return txs.DB.update(func(txDB StorageTx) error {
 		b := txDB.Bucket([]byte(transactionsOutputsBucket))
 
 		if b == nil {
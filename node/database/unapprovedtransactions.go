@@ -1,17 +1,17 @@
 package database
 
 import (
-	"github.com/boltdb/bolt"
+	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
 const unapprovedTransactionsBucket = "unapprovedtransactions"
 
 type UnapprovedTransactions struct {
-	DB *BoltDB
+	DB *KVDB
 }
 
 func (uts *UnapprovedTransactions) InitDB() error {
-	err := uts.DB.db.Update(func(tx *bolt.Tx) error {
+	err := uts.DB.update(func(tx StorageTx) error {
 		_, err := tx.CreateBucket([]byte(unapprovedTransactionsBucket))
 
 		if err != nil {
@@ -37,10 +37,10 @@ func (uts *UnapprovedTransactions) GetCount() (int, error) {
 }
 
 func (uts *UnapprovedTransactions) TruncateDB() error {
-	err := uts.DB.db.Update(func(tx *bolt.Tx) error {
+	err := uts.DB.update(func(tx StorageTx) error {
 		err := tx.DeleteBucket([]byte(unapprovedTransactionsBucket))
 
-		if err != nil && err != bolt.ErrBucketNotFound {
+		if err != nil && err != ErrBucketNotFound {
 			return err
 		}
 
@@ -61,14 +61,17 @@ func (uts *UnapprovedTransactions) TruncateDB() error {
 func (uts *UnapprovedTransactions) GetTransaction(txID []byte) ([]byte, error) {
 	var txBytes []byte
 
-	err := uts.DB.db.View(func(tx *bolt.Tx) error {
+	err := uts.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(unapprovedTransactionsBucket))
 
 		if b == nil {
 			return NewDBIsNotReadyError()
 		}
 
-		txBytes = b.Get(txID)
+		// values are valid only till end of a transaction
+		if v := b.Get(txID); v != nil {
+			txBytes = utils.CopyBytes(v)
+		}
 
 		return nil
 	})
@@ -80,7 +83,7 @@ func (uts *UnapprovedTransactions) GetTransaction(txID []byte) ([]byte, error) {
 
 // Add transaction record
 func (uts *UnapprovedTransactions) PutTransaction(txID []byte, txdata []byte) error {
-	return uts.DB.db.Update(func(tx *bolt.Tx) error {
+	return uts.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(unapprovedTransactionsBucket))
 
 		if b == nil {
@@ -93,7 +96,7 @@ func (uts *UnapprovedTransactions) PutTransaction(txID []byte, txdata []byte) er
 
 // delete transation from DB
 func (uts *UnapprovedTransactions) DeleteTransaction(txID []byte) error {
-	return uts.DB.db.Update(func(tx *bolt.Tx) error {
+	return uts.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(unapprovedTransactionsBucket))
 
 		if b == nil {
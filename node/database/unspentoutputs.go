@@ -1,18 +1,24 @@
 package database
 
 import (
-	"github.com/boltdb/bolt"
+	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
 const unspentTransactionsBucket = "unspentoutputstransactions"
+const blockUndoBucket = "unspentoutputsundo"
 
 type UnspentOutputs struct {
-	DB *BoltDB
+	DB *KVDB
 }
 
 func (uos *UnspentOutputs) InitDB() error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx StorageTx) error {
 		_, err := tx.CreateBucket([]byte(unspentTransactionsBucket))
+
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte(blockUndoBucket))
 		return err
 	})
 }
@@ -27,8 +33,9 @@ func (uos *UnspentOutputs) GetCount() (int, error) {
 	return uos.DB.getCountInBucket(unspentTransactionsBucket)
 }
 
+// Removes unspent outputs and undo data of blocks
 func (uos *UnspentOutputs) TruncateDB() error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx StorageTx) error {
 		err := tx.DeleteBucket([]byte(unspentTransactionsBucket))
 
 		if err != nil {
@@ -36,6 +43,18 @@ func (uos *UnspentOutputs) TruncateDB() error {
 		}
 		_, err = tx.CreateBucket([]byte(unspentTransactionsBucket))
 
+		if err != nil {
+			return err
+		}
+
+		// DB can be created before undo data was stored
+		err = tx.DeleteBucket([]byte(blockUndoBucket))
+
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte(blockUndoBucket))
+
 		return err
 	})
 }
@@ -43,14 +62,17 @@ func (uos *UnspentOutputs) TruncateDB() error {
 func (uos *UnspentOutputs) GetDataForTransaction(txID []byte) ([]byte, error) {
 	var txData []byte
 
-	err := uos.DB.db.View(func(tx *bolt.Tx) error {
+	err := uos.DB.view(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(unspentTransactionsBucket))
 
 		if b == nil {
 			return NewDBIsNotReadyError()
 		}
 
-		txData = b.Get(txID)
+		// values are valid only till end of a transaction
+		if v := b.Get(txID); v != nil {
+			txData = utils.CopyBytes(v)
+		}
 
 		return nil
 	})
@@ -61,7 +83,7 @@ func (uos *UnspentOutputs) GetDataForTransaction(txID []byte) ([]byte, error) {
 }
 
 func (uos *UnspentOutputs) DeleteDataForTransaction(txID []byte) error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(unspentTransactionsBucket))
 
 		if b == nil {
@@ -72,7 +94,7 @@ func (uos *UnspentOutputs) DeleteDataForTransaction(txID []byte) error {
 	})
 }
 func (uos *UnspentOutputs) PutDataForTransaction(txID []byte, txData []byte) error {
-	return uos.DB.db.Update(func(tx *bolt.Tx) error {
+	return uos.DB.update(func(tx StorageTx) error {
 		b := tx.Bucket([]byte(unspentTransactionsBucket))
 
 		if b == nil {
@@ -82,3 +104,52 @@ func (uos *UnspentOutputs) PutDataForTransaction(txID []byte, txData []byte) err
 		return b.Put(txID, txData)
 	})
 }
+
+// Save outputs spent by a block. They are returned to unspent when the block is disconnected
+func (uos *UnspentOutputs) PutBlockUndo(blockHash []byte, undoData []byte) error {
+	return uos.DB.update(func(tx StorageTx) error {
+		// DB can be created before undo data was stored
+		b, err := tx.CreateBucketIfNotExists([]byte(blockUndoBucket))
+
+		if err != nil {
+			return err
+		}
+
+		return b.Put(blockHash, undoData)
+	})
+}
+
+// Returns outputs spent by a block. Nil if undo data was not saved for the block
+func (uos *UnspentOutputs) GetBlockUndo(blockHash []byte) ([]byte, error) {
+	var undoData []byte
+
+	err := uos.DB.view(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blockUndoBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		if v := b.Get(blockHash); v != nil {
+			undoData = utils.CopyBytes(v)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return undoData, nil
+}
+
+func (uos *UnspentOutputs) DeleteBlockUndo(blockHash []byte) error {
+	return uos.DB.update(func(tx StorageTx) error {
+		b := tx.Bucket([]byte(blockUndoBucket))
+
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete(blockHash)
+	})
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/NlaakStudios/democoin/lib/net"
 	"github.com/NlaakStudios/democoin/lib/nodeclient"
 	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/lib/wallet"
 	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/consensus"
 	"github.com/NlaakStudios/democoin/node/nodemanager"
 	"github.com/NlaakStudios/democoin/node/server"
 )
@@ -37,6 +39,9 @@ func getNodeCLI(input config.AppInput) NodeCLI {
 
 	cli.Logger.EnableLogs(input.Logs)
 
+	// subsidy of blocks depends on the network schedule
+	consensus.SetEmissionConfig(input.Genesis.Emission)
+
 	if input.Args.LogDest != "stdout" {
 		cli.Logger.LogToFiles(cli.DataDir, "log_trace.txt", "log_info.txt", "log_warning.txt", "log_error.txt")
 	} else {
@@ -62,10 +67,10 @@ func getNodeCLI(input config.AppInput) NodeCLI {
 /*
 * Createes node object. Node does all work related to acces to bockchain and DB
  */
-func (c *NodeCLI) CreateNode() {
+func (c *NodeCLI) CreateNode() error {
 	if c.Node != nil {
 		//already created
-		return
+		return nil
 	}
 	node := nodemanager.Node{}
 
@@ -83,11 +88,26 @@ func (c *NodeCLI) CreateNode() {
 	node.MinterAddress = c.Input.MinterAddress
 
 	node.Init()
+
+	err := node.InitTransport(c.Input.RequireEncryption)
+
+	if err != nil {
+		if c.Input.RequireEncryption {
+			// without transport connections are not encrypted
+			return errors.New("Transport init error: " + err.Error())
+		}
+		c.Logger.Error.Println("Transport init error: ", err.Error())
+	}
+
+	node.NodeNet.Bootstrap = c.Input.GetBootstrapSources()
+
 	node.InitNodes(c.Input.Nodes, false)
 
 	node.NodeClient.SetAuthStr(c.NodeAuthStr)
 
 	c.Node = &node
+
+	return nil
 }
 
 /*
@@ -111,6 +131,7 @@ func (c NodeCLI) isInteractiveMode() bool {
 		"dropblock",
 		"addrhistory",
 		"showunspent",
+		"showsupply",
 		"shownodes",
 		"addnode",
 		"removenode"}
@@ -142,7 +163,12 @@ func (c NodeCLI) isNodeManageMode() bool {
 * Executes the client command in interactive mode
  */
 func (c NodeCLI) ExecuteCommand() error {
-	c.CreateNode() // init node struct
+	// init node struct
+	err := c.CreateNode()
+
+	if err != nil {
+		return err
+	}
 
 	if c.Command != "createblockchain" &&
 		c.Command != "initblockchain" &&
@@ -153,6 +179,12 @@ func (c NodeCLI) ExecuteCommand() error {
 		if !c.Node.BlockchainExist() {
 			return errors.New("Blockchain is not found. Must be created or inited")
 		}
+
+		err := c.Node.UpgradeDatabase()
+
+		if err != nil {
+			return err
+		}
 	}
 
 	defer c.Node.DBConn.CloseConnection()
@@ -202,6 +234,9 @@ func (c NodeCLI) ExecuteCommand() error {
 	} else if c.Command == "showunspent" {
 		return c.commandShowUnspent()
 
+	} else if c.Command == "showsupply" {
+		return c.commandShowSupply()
+
 	} else if c.Command == "shownodes" {
 		return c.commandShowNodes()
 
@@ -221,16 +256,32 @@ func (c NodeCLI) ExecuteCommand() error {
 func (c NodeCLI) createDaemonManager() (*server.NodeDaemon, error) {
 	nd := server.NodeDaemon{}
 
-	c.CreateNode()
+	err := c.CreateNode()
+
+	if err != nil {
+		return nil, err
+	}
 
 	if !c.Node.BlockchainExist() {
 		return nil, errors.New("Blockchain is not found. Must be created or inited")
 	}
 
+	err = c.Node.UpgradeDatabase()
+
+	c.Node.DBConn.CloseConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
 	nd.DataDir = c.DataDir
 	nd.Logger = c.Logger
 	nd.Port = c.Input.Port
 	nd.Host = c.Input.Host
+	nd.BanTime = c.Input.BanTime
+	nd.BindHost = c.Input.BindHost
+	nd.ExternalHost = c.Input.ExternalHost
+	nd.ExternalPort = c.Input.ExternalPort
 	nd.Node = c.Node
 	nd.Init()
 
@@ -259,7 +310,11 @@ func (c NodeCLI) ExecuteManageCommand() error {
 		return noddaemon.DaemonizeServer()
 
 	} else if c.Command == "nodestate" {
-		c.CreateNode()
+		err = c.CreateNode()
+
+		if err != nil {
+			return err
+		}
 		return c.commandShowState(noddaemon)
 
 	}
@@ -273,9 +328,12 @@ func (c *NodeCLI) getWalletsCLI() (*wallet.WalletCLI, error) {
 	winput.Address = c.Input.Args.Address
 	winput.DataDir = c.Input.DataDir
 	winput.NodePort = c.Input.Port
-	winput.NodeHost = "localhost"
+	winput.NodeHost = net.GetLocalHost(c.Input.BindHost)
 	winput.Amount = c.Input.Args.Amount
+	winput.Fee = c.Input.Args.Fee
 	winput.ToAddress = c.Input.Args.To
+	winput.Genesis = c.Input.Genesis.Hash
+	winput.RequireEncryption = c.Input.RequireEncryption
 
 	if c.Input.Args.From != "" {
 		winput.Address = c.Input.Args.From
@@ -286,10 +344,14 @@ func (c *NodeCLI) getWalletsCLI() (*wallet.WalletCLI, error) {
 
 	if c.AlreadyRunningPort > 0 {
 		winput.NodePort = c.AlreadyRunningPort
-		winput.NodeHost = "localhost"
+		winput.NodeHost = net.GetLocalHost(c.Input.BindHost)
 	}
 
-	walletscli.Init(c.Logger, winput)
+	err := walletscli.Init(c.Logger, winput)
+
+	if err != nil {
+		return nil, err
+	}
 
 	walletscli.NodeMode = true
 
@@ -313,7 +375,7 @@ func (c *NodeCLI) forwardCommandToWallet() error {
 func (c *NodeCLI) getLocalNetworkClient() nodeclient.NodeClient {
 	nc := *c.Node.NodeClient
 	nc.NodeAddress.Port = c.AlreadyRunningPort
-	nc.NodeAddress.Host = "localhost"
+	nc.NodeAddress.Host = net.GetLocalHost(c.Input.BindHost)
 	return nc
 }
 
@@ -337,7 +399,14 @@ func (c *NodeCLI) commandInitBlockchain() error {
 		return errors.New("Blockchain already exists")
 	}
 
-	alldone, err := c.Node.InitBlockchainFromOther(c.Input.Args.NodeHost, c.Input.Args.NodePort)
+	// the chain doesn't exist yet. the network is known only from the config
+	genesisHash, err := net.DecodeGenesisHash(c.Input.Genesis.Hash)
+
+	if err != nil {
+		return err
+	}
+
+	alldone, err := c.Node.InitBlockchainFromOther(c.Input.Args.NodeHost, c.Input.Args.NodePort, genesisHash)
 
 	if err != nil {
 		return err
@@ -392,6 +461,7 @@ func (c *NodeCLI) commandPrintChain() error {
 			fmt.Printf("============ Block %x ============\n", block.Hash)
 			fmt.Printf("Height: %d\n", block.Height)
 			fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
+			fmt.Printf("Version: %d, Merkle root: %x\n", block.Version, block.MerkleRoot)
 
 			for _, tx := range block.Transactions {
 				fmt.Println(tx)
@@ -465,7 +535,8 @@ func (c *NodeCLI) commandAddressesBalance() error {
 	fmt.Println()
 
 	for address, balance := range result {
-		fmt.Printf("%s: %.8f (Approved - %.8f, Pending - %.8f)\n", address, balance.Total, balance.Approved, balance.Pending)
+		fmt.Printf("%s: %s (Approved - %s, Pending - %s)\n", address,
+			utils.FormatAmount(balance.Total), utils.FormatAmount(balance.Approved), utils.FormatAmount(balance.Pending))
 	}
 
 	return nil
@@ -479,7 +550,7 @@ func (c *NodeCLI) commandAddressHistory() error {
 		return c.forwardCommandToWallet()
 	}
 
-	result, err := c.Node.NodeBC.GetAddressHistory(c.Input.Args.Address)
+	result, err := c.Node.NodeBC.GetAddressHistory(c.Input.Args.Address, 0, 0)
 
 	if err != nil {
 		return err
@@ -487,9 +558,9 @@ func (c *NodeCLI) commandAddressHistory() error {
 	fmt.Println("History of transactions:")
 	for _, rec := range result {
 		if rec.IOType {
-			fmt.Printf("%f\t In from\t%s\n", rec.Value, rec.Address)
+			fmt.Printf("%s\t In from\t%s\n", utils.FormatAmount(rec.Value), rec.Address)
 		} else {
-			fmt.Printf("%f\t Out To  \t%s\n", rec.Value, rec.Address)
+			fmt.Printf("%s\t Out To  \t%s\n", utils.FormatAmount(rec.Value), rec.Address)
 		}
 
 	}
@@ -504,11 +575,11 @@ func (c *NodeCLI) commandShowUnspent() error {
 		return c.forwardCommandToWallet()
 	}
 
-	balance := float64(0)
+	balance := int64(0)
 
 	err := c.Node.GetTransactionsManager().ForEachUnspentOutput(c.Input.Args.Address,
-		func(fromaddr string, value float64, txID []byte, output int, isbase bool) error {
-			fmt.Printf("%f\t from\t%s in transaction %x output #%d\n", value, fromaddr, txID, output)
+		func(fromaddr string, value int64, txID []byte, output int, isbase bool) error {
+			fmt.Printf("%s\t from\t%s in transaction %x output #%d\n", utils.FormatAmount(value), fromaddr, txID, output)
 			balance += value
 			return nil
 		})
@@ -517,7 +588,7 @@ func (c *NodeCLI) commandShowUnspent() error {
 		return err
 	}
 
-	fmt.Printf("\nBalance - %f\n", balance)
+	fmt.Printf("\nBalance - %s\n", utils.FormatAmount(balance))
 
 	return nil
 }
@@ -535,9 +606,9 @@ func (c *NodeCLI) commandGetBalance() error {
 		return err
 	}
 
-	fmt.Printf("Balance of '%s': \nTotal - %.8f\n", c.Input.Args.Address, balance.Total)
-	fmt.Printf("Approved - %.8f\n", balance.Approved)
-	fmt.Printf("Pending - %.8f\n", balance.Pending)
+	fmt.Printf("Balance of '%s': \nTotal - %s\n", c.Input.Args.Address, utils.FormatAmount(balance.Total))
+	fmt.Printf("Approved - %s\n", utils.FormatAmount(balance.Approved))
+	fmt.Printf("Pending - %s\n", utils.FormatAmount(balance.Pending))
 	return nil
 }
 
@@ -565,7 +636,7 @@ func (c *NodeCLI) commandSend() error {
 	}
 
 	txid, err := c.Node.Send(walletobj.GetPublicKey(), walletobj.GetPrivateKey(),
-		c.Input.Args.To, c.Input.Args.Amount)
+		c.Input.Args.To, c.Input.Args.Amount, c.Input.Args.Fee)
 
 	if err != nil {
 		return err
@@ -585,6 +656,7 @@ func (c *NodeCLI) commandReindexCache() error {
 	}
 
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", info["unspentoutputs"])
+	fmt.Printf("There are %d records in the address transactions index.\n", info["addresstransactions"])
 	return nil
 }
 
@@ -684,6 +756,10 @@ func (c *NodeCLI) commandShowState(daemon *server.NodeDaemon) error {
 		return err
 	}
 
+	if info.Host != "" {
+		fmt.Printf("Advertised address: %s\n", info.Host)
+	}
+
 	fmt.Println("Blockchain state:")
 
 	fmt.Printf("  Number of blocks - %d\n", info.BlocksNumber)
@@ -699,26 +775,80 @@ func (c *NodeCLI) commandShowState(daemon *server.NodeDaemon) error {
 	return nil
 }
 
+// Shows current supply of coins and the emission schedule
+func (c *NodeCLI) commandShowSupply() error {
+	var info nodeclient.ComGetNodeState
+	var err error
+
+	if c.AlreadyRunningPort > 0 {
+		// DB is used by the node server. request state from it
+		nc := c.getLocalNetworkClient()
+
+		info, err = nc.SendGetState()
+	} else {
+		info, err = c.Node.GetNodeState()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	height := info.BlocksNumber - 1
+
+	fmt.Println("Supply:")
+	fmt.Printf("  Height - %d\n", height)
+	fmt.Printf("  Current supply - %s\n", utils.FormatAmount(consensus.GetSupplyAtHeight(height)))
+	fmt.Printf("  Next block subsidy - %s\n", utils.FormatAmount(consensus.GetBlockSubsidy(height+1)))
+	fmt.Printf("  Max supply - %s\n", utils.FormatAmount(consensus.GetEmissionConfig().MaxSupply))
+
+	fmt.Println("Emission schedule:")
+
+	for _, p := range consensus.GetEmissionSchedule() {
+		fmt.Printf("  Blocks %d - %d\t subsidy %s\t supply after %s\n", p.StartHeight, p.EndHeight,
+			utils.FormatAmount(p.Subsidy), utils.FormatAmount(p.Supply))
+	}
+
+	return nil
+}
+
 // Displays list of nodes (connections)
 func (c *NodeCLI) commandShowNodes() error {
-	var nodes []net.NodeAddr
+	var nodes []nodeclient.ComNodeInfo
 	var err error
 
 	if c.AlreadyRunningPort > 0 {
 		// connect to node to get nodes list
 		nc := c.getLocalNetworkClient()
-		nodes, err = nc.SendGetNodes()
+		nodes, err = nc.SendGetNodesInfo()
 
 		if err != nil {
 			return err
 		}
 	} else {
-		nodes = c.Node.NodeNet.GetNodes()
+		// scores are known only by running node
+		bans, err := c.Node.GetNodesStorage().GetBans()
+
+		if err != nil {
+			return err
+		}
+		nodes = c.Node.GetNodesInfo(bans, nil)
 	}
 	fmt.Println("Nodes:")
 
 	for _, n := range nodes {
-		fmt.Println("  ", n.NodeAddrToString())
+		addr := n.Node.NodeAddrToString()
+
+		if n.Node.Port == 0 {
+			addr = n.Node.Host
+		}
+
+		status := fmt.Sprintf("score %d", n.Score)
+
+		if n.BannedTill > time.Now().Unix() {
+			status += ", banned till " + time.Unix(n.BannedTill, 0).Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Printf("   %s (%s)\n", addr, status)
 	}
 
 	return nil
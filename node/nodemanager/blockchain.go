@@ -66,8 +66,9 @@ func (n *NodeBlockchain) GetTopBlockHash() ([]byte, error) {
 	return topHash, nil
 }
 
-// Returns history of transactions for given address
-func (n *NodeBlockchain) GetAddressHistory(address string) ([]transaction.TransactionsHistory, error) {
+// Returns history of transactions for given address. Uses the index of address transactions.
+// Only blocks below beforeHeight are used if it is not 0. 0 maxCount means full history
+func (n *NodeBlockchain) GetAddressHistory(address string, beforeHeight int, maxCount int) ([]transaction.TransactionsHistory, error) {
 	if address == "" {
 		return nil, errors.New("Address is missed")
 	}
@@ -76,15 +77,7 @@ func (n *NodeBlockchain) GetAddressHistory(address string) ([]transaction.Transa
 	if !w.ValidateAddress(address) {
 		return nil, errors.New("Address is not valid")
 	}
-	bci, err := blockchain.NewBlockchainIterator(n.DBConn.DB())
-
-	if err != nil {
-		return nil, err
-	}
-
-	pubKeyHash, _ := utils.AddresToPubKeyHash(address)
-
-	return bci.GetAddressHistory(pubKeyHash, address)
+	return n.getTransactionsManager().GetAddressHistory(address, beforeHeight, maxCount)
 }
 
 // Drop block from a top of blockchain
@@ -1,6 +1,7 @@
 package nodemanager
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -74,7 +75,7 @@ func (n *makeBlockchain) CreateBlockchain(genesisCoinbaseData string) error {
 // Creates new blockchain DB from given list of blocks
 // This would be used when new empty node started and syncs with other nodes
 
-func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *nodeclient.NodeClient, BC *NodeBlockchain) (bool, error) {
+func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *nodeclient.NodeClient, BC *NodeBlockchain, genesisHash []byte) (bool, error) {
 
 	n.Logger.Trace.Printf("Try to init blockchain from %s:%d", addr.Host, addr.Port)
 
@@ -96,6 +97,10 @@ func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *
 	if err != nil {
 		return false, err
 	}
+
+	if genesisHash != nil && !bytes.Equal(block.Hash, genesisHash) {
+		return false, errors.New(fmt.Sprintf("Node %s is from other network with genesis %x", addr.NodeAddrToString(), block.Hash))
+	}
 	n.Logger.Trace.Printf("Importing first block hash %x", block.Hash)
 	// make blockchain with single block
 	err = n.addFirstBlock(block)
@@ -127,14 +132,21 @@ func (n *makeBlockchain) InitBlockchainFromOther(addr net.NodeAddr, nodeclient *
 				return false, err
 			}
 
-			_, err = BC.AddBlock(block)
+			// the block and caches of transactions are saved together
+			err = n.DBConn.DB().ExecuteInTransaction(func() error {
+				_, err := BC.AddBlock(block)
+
+				if err != nil {
+					return err
+				}
+
+				return TXMan.BlockAdded(block, true)
+			})
 
 			if err != nil {
 				return false, err
 			}
 
-			TXMan.BlockAdded(block, true)
-
 			MH = block.Height
 		}
 	}
@@ -160,14 +172,18 @@ func (n *makeBlockchain) prepareGenesisBlock(address, genesisCoinbaseData string
 
 	cbtx := &transaction.Transaction{}
 
-	errc := cbtx.MakeCoinbaseTX(address, genesisCoinbaseData)
+	errc := cbtx.MakeCoinbaseTX(address, genesisCoinbaseData, consensus.GetBlockSubsidy(0))
 
 	if errc != nil {
 		return nil, errc
 	}
 
 	genesis := &structures.Block{}
-	genesis.PrepareNewBlock([]*transaction.Transaction{cbtx}, []byte{}, 0)
+	err := genesis.PrepareNewBlock([]*transaction.Transaction{cbtx}, []byte{}, 0)
+
+	if err != nil {
+		return nil, err
+	}
 
 	return genesis, nil
 }
@@ -210,6 +226,13 @@ func (n *makeBlockchain) addFirstBlock(genesis *structures.Block) error {
 		return err
 	}
 
+	// new DB has data in current format. no upgrades needed
+	err = bcdb.SaveDBVersion(currentDBVersion)
+
+	if err != nil {
+		return err
+	}
+
 	// add first rec to chain list
 	err = bcdb.AddToChain(genesis.Hash, []byte{})
 
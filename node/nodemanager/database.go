@@ -75,7 +75,7 @@ func (db *Database) OpenConnection(reason string, sessid string) error {
 }
 
 func (db *Database) PrepareConnection(sessid string) {
-	obj := &database.BoltDBManager{}
+	obj := &database.KVDBManager{}
 	obj.SessID = sessid
 	db.db = obj
 	db.db.SetLogger(db.Logger)
@@ -13,6 +13,7 @@ import (
 	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/lib/wallet"
 	"github.com/NlaakStudios/democoin/node/blockchain"
+	"github.com/NlaakStudios/democoin/node/config"
 	"github.com/NlaakStudios/democoin/node/consensus"
 	"github.com/NlaakStudios/democoin/node/structures"
 	"github.com/NlaakStudios/democoin/node/transactions"
@@ -95,6 +96,20 @@ func (n *Node) InitClient() error {
 	return nil
 }
 
+// Init encrypted transport for the network client. Key of the node is loaded from data dir
+// or is created if this is first start
+func (n *Node) InitTransport(requireEncryption bool) error {
+	transport, err := net.NewTransport(n.DataDir, requireEncryption)
+
+	if err != nil {
+		return err
+	}
+
+	n.NodeClient.Transport = transport
+
+	return nil
+}
+
 // Load list of other nodes addresses
 func (n *Node) InitNodes(list []net.NodeAddr, force bool) error {
 	if n.DBConn.OpenConnectionIfNeeded("CheckNodesAndGenesis", n.SessionID) {
@@ -125,6 +140,43 @@ func (n *Node) InitNodes(list []net.NodeAddr, force bool) error {
 	return nil
 }
 
+// Returns storage of nodes list and bans of nodes
+func (n *Node) GetNodesStorage() NodesListStorage {
+	return NodesListStorage{n.DBConn, n.SessionID}
+}
+
+// Returns known nodes with scores and bans. Scores and bans are by host IP
+// Banned hosts which are not in the list of nodes are added in the end
+func (n *Node) GetNodesInfo(bans map[string]int64, scores map[string]int) []nodeclient.ComNodeInfo {
+	list := []nodeclient.ComNodeInfo{}
+	listed := map[string]bool{}
+
+	for _, addr := range n.NodeNet.GetNodes() {
+		info := nodeclient.ComNodeInfo{}
+		info.Node = addr
+
+		for host, until := range bans {
+			if addr.CompareToAddress(net.NodeAddr{Host: host, Port: addr.Port}) {
+				info.BannedTill = until
+				listed[host] = true
+			}
+		}
+		for host, score := range scores {
+			if addr.CompareToAddress(net.NodeAddr{Host: host, Port: addr.Port}) {
+				info.Score = score
+			}
+		}
+		list = append(list, info)
+	}
+
+	for host, until := range bans {
+		if !listed[host] {
+			list = append(list, nodeclient.ComNodeInfo{Node: net.NodeAddr{Host: host}, Score: scores[host], BannedTill: until})
+		}
+	}
+	return list
+}
+
 // Check if blockchain already exists. If no, we will not allow most of operations
 // It is needed to create it first
 
@@ -149,24 +201,31 @@ func (n *Node) CreateBlockchain(address, genesisCoinbaseData string) error {
 
 // Creates new blockchain DB from given list of blocks
 // This would be used when new empty node started and syncs with other nodes
+// Genesis hash is from a config. If it is set, nodes and blocks of other networks are not used
 
-func (n *Node) InitBlockchainFromOther(host string, port int) (bool, error) {
+func (n *Node) InitBlockchainFromOther(host string, port int, genesisHash []byte) (bool, error) {
 	if host == "" {
 		// load node from special hardcoded url
-		n.NodeNet.LoadInitialNodes(nil)
+		err := n.NodeNet.LoadInitialNodes(genesisHash)
+
+		if err != nil {
+			n.Logger.Trace.Println(err.Error())
+		}
 		// get node from known nodes
-		if len(n.NodeNet.Nodes) == 0 {
+		nodes := n.NodeNet.SelectOutbound(1, n.NodeClient.NodeAddress)
+
+		if len(nodes) == 0 {
 
 			return false, errors.New("No known nodes to request a blockchain")
 		}
-		nd := n.NodeNet.Nodes[rand.Intn(len(n.NodeNet.Nodes))]
+		nd := nodes[0]
 
 		host = nd.Host
 		port = nd.Port
 	}
 	addr := net.NodeAddr{host, port}
 
-	complete, err := n.getCreateManager().InitBlockchainFromOther(addr, n.NodeClient, &n.NodeBC)
+	complete, err := n.getCreateManager().InitBlockchainFromOther(addr, n.NodeClient, &n.NodeBC, genesisHash)
 
 	if err != nil {
 		return false, err
@@ -178,15 +237,21 @@ func (n *Node) InitBlockchainFromOther(host string, port int) (bool, error) {
 }
 
 /*
-* Send transaction to all known nodes. This wil send only hash and node hash to check if hash exists or no
+* Send transaction to selected known nodes. This wil send only hash and node hash to check if hash exists or no
+* If the inventory relay is used, the hash is queued and sent later. Nodes that have the transaction are skipped
  */
 func (n *Node) SendTransactionToAll(tx *transaction.Transaction) {
-	n.Logger.Trace.Printf("Send transaction to %d nodes", len(n.NodeNet.Nodes))
+	nodes := n.NodeNet.SelectOutbound(config.MaxOutboundNodes, n.NodeClient.NodeAddress)
 
-	for _, node := range n.NodeNet.Nodes {
-		if node.CompareToAddress(n.NodeClient.NodeAddress) {
-			continue
-		}
+	if n.NodeClient.Inventory != nil {
+		n.Logger.Trace.Printf("Queue TX %x for %d nodes", tx.ID, len(nodes))
+		n.NodeClient.Inventory.Queue(nodes, tx.ID)
+		return
+	}
+
+	n.Logger.Trace.Printf("Send transaction to %d nodes", len(nodes))
+
+	for _, node := range nodes {
 		n.Logger.Trace.Printf("Send TX %x to %s", tx.ID, node.NodeAddrToString())
 		n.NodeClient.SendInv(node, "tx", [][]byte{tx.ID})
 	}
@@ -207,14 +272,14 @@ func (n *Node) AddNodeToKnown(addr net.NodeAddr, sendversion bool) {
 	}
 }
 
-// Send block to all known nodes
+// Send block to selected known nodes
 // This is used in case when new block was received from other node or
 // created by this node. We will notify our network about new block
 // But not send full block, only hash and previous hash. So, other can copy it
 // Address from where we get it will be skipped
 func (n *Node) SendBlockToAll(newBlock *structures.Block, skipaddr net.NodeAddr) {
-	for _, node := range n.NodeNet.Nodes {
-		if node.CompareToAddress(n.NodeClient.NodeAddress) {
+	for _, node := range n.NodeNet.SelectOutbound(config.MaxOutboundNodes, n.NodeClient.NodeAddress) {
+		if node.CompareToAddress(skipaddr) {
 			continue
 		}
 		blockshortdata, err := newBlock.GetShortCopy().Serialize()
@@ -225,12 +290,18 @@ func (n *Node) SendBlockToAll(newBlock *structures.Block, skipaddr net.NodeAddr)
 }
 
 /*
-* Send own version to all known nodes
+* Send own version to given nodes or to selected known nodes if the list is empty
  */
 func (n *Node) SendVersionToNodes(nodes []net.NodeAddr) {
 	opened := n.DBConn.OpenConnectionIfNeeded("GetHeigh", n.SessionID)
 	bestHeight, err := n.NodeBC.GetBestHeight()
 
+	var genesisHash []byte
+
+	if err == nil {
+		genesisHash, err = n.NodeBC.GetBCManager().GetGenesisBlockHash()
+	}
+
 	if opened {
 		n.DBConn.CloseConnection()
 	}
@@ -240,26 +311,42 @@ func (n *Node) SendVersionToNodes(nodes []net.NodeAddr) {
 	}
 
 	if len(nodes) == 0 {
-		nodes = n.NodeNet.Nodes
+		nodes = n.NodeNet.SelectOutbound(config.MaxOutboundNodes, n.NodeClient.NodeAddress)
 	}
 
 	for _, node := range nodes {
 		if node.CompareToAddress(n.NodeClient.NodeAddress) {
 			continue
 		}
-		n.NodeClient.SendVersion(node, bestHeight)
+		n.NodeClient.SendVersion(node, bestHeight, genesisHash)
+	}
+}
+
+// Adds addresses received from other node to the address book. Address of this node is skipped
+func (n *Node) AddAddresses(list []net.NodeAddr, source string) []net.NodeAddr {
+	own := n.NodeClient.NodeAddress
+	filtered := []net.NodeAddr{}
+
+	for _, addr := range list {
+		if addr.Port == own.Port && (addr.CompareToAddress(own) || net.GetAddressGroup(addr.Host) == "local") {
+			continue
+		}
+		filtered = append(filtered, addr)
 	}
+
+	return n.NodeNet.AddAddresses(filtered, source)
 }
 
 /*
 * Check if the address is known . If not then add to known
-* and send list of all addresses to that node
+* and send list of random good addresses to that node
  */
 func (n *Node) CheckAddressKnown(addr net.NodeAddr) bool {
 	if !n.NodeNet.CheckIsKnown(addr) {
-		// send him all addresses
-		n.Logger.Trace.Printf("sending list of address to %s , %s", addr.NodeAddrToString(), n.NodeNet.Nodes)
-		n.NodeClient.SendAddrList(addr, n.NodeNet.Nodes)
+		nodes := n.NodeNet.GetAddressesForGossip(net.AddrGossipMax, addr.Host)
+
+		n.Logger.Trace.Printf("sending list of %d addresses to %s", len(nodes), addr.NodeAddrToString())
+		n.NodeClient.SendAddrList(addr, nodes)
 
 		n.NodeNet.AddNodeToKnown(addr)
 
@@ -273,7 +360,7 @@ func (n *Node) CheckAddressKnown(addr net.NodeAddr) bool {
 * Send money .
 * This adds a transaction directly to the DB. Can be executed when a node server is not running
  */
-func (n *Node) Send(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount float64) ([]byte, error) {
+func (n *Node) Send(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount int64, fee int64) ([]byte, error) {
 	// get pubkey of the wallet with "from" address
 	if to == "" {
 		return nil, errors.New("Recipient address is not provided")
@@ -284,7 +371,7 @@ func (n *Node) Send(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount f
 		return nil, errors.New("Recipient address is not valid")
 	}
 
-	tx, err := n.GetTransactionsManager().CreateTransaction(PubKey, privKey, to, amount)
+	tx, err := n.GetTransactionsManager().CreateTransaction(PubKey, privKey, to, amount, fee)
 
 	if err != nil {
 		return nil, err
@@ -382,48 +469,65 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 
 	curLastHash, _, err := bcm.GetState()
 
-	// we need to know how the block was added to managed transactions caches correctly
-	addstate, err := n.NodeBC.AddBlock(block)
+	var addstate uint
 
-	if err != nil {
-		return 0, err
-	}
+	// the block, chain records and caches of transactions are saved in one DB transaction.
+	// if something fails, nothing is saved and caches stay consistent with the chain
+	err = n.DBConn.DB().ExecuteInTransaction(func() error {
+		var err error
 
-	if addstate == blockchain.BCBAddState_addedToParallel ||
-		addstate == blockchain.BCBAddState_addedToTop ||
-		addstate == blockchain.BCBAddState_addedToParallelTop {
+		// we need to know how the block was added to managed transactions caches correctly
+		addstate, err = n.NodeBC.AddBlock(block)
 
-		n.GetTransactionsManager().BlockAdded(block, addstate == blockchain.BCBAddState_addedToTop)
-	}
+		if err != nil {
+			return err
+		}
 
-	if addstate == blockchain.BCBAddState_addedToParallelTop {
-		// get 2 blocks branches that replaced each other
-		newChain, oldChain, err := n.NodeBC.GetBranchesReplacement(curLastHash, []byte{})
+		if addstate == blockchain.BCBAddState_addedToParallel ||
+			addstate == blockchain.BCBAddState_addedToTop ||
+			addstate == blockchain.BCBAddState_addedToParallelTop {
 
-		if err != nil {
-			return 0, err
+			err = n.GetTransactionsManager().BlockAdded(block, addstate == blockchain.BCBAddState_addedToTop)
+
+			if err != nil {
+				return err
+			}
 		}
 
-		if newChain != nil && oldChain != nil {
-			for _, block := range oldChain {
+		if addstate == blockchain.BCBAddState_addedToParallelTop {
+			// get 2 blocks branches that replaced each other
+			newChain, oldChain, err := n.NodeBC.GetBranchesReplacement(curLastHash, []byte{})
+
+			if err != nil {
+				return err
+			}
+
+			if newChain != nil && oldChain != nil {
+				for _, block := range oldChain {
 
-				err := n.GetTransactionsManager().BlockRemovedFromPrimaryChain(block)
+					err := n.GetTransactionsManager().BlockRemovedFromPrimaryChain(block)
 
-				if err != nil {
+					if err != nil {
 
-					return 0, err
+						return err
+					}
 				}
-			}
-			for _, block := range newChain {
+				for _, block := range newChain {
 
-				err := n.GetTransactionsManager().BlockAddedToPrimaryChain(block)
+					err := n.GetTransactionsManager().BlockAddedToPrimaryChain(block)
 
-				if err != nil {
+					if err != nil {
 
-					return 0, err
+						return err
+					}
 				}
 			}
 		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
 	}
 
 	return addstate, nil
@@ -434,15 +538,16 @@ func (n *Node) AddBlock(block *structures.Block) (uint, error) {
 * This will not check if there are other branch that can now be longest and becomes main branch
  */
 func (n *Node) DropBlock() error {
-	block, err := n.NodeBC.DropBlock()
-
-	if err != nil {
-		return err
-	}
+	// the block and caches of transactions are updated in one DB transaction
+	return n.DBConn.DB().ExecuteInTransaction(func() error {
+		block, err := n.NodeBC.DropBlock()
 
-	n.GetTransactionsManager().BlockRemoved(block)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		return n.GetTransactionsManager().BlockRemoved(block)
+	})
 }
 
 // New block info received from oher node. It is only Hash and PrevHash, not full block
@@ -1,7 +1,11 @@
 package nodemanager
 
 import (
+	"encoding/binary"
+	"encoding/json"
+
 	"github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/node/database"
 )
 
 type NodesListStorage struct {
@@ -9,70 +13,119 @@ type NodesListStorage struct {
 	SessionID string
 }
 
-func (s NodesListStorage) GetNodes() ([]net.NodeAddr, error) {
+// Opens nodes DB with separate connection and closes it right after a function is done.
+// This structure is shared between threads and is used while a caller has other DB opened.
+// The nodes DB must not stay locked till the caller closes own connection
+func (s NodesListStorage) withNodesDB(reason string, f func(nddb database.NodesInterface) error) error {
+	conn := s.DBConn.Clone()
 
-	nddb, err := s.DBConn.DB().GetNodesObject()
+	err := conn.OpenConnection(reason, s.SessionID)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer conn.CloseConnection()
+
+	nddb, err := conn.DB().GetNodesObject()
+
+	if err != nil {
+		return err
+	}
+
+	return f(nddb)
+}
+
+// Returns saved address book. Records are saved as JSON. Older versions saved only host:port
+func (s NodesListStorage) GetNodes() ([]net.KnownAddress, error) {
+	nodes := []net.KnownAddress{}
 
-	nodes := []net.NodeAddr{}
+	err := s.withNodesDB("GetNodes", func(nddb database.NodesInterface) error {
+		return nddb.ForEach(func(k, v []byte) error {
+			node := net.KnownAddress{}
 
-	nddb.ForEach(func(k, v []byte) error {
-		addr := string(v)
-		node := net.NodeAddr{}
-		node.LoadFromString(addr)
+			if json.Unmarshal(v, &node) != nil {
+				node = net.KnownAddress{}
 
-		nodes = append(nodes, node)
-		return nil
+				if node.Addr.LoadFromString(string(v)) != nil {
+					return nil
+				}
+			}
+
+			nodes = append(nodes, node)
+			return nil
+		})
 	})
 
+	if err != nil {
+		return nil, err
+	}
 	return nodes, nil
 }
-func (s NodesListStorage) AddNodeToKnown(addr net.NodeAddr) {
-	if !s.DBConn.CheckConnectionIsOpen() {
-		// if connection is not opened when this function is called, we have to close it
-		// we do this because this structre can be shared between threads.
-		defer s.DBConn.CloseConnection()
-	}
-	s.DBConn.Logger.Trace.Printf("AddNodeToKnown %s", addr.NodeAddrToString())
+func (s NodesListStorage) AddNodeToKnown(node net.KnownAddress) {
+	s.DBConn.Logger.Trace.Printf("AddNodeToKnown %s", node.Addr.NodeAddrToString())
 
-	nddb, err := s.DBConn.DB().GetNodesObject()
+	data, err := json.Marshal(node)
 
 	if err != nil {
 		return
 	}
-	address := addr.NodeAddrToString()
-	key := []byte(address)
 
-	nddb.PutNode(key, key)
-
-	return
+	s.withNodesDB("AddNodeToKnown", func(nddb database.NodesInterface) error {
+		return nddb.PutNode([]byte(node.Addr.NodeAddrToString()), data)
+	})
 }
 func (s NodesListStorage) RemoveNodeFromKnown(addr net.NodeAddr) {
-	if !s.DBConn.CheckConnectionIsOpen() {
-		// if connection is not opened when this function is called, we have to close it
-		// we do this because this structre can be shared between threads.
-		defer s.DBConn.CloseConnection()
-	}
-	nddb, err := s.DBConn.DB().GetNodesObject()
-
-	if err != nil {
-		return
-	}
-	address := addr.NodeAddrToString()
-	key := []byte(address)
-	nddb.DeleteNode(key)
-	return
+	s.withNodesDB("RemoveNodeFromKnown", func(nddb database.NodesInterface) error {
+		return nddb.DeleteNode([]byte(addr.NodeAddrToString()))
+	})
 }
 func (s NodesListStorage) GetCountOfKnownNodes() (int, error) {
+	count := 0
+
+	err := s.withNodesDB("GetCountOfKnownNodes", func(nddb database.NodesInterface) error {
+		var err error
+		count, err = nddb.GetCount()
+
+		return err
+	})
+
+	return count, err
+}
 
-	nddb, err := s.DBConn.DB().GetNodesObject()
+// Returns banned hosts and times (unix seconds) when bans end
+func (s NodesListStorage) GetBans() (map[string]int64, error) {
+	bans := map[string]int64{}
+
+	err := s.withNodesDB("GetBans", func(nddb database.NodesInterface) error {
+		return nddb.ForEachBan(func(k, v []byte) error {
+			if len(v) == 8 {
+				bans[string(k)] = int64(binary.BigEndian.Uint64(v))
+			}
+			return nil
+		})
+	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	return bans, nil
+}
+
+// Saves a ban of a host till given time (unix seconds)
+func (s NodesListStorage) AddBan(host string, until int64) error {
+	s.DBConn.Logger.Trace.Printf("AddBan %s till %d", host, until)
+
+	bandata := make([]byte, 8)
+	binary.BigEndian.PutUint64(bandata, uint64(until))
 
-	return nddb.GetCount()
+	return s.withNodesDB("AddBan", func(nddb database.NodesInterface) error {
+		return nddb.PutBan([]byte(host), bandata)
+	})
+}
+
+// Removes a ban of a host
+func (s NodesListStorage) RemoveBan(host string) error {
+	return s.withNodesDB("RemoveBan", func(nddb database.NodesInterface) error {
+		return nddb.DeleteBan([]byte(host))
+	})
 }
@@ -0,0 +1,628 @@
+package nodemanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/blockchain"
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/consensus"
+	"github.com/NlaakStudios/democoin/node/database"
+	"github.com/NlaakStudios/democoin/node/structures"
+)
+
+// Header-first synchronization of the blockchain. It is used when this node is far behind other nodes
+// 1. Headers of main chains of other nodes are loaded, checked and saved to the DB
+// 2. Blocks are loaded in parallel from all nodes, but not further than a window from the last added block
+// 3. Blocks are added in order of heights. Header of an added block is deleted
+// If the process is stopped then next sync continues from saved headers
+// Headers show only a chain of other node. Blocks are fully verified when added
+type blockchainSync struct {
+	node   *Node
+	nodes  []net.NodeAddr
+	Logger *utils.LoggerMan
+}
+
+// Headers of other chain loaded from a node. They are kept in memory till they have more work
+// than saved headers. Then they replace saved headers
+type syncHeadersBranch struct {
+	forkHeight int // height of own block the branch follows
+	headers    []*structures.BlockHeaderInfo
+}
+
+// Result of loading of a block from other node
+type syncBlockResult struct {
+	height int
+	addr   net.NodeAddr
+	block  *structures.Block
+	err    error
+}
+
+// Loads blocks from given nodes using header-first sync
+func (n *Node) SyncBlockchain(nodes []net.NodeAddr) error {
+	s := blockchainSync{}
+	s.node = n
+	s.Logger = n.Logger
+
+	for _, addr := range nodes {
+		if addr.CompareToAddress(n.NodeClient.NodeAddress) {
+			continue
+		}
+		if !n.NodeClient.PeerSupports(addr, net.ServiceHeaders) {
+			// old node. blocks are loaded from it with getblocks
+			continue
+		}
+		s.nodes = append(s.nodes, addr)
+	}
+
+	if len(s.nodes) == 0 {
+		return errors.New("No nodes to sync with")
+	}
+
+	// DB is opened only when needed. Other routines can use it while we wait for network
+	defer n.DBConn.CloseConnection()
+
+	err := s.loadHeaders()
+
+	if err != nil {
+		return err
+	}
+
+	return s.loadBlocks()
+}
+
+func (s *blockchainSync) getHeadersDB() (database.SyncHeadersInterface, error) {
+	return s.node.DBConn.DB().GetSyncHeadersObject()
+}
+
+// Returns a header decoded from DB data. Nil if there is no data
+func (s *blockchainSync) decodeHeader(data []byte, err error) (*structures.BlockHeaderInfo, error) {
+	if err != nil || data == nil {
+		return nil, err
+	}
+	h := &structures.BlockHeaderInfo{}
+
+	err = h.Deserialize(data)
+
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Loads headers from all nodes. Every node is asked for headers following the last known header
+func (s *blockchainSync) loadHeaders() error {
+	for _, addr := range s.nodes {
+		err := s.loadHeadersFromNode(addr)
+
+		if err != nil {
+			// try other nodes. we will use only headers which were checked
+			s.Logger.Trace.Printf("Sync: headers loading from %s failed: %s", addr.NodeAddrToString(), err.Error())
+		}
+	}
+	return nil
+}
+
+// Loads headers from a node by batches till the node returns all
+func (s *blockchainSync) loadHeadersFromNode(addr net.NodeAddr) error {
+	branch := &syncHeadersBranch{}
+
+	for {
+		locator, err := s.getLocator(branch)
+
+		s.node.DBConn.CloseConnection()
+
+		if err != nil {
+			return err
+		}
+
+		headersdata, err := s.node.NodeClient.SendGetHeaders(addr, locator, config.SyncHeadersBatchSize)
+
+		if err != nil {
+			return err
+		}
+
+		if len(headersdata) == 0 {
+			return nil
+		}
+
+		err = s.saveHeaders(headersdata, branch)
+
+		s.node.DBConn.CloseConnection()
+
+		if err != nil {
+			return err
+		}
+
+		s.Logger.Trace.Printf("Sync: loaded %d headers from %s", len(headersdata), addr.NodeAddrToString())
+
+		if len(headersdata) < config.SyncHeadersBatchSize {
+			return nil
+		}
+	}
+}
+
+// Returns locator to request headers. It is the last header of not saved branch or the last saved header
+// and hashes from own chain
+func (s *blockchainSync) getLocator(branch *syncHeadersBranch) ([][]byte, error) {
+	hdb, err := s.getHeadersDB()
+
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := s.decodeHeader(hdb.GetLastHeader())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(branch.headers) > 0 {
+		last = branch.headers[len(branch.headers)-1]
+	}
+
+	locator, err := s.node.NodeBC.GetBCManager().GetBlockLocator()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if last != nil {
+		locator = append([][]byte{last.Hash}, locator...)
+	}
+	return locator, nil
+}
+
+// Checks received headers and saves them. Headers must follow saved headers or a block in own chain
+// If they follow a block then they are other branch. It replaces saved headers above that block
+// only when it has more work. Till then the branch is kept in memory
+func (s *blockchainSync) saveHeaders(headersdata [][]byte, branch *syncHeadersBranch) error {
+	hdb, err := s.getHeadersDB()
+
+	if err != nil {
+		return err
+	}
+
+	headers := []*structures.BlockHeaderInfo{}
+
+	for _, hdata := range headersdata {
+		h, err := s.decodeHeader(hdata, nil)
+
+		if err != nil {
+			return err
+		}
+		headers = append(headers, h)
+	}
+
+	prevHash := headers[0].Header.PrevBlockHash
+	prevHeight := -1
+
+	last, err := s.decodeHeader(hdb.GetLastHeader())
+
+	if err != nil {
+		return err
+	}
+
+	// previous headers of the chain which are not in the blockchain. They are needed to check headers
+	known := map[string]*structures.BlockHeaderInfo{}
+	lookback := getHeadersLookback()
+
+	if len(branch.headers) > 0 && bytes.Equal(branch.headers[len(branch.headers)-1].Hash, prevHash) {
+		prevHeight = branch.headers[len(branch.headers)-1].Height
+
+		for i := len(branch.headers) - 1; i >= 0 && i >= len(branch.headers)-lookback; i-- {
+			known[string(branch.headers[i].Hash)] = branch.headers[i]
+		}
+	} else if last != nil && bytes.Equal(last.Hash, prevHash) {
+		prevHeight = last.Height
+		branch.headers = nil
+
+		for height := last.Height; height > last.Height-lookback; height-- {
+			h, err := s.decodeHeader(hdb.GetHeader(height))
+
+			if err != nil {
+				return err
+			}
+
+			if h == nil {
+				// block is added already
+				break
+			}
+			known[string(h.Hash)] = h
+		}
+	} else {
+		prevBlock, err := s.node.NodeBC.GetBlock(prevHash)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Headers don't follow known blocks: %s", err.Error()))
+		}
+		prevHeight = prevBlock.Height
+
+		branch.forkHeight = prevHeight
+		branch.headers = []*structures.BlockHeaderInfo{}
+	}
+
+	getHeader := func(hash []byte) (*structures.BlockHeaderInfo, error) {
+		if h, ok := known[string(hash)]; ok {
+			return h, nil
+		}
+
+		block, err := s.node.NodeBC.GetBlock(hash)
+
+		if err != nil {
+			return nil, err
+		}
+		return block.GetHeaderInfo(), nil
+	}
+
+	for _, h := range headers {
+		if h.Height != prevHeight+1 || !bytes.Equal(h.Header.PrevBlockHash, prevHash) {
+			return errors.New(fmt.Sprintf("Header %x is not in a chain", h.Hash))
+		}
+
+		err = consensus.ValidateHeader(h, getHeader)
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Header %x is not valid: %s", h.Hash, err.Error()))
+		}
+
+		known[string(h.Hash)] = h
+
+		prevHash = h.Hash
+		prevHeight = h.Height
+	}
+
+	if branch.headers == nil {
+		// headers continue saved headers
+		return s.putHeaders(hdb, headers)
+	}
+
+	branch.headers = append(branch.headers, headers...)
+
+	savedWork, err := s.getSavedHeadersWork(hdb, branch.forkHeight+1)
+
+	if err != nil {
+		return err
+	}
+
+	if getHeadersWork(branch.headers).Cmp(savedWork) <= 0 {
+		s.Logger.Trace.Printf("Sync: branch from %d has less work than saved headers", branch.forkHeight)
+		return nil
+	}
+
+	err = hdb.DeleteHeadersFrom(branch.forkHeight + 1)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.putHeaders(hdb, branch.headers)
+
+	if err != nil {
+		return err
+	}
+	branch.headers = nil
+
+	return nil
+}
+
+func (s *blockchainSync) putHeaders(hdb database.SyncHeadersInterface, headers []*structures.BlockHeaderInfo) error {
+	for _, h := range headers {
+		hdata, err := h.Serialize()
+
+		if err != nil {
+			return err
+		}
+
+		err = hdb.PutHeader(h.Height, hdata)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns work of saved headers starting from given height
+func (s *blockchainSync) getSavedHeadersWork(hdb database.SyncHeadersInterface, fromHeight int) (*big.Int, error) {
+	headers := []*structures.BlockHeaderInfo{}
+
+	last, err := s.decodeHeader(hdb.GetLastHeader())
+
+	if err != nil || last == nil {
+		return big.NewInt(0), err
+	}
+
+	for height := fromHeight; height <= last.Height; height++ {
+		h, err := s.decodeHeader(hdb.GetHeader(height))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if h != nil {
+			headers = append(headers, h)
+		}
+	}
+	return getHeadersWork(headers), nil
+}
+
+// Returns total work of blocks of headers
+func getHeadersWork(headers []*structures.BlockHeaderInfo) *big.Int {
+	work := big.NewInt(0)
+
+	for _, h := range headers {
+		work.Add(work, blockchain.GetWorkForBits(consensus.GetHeaderTargetBits(h)))
+	}
+	return work
+}
+
+// Number of previous blocks needed to check a header. Difficulty and median time past depend on them
+func getHeadersLookback() int {
+	if config.RetargetInterval > config.MedianTimePastBlocks {
+		return config.RetargetInterval
+	}
+	return config.MedianTimePastBlocks
+}
+
+// Loads blocks for all saved headers and adds them to the blockchain
+func (s *blockchainSync) loadBlocks() error {
+	hdb, err := s.getHeadersDB()
+
+	if err != nil {
+		return err
+	}
+
+	first, err := s.decodeHeader(hdb.GetFirstHeader())
+
+	if err != nil {
+		return err
+	}
+
+	last, err := s.decodeHeader(hdb.GetLastHeader())
+
+	s.node.DBConn.CloseConnection()
+
+	if err != nil {
+		return err
+	}
+
+	if first == nil {
+		s.Logger.Trace.Printf("Sync: no headers to load blocks for")
+		return nil
+	}
+
+	s.Logger.Trace.Printf("Sync: load blocks %d - %d", first.Height, last.Height)
+
+	results := make(chan syncBlockResult, len(s.nodes))
+
+	// a copy. nodes are appended to it when they finish
+	idle := append([]net.NodeAddr{}, s.nodes...)
+	failures := map[string]int{}
+	retry := []*structures.BlockHeaderInfo{}
+	ready := map[int]*structures.Block{}
+	inflight := 0
+
+	next := first.Height
+	nextToLoad := first.Height
+
+	for next <= last.Height {
+		// give a job to every free node
+		for len(idle) > 0 {
+			var header *structures.BlockHeaderInfo
+
+			if len(retry) > 0 {
+				header = retry[0]
+				retry = retry[1:]
+			} else if nextToLoad <= last.Height && nextToLoad < next+config.SyncDownloadWindow {
+				header, err = s.getHeaderToLoad(nextToLoad)
+
+				if err != nil {
+					return err
+				}
+				nextToLoad++
+
+				if header == nil {
+					// block already exists
+					continue
+				}
+			} else {
+				break
+			}
+
+			go s.loadBlock(idle[0], header, results)
+
+			idle = idle[1:]
+			inflight++
+		}
+
+		if inflight == 0 {
+			if len(ready) == 0 && len(retry) == 0 && nextToLoad > last.Height {
+				// all blocks already existed
+				break
+			}
+			return errors.New("No nodes left to load blocks from. Sync will continue later")
+		}
+
+		r := <-results
+		inflight--
+
+		if r.err != nil {
+			s.Logger.Trace.Printf("Sync: block %d loading from %s failed: %s", r.height, r.addr.NodeAddrToString(), r.err.Error())
+
+			header, err := s.getHeaderToLoad(r.height)
+
+			if err != nil {
+				return err
+			}
+
+			if header != nil {
+				retry = append(retry, header)
+			}
+
+			failures[r.addr.NodeAddrToString()]++
+
+			if failures[r.addr.NodeAddrToString()] < config.SyncMaxNodeFailures {
+				idle = append(idle, r.addr)
+			}
+			continue
+		}
+
+		idle = append(idle, r.addr)
+		ready[r.height] = r.block
+
+		// add all blocks which are in order now
+		for {
+			block, ok := ready[next]
+
+			if !ok {
+				break
+			}
+			delete(ready, next)
+
+			err = s.addBlock(block)
+
+			if err != nil {
+				return err
+			}
+			next++
+		}
+
+		// skip heights of blocks which existed before
+		for next < nextToLoad {
+			if _, ok := ready[next]; ok || s.isLoading(next, retry) {
+				break
+			}
+			exists, err := s.getHeaderToLoad(next)
+
+			if err != nil {
+				return err
+			}
+
+			if exists != nil {
+				break
+			}
+			next++
+		}
+
+		s.node.DBConn.CloseConnection()
+	}
+
+	s.Logger.Trace.Printf("Sync: all blocks loaded")
+
+	return nil
+}
+
+// Checks if a block on the height waits to be loaded again
+func (s *blockchainSync) isLoading(height int, retry []*structures.BlockHeaderInfo) bool {
+	for _, h := range retry {
+		if h.Height == height {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns saved header for a height if the block is not yet in the blockchain
+func (s *blockchainSync) getHeaderToLoad(height int) (*structures.BlockHeaderInfo, error) {
+	hdb, err := s.getHeadersDB()
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := s.decodeHeader(hdb.GetHeader(height))
+
+	if err != nil || header == nil {
+		return nil, err
+	}
+
+	exists, err := s.node.NodeBC.CheckBlockExists(header.Hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		// was added by other routine. header is not needed
+		return nil, hdb.DeleteHeader(height)
+	}
+	return header, nil
+}
+
+// Loads a block from a node and checks it matches the header. It is executed in separate routine
+func (s *blockchainSync) loadBlock(addr net.NodeAddr, header *structures.BlockHeaderInfo, results chan syncBlockResult) {
+	r := syncBlockResult{height: header.Height, addr: addr}
+
+	blockdata, err := s.node.NodeClient.SendGetBlock(addr, header.Hash)
+
+	if err != nil {
+		r.err = err
+		results <- r
+		return
+	}
+
+	block := &structures.Block{}
+	err = block.DeserializeBlock(blockdata)
+
+	if err != nil {
+		r.err = err
+		results <- r
+		return
+	}
+
+	if !bytes.Equal(block.Hash, header.Hash) || block.Height != header.Height {
+		r.err = errors.New("Block doesn't match the header")
+		results <- r
+		return
+	}
+
+	if block.Version > 0 {
+		merkleRoot, err := block.HashTransactions()
+
+		if err != nil {
+			r.err = err
+			results <- r
+			return
+		}
+
+		if !bytes.Equal(block.GetHeader().Hash(), header.Hash) || !bytes.Equal(merkleRoot, block.MerkleRoot) {
+			r.err = errors.New("Block data don't match the header")
+			results <- r
+			return
+		}
+	}
+
+	r.block = block
+	results <- r
+}
+
+// Adds loaded block to the blockchain. Block is fully verified
+func (s *blockchainSync) addBlock(block *structures.Block) error {
+	hdb, err := s.getHeadersDB()
+
+	if err != nil {
+		return err
+	}
+
+	addstate, err := s.node.AddBlock(block)
+
+	if err != nil {
+		// headers chain is wrong starting from this block. load headers again on next sync
+		hdb.DeleteHeadersFrom(block.Height)
+
+		return errors.New(fmt.Sprintf("Block %x can not be added: %s", block.Hash, err.Error()))
+	}
+
+	if addstate == blockchain.BCBAddState_notAddedNoPrev {
+		hdb.DeleteHeadersFrom(block.Height)
+
+		return errors.New(fmt.Sprintf("Previous block for %x is not found", block.Hash))
+	}
+
+	return hdb.DeleteHeader(block.Height)
+}
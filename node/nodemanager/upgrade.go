@@ -0,0 +1,169 @@
+package nodemanager
+
+import (
+	"github.com/NlaakStudios/democoin/node/database"
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+// Version of data format in the DB. Increase it when a new upgrade step is added
+const currentDBVersion = 3
+
+// Upgrade steps. Key is a version the step upgrades DB to
+var dbUpgradeSteps = map[int]func(n *Node) error{
+	1: upgradeDBAmountsToUnits,
+	2: upgradeDBAddressTransactions,
+	3: upgradeDBChainHeights,
+}
+
+// Checks version of data in the DB and executes all missed upgrade steps
+func (n *Node) UpgradeDatabase() error {
+	bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+	if err != nil {
+		return err
+	}
+
+	version, err := bcdb.GetDBVersion()
+
+	if err != nil {
+		return err
+	}
+
+	for version < currentDBVersion {
+		version++
+
+		n.Logger.Trace.Printf("Upgrade DB to version %d", version)
+
+		err = dbUpgradeSteps[version](n)
+
+		if err != nil {
+			return err
+		}
+
+		err = bcdb.SaveDBVersion(version)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Amounts were float64 number of coins. Now they are int64 smallest units
+// Blocks and unapproved transactions are saved in new format, cache is built again.
+// Float values of old transactions are kept, old hashes, signatures and PoW are verified with them
+func upgradeDBAmountsToUnits(n *Node) error {
+	bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+	if err != nil {
+		return err
+	}
+
+	blocks := map[string][]byte{}
+
+	// can not update the DB inside of iteration. collect all first
+	err = bcdb.ForEachBlock(func(hash, blockdata []byte) error {
+		block := structures.Block{}
+
+		err := block.DeserializeBlock(blockdata)
+
+		if err != nil {
+			return err
+		}
+
+		newdata, err := block.Serialize()
+
+		if err != nil {
+			return err
+		}
+
+		blocks[string(hash)] = newdata
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for hash, blockdata := range blocks {
+		err = bcdb.PutBlock([]byte(hash), blockdata)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = upgradeUnapprovedTransactions(n.DBConn.DB())
+
+	if err != nil {
+		return err
+	}
+
+	_, err = n.GetTransactionsManager().ReindexData()
+
+	return err
+}
+
+// History of addresses is read from the index of address transactions. Build it for existing blocks
+func upgradeDBAddressTransactions(n *Node) error {
+	_, err := n.GetTransactionsManager().ReindexData()
+
+	return err
+}
+
+// Blocks are found by height with the index of chain heights. Build it for existing chain
+func upgradeDBChainHeights(n *Node) error {
+	bcdb, err := n.DBConn.DB().GetBlockchainObject()
+
+	if err != nil {
+		return err
+	}
+
+	return bcdb.ReindexChainHeights()
+}
+
+// Saves all unapproved transactions in current format
+func upgradeUnapprovedTransactions(db database.DBManager) error {
+	utdb, err := db.GetUnapprovedTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	txs := map[string][]byte{}
+
+	err = utdb.ForEach(func(txID, txdata []byte) error {
+		tx := transaction.Transaction{}
+
+		err := tx.DeserializeTransaction(txdata)
+
+		if err != nil {
+			return err
+		}
+
+		newdata, err := tx.Serialize()
+
+		if err != nil {
+			return err
+		}
+
+		txs[string(txID)] = newdata
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for txID, txdata := range txs {
+		err = utdb.PutTransaction([]byte(txID), txdata)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
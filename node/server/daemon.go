@@ -23,9 +23,14 @@ type NodeDaemon struct {
 	Port    int
 	Host    string
 	DataDir string
+	BanTime int
 	Server  *NodeServer
 	Logger  *utils.LoggerMan
 	Node    *nodemanager.Node
+	// listening interface and advertised address
+	BindHost     string
+	ExternalHost string
+	ExternalPort int
 }
 
 func (n *NodeDaemon) Init() error {
@@ -49,8 +54,22 @@ func (n *NodeDaemon) createServer() error {
 
 	server := NodeServer{}
 
-	server.NodeAddress.Port = n.Port
-	server.NodeAddress.Host = n.Host
+	server.BindAddress.Port = n.Port
+	server.BindAddress.Host = n.BindHost
+
+	// -host was used to set advertised host before -externalhost
+	server.NodeAddress.Host = n.ExternalHost
+
+	if server.NodeAddress.Host == "" {
+		server.NodeAddress.Host = n.Host
+	}
+	server.NodeAddress.Port = n.ExternalPort
+
+	if server.NodeAddress.Port < 1 {
+		server.NodeAddress.Port = n.Port
+	}
+	// find the host from other nodes if it is not set
+	server.external = newExternalAddress(server.NodeAddress.Host != "" && server.NodeAddress.Host != "localhost")
 
 	server.DataDir = n.DataDir
 
@@ -63,6 +82,8 @@ func (n *NodeDaemon) createServer() error {
 
 	server.Node = n.Node
 
+	server.scores = newPeerScores(n.BanTime)
+
 	n.Server = &server
 
 	return nil
@@ -122,9 +143,13 @@ func (n *NodeDaemon) checkArgumentsAreFine() error {
 
 	walletscli := wallet.WalletCLI{}
 
-	walletscli.Init(n.Logger, winput)
+	err := walletscli.Init(n.Logger, winput)
+
+	if err != nil {
+		return err
+	}
 
-	_, err := walletscli.WalletsObj.GetWallet(n.Server.Node.MinterAddress)
+	_, err = walletscli.WalletsObj.GetWallet(n.Server.Node.MinterAddress)
 
 	if err != nil {
 		return errors.New("Minter Address can not be loaded from wallet. Does it exist?")
@@ -155,6 +180,9 @@ func (n *NodeDaemon) StartServer() error {
 		"-minter=" + n.Server.Node.MinterAddress + " " +
 		"-port=" + strconv.Itoa(n.Port) + " " +
 		"-host=" + n.Host + " " +
+		"-bindhost=" + n.BindHost + " " +
+		"-externalhost=" + n.ExternalHost + " " +
+		"-externalport=" + strconv.Itoa(n.ExternalPort) + " " +
 		"-logs=" + logsstate
 
 	n.Logger.Trace.Println("Execute command : ", command)
@@ -166,6 +194,9 @@ func (n *NodeDaemon) StartServer() error {
 		"-minter="+n.Server.Node.MinterAddress,
 		"-port="+strconv.Itoa(n.Port),
 		"-host="+n.Host,
+		"-bindhost="+n.BindHost,
+		"-externalhost="+n.ExternalHost,
+		"-externalport="+strconv.Itoa(n.ExternalPort),
 		"-logs="+logsstate)
 	cmd.Start()
 	n.Logger.Trace.Println("Daemon process ID is : ", cmd.Process.Pid)
@@ -298,8 +329,8 @@ func (n *NodeDaemon) DaemonizeServer() error {
 
 		// to force server to try to handle next command if there were no input connects
 		// if we don't do this it will stay in "Accepting" mode and can not real channel
-		n.Logger.Trace.Println("Send void command on port ", server.NodeAddress.Port)
-		serverAddr := net.NodeAddr{"localhost", server.NodeAddress.Port}
+		n.Logger.Trace.Println("Send void command on port ", server.BindAddress.Port)
+		serverAddr := net.NodeAddr{net.GetLocalHost(server.BindAddress.Host), server.BindAddress.Port}
 
 		nodeclient := server.GetClient()
 
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sync"
+
+	netlib "github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/node/config"
+)
+
+// Address of this node as other nodes see it. Nodes send the address they used to connect
+// in version command. A node behind NAT doesn't know own external address, so the host
+// reported by enough different nodes is advertised. Not used if the host is set in options
+type externalAddress struct {
+	fixed bool
+
+	lock    sync.Mutex
+	reports map[string]map[string]bool // host -> hosts of nodes which reported it
+	host    string
+}
+
+// Reported hosts are not remembered when there are more hosts than this
+const externalAddressMaxHosts = 100
+
+func newExternalAddress(fixed bool) *externalAddress {
+	e := &externalAddress{}
+	e.fixed = fixed
+	e.reports = make(map[string]map[string]bool)
+
+	return e
+}
+
+// Adds a host reported by a node. Returns the host and true if it becomes new external host
+func (e *externalAddress) addObserved(host string, reporter string) (string, bool) {
+	if e.fixed || !netlib.IsRoutable(host) {
+		return "", false
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	reporters, ok := e.reports[host]
+
+	if !ok {
+		if len(e.reports) >= externalAddressMaxHosts {
+			return "", false
+		}
+		reporters = make(map[string]bool)
+		e.reports[host] = reporters
+	}
+	reporters[reporter] = true
+
+	if host == e.host || len(reporters) < config.ExternalAddressMinReports {
+		return "", false
+	}
+
+	// other host is reported by more nodes
+	if e.host != "" && len(e.reports[e.host]) >= len(reporters) {
+		return "", false
+	}
+	e.host = host
+
+	return host, true
+}
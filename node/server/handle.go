@@ -12,6 +12,8 @@ import (
 	"github.com/NlaakStudios/democoin/lib/nodeclient"
 	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/node/blockchain"
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/consensus"
 	"github.com/NlaakStudios/democoin/node/nodemanager"
 	"github.com/NlaakStudios/democoin/node/structures"
 	"github.com/NlaakStudios/democoin/node/transactions"
@@ -43,12 +45,19 @@ func (s *NodeServerRequest) parseRequestData(payload interface{}) error {
 	err := dec.Decode(payload)
 
 	if err != nil {
+		s.misbehaving(config.PeerScoreMalformedData, "malformed request")
+
 		return errors.New("Parse request: " + err.Error())
 	}
 
 	return nil
 }
 
+// Adds points to a score of a host that sent the request
+func (s *NodeServerRequest) misbehaving(score int, reason string) {
+	s.S.penalizeHost(s.Node, s.RequestIP, score, reason)
+}
+
 // Find and return the list of unspent transactions
 func (s *NodeServerRequest) handleGetUnspent() error {
 	s.HasResponse = true
@@ -70,7 +79,7 @@ func (s *NodeServerRequest) handleGetUnspent() error {
 	}
 
 	err = s.Node.GetTransactionsManager().ForEachUnspentOutput(payload.Address,
-		func(fromaddr string, value float64, txID []byte, output int, isbase bool) error {
+		func(fromaddr string, value int64, txID []byte, output int, isbase bool) error {
 			ut := nodeclient.ComUnspentTransaction{}
 			ut.Amount = value
 			ut.TXID = txID
@@ -109,7 +118,7 @@ func (s *NodeServerRequest) handleGetHistory() error {
 
 	result := []nodeclient.ComHistoryTransaction{}
 
-	history, err := s.Node.NodeBC.GetAddressHistory(payload.Address)
+	history, err := s.Node.NodeBC.GetAddressHistory(payload.Address, payload.BeforeHeight, payload.MaxCount)
 
 	if err != nil {
 		return err
@@ -120,6 +129,7 @@ func (s *NodeServerRequest) handleGetHistory() error {
 		ut.Amount = t.Value
 		ut.IOType = t.IOType
 		ut.TXID = t.TXID
+		ut.Height = t.Height
 
 		if t.IOType {
 			ut.From = t.Address
@@ -138,6 +148,47 @@ func (s *NodeServerRequest) handleGetHistory() error {
 	return nil
 }
 
+// Return Merkle proof of a transaction in a block. Lite wallets use it to confirm a payment
+func (s *NodeServerRequest) handleGetTransactionProof() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetTransactionProof
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	block, err := s.Node.NodeBC.GetBlock(payload.BlockHash)
+
+	if err != nil {
+		return err
+	}
+
+	result := nodeclient.ComTransactionProof{}
+
+	result.TXData, result.Proof, result.MerkleRoot, err = block.GetTransactionProof(payload.TXID)
+
+	if err != nil {
+		return err
+	}
+
+	if block.Version > 0 {
+		// old blocks have no Merkle root in the header
+		result.Header = block.GetHeader().Serialize()
+	}
+	result.Height = block.Height
+
+	s.Response, err = net.GobEncode(result)
+
+	if err != nil {
+		return err
+	}
+	s.Logger.Trace.Printf("Return proof for %x in block %x\n", payload.TXID, payload.BlockHash)
+	return nil
+}
+
 // Balance for address. Complex balance
 func (s *NodeServerRequest) handleGetBalance() error {
 	s.HasResponse = true
@@ -167,7 +218,7 @@ func (s *NodeServerRequest) handleGetBalance() error {
 	if err != nil {
 		return err
 	}
-	s.Logger.Trace.Printf("Return balance for %s. %.8f, %.8f, %.8f", payload.Address, balance.Total, balance.Approved, balance.Pending)
+	s.Logger.Trace.Printf("Return balance for %s. %d, %d, %d", payload.Address, balance.Total, balance.Approved, balance.Pending)
 	return nil
 }
 
@@ -258,7 +309,7 @@ func (s *NodeServerRequest) handleTxRequest() error {
 	result := nodeclient.ComRequestTransactionData{}
 
 	TXBytes, DataToSign, err := s.Node.GetTransactionsManager().
-		PrepareNewTransaction(payload.PubKey, payload.To, payload.Amount)
+		PrepareNewTransaction(payload.PubKey, payload.To, payload.Amount, payload.Fee)
 
 	if err != nil {
 		return err
@@ -314,6 +365,7 @@ func (s *NodeServerRequest) handleGetFirstBlocks() error {
 }
 
 // Received the lst of nodes from some other node. add missed nodes to own nodes list
+// Short lists are announcements of new nodes. They are relayed to few other nodes
 
 func (s *NodeServerRequest) handleAddr() error {
 	var payload []net.NodeAddr
@@ -322,30 +374,80 @@ func (s *NodeServerRequest) handleAddr() error {
 	if err != nil {
 		return err
 	}
-	addednodes := []net.NodeAddr{}
 
-	s.Logger.Trace.Printf("SessID: %s . Received nodes %s", s.SessID, payload)
-
-	for _, node := range payload {
-		s.Logger.Trace.Printf("SessID: %s . node %s", s.SessID, node.NodeAddrToString())
-		if s.S.Node.NodeNet.AddNodeToKnown(node) {
-			addednodes = append(addednodes, node)
-			s.Logger.Trace.Printf("SessID: %s . node appended %s", s.SessID, node.NodeAddrToString())
-		}
+	if len(payload) > net.AddrGossipMax {
+		s.misbehaving(config.PeerScoreMalformedData, "too many addresses")
+		payload = payload[:net.AddrGossipMax]
 	}
 
-	s.Logger.Trace.Printf("SessID: %s . There are %d known nodes now!", s.SessID, len(s.Node.NodeNet.Nodes))
-	s.Logger.Trace.Printf("SessID: %s . Send version to %d new nodes", s.SessID, len(addednodes))
+	s.Logger.Trace.Printf("SessID: %s . Received %d nodes", s.SessID, len(payload))
+
+	addednodes := s.Node.AddAddresses(payload, s.RequestIP)
+
+	s.Logger.Trace.Printf("SessID: %s . There are %d known nodes now!", s.SessID, s.Node.NodeNet.GetCountOfKnownNodes())
+
+	if len(addednodes) > 0 && len(addednodes) <= config.AddrRelayMax {
+		s.Logger.Trace.Printf("SessID: %s . Send version to %d new nodes", s.SessID, len(addednodes))
 
-	if len(addednodes) > 0 {
 		// send own version to all new found nodes. maybe they have some more blocks
 		// and they will add me to known nodes after this
 		s.Node.SendVersionToNodes(addednodes)
+
+		s.relayAddresses(addednodes)
 	}
 
 	return nil
 }
 
+// Sends new addresses to few random nodes. A node which sent them is skipped
+func (s *NodeServerRequest) relayAddresses(addrs []net.NodeAddr) {
+	relayed := 0
+
+	for _, node := range s.Node.NodeNet.SelectOutbound(config.MaxOutboundNodes, s.Node.NodeClient.NodeAddress) {
+		if relayed >= config.AddrRelayNodes {
+			break
+		}
+
+		if node.Host == s.RequestIP || !s.Node.NodeClient.PeerSupports(node, net.ServiceAddrGossip) {
+			continue
+		}
+
+		reachable := []net.NodeAddr{}
+
+		for _, addr := range addrs {
+			if net.IsReachableFrom(addr.Host, node.Host) {
+				reachable = append(reachable, addr)
+			}
+		}
+
+		if len(reachable) == 0 {
+			continue
+		}
+
+		if s.Node.NodeClient.SendAddrList(node, reachable) == nil {
+			relayed++
+		}
+	}
+}
+
+// Returns random good addresses of other nodes. Is used by nodes to fill address books
+func (s *NodeServerRequest) handleGetAddr() error {
+	s.HasResponse = true
+
+	nodes := s.Node.NodeNet.GetAddressesForGossip(net.AddrGossipMax, s.RequestIP)
+
+	s.Logger.Trace.Printf("Return %d addresses\n", len(nodes))
+
+	var err error
+
+	s.Response, err = net.GobEncode(&nodes)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Block received from other node
 func (s *NodeServerRequest) handleBlock() error {
 	var payload nodeclient.ComBlock
@@ -355,31 +457,225 @@ func (s *NodeServerRequest) handleBlock() error {
 		return err
 	}
 
-	blockstate, addstate, block, err := s.Node.ReceivedFullBlockFromOtherNode(payload.Block)
+	return s.processBlock(payload.AddrFrom, payload.Block)
+}
+
+// Compact block received from other node. Full block is built from unapproved transactions
+// and transactions requested from the node
+func (s *NodeServerRequest) handleCompactBlock() error {
+	var payload nodeclient.ComBlock
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	cb := &structures.CompactBlock{}
+	err = cb.DeserializeBlock(payload.Block)
+
+	if err != nil {
+		s.misbehaving(config.PeerScoreMalformedData, "malformed compact block")
+		return err
+	}
+
+	blockstate, err := s.Node.NodeBC.CheckBlockState(cb.Hash, cb.Header.PrevBlockHash)
+
+	if err != nil {
+		return err
+	}
+
+	if blockstate != 0 {
+		s.Logger.Trace.Printf("Compact block %x is not needed. State is %d", cb.Hash, blockstate)
+		return s.continueBlocksLoading(payload.AddrFrom)
+	}
+
+	block, err := s.rebuildCompactBlock(payload.AddrFrom, cb)
+
+	if err != nil {
+		return err
+	}
+
+	blockdata, err := block.Serialize()
+
+	if err != nil {
+		return err
+	}
+
+	return s.processBlock(payload.AddrFrom, blockdata)
+}
+
+// Builds full block from a compact block. If built block doesn't match the header (it can be
+// because of short IDs collision with unapproved transactions) full block is requested
+func (s *NodeServerRequest) rebuildCompactBlock(addr net.NodeAddr, cb *structures.CompactBlock) (*structures.Block, error) {
+	// compact blocks are sent only for blocks with the Merkle root in a header
+	if cb.Header.Version == 0 || !bytes.Equal(cb.Header.Hash(), cb.Hash) {
+		s.misbehaving(config.PeerScoreMalformedData, "compact block header doesn't match hash")
+		return nil, errors.New("Compact block header doesn't match hash")
+	}
+
+	known, err := s.Node.GetTransactionsManager().GetUnapprovedByShortIDs(cb.Hash, cb.ShortIDs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, missing, err := cb.Rebuild(known)
+
+	if err != nil {
+		s.misbehaving(config.PeerScoreMalformedData, "malformed compact block")
+		return nil, err
+	}
+
+	s.Logger.Trace.Printf("Compact block %x has %d transactions, %d missing", cb.Hash, cb.GetTransactionsCount(), len(missing))
+
+	if len(missing) > 0 {
+		txsdata, err := s.Node.NodeClient.SendGetBlockTransactions(addr, cb.Hash, missing)
+
+		if err != nil {
+			return nil, err
+		}
+
+		txs := []*transaction.Transaction{}
+
+		for _, txdata := range txsdata {
+			tx := &transaction.Transaction{}
+
+			err = tx.DeserializeTransaction(txdata)
+
+			if err != nil {
+				s.misbehaving(config.PeerScoreMalformedData, "malformed block transaction")
+				return nil, err
+			}
+			txs = append(txs, tx)
+		}
+
+		err = cb.FillMissing(block, missing, txs)
+
+		if err != nil {
+			s.misbehaving(config.PeerScoreMalformedData, "wrong block transactions")
+			return nil, err
+		}
+	}
+
+	merkleRoot, err := block.HashTransactions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(merkleRoot, block.MerkleRoot) {
+		return block, nil
+	}
+
+	s.Logger.Trace.Printf("Compact block %x doesn't match Merkle root. Request full block", cb.Hash)
+
+	blockdata, err := s.Node.NodeClient.SendGetBlock(addr, cb.Hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block = &structures.Block{}
+	err = block.DeserializeBlock(blockdata)
+
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Returns transactions of a block requested by a node to complete a compact block
+func (s *NodeServerRequest) handleGetBlockTransactions() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetBlockTransactions
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	block, err := s.Node.NodeBC.GetBlock(payload.BlockHash)
+
+	if err != nil {
+		return err
+	}
+
+	result := [][]byte{}
+
+	for _, index := range payload.Indexes {
+		if index < 0 || index >= len(block.Transactions) {
+			s.misbehaving(config.PeerScoreMalformedData, "wrong transaction index")
+			return errors.New(fmt.Sprintf("Block %x has no transaction %d", payload.BlockHash, index))
+		}
+
+		txser, err := block.Transactions[index].Serialize()
+
+		if err != nil {
+			return err
+		}
+		result = append(result, txser)
+	}
+
+	s.Logger.Trace.Printf("Return %d transactions of block %x", len(result), payload.BlockHash)
+
+	s.Response, err = net.GobEncode(&result)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Adds a block received from other node and continues loading of blocks posted by that node before
+func (s *NodeServerRequest) processBlock(addrfrom net.NodeAddr, blockdata []byte) error {
+	blockstate, addstate, block, err := s.Node.ReceivedFullBlockFromOtherNode(blockdata)
 	s.Logger.Trace.Printf("adding new block %d, %d", blockstate, addstate)
 	// state of this adding we don't check. not interesting in this place
 	if err != nil {
+		if err, ok := err.(*consensus.BlockVerifyError); ok {
+			s.Logger.Trace.Printf("Block %x from %s rejected. Reason %s", err.Block, addrfrom.NodeAddrToString(), err.GetKind())
+
+			if err.GetKind() == consensus.BlockVerifyErrorTimeTooNew {
+				// can be because of wrong clock
+				s.misbehaving(config.PeerScoreFutureBlock, "block from future")
+			} else {
+				s.misbehaving(config.PeerScoreInvalidBlock, "invalid block")
+			}
+		}
 		return err
 	}
 
 	if blockstate == 0 {
 		s.Logger.Trace.Printf("send block to all ")
 		// block was added, now we can send it to all other nodes.
-		s.Node.SendBlockToAll(block, payload.AddrFrom)
+		s.Node.SendBlockToAll(block, addrfrom)
 	}
+	s.Logger.Trace.Printf("check if try to make new %d , %d ", addstate, blockchain.BCBAddState_addedToParallelTop)
+	if addstate == blockchain.BCBAddState_addedToParallelTop {
+		// maybe some transactiosn become unapproved now. try to make new block from them on top of new chain
+		s.S.TryToMakeNewBlock([]byte{1})
+	}
+	return s.continueBlocksLoading(addrfrom)
+}
+
+// Continues loading of blocks posted by a node before. Is called after a block from the node
+// is processed, also if the block was not needed
+func (s *NodeServerRequest) continueBlocksLoading(addrfrom net.NodeAddr) error {
 	// this is the list of hashes some node posted before. If there are yes some data then try to get that blocks.
-	s.Logger.Trace.Printf("check count blocks left %d ", s.S.Transit.GetBlocksCount(payload.AddrFrom))
-	if s.S.Transit.GetBlocksCount(payload.AddrFrom) > 0 {
+	s.Logger.Trace.Printf("check count blocks left %d ", s.S.Transit.GetBlocksCount(addrfrom))
+	if s.S.Transit.GetBlocksCount(addrfrom) > 0 {
 		// get next block. continue to get next block if nothing is sent
 		for {
-			blockdata, err := s.S.Transit.ShiftNextBlock(payload.AddrFrom)
+			nextblock, err := s.S.Transit.ShiftNextBlock(addrfrom)
 
 			if err != nil {
 				s.Logger.Trace.Printf("Request new block failed %s ", err.Error())
 				return err
 			}
 
-			blockstate, err := s.Node.ReceivedBlockFromOtherNode(payload.AddrFrom, blockdata)
+			blockstate, err := s.Node.ReceivedBlockFromOtherNode(addrfrom, nextblock)
 
 			if err != nil {
 				return err
@@ -392,30 +688,25 @@ func (s *NodeServerRequest) handleBlock() error {
 
 			if blockstate == 2 {
 				// previous block is not in the blockchain. no sense to check next blocks in this list
-				s.S.Transit.CleanBlocks(payload.AddrFrom)
+				s.S.Transit.CleanBlocks(addrfrom)
 
 				// request from a node blocks down to this first block
 				bs := &structures.BlockShort{}
-				err := bs.DeserializeBlock(blockdata)
+				err := bs.DeserializeBlock(nextblock)
 
 				if err != nil {
 					return err
 				}
 				// get blocks down stargin from previous for the first in given list
-				s.Node.NodeClient.SendGetBlocks(payload.AddrFrom, bs.PrevBlockHash)
+				s.Node.NodeClient.SendGetBlocks(addrfrom, bs.PrevBlockHash)
 			}
 
-			if s.S.Transit.GetBlocksCount(payload.AddrFrom) == 0 {
+			if s.S.Transit.GetBlocksCount(addrfrom) == 0 {
 				break
 			}
 		}
 	}
-	s.Logger.Trace.Printf("check if try to make new %d , %d ", addstate, blockchain.BCBAddState_addedToParallelTop)
-	if addstate == blockchain.BCBAddState_addedToParallelTop {
-		// maybe some transactiosn become unapproved now. try to make new block from them on top of new chain
-		s.S.TryToMakeNewBlock([]byte{1})
-	}
-	s.Node.CheckAddressKnown(payload.AddrFrom)
+	s.Node.CheckAddressKnown(addrfrom)
 
 	return nil
 }
@@ -436,6 +727,13 @@ func (s *NodeServerRequest) handleInv() error {
 
 	s.Logger.Trace.Printf("SessID: %s . Recevied inventory with %d %s\n", s.SessID, len(payload.Items), payload.Type)
 
+	if len(payload.Items) == 0 || len(payload.Items) > nodeclient.InvMaxItems ||
+		(payload.Type != "block" && payload.Type != "tx") {
+		s.misbehaving(config.PeerScoreMalformedData, "wrong inventory")
+
+		return errors.New(fmt.Sprintf("Wrong inventory with %d %s", len(payload.Items), payload.Type))
+	}
+
 	if payload.Type == "block" {
 
 		s.S.Transit.AddBlocks(payload.AddrFrom, payload.Items)
@@ -482,13 +780,26 @@ func (s *NodeServerRequest) handleInv() error {
 	}
 
 	if payload.Type == "tx" {
-		txID := payload.Items[0]
+		inventory := s.Node.NodeClient.Inventory
+
+		for _, txID := range payload.Items {
+			if inventory != nil {
+				// don't announce it back
+				inventory.AddKnown(payload.AddrFrom, txID)
+			}
 
-		s.Logger.Trace.Printf("Check if TX exists %x\n", txID)
+			s.Logger.Trace.Printf("Check if TX exists %x\n", txID)
 
-		tx, err := s.Node.GetTransactionsManager().GetIfExists(txID)
+			tx, err := s.Node.GetTransactionsManager().GetIfExists(txID)
 
-		if tx == nil && err == nil {
+			if tx != nil || err != nil {
+				continue
+			}
+
+			if inventory != nil && !inventory.Request(txID) {
+				// other node was asked for it already
+				continue
+			}
 			// not exists
 			s.Logger.Trace.Printf("Not exist. Request it\n")
 			s.Node.NodeClient.SendGetData(payload.AddrFrom, "tx", txID)
@@ -571,6 +882,95 @@ func (s *NodeServerRequest) handleGetBlocksUpper() error {
 	return s.Node.NodeClient.SendInv(payload.AddrFrom, "block", data)
 }
 
+/*
+* Request for headers of main chain after a common block. It is used by nodes on header-first sync
+ */
+func (s *NodeServerRequest) handleGetHeaders() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetHeaders
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	maxcount := payload.MaxCount
+
+	if maxcount <= 0 || maxcount > config.SyncHeadersBatchSize {
+		maxcount = config.SyncHeadersBatchSize
+	}
+
+	bcm := s.Node.NodeBC.GetBCManager()
+
+	startHash, err := bcm.FindLocatorInChain(payload.Locator)
+
+	if err != nil {
+		return err
+	}
+
+	headers, err := bcm.GetHeadersAfter(startHash, maxcount)
+
+	if err != nil {
+		return err
+	}
+
+	result := [][]byte{}
+
+	for _, h := range headers {
+		hdata, err := h.Serialize()
+
+		if err != nil {
+			return err
+		}
+		result = append(result, hdata)
+	}
+
+	s.Response, err = net.GobEncode(result)
+
+	if err != nil {
+		return err
+	}
+
+	s.Logger.Trace.Printf("Return %d headers after %x", len(result), startHash)
+	return nil
+}
+
+/*
+* Return full block data as a response. It is used by nodes on header-first sync
+ */
+func (s *NodeServerRequest) handleGetBlock() error {
+	s.HasResponse = true
+
+	var payload nodeclient.ComGetData
+
+	err := s.parseRequestData(&payload)
+
+	if err != nil {
+		return err
+	}
+
+	block, err := s.Node.NodeBC.GetBlock(payload.ID)
+
+	if err != nil {
+		return err
+	}
+
+	bs, err := block.Serialize()
+
+	if err != nil {
+		return err
+	}
+
+	s.Response, err = net.GobEncode(bs)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 /*
 * Response on request to get full body of a block or transaction
  */
@@ -592,10 +992,30 @@ func (s *NodeServerRequest) handleGetData() error {
 			return err
 		}
 
-		bs, err := block.Serialize()
+		info, ok := s.Node.NodeClient.Peers.Get(payload.AddrFrom)
+
+		// older blocks are requested when a node loads a chain. it doesn't have their transactions
+		topHash, _ := s.Node.NodeBC.GetTopBlockHash()
+
+		if ok && info.HasService(net.ServiceCompactBlocks) && block.Version > 0 && bytes.Equal(topHash, block.Hash) {
+			// new top block. the node has most of transactions in unapproved pool. send only short IDs of them
+			cb, err := structures.NewCompactBlock(block)
+
+			if err != nil {
+				return err
+			}
+
+			cbs, err := cb.Serialize()
 
-		if err == nil {
-			s.Node.NodeClient.SendBlock(payload.AddrFrom, bs)
+			if err == nil {
+				s.Node.NodeClient.SendCompactBlock(payload.AddrFrom, cbs)
+			}
+		} else {
+			bs, err := block.Serialize()
+
+			if err == nil {
+				s.Node.NodeClient.SendBlock(payload.AddrFrom, bs)
+			}
 		}
 
 	}
@@ -614,6 +1034,10 @@ func (s *NodeServerRequest) handleGetData() error {
 
 			s.Node.NodeClient.SendTx(payload.AddrFrom, txser)
 
+			if s.Node.NodeClient.Inventory != nil {
+				s.Node.NodeClient.Inventory.AddKnown(payload.AddrFrom, payload.ID)
+			}
+
 		}
 	}
 
@@ -642,9 +1066,16 @@ func (s *NodeServerRequest) handleTx() error {
 	err = tx.DeserializeTransaction(txData)
 
 	if err != nil {
+		s.misbehaving(config.PeerScoreMalformedData, "malformed transaction")
+
 		return err
 	}
 
+	if s.Node.NodeClient.Inventory != nil {
+		s.Node.NodeClient.Inventory.AddKnown(payload.AddFrom, tx.ID)
+		s.Node.NodeClient.Inventory.Received(tx.ID)
+	}
+
 	if txe, err := s.Node.GetTransactionsManager().GetIfExists(tx.ID); err == nil && txe != nil {
 		s.Logger.Trace.Printf("Received transaction. It already exists: %x ", tx.ID)
 		// exists , nothing to do, it was already processed before
@@ -661,6 +1092,13 @@ func (s *NodeServerRequest) handleTx() error {
 		if err, ok := err.(*transactions.TXVerifyError); ok {
 			s.Logger.Trace.Println("Custom errro of kind ", err.GetKind())
 
+			if err.GetKind() == transactions.TXVerifyErrorSignature {
+				s.misbehaving(config.PeerScoreBadSignature, "bad transaction signature")
+
+			} else if err.GetKind() != transactions.TXVerifyErrorNoInput {
+				s.misbehaving(config.PeerScoreInvalidTransaction, "invalid transaction")
+			}
+
 			if err.GetKind() == transactions.TXVerifyErrorNoInput {
 				/*
 					* we will not do somethign in this case. If no base TX that is not yet approved we wil ignore it
@@ -692,7 +1130,8 @@ func (s *NodeServerRequest) handleTx() error {
 }
 
 /*
-* Process version command. Other node sends own address and index of top block.
+* Process version command. Other node sends own address, index of top block and supported features.
+* Node with other genesis block or not compatible protocol version is refused.
 * This node checks if index is bogger then request for a rest of blocks. If index is less
 * then sends own version command and that node will request for blocks
  */
@@ -711,16 +1150,74 @@ func (s *NodeServerRequest) handleVersion() error {
 		return err
 	}
 
-	if payload.AddrFrom.Host == "localhost" {
+	genesisHash, err := s.Node.NodeBC.GetBCManager().GetGenesisBlockHash()
+
+	if err != nil {
+		return err
+	}
+
+	// node doesn't know own address or advertises address we can not connect to
+	if payload.AddrFrom.Host == "localhost" ||
+		!net.IsReachableFrom(payload.AddrFrom.Host, s.RequestIP) && net.IsRoutable(s.RequestIP) {
 		payload.AddrFrom.Host = s.RequestIP
 	}
 
-	s.Logger.Trace.Printf("Received version from %s. Their heigh %d, our heigh %d\n",
-		payload.AddrFrom.NodeAddrToString(), payload.BestHeight, myBestHeight)
+	s.Logger.Trace.Printf("Received version %d from %s (%s, services %d). Their heigh %d, our heigh %d\n",
+		payload.Version, payload.AddrFrom.NodeAddrToString(), payload.UserAgent, payload.Services,
+		payload.BestHeight, myBestHeight)
+
+	err = s.checkPeerVersion(&payload, genesisHash)
+
+	if err != nil {
+		// don't talk to this node anymore
+		s.S.Node.NodeNet.RemoveNodeFromKnown(payload.AddrFrom)
+
+		if s.Node.NodeClient.Peers != nil {
+			s.Node.NodeClient.Peers.Remove(payload.AddrFrom)
+		}
+
+		if s.Node.NodeClient.Inventory != nil {
+			s.Node.NodeClient.Inventory.Remove(payload.AddrFrom)
+		}
+		return err
+	}
+
+	// the node tells how it sees us
+	s.S.addObservedHost(payload.AddrRecv.Host, s.RequestIP)
+
+	knownBefore := true
+
+	if s.Node.NodeClient.Peers != nil {
+		knownBefore = s.Node.NodeClient.Peers.Set(payload.AddrFrom, nodeclient.PeerInfo{
+			Version:   payload.Version,
+			Services:  payload.Services,
+			UserAgent: payload.UserAgent})
+	}
 
 	foreignerBestHeight := payload.BestHeight
 
-	if myBestHeight < foreignerBestHeight {
+	if !knownBefore && myBestHeight <= foreignerBestHeight {
+		// other node must know our features too. it will not answer again as it knows us already
+		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight, genesisHash)
+	}
+
+	if !knownBefore && payload.Services&net.ServiceAddrGossip != 0 &&
+		s.Node.NodeNet.GetCountOfKnownNodes() < config.AddrBookMinSize {
+		// new node can tell us about more nodes
+		s.S.requestAddresses(s.Node, payload.AddrFrom)
+	}
+
+	if foreignerBestHeight-myBestHeight > config.SyncMinHeightDifference &&
+		s.Node.NodeClient.PeerSupports(payload.AddrFrom, net.ServiceHeaders) {
+		// we are far behind. load headers first and then blocks from all nodes
+		s.Logger.Trace.Printf("Start blockchain sync. %s is far ahead\n", payload.AddrFrom.NodeAddrToString())
+
+		s.S.Node.CheckAddressKnown(payload.AddrFrom)
+		s.S.StartBlockchainSync()
+
+		return nil
+
+	} else if myBestHeight < foreignerBestHeight {
 		s.Logger.Trace.Printf("Request blocks from %s\n", payload.AddrFrom.NodeAddrToString())
 
 		if foreignerBestHeight > s.S.Transit.MaxKnownHeigh {
@@ -732,7 +1229,7 @@ func (s *NodeServerRequest) handleVersion() error {
 	} else if myBestHeight > foreignerBestHeight {
 		s.Logger.Trace.Printf("Send my version back to %s\n", payload.AddrFrom.NodeAddrToString())
 
-		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight)
+		s.Node.NodeClient.SendVersion(payload.AddrFrom, myBestHeight, genesisHash)
 	} else {
 		s.Logger.Trace.Printf("Teir blockchain is same as my for %s\n", payload.AddrFrom.NodeAddrToString())
 	}
@@ -742,6 +1239,25 @@ func (s *NodeServerRequest) handleVersion() error {
 	return nil
 }
 
+// Checks other node can work with this node. Nodes of version 1 don't send genesis hash and services
+func (s *NodeServerRequest) checkPeerVersion(payload *nodeclient.ComVersion, genesisHash []byte) error {
+	if payload.Version < net.MinNodeVersion {
+		return errors.New(fmt.Sprintf("Version %d of %s is not supported. Minimum is %d",
+			payload.Version, payload.AddrFrom.NodeAddrToString(), net.MinNodeVersion))
+	}
+
+	if payload.Version == 1 {
+		payload.Services = net.LegacyNodeServices
+		return nil
+	}
+
+	if !bytes.Equal(payload.GenesisHash, genesisHash) {
+		return errors.New(fmt.Sprintf("Node %s has other genesis block %x",
+			payload.AddrFrom.NodeAddrToString(), payload.GenesisHash))
+	}
+	return nil
+}
+
 // Returns list of nodes from contacts on this node
 
 func (s *NodeServerRequest) handleGetNodes() error {
@@ -761,6 +1277,26 @@ func (s *NodeServerRequest) handleGetNodes() error {
 	return nil
 }
 
+// Returns list of nodes with scores and bans
+func (s *NodeServerRequest) handleGetNodesInfo() error {
+	if !s.NodeAuthStrIsGood {
+		return errors.New("Local Network Auth is required")
+	}
+
+	s.HasResponse = true
+
+	info := s.S.Node.GetNodesInfo(s.S.scores.getBans(), s.S.scores.getScores())
+
+	var err error
+
+	s.Response, err = net.GobEncode(&info)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // Add new node to list of nodes
 func (s *NodeServerRequest) handleAddNode() error {
 	if !s.NodeAuthStrIsGood {
@@ -803,7 +1339,7 @@ func (s *NodeServerRequest) handleRemoveNode() error {
 	s.S.Node.NodeNet.RemoveNodeFromKnown(payload.Node)
 
 	s.Logger.Trace.Printf("Removed node %s\n", payload.Node.NodeAddrToString())
-	s.Logger.Trace.Println(s.S.Node.NodeNet.Nodes)
+	s.Logger.Trace.Printf("There are %d known nodes now\n", s.S.Node.NodeNet.GetCountOfKnownNodes())
 
 	s.Response = []byte{}
 
@@ -825,6 +1361,7 @@ func (s *NodeServerRequest) handleGetState() error {
 	}
 
 	info.ExpectingBlocksHeight = s.S.Transit.MaxKnownHeigh
+	info.Host = s.S.GetNodeAddress().NodeAddrToString()
 
 	s.Response, err = net.GobEncode(&info)
 
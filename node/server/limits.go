@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/node/config"
+)
+
+// Max size of a payload of a command. Other commands can not be bigger than config.MaxRequestPayload
+var commandPayloadLimits = map[string]int{
+	"block":       config.MaxBlockPayload,
+	"cmpctblock":  config.MaxBlockPayload,
+	"getblocktxn": config.MaxListPayload,
+	"tx":          config.MaxTransactionPayload,
+	"txfull":      config.MaxTransactionPayload,
+	"txdata":      config.MaxTransactionPayload,
+	"addr":        config.MaxListPayload,
+	"inv":         config.MaxListPayload,
+}
+
+func getCommandPayloadLimit(command string) int {
+	if limit, ok := commandPayloadLimits[command]; ok {
+		return limit
+	}
+	return config.MaxRequestPayload
+}
+
+// Max size of a session frame. It contains a request of any command
+func getSessionFrameLimit() int {
+	max := config.MaxRequestPayload
+
+	for _, limit := range commandPayloadLimits {
+		if limit > max {
+			max = limit
+		}
+	}
+	return net.CommandLength + 8 + max + config.MaxRequestExtraData
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/nodemanager"
+)
+
+// Reputation of other nodes and wallets. Hosts are identified by IP
+// Misbehaviour adds points to a score. Host with too big score is banned for some time
+// Bans are saved in the nodes DB and are loaded on start. Expired bans are removed from the DB
+// by the address gossip routine
+type peerScores struct {
+	banTime int64 // seconds
+
+	lock  sync.Mutex
+	peers map[string]*peerScore
+	bans  map[string]int64 // host -> unix time when a ban ends
+}
+
+// Hosts without score are forgotten when there are more hosts than this. If it is not enough
+// hosts with the lowest scores are forgotten till the list is smaller by peerScoresEvictHosts
+const peerScoresMaxHosts = 10000
+const peerScoresEvictHosts = 1000
+
+type peerScore struct {
+	score   int
+	updated time.Time
+
+	// requests rate limit. token bucket
+	tokens       float64
+	tokensFilled time.Time
+}
+
+func newPeerScores(banTime int) *peerScores {
+	p := &peerScores{}
+
+	if banTime < 1 {
+		banTime = config.DefaultBanTime
+	}
+	p.banTime = int64(banTime)
+	p.peers = make(map[string]*peerScore)
+	p.bans = make(map[string]int64)
+
+	return p
+}
+
+// Loads bans from the DB. Expired bans are removed
+func (p *peerScores) loadBans(storage nodemanager.NodesListStorage) error {
+	bans, err := storage.GetBans()
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for host, until := range bans {
+		if until <= now {
+			storage.RemoveBan(host)
+			continue
+		}
+		p.bans[host] = until
+	}
+	return nil
+}
+
+// Checks if a host is banned now
+func (p *peerScores) isBanned(host string) bool {
+	return p.getBanEnd(host) > 0
+}
+
+// Returns unix time when a ban of a host ends. 0 if the host is not banned
+func (p *peerScores) getBanEnd(host string) int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	until, ok := p.bans[host]
+
+	if !ok {
+		return 0
+	}
+
+	if until <= time.Now().Unix() {
+		// the ban is removed from the map with removeExpiredBans to remove it from the DB too
+		return 0
+	}
+	return until
+}
+
+// Removes expired bans. Returns hosts which were unbanned, the caller removes them from the DB
+func (p *peerScores) removeExpiredBans() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now().Unix()
+	hosts := []string{}
+
+	for host, until := range p.bans {
+		if until <= now {
+			delete(p.bans, host)
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Returns current score of a host
+func (p *peerScores) getScore(host string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.getPeer(host).score
+}
+
+// Returns all current bans
+func (p *peerScores) getBans() map[string]int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now().Unix()
+	bans := map[string]int64{}
+
+	for host, until := range p.bans {
+		if until > now {
+			bans[host] = until
+		}
+	}
+	return bans
+}
+
+// Returns not zero scores of all hosts
+func (p *peerScores) getScores() map[string]int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	scores := map[string]int{}
+
+	for host := range p.peers {
+		score := p.getPeer(host).score
+
+		if score > 0 {
+			scores[host] = score
+		}
+	}
+	return scores
+}
+
+// Adds points to a score of a host. Returns time when a ban ends if the host is banned now
+// The ban must be saved by a caller
+func (p *peerScores) misbehaving(host string, score int) int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	peer := p.getPeer(host)
+	peer.score += score
+
+	if peer.score < config.PeerBanScore {
+		return 0
+	}
+
+	now := time.Now().Unix()
+
+	if until, ok := p.bans[host]; ok && until > now {
+		// already banned
+		return 0
+	}
+
+	until := now + p.banTime
+
+	p.bans[host] = until
+	delete(p.peers, host)
+
+	return until
+}
+
+// Checks if a host can send one more request now
+func (p *peerScores) allowRequest(host string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	peer := p.getPeer(host)
+
+	now := time.Now()
+
+	peer.tokens += now.Sub(peer.tokensFilled).Seconds() * config.PeerRequestsPerSecond
+	peer.tokensFilled = now
+
+	if peer.tokens > config.PeerRequestsBurst {
+		peer.tokens = config.PeerRequestsBurst
+	}
+
+	if peer.tokens < 1 {
+		return false
+	}
+	peer.tokens--
+
+	return true
+}
+
+// Returns a state of a host. Score is decreased by time passed since last update
+// Must be called under the lock
+func (p *peerScores) getPeer(host string) *peerScore {
+	now := time.Now()
+
+	peer, ok := p.peers[host]
+
+	if !ok {
+		if len(p.peers) >= peerScoresMaxHosts {
+			p.forgetIdle(now)
+		}
+		peer = &peerScore{}
+		peer.updated = now
+		peer.tokens = config.PeerRequestsBurst
+		peer.tokensFilled = now
+
+		p.peers[host] = peer
+
+		return peer
+	}
+
+	peer.decay(now)
+
+	return peer
+}
+
+// Decreases a score by time passed since last update
+func (peer *peerScore) decay(now time.Time) {
+	decay := int(now.Sub(peer.updated) / (config.PeerScoreDecayTime * time.Second))
+
+	if decay <= 0 {
+		return
+	}
+	peer.score -= decay
+
+	if peer.score < 0 {
+		peer.score = 0
+	}
+	peer.updated = peer.updated.Add(time.Duration(decay) * config.PeerScoreDecayTime * time.Second)
+}
+
+// Removes hosts which have no score and didn't send requests recently. If the list is still full
+// hosts with the lowest scores are removed, so the list doesn't grow when many hosts misbehave
+// Must be called under the lock
+func (p *peerScores) forgetIdle(now time.Time) {
+	idleTime := time.Duration(config.PeerRequestsBurst/config.PeerRequestsPerSecond) * time.Second
+
+	for host, peer := range p.peers {
+		peer.decay(now)
+
+		if peer.score == 0 && now.Sub(peer.tokensFilled) > idleTime {
+			delete(p.peers, host)
+		}
+	}
+
+	if len(p.peers) < peerScoresMaxHosts {
+		return
+	}
+
+	hosts := make([]string, 0, len(p.peers))
+
+	for host := range p.peers {
+		hosts = append(hosts, host)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		a := p.peers[hosts[i]]
+		b := p.peers[hosts[j]]
+
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		return a.updated.Before(b.updated)
+	})
+
+	count := len(hosts) - peerScoresMaxHosts + peerScoresEvictHosts
+
+	for _, host := range hosts[:count] {
+		delete(p.peers, host)
+	}
+}
+
+// Checks if a host is this machine. Such hosts are not scored
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
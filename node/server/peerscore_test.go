@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NlaakStudios/democoin/node/config"
+)
+
+func TestPeerScoreBan(t *testing.T) {
+	p := newPeerScores(0)
+
+	host := "10.0.0.1"
+
+	if p.misbehaving(host, config.PeerScoreInvalidBlock) != 0 {
+		t.Fatalf("Host is banned for one invalid block")
+	}
+
+	if p.getScore(host) != config.PeerScoreInvalidBlock {
+		t.Fatalf("Expected score %d, got %d", config.PeerScoreInvalidBlock, p.getScore(host))
+	}
+
+	until := p.misbehaving(host, config.PeerBanScore-config.PeerScoreInvalidBlock)
+
+	if until == 0 || !p.isBanned(host) {
+		t.Fatalf("Host is not banned when the score reaches the ban score")
+	}
+
+	if p.misbehaving(host, config.PeerBanScore) != 0 {
+		t.Fatalf("Banned host is banned again")
+	}
+}
+
+func TestPeerScoreDecay(t *testing.T) {
+	p := newPeerScores(0)
+
+	host := "10.0.0.2"
+
+	p.misbehaving(host, 10)
+
+	p.peers[host].updated = time.Now().Add(-3 * config.PeerScoreDecayTime * time.Second)
+
+	if p.getScore(host) != 7 {
+		t.Fatalf("Expected score 7 after decay, got %d", p.getScore(host))
+	}
+
+	p.peers[host].updated = time.Now().Add(-20 * config.PeerScoreDecayTime * time.Second)
+
+	if p.getScore(host) != 0 {
+		t.Fatalf("Score must not be below 0, got %d", p.getScore(host))
+	}
+
+	if _, ok := p.getScores()[host]; ok {
+		t.Fatalf("Host with zero score is in the list of scores")
+	}
+}
+
+func TestPeerScoresLimit(t *testing.T) {
+	p := newPeerScores(0)
+
+	// all hosts have score and sent requests now, so nothing is idle
+	for i := 0; i < peerScoresMaxHosts+10; i++ {
+		p.misbehaving("10.1."+strconv.Itoa(i/256)+"."+strconv.Itoa(i%256), 1+i%5)
+	}
+
+	if len(p.peers) > peerScoresMaxHosts {
+		t.Fatalf("Expected not more than %d hosts, got %d", peerScoresMaxHosts, len(p.peers))
+	}
+
+	p.misbehaving("10.2.0.1", 50)
+
+	if p.getScore("10.2.0.1") != 50 {
+		t.Fatalf("Host with high score is forgotten")
+	}
+}
+
+func TestPeerScoresExpiredBans(t *testing.T) {
+	p := newPeerScores(0)
+
+	now := time.Now().Unix()
+
+	p.bans["10.0.0.3"] = now - 1
+	p.bans["10.0.0.4"] = now + 100
+
+	if p.isBanned("10.0.0.3") {
+		t.Fatalf("Host with expired ban is banned")
+	}
+
+	if p.misbehaving("10.0.0.3", config.PeerBanScore) == 0 {
+		t.Fatalf("Host with expired ban is not banned again")
+	}
+
+	p.bans["10.0.0.3"] = now - 1
+
+	removed := p.removeExpiredBans()
+
+	if len(removed) != 1 || removed[0] != "10.0.0.3" {
+		t.Fatalf("Expected one expired ban, got %v", removed)
+	}
+
+	if _, ok := p.bans["10.0.0.3"]; ok {
+		t.Fatalf("Expired ban is not removed")
+	}
+
+	if !p.isBanned("10.0.0.4") {
+		t.Fatalf("Active ban is removed")
+	}
+}
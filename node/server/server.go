@@ -8,11 +8,14 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	netlib "github.com/NlaakStudios/democoin/lib/net"
 	"github.com/NlaakStudios/democoin/lib/nodeclient"
 	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/config"
 	"github.com/NlaakStudios/democoin/node/nodemanager"
 )
 
@@ -20,7 +23,12 @@ type NodeServer struct {
 	DataDir string
 	Node    *nodemanager.Node
 
+	// address advertised to other nodes and interface to listen on
 	NodeAddress netlib.NodeAddr
+	BindAddress netlib.NodeAddr
+	addrLock    sync.Mutex
+	// address of this node reported by other nodes
+	external *externalAddress
 
 	Transit nodeTransit
 
@@ -31,6 +39,12 @@ type NodeServer struct {
 	BlockBilderChan     chan []byte
 
 	NodeAuthStr string
+
+	// 1 when header-first sync of the blockchain is in progress
+	syncRunning int32
+
+	// scores and bans of other nodes
+	scores *peerScores
 }
 
 func (s *NodeServer) GetClient() *nodeclient.NodeClient {
@@ -39,21 +53,71 @@ func (s *NodeServer) GetClient() *nodeclient.NodeClient {
 }
 
 // handle received data. It can be one way command or a request for some data
+// If a client opens a session then the connection is used for many commands
 
 func (s *NodeServer) handleConnection(conn net.Conn) {
-	starttime := time.Now().UnixNano()
-	sessid := utils.RandString(5)
+	if s.scores != nil && s.scores.isBanned(s.getRemoteIP(conn)) {
+		s.Logger.Trace.Println("Connection from banned host ", s.getRemoteIP(conn))
+		conn.Close()
+		return
+	}
 
-	//s.Logger.Trace.Printf("New command. Start reading %s", sessid)
+	if s.Node.NodeClient.Transport != nil {
+		// encrypted connection or plain if it is allowed
+		tconn, err := s.Node.NodeClient.Transport.Accept(conn)
+
+		if err != nil {
+			s.Logger.Trace.Println("Connection is not accepted: ", err.Error())
+			conn.Close()
+			return
+		}
+		conn = tconn
+	}
 
 	command, request, authstring, err := s.readRequest(conn)
 
 	if err != nil {
-		s.sendErrorBack(conn, errors.New("Network Data Reading Error: "+err.Error()))
+		s.Logger.Trace.Println("Network Data Reading Error: ", err.Error())
+		s.sendErrorBack(conn, err)
 		conn.Close()
 		return
 	}
 
+	if command == netlib.SessionCommand {
+		s.handleSession(conn, request)
+		return
+	}
+
+	response := s.handleCommand(command, request, authstring, s.getRemoteIP(conn))
+
+	if len(response) > 0 {
+		s.Logger.Trace.Printf("Responding %d bytes\n", len(response))
+
+		_, err := conn.Write(response)
+
+		if err != nil {
+			s.Logger.Error.Println("Sending response error: ", err.Error())
+		}
+	}
+
+	conn.Close()
+}
+
+// Returns IP address of other side of a connection
+func (s *NodeServer) getRemoteIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// Executes a command. Returns data to send back to a client. It is empty if the command has no response
+// First byte of a response is 1 if the command was success or 0 if it is error message
+
+func (s *NodeServer) handleCommand(command string, request []byte, authstring string, requestIP string) []byte {
+	starttime := time.Now().UnixNano()
+	sessid := utils.RandString(5)
+
 	s.Logger.Trace.Printf("Received %s command, %s, old sess %s", command, sessid, s.Node.SessionID)
 
 	requestobj := NodeServerRequest{}
@@ -65,19 +129,27 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	requestobj.S = s
 	requestobj.S.Node.SessionID = sessid
 	requestobj.SessID = sessid
+	requestobj.RequestIP = requestIP
 
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
-		requestobj.RequestIP = addr.IP.String()
-	}
 	request = nil
 
+	if s.scores != nil && !isLoopbackHost(requestIP) {
+		if s.scores.isBanned(requestIP) {
+			return s.getErrorResponse(netlib.NewRequestError("The host is banned", netlib.RequestErrorBanned))
+		}
+
+		if !s.scores.allowRequest(requestIP) {
+			requestobj.misbehaving(config.PeerScoreFlood, "too many requests")
+
+			return s.getErrorResponse(netlib.NewRequestError("Too many requests", netlib.RequestErrorRateLimit))
+		}
+	}
+
 	// open blockchain. and close in the end ofthis function
-	err = requestobj.Node.DBConn.OpenConnection("HandleCommand "+command, sessid)
+	err := requestobj.Node.DBConn.OpenConnection("HandleCommand "+command, sessid)
 
 	if err != nil {
-		s.sendErrorBack(conn, errors.New("Blockchain open Error: "+err.Error()))
-		conn.Close()
-		return
+		return s.getErrorResponse(errors.New("Blockchain open Error: " + err.Error()))
 	}
 
 	//s.Logger.Trace.Printf("Nodes Network State: %d , %s", len(requestobj.Node.NodeNet.Nodes), requestobj.Node.NodeNet.Nodes)
@@ -92,6 +164,10 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		s.Logger.Trace.Println("Void command reveived")
 	case "block":
 		rerr = requestobj.handleBlock()
+	case "cmpctblock":
+		rerr = requestobj.handleCompactBlock()
+	case "getblocktxn":
+		rerr = requestobj.handleGetBlockTransactions()
 	case "inv":
 		rerr = requestobj.handleInv()
 	case "getblocks":
@@ -103,6 +179,12 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case "getdata":
 		rerr = requestobj.handleGetData()
 
+	case "getheaders":
+		rerr = requestobj.handleGetHeaders()
+
+	case "getblock":
+		rerr = requestobj.handleGetBlock()
+
 	case "getunspent":
 		rerr = requestobj.handleGetUnspent()
 
@@ -112,6 +194,9 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case "getbalance":
 		rerr = requestobj.handleGetBalance()
 
+	case "gettxproof":
+		rerr = requestobj.handleGetTransactionProof()
+
 	case "getfblocks":
 		rerr = requestobj.handleGetFirstBlocks()
 
@@ -127,9 +212,15 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	case "txrequest":
 		rerr = requestobj.handleTxRequest()
 
+	case "getaddr":
+		rerr = requestobj.handleGetAddr()
+
 	case "getnodes":
 		rerr = requestobj.handleGetNodes()
 
+	case "getnodesinfo":
+		rerr = requestobj.handleGetNodesInfo()
+
 	case "addnode":
 		rerr = requestobj.handleAddNode()
 
@@ -147,6 +238,8 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 
 	requestobj.Node.DBConn.CloseConnection()
 
+	var response []byte
+
 	if rerr != nil {
 		s.Logger.Error.Println("Network Command Handle Error: ", rerr.Error())
 		s.Logger.Trace.Println("Network Command Handle Error: ", rerr.Error())
@@ -154,42 +247,77 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		if requestobj.HasResponse {
 			// return error to the client
 			// first byte is bool false to indicate there was error
-			s.sendErrorBack(conn, rerr)
+			response = s.getErrorResponse(rerr)
 		}
 	}
 
 	if requestobj.HasResponse && requestobj.Response != nil && rerr == nil {
 		// send this response back
 		// first byte is bool true to indicate request was success
-		dataresponse := append([]byte{1}, requestobj.Response...)
+		response = append([]byte{netlib.ResponseSuccess}, requestobj.Response...)
+	}
+	duration := time.Since(time.Unix(0, starttime))
+	ms := duration.Nanoseconds() / int64(time.Millisecond)
+	s.Logger.Trace.Printf("Complete processing %s command. Time: %d ms, sess %s", command, ms, sessid)
 
-		s.Logger.Trace.Printf("Responding %d bytes\n", len(dataresponse))
+	return response
+}
 
-		_, err := conn.Write(dataresponse)
+// Adds points to a score of a host. Bans the host if the score is too big
+// Known nodes on the host are forgotten
+func (s *NodeServer) penalizeHost(node *nodemanager.Node, host string, score int, reason string) {
+	s.Logger.Trace.Printf("Host %s misbehaves: %s. Score +%d", host, reason, score)
 
-		if err != nil {
-			s.Logger.Error.Println("Sending response error: ", err.Error())
+	if s.scores == nil || host == "" || isLoopbackHost(host) {
+		// local wallets and tools use same port. they must not be locked out
+		return
+	}
+
+	until := s.scores.misbehaving(host, score)
+
+	if until == 0 {
+		return
+	}
+	s.Logger.Trace.Printf("Host %s is banned till %s", host, time.Unix(until, 0).String())
+
+	err := node.GetNodesStorage().AddBan(host, until)
+
+	if err != nil {
+		s.Logger.Error.Println("Ban saving error: ", err.Error())
+	}
+
+	for _, addr := range s.Node.NodeNet.GetNodes() {
+		if addr.CompareToAddress(netlib.NodeAddr{Host: host, Port: addr.Port}) {
+			s.Node.NodeNet.RemoveNodeFromKnown(addr)
 		}
 	}
-	duration := time.Since(time.Unix(0, starttime))
-	ms := duration.Nanoseconds() / int64(time.Millisecond)
-	s.Logger.Trace.Printf("Complete processing %s command. Time: %d ms, sess %s", command, ms, sessid)
+}
 
-	conn.Close()
+// Removes expired bans from memory and from the DB
+func (s *NodeServer) removeExpiredBans(node *nodemanager.Node) {
+	if s.scores == nil {
+		return
+	}
+
+	for _, host := range s.scores.removeExpiredBans() {
+		err := node.GetNodesStorage().RemoveBan(host)
+
+		if err != nil {
+			s.Logger.Error.Println("Ban removing error: ", err.Error())
+		}
+	}
 }
 
 // response error to a client
 func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
-	s.Logger.Error.Println("Sending back error message: ", err.Error())
-	s.Logger.Trace.Println("Sending back error message: ", err.Error())
-
-	payload, err := netlib.GobEncode(err.Error())
-
-	if err == nil {
-		dataresponse := append([]byte{0}, payload...)
+	dataresponse := s.getErrorResponse(err)
 
+	if len(dataresponse) > 0 {
 		s.Logger.Trace.Printf("Responding %d bytes as error message\n", len(dataresponse))
 
+		// other side can be not reading
+		conn.SetWriteDeadline(time.Now().Add(config.ConnectionWaitTimeout * time.Second))
+
 		_, err = conn.Write(dataresponse)
 
 		if err != nil {
@@ -198,12 +326,36 @@ func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
 	}
 }
 
+// Prepares error message to send to a client. First byte is 0 to indicate there was error
+// Refused requests get typed error. First byte is 2 in this case
+func (s *NodeServer) getErrorResponse(err error) []byte {
+	s.Logger.Error.Println("Sending back error message: ", err.Error())
+	s.Logger.Trace.Println("Sending back error message: ", err.Error())
+
+	if rerr, ok := err.(*netlib.RequestError); ok {
+		payload, err := netlib.GobEncode(rerr)
+
+		if err != nil {
+			return nil
+		}
+		return append([]byte{netlib.ResponseTypedError}, payload...)
+	}
+
+	payload, err := netlib.GobEncode(err.Error())
+
+	if err != nil {
+		return nil
+	}
+	return append([]byte{netlib.ResponseError}, payload...)
+}
+
 // Starts a server for node. It listens TPC port and communicates with other nodes and lite clients
 
 func (s *NodeServer) StartServer(serverStartResult chan string) error {
-	s.Logger.Trace.Println("Prepare server to start ", s.NodeAddress.NodeAddrToString())
+	s.Logger.Trace.Printf("Prepare server to start on %s, advertised address %s",
+		s.BindAddress.NodeAddrToString(), s.GetNodeAddress().NodeAddrToString())
 
-	ln, err := net.Listen(netlib.Protocol, ":"+strconv.Itoa(s.NodeAddress.Port))
+	ln, err := net.Listen(netlib.Protocol, net.JoinHostPort(s.BindAddress.Host, strconv.Itoa(s.BindAddress.Port)))
 
 	if err != nil {
 		serverStartResult <- err.Error()
@@ -215,10 +367,27 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 	defer ln.Close()
 
 	// client will use the address to include it in requests
-	s.Node.NodeClient.SetNodeAddress(s.NodeAddress)
+	s.Node.NodeClient.SetNodeAddress(s.GetNodeAddress())
+
+	// keep connections to other nodes open. clones of the node use same sessions
+	s.Node.NodeClient.Sessions = nodeclient.NewPeerSessions(s.Logger, s.Node.NodeClient.Transport)
+	s.Node.NodeClient.Peers = nodeclient.NewPeersInfo()
+	s.Node.NodeClient.Inventory = nodeclient.NewInventoryRelay()
+
+	if s.scores == nil {
+		s.scores = newPeerScores(0)
+	}
+	err = s.scores.loadBans(s.Node.GetNodesStorage())
+
+	if err != nil {
+		s.Logger.Error.Println("Bans loading error: ", err.Error())
+	}
 
 	s.Node.SendVersionToNodes([]netlib.NodeAddr{})
 
+	// continue sync if it was not finished before
+	s.StartBlockchainSync()
+
 	s.Logger.Trace.Println("Start block bilding routine")
 	s.BlockBilderChan = make(chan []byte, 100)
 	// we set buffer to 100 transactions.
@@ -230,7 +399,14 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 
 	go s.BlockBuilder()
 
-	s.Logger.Trace.Println("Start listening connections on port ", s.NodeAddress.Port)
+	go s.AddrGossip()
+
+	go s.InvTrickle()
+
+	s.Logger.Trace.Println("Start listening connections on port ", s.BindAddress.Port)
+
+	// slots for connections handled at same time
+	connSlots := make(chan struct{}, config.MaxConnections)
 
 	for {
 		conn, err := ln.Accept()
@@ -256,6 +432,10 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 			// complete all tasks. save data if needed
 			ln.Close()
 
+			if s.Node.NodeClient.Sessions != nil {
+				s.Node.NodeClient.Sessions.CloseAll()
+			}
+
 			close(s.StopMainConfirmChan)
 
 			s.BlockBilderChan <- []byte{} // send signal to block building thread to exit
@@ -265,11 +445,48 @@ func (s *NodeServer) StartServer(serverStartResult chan string) error {
 			break
 		}
 
-		go s.handleConnection(conn)
+		// wait for a free slot. new connections are not accepted while we wait
+		if s.waitConnectionSlot(connSlots) {
+			go func(conn net.Conn) {
+				defer func() { <-connSlots }()
+
+				s.handleConnection(conn)
+			}(conn)
+		} else {
+			go s.refuseConnection(conn)
+		}
 	}
 	return nil
 }
 
+// Takes a slot for new connection. Returns false if there was no free slot during ConnectionWaitTimeout
+func (s *NodeServer) waitConnectionSlot(connSlots chan struct{}) bool {
+	select {
+	case connSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(config.ConnectionWaitTimeout * time.Second)
+	defer timer.Stop()
+
+	select {
+	case connSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Closes a connection when there are too many connections. Error is sent to a client
+func (s *NodeServer) refuseConnection(conn net.Conn) {
+	defer conn.Close()
+
+	s.Logger.Trace.Println("Too many connections. Refuse connection from ", s.getRemoteIP(conn))
+
+	s.sendErrorBack(conn, netlib.NewRequestError("Node is busy. Try later", netlib.RequestErrorBusy))
+}
+
 /*
 * Sends signal to routine where we make blocks. This makes the routine to check transactions in unapproved cache
 * And try to make a block if there are enough transactions
@@ -319,6 +536,74 @@ func (s *NodeServer) BlockBuilder() {
 	}
 }
 
+// Requests addresses of other nodes from a random node regularly. Exits when the server stops
+func (s *NodeServer) AddrGossip() {
+	for {
+		select {
+		case <-s.StopMainChan:
+			s.Logger.Trace.Printf("Exit address gossip thread")
+			return
+		case <-time.After(config.AddrGossipInterval * time.Second):
+		}
+
+		node := s.CloneNode()
+
+		s.removeExpiredBans(node)
+
+		for _, addr := range node.NodeNet.SelectOutbound(config.MaxOutboundNodes, s.GetNodeAddress()) {
+			info, ok := node.NodeClient.Peers.Get(addr)
+
+			if !ok || !info.HasService(netlib.ServiceAddrGossip) {
+				continue
+			}
+
+			if s.requestAddresses(node, addr) == nil {
+				break
+			}
+		}
+		node.DBConn.CloseConnection()
+	}
+}
+
+// Sends queued transactions announcements to other nodes when their random delays are over
+func (s *NodeServer) InvTrickle() {
+	node := s.CloneNode()
+
+	for {
+		select {
+		case <-s.StopMainChan:
+			s.Logger.Trace.Printf("Exit inventory trickle thread")
+			return
+		case <-time.After(config.InvTrickleTick * time.Millisecond):
+		}
+
+		for _, a := range node.NodeClient.Inventory.TakeReady() {
+			s.Logger.Trace.Printf("Announce %d transactions to %s", len(a.Items), a.Addr.NodeAddrToString())
+			node.NodeClient.SendInv(a.Addr, "tx", a.Items)
+		}
+	}
+}
+
+// Requests addresses of other nodes from a node and adds them to the address book
+func (s *NodeServer) requestAddresses(node *nodemanager.Node, addr netlib.NodeAddr) error {
+	list, err := node.NodeClient.SendGetAddr(addr)
+
+	if err != nil {
+		s.Logger.Trace.Println(err.Error())
+		return err
+	}
+
+	if len(list) > netlib.AddrGossipMax {
+		list = list[:netlib.AddrGossipMax]
+	}
+
+	added := node.AddAddresses(list, addr.Host)
+
+	s.Logger.Trace.Printf("Received %d addresses from %s, %d are new", len(list), addr.NodeAddrToString(), len(added))
+
+	return nil
+}
+
 /*
 * Creates clone of a node object. We use this in case if we need separate object
 * for a routine. This prevents conflicts of pointers in different routines
@@ -337,15 +622,77 @@ func (s *NodeServer) CloneNode() *nodemanager.Node {
 
 	node.Init()
 
-	node.NodeClient.SetNodeAddress(s.NodeAddress)
+	node.NodeClient.SetNodeAddress(s.GetNodeAddress())
+	node.NodeClient.Sessions = orignode.NodeClient.Sessions
+	node.NodeClient.Transport = orignode.NodeClient.Transport
+	node.NodeClient.Peers = orignode.NodeClient.Peers
+	node.NodeClient.Inventory = orignode.NodeClient.Inventory
 
-	node.InitNodes(orignode.NodeNet.Nodes, true) // set list of nodes and skip loading default if this is empty list
+	// all clones use same address book
+	node.NodeNet.UseBookOf(&orignode.NodeNet)
 
 	return &node
 }
 
+// Returns address of this node advertised to other nodes
+func (s *NodeServer) GetNodeAddress() netlib.NodeAddr {
+	s.addrLock.Lock()
+	defer s.addrLock.Unlock()
+
+	return s.NodeAddress
+}
+
+// Remembers a host of this node reported by other node. If enough nodes report same host
+// it becomes advertised address of this node
+func (s *NodeServer) addObservedHost(host string, reporter string) {
+	if s.external == nil {
+		return
+	}
+
+	host, changed := s.external.addObserved(host, reporter)
+
+	if !changed {
+		return
+	}
+
+	s.addrLock.Lock()
+	s.NodeAddress.Host = host
+	addr := s.NodeAddress
+	s.addrLock.Unlock()
+
+	s.Logger.Trace.Printf("External address is found: %s", addr.NodeAddrToString())
+
+	s.Node.NodeClient.SetNodeAddress(addr)
+}
+
+// Starts header-first sync of the blockchain with known nodes in separate routine.
+// Does nothing if sync is already running
+func (s *NodeServer) StartBlockchainSync() {
+	if !atomic.CompareAndSwapInt32(&s.syncRunning, 0, 1) {
+		return
+	}
+
+	node := s.CloneNode()
+
+	go func() {
+		defer atomic.StoreInt32(&s.syncRunning, 0)
+
+		err := node.SyncBlockchain(node.NodeNet.SelectOutbound(config.MaxOutboundNodes, s.GetNodeAddress()))
+
+		if err != nil {
+			s.Logger.Error.Println("Blockchain sync error: ", err.Error())
+		}
+	}()
+}
+
 // Reads and parses request from network data
-func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error) {
+// Sizes of data are checked before reading. Request must be read during RequestReadTimeout
+func (s *NodeServer) readRequest(conn io.Reader) (string, []byte, string, error) {
+	if c, ok := conn.(net.Conn); ok {
+		c.SetReadDeadline(time.Now().Add(config.RequestReadTimeout * time.Second))
+		defer c.SetReadDeadline(time.Time{})
+	}
+
 	// 1. Read command
 	commandbuffer, err := s.readFromConnection(conn, netlib.CommandLength)
 
@@ -363,8 +710,7 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		return "", nil, "", err
 	}
 
-	var datalength uint32
-	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &datalength)
+	datalength := binary.LittleEndian.Uint32(lengthbuffer)
 
 	// 3. Get length of extra data
 	lengthbuffer, err = s.readFromConnection(conn, 4)
@@ -373,8 +719,19 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		return "", nil, "", err
 	}
 
-	var extradatalength uint32
-	binary.Read(bytes.NewReader(lengthbuffer), binary.LittleEndian, &extradatalength)
+	extradatalength := binary.LittleEndian.Uint32(lengthbuffer)
+
+	if uint64(datalength) > uint64(getCommandPayloadLimit(command)) {
+		return "", nil, "", netlib.NewRequestError(
+			fmt.Sprintf("Payload of %s command is too large: %d bytes. Max %d", command, datalength, getCommandPayloadLimit(command)),
+			netlib.RequestErrorTooLarge)
+	}
+
+	if extradatalength > config.MaxRequestExtraData {
+		return "", nil, "", netlib.NewRequestError(
+			fmt.Sprintf("Extra data of a request is too large: %d bytes", extradatalength),
+			netlib.RequestErrorTooLarge)
+	}
 
 	// 4. read command data by length
 	//s.Logger.Trace.Printf("Before read data %d bytes", datalength)
@@ -385,7 +742,7 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		databuffer, err = s.readFromConnection(conn, int(datalength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of request: %s", datalength, err.Error()))
+			return "", nil, "", err
 		}
 	}
 
@@ -397,7 +754,7 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 		extradatabuffer, err := s.readFromConnection(conn, int(extradatalength))
 
 		if err != nil {
-			return "", nil, "", errors.New(fmt.Sprintf("Error reading %d bytes of extra data: %s", extradatalength, err.Error()))
+			return "", nil, "", err
 		}
 
 		authstr = netlib.BytesToCommand(extradatabuffer)
@@ -407,46 +764,22 @@ func (s *NodeServer) readRequest(conn net.Conn) (string, []byte, string, error)
 }
 
 // Read given amount of bytes from connection
-func (s *NodeServer) readFromConnection(conn net.Conn, countofbytes int) ([]byte, error) {
+// Memory is allocated while data are received, not by the expected size
+func (s *NodeServer) readFromConnection(conn io.Reader, countofbytes int) ([]byte, error) {
 	buff := new(bytes.Buffer)
 
-	pauses := 0
-
-	for {
-
-		tmpbuffer := make([]byte, countofbytes-buff.Len())
-
-		read, err := conn.Read(tmpbuffer)
-
-		if read > 0 {
-			buff.Write(tmpbuffer[:read])
-
-			if buff.Len() == countofbytes {
-				break
-			}
-			pauses = 0
-		}
+	_, err := io.CopyN(buff, conn, int64(countofbytes))
 
-		if err == io.EOF {
-			break
-		}
-
-		if err != nil {
-			return nil, err
-		}
-
-		if read == 0 {
-			if pauses > 30 {
-				break
-			}
-			time.Sleep(1 * time.Second)
-			pauses++
-		}
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return nil, netlib.NewRequestError(
+			fmt.Sprintf("Request was not received in time. Expected - %d bytes, read - %d", countofbytes, buff.Len()),
+			netlib.RequestErrorTimeout)
 	}
 
-	if buff.Len() < countofbytes {
-		return nil,
-			errors.New(fmt.Sprintf("Wrong number of bytes received for a request. Expected - %d, read - %d", countofbytes, buff.Len()))
+	if err != nil {
+		return nil, netlib.NewRequestError(
+			fmt.Sprintf("Wrong number of bytes received for a request. Expected - %d, read - %d", countofbytes, buff.Len()),
+			netlib.RequestErrorMalformed)
 	}
 
 	return buff.Bytes(), nil
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	netlib "github.com/NlaakStudios/democoin/lib/net"
+	"github.com/NlaakStudios/democoin/lib/nodeclient"
+	"github.com/NlaakStudios/democoin/node/config"
+)
+
+// Max number of requests of one session executed at same time. Next requests are not read
+// from a connection till some of them are complete
+const sessionMaxRequestsInProgress = 16
+
+// Persistent session opened by other node or a client. Requests are read from the connection
+// and executed in parallel. Responses are sent with ID of a request in any order
+type serverSession struct {
+	S         *NodeServer
+	conn      net.Conn
+	writeLock sync.Mutex
+}
+
+// Handles a session. Request is the data of a handshake command. Returns when the connection is closed
+func (s *NodeServer) handleSession(conn net.Conn, request []byte) {
+	defer conn.Close()
+
+	session := serverSession{S: s, conn: conn}
+
+	err := session.handshake(request)
+
+	if err != nil {
+		s.Logger.Trace.Println("Session handshake failed: ", err.Error())
+		return
+	}
+
+	inprogress := make(chan struct{}, sessionMaxRequestsInProgress)
+	wg := sync.WaitGroup{}
+
+	maxFrameLength := getSessionFrameLimit()
+
+	for {
+		// idle session is closed. a client opens new one when needed
+		conn.SetReadDeadline(time.Now().Add(config.SessionIdleTimeout * time.Second))
+
+		id, kind, body, err := netlib.ReadSessionFrame(conn, maxFrameLength)
+
+		if err != nil {
+			s.Logger.Trace.Println("Session closed: ", err.Error())
+			break
+		}
+
+		if kind != netlib.SessionFrameRequest && kind != netlib.SessionFrameRequestResponse {
+			s.Logger.Trace.Printf("Unexpected session frame kind %d", kind)
+			break
+		}
+
+		inprogress <- struct{}{}
+		wg.Add(1)
+
+		go func(id uint32, kind byte, body []byte) {
+			defer func() {
+				<-inprogress
+				wg.Done()
+			}()
+
+			response := session.handleRequest(body)
+
+			if kind == netlib.SessionFrameRequestResponse {
+				session.writeFrame(id, netlib.SessionFrameResponse, response)
+			}
+		}(id, kind, body)
+	}
+	// don't close the connection while responses are written
+	wg.Wait()
+}
+
+// Checks version of a session protocol and responds with verack
+func (ss *serverSession) handshake(request []byte) error {
+	version := nodeclient.ComSessionVersion{}
+
+	requestobj := NodeServerRequest{Request: request}
+
+	err := requestobj.parseRequestData(&version)
+
+	if err != nil {
+		return err
+	}
+
+	if version.Version < 1 {
+		return errors.New("Session protocol version is not supported")
+	}
+
+	payload, err := netlib.GobEncode(&nodeclient.ComSessionVersion{Version: netlib.SessionProtocolVersion, AddrFrom: ss.S.GetNodeAddress()})
+
+	if err != nil {
+		return err
+	}
+
+	return ss.writeFrame(0, netlib.SessionFrameVerack, append([]byte{netlib.ResponseSuccess}, payload...))
+}
+
+// Executes a request received in a session. Request data are same as without a session
+func (ss *serverSession) handleRequest(body []byte) []byte {
+	command, request, authstring, err := ss.S.readRequest(bytes.NewReader(body))
+
+	if err != nil {
+		return ss.S.getErrorResponse(err)
+	}
+
+	return ss.S.handleCommand(command, request, authstring, ss.S.getRemoteIP(ss.conn))
+}
+
+// Writes a frame. Frames written from different routines must not mix
+func (ss *serverSession) writeFrame(id uint32, kind byte, body []byte) error {
+	ss.writeLock.Lock()
+	defer ss.writeLock.Unlock()
+
+	err := netlib.WriteSessionFrame(ss.conn, id, kind, body)
+
+	if err != nil {
+		ss.S.Logger.Error.Println("Sending session frame error: ", err.Error())
+	}
+	return err
+}
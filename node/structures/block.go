@@ -3,6 +3,7 @@ package structures
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"time"
 
 	"github.com/NlaakStudios/democoin/lib/utils"
@@ -10,6 +11,7 @@ import (
 )
 
 // Block represents a block in the blockchain
+// Fields covered by PoW are listed in BlockHeader
 type Block struct {
 	Timestamp     int64
 	Transactions  []*transaction.Transaction
@@ -17,6 +19,9 @@ type Block struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	Bits          int    // difficulty target bits. 0 for blocks made before it was stored in a block
+	Version       int    // 0 for blocks made before the header existed
+	MerkleRoot    []byte // root of Merkle tree of transactions. Empty in blocks of version 0
 }
 
 // short info about a block. to exchange over network
@@ -34,6 +39,9 @@ type BlockSimpler struct {
 	Hash          []byte
 	Nonce         int
 	Height        int
+	Bits          int
+	Version       int
+	MerkleRoot    []byte
 }
 
 // Reverce list of blocks
@@ -106,6 +114,9 @@ func (b *Block) GetSimpler() *BlockSimpler {
 	Block.Hash = b.Hash[:]
 	Block.Height = b.Height
 	Block.PrevBlockHash = b.PrevBlockHash[:]
+	Block.Bits = b.Bits
+	Block.Version = b.Version
+	Block.MerkleRoot = b.MerkleRoot[:]
 
 	Block.Transactions = []string{}
 
@@ -135,6 +146,9 @@ func (b *Block) Copy() *Block {
 
 	bc.Nonce = b.Nonce
 	bc.Height = b.Height
+	bc.Bits = b.Bits
+	bc.Version = b.Version
+	bc.MerkleRoot = utils.CopyBytes(b.MerkleRoot)
 
 	for _, t := range b.Transactions {
 		tc, _ := t.Copy()
@@ -157,12 +171,20 @@ func (b *Block) PrepareNewBlock(transactions []*transaction.Transaction, prevBlo
 	b.Hash = []byte{}
 	b.Nonce = 0
 	b.Height = height
+	b.Version = CurrentBlockVersion
+
+	merkleRoot, err := b.HashTransactions()
+
+	if err != nil {
+		return err
+	}
+	b.MerkleRoot = merkleRoot
 
 	return nil
 }
 
-// HashTransactions returns a hash of the transactions in the block
-func (b *Block) HashTransactions() ([]byte, error) {
+// Returns data of transactions used as leaves of Merkle tree
+func (b *Block) getTransactionsMerkleData() ([][]byte, error) {
 	var transactions [][]byte
 
 	for _, tx := range b.Transactions {
@@ -173,11 +195,50 @@ func (b *Block) HashTransactions() ([]byte, error) {
 		}
 		transactions = append(transactions, txser)
 	}
+	return transactions, nil
+}
+
+// HashTransactions returns a hash of the transactions in the block
+func (b *Block) HashTransactions() ([]byte, error) {
+	transactions, err := b.getTransactionsMerkleData()
+
+	if err != nil {
+		return nil, err
+	}
+
 	mTree := utils.NewMerkleTree(transactions)
 
 	return mTree.RootNode.Data, nil
 }
 
+// Returns Merkle branch for a transaction in the block. Returns also data of the transaction
+// used as a leaf of the tree and the Merkle root
+func (b *Block) GetTransactionProof(txID []byte) ([]byte, []utils.MerkleProofStep, []byte, error) {
+	transactions, err := b.getTransactionsMerkleData()
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for i, tx := range b.Transactions {
+		if !bytes.Equal(tx.ID, txID) {
+			continue
+		}
+
+		mTree := utils.NewMerkleTree(transactions)
+
+		proof, err := mTree.GetProof(i)
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return transactions[i], proof, mTree.RootNode.Data, nil
+	}
+
+	return nil, nil, nil, errors.New("Transaction is not found in the block")
+}
+
 // Serialize serializes the block
 func (b *Block) Serialize() ([]byte, error) {
 	var result bytes.Buffer
@@ -192,12 +253,16 @@ func (b *Block) Serialize() ([]byte, error) {
 }
 
 // DeserializeBlock deserializes a block
+// Blocks saved before amounts became int64 are converted
 func (b *Block) DeserializeBlock(d []byte) error {
 
 	decoder := gob.NewDecoder(bytes.NewReader(d))
 	err := decoder.Decode(&b)
 
 	if err != nil {
+		if b.deserializeLegacyBlock(d) == nil {
+			return nil
+		}
 		return err
 	}
 
@@ -0,0 +1,106 @@
+package structures
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+
+	"github.com/NlaakStudios/democoin/lib"
+	"github.com/NlaakStudios/democoin/lib/utils"
+)
+
+// Version of blocks having the header. Blocks of version 0 were made before the header existed,
+// their PoW was calculated over other data and they don't store the Merkle root
+const CurrentBlockVersion = 1
+
+// BlockHeader contains all data of a block covered by PoW. Transactions are presented
+// by the Merkle root. So, a header can be verified without the list of transactions
+type BlockHeader struct {
+	Version       int
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          int
+	Nonce         int
+}
+
+// Returns the header of a block
+func (b *Block) GetHeader() *BlockHeader {
+	h := BlockHeader{}
+	h.Version = b.Version
+	h.PrevBlockHash = utils.CopyBytes(b.PrevBlockHash)
+	h.MerkleRoot = utils.CopyBytes(b.MerkleRoot)
+	h.Timestamp = b.Timestamp
+	h.Bits = b.Bits
+	h.Nonce = b.Nonce
+
+	return &h
+}
+
+// Serializes the header without the nonce. PoW adds a nonce to this data on each iteration
+// Every field has fixed length, see lib.BlockHeaderLength. Empty prev hash of the genesis block is all zeros
+func (h *BlockHeader) SerializeWithoutNonce() []byte {
+	data := utils.IntToHex(int64(h.Version))
+
+	prevHash := make([]byte, lib.BlockHeaderHashLength)
+	copy(prevHash, h.PrevBlockHash)
+	data = append(data, prevHash...)
+
+	merkleRoot := make([]byte, lib.BlockHeaderHashLength)
+	copy(merkleRoot, h.MerkleRoot)
+	data = append(data, merkleRoot...)
+
+	data = append(data, utils.IntToHex(h.Timestamp)...)
+	data = append(data, utils.IntToHex(int64(h.Bits))...)
+
+	return data
+}
+
+// Serializes the header. Hash of a block is the hash of this data
+func (h *BlockHeader) Serialize() []byte {
+	return append(h.SerializeWithoutNonce(), utils.IntToHex(int64(h.Nonce))...)
+}
+
+// Returns hash of the header
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// Header of a block with its hash and position in a chain. Nodes exchange it on header-first sync
+type BlockHeaderInfo struct {
+	Header BlockHeader
+	Hash   []byte
+	Height int
+}
+
+// Returns header info of a block
+func (b *Block) GetHeaderInfo() *BlockHeaderInfo {
+	hi := BlockHeaderInfo{}
+	hi.Header = *b.GetHeader()
+	hi.Hash = utils.CopyBytes(b.Hash)
+	hi.Height = b.Height
+
+	return &hi
+}
+
+// Serialise BlockHeaderInfo to bytes
+func (hi *BlockHeaderInfo) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+
+	err := encoder.Encode(hi)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// Deserialize BlockHeaderInfo from bytes
+func (hi *BlockHeaderInfo) Deserialize(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+
+	return decoder.Decode(hi)
+}
@@ -0,0 +1,52 @@
+package structures
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+// Block format used before amounts were stored as int64 smallest units
+// It is used only to read old data
+type legacyBlock struct {
+	Timestamp     int64
+	Transactions  []*transaction.LegacyTransaction
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+	Bits          int
+}
+
+// Block was made before versions and difficulty bits were stored in blocks.
+// Its PoW is calculated without a header
+func (b *Block) IsLegacy() bool {
+	return b.Version == 0 && b.Bits == 0
+}
+
+// Decode block serialized in old format and convert to current format
+func (b *Block) deserializeLegacyBlock(d []byte) error {
+	lb := legacyBlock{}
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&lb)
+
+	if err != nil {
+		return err
+	}
+
+	b.Timestamp = lb.Timestamp
+	b.PrevBlockHash = lb.PrevBlockHash
+	b.Hash = lb.Hash
+	b.Nonce = lb.Nonce
+	b.Height = lb.Height
+	b.Bits = lb.Bits
+	b.Transactions = []*transaction.Transaction{}
+
+	for _, ltx := range lb.Transactions {
+		b.Transactions = append(b.Transactions, ltx.ToTransaction())
+	}
+
+	return nil
+}
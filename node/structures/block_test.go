@@ -1,8 +1,14 @@
 package structures
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"testing"
+
+	"github.com/NlaakStudios/democoin/lib"
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
 )
 
 func TestCopyBlock(t *testing.T) {
@@ -31,6 +37,10 @@ func TestDeserialiseBlock(t *testing.T) {
 		if len(b.Transactions) != 2 {
 			t.Fatalf("Number of transactions is wrong. 2 is expected, got %d", len(b.Transactions))
 		}
+
+		if b.Version != 0 {
+			t.Fatalf("Old block must have version 0, got %d", b.Version)
+		}
 		/*
 			fmt.Println(b)
 
@@ -41,3 +51,84 @@ func TestDeserialiseBlock(t *testing.T) {
 		*/
 	}
 }
+
+func TestBlockHeader(t *testing.T) {
+	b := Block{}
+	b.Timestamp = 1500000000
+	b.PrevBlockHash = bytes.Repeat([]byte{1}, 32)
+	b.Version = CurrentBlockVersion
+	b.MerkleRoot = bytes.Repeat([]byte{2}, 32)
+	b.Bits = 16
+	b.Nonce = 5
+
+	h := b.GetHeader()
+
+	data := h.Serialize()
+
+	if len(data) != 8+32+32+8+8+8 {
+		t.Fatalf("Wrong length of serialized header %d", len(data))
+	}
+
+	if !bytes.Equal(data[:len(data)-8], h.SerializeWithoutNonce()) {
+		t.Fatalf("Nonce must be the last field of the header")
+	}
+
+	hash := sha256.Sum256(data)
+
+	if !bytes.Equal(hash[:], h.Hash()) {
+		t.Fatalf("Wrong hash of the header")
+	}
+
+	if len(data) != lib.BlockHeaderLength {
+		t.Fatalf("Header length %d is different from the layout %d", len(data), lib.BlockHeaderLength)
+	}
+
+	if !bytes.Equal(data[lib.BlockHeaderMerkleRootOffset:lib.BlockHeaderMerkleRootOffset+lib.BlockHeaderHashLength], b.MerkleRoot) {
+		t.Fatalf("Merkle root is not in its place in the header")
+	}
+
+	// genesis block has no prev hash. header has same length
+	h.PrevBlockHash = []byte{}
+
+	if len(h.Serialize()) != len(data) {
+		t.Fatalf("Header length depends on prev hash")
+	}
+}
+
+func TestTransactionProof(t *testing.T) {
+	b := Block{}
+
+	for i := 0; i < 3; i++ {
+		tx := &transaction.Transaction{}
+		tx.ID = []byte{byte(i + 1)}
+		tx.Vin = []transaction.TXInput{}
+		tx.Vout = []transaction.TXOutput{transaction.TXOutput{Value: int64(i), PubKeyHash: []byte{byte(i)}}}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	root, err := b.HashTransactions()
+
+	if err != nil {
+		t.Fatalf("Hash error: %s", err.Error())
+	}
+
+	data, proof, proofRoot, err := b.GetTransactionProof([]byte{2})
+
+	if err != nil {
+		t.Fatalf("Proof error: %s", err.Error())
+	}
+
+	if !bytes.Equal(root, proofRoot) {
+		t.Fatalf("Proof root is not the block Merkle root")
+	}
+
+	if !utils.VerifyMerkleProof(data, proof, root) {
+		t.Fatalf("Proof is not valid")
+	}
+
+	_, _, _, err = b.GetTransactionProof([]byte{5})
+
+	if err == nil {
+		t.Fatalf("Proof for missed transaction must fail")
+	}
+}
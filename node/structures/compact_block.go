@@ -0,0 +1,189 @@
+package structures
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+// Length of a short transaction ID in a compact block
+const ShortTransactionIDLength = 6
+
+// Block presented by the header and short IDs of transactions. It is used to relay new blocks.
+// Receivers rebuild a block from own unapproved transactions and request only missing transactions.
+// Transactions other nodes can not have (coinbase) are included in full
+type CompactBlock struct {
+	Hash      []byte
+	Height    int
+	Header    BlockHeader
+	Prefilled []PrefilledTransaction
+	ShortIDs  [][]byte // short IDs of not prefilled transactions, in order of the block
+}
+
+type PrefilledTransaction struct {
+	Index int
+	TX    []byte // serialized transaction
+}
+
+// Returns short ID of a transaction. IDs depend on a block hash, so collisions
+// can not be prepared for all blocks
+func GetShortTransactionID(blockHash []byte, txID []byte) []byte {
+	data := append(utils.CopyBytes(blockHash), txID...)
+	hash := sha256.Sum256(data)
+
+	return hash[:ShortTransactionIDLength]
+}
+
+// Makes compact presentation of a block
+func NewCompactBlock(b *Block) (*CompactBlock, error) {
+	cb := CompactBlock{}
+	cb.Hash = utils.CopyBytes(b.Hash)
+	cb.Height = b.Height
+	cb.Header = *b.GetHeader()
+	cb.Prefilled = []PrefilledTransaction{}
+	cb.ShortIDs = [][]byte{}
+
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			txser, err := tx.Serialize()
+
+			if err != nil {
+				return nil, err
+			}
+
+			cb.Prefilled = append(cb.Prefilled, PrefilledTransaction{i, txser})
+			continue
+		}
+		cb.ShortIDs = append(cb.ShortIDs, GetShortTransactionID(b.Hash, tx.ID))
+	}
+	return &cb, nil
+}
+
+// Returns number of transactions in the block
+func (cb *CompactBlock) GetTransactionsCount() int {
+	return len(cb.Prefilled) + len(cb.ShortIDs)
+}
+
+// Builds a block from the compact block. Transactions are found by short IDs in a given map.
+// Returns the block and indexes of missing transactions. Places of missing transactions are nil
+func (cb *CompactBlock) Rebuild(known map[string]*transaction.Transaction) (*Block, []int, error) {
+	count := cb.GetTransactionsCount()
+
+	b := Block{}
+	b.Hash = utils.CopyBytes(cb.Hash)
+	b.Height = cb.Height
+	b.Version = cb.Header.Version
+	b.PrevBlockHash = utils.CopyBytes(cb.Header.PrevBlockHash)
+	b.MerkleRoot = utils.CopyBytes(cb.Header.MerkleRoot)
+	b.Timestamp = cb.Header.Timestamp
+	b.Bits = cb.Header.Bits
+	b.Nonce = cb.Header.Nonce
+	b.Transactions = make([]*transaction.Transaction, count)
+
+	for _, p := range cb.Prefilled {
+		if p.Index < 0 || p.Index >= count || b.Transactions[p.Index] != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Wrong index of prefilled transaction %d", p.Index))
+		}
+
+		tx := transaction.Transaction{}
+
+		err := tx.DeserializeTransaction(p.TX)
+
+		if err != nil {
+			return nil, nil, err
+		}
+		b.Transactions[p.Index] = &tx
+	}
+
+	missing := []int{}
+	shortIndex := 0
+
+	for i := range b.Transactions {
+		if b.Transactions[i] != nil {
+			continue
+		}
+
+		if tx, ok := known[string(cb.ShortIDs[shortIndex])]; ok && tx != nil {
+			b.Transactions[i] = tx
+		} else {
+			missing = append(missing, i)
+		}
+		shortIndex++
+	}
+	return &b, missing, nil
+}
+
+// Puts transactions received from other node to places of missing transactions
+// Every transaction must have the short ID from the compact block
+func (cb *CompactBlock) FillMissing(b *Block, indexes []int, txs []*transaction.Transaction) error {
+	if len(indexes) != len(txs) {
+		return errors.New(fmt.Sprintf("Expected %d transactions, got %d", len(indexes), len(txs)))
+	}
+
+	// map indexes in the block to short IDs
+	shortIDs := map[int][]byte{}
+	prefilled := map[int]bool{}
+
+	for _, p := range cb.Prefilled {
+		prefilled[p.Index] = true
+	}
+
+	shortIndex := 0
+
+	for i := 0; i < cb.GetTransactionsCount(); i++ {
+		if prefilled[i] {
+			continue
+		}
+		shortIDs[i] = cb.ShortIDs[shortIndex]
+		shortIndex++
+	}
+
+	for i, index := range indexes {
+		shortID, ok := shortIDs[index]
+
+		if !ok || b.Transactions[index] != nil {
+			return errors.New(fmt.Sprintf("Transaction %d is not missing", index))
+		}
+
+		if !bytes.Equal(GetShortTransactionID(cb.Hash, txs[i].ID), shortID) {
+			return errors.New(fmt.Sprintf("Transaction %x doesn't match short ID %x", txs[i].ID, shortID))
+		}
+		b.Transactions[index] = txs[i]
+	}
+	return nil
+}
+
+// Serialize the compact block
+func (cb *CompactBlock) Serialize() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+
+	err := encoder.Encode(cb)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// Deserialize the compact block. Number of short IDs must fit a block
+func (cb *CompactBlock) DeserializeBlock(d []byte) error {
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(cb)
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range cb.ShortIDs {
+		if len(id) != ShortTransactionIDLength {
+			return errors.New("Wrong length of short transaction ID")
+		}
+	}
+	return nil
+}
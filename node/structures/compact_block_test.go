@@ -0,0 +1,100 @@
+package structures
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+func TestCompactBlock(t *testing.T) {
+	b := Block{}
+	b.Version = CurrentBlockVersion
+	b.Hash = bytes.Repeat([]byte{7}, 32)
+	b.Height = 10
+
+	coinbase := &transaction.Transaction{}
+	coinbase.ID = []byte{100}
+	coinbase.Vin = []transaction.TXInput{{Txid: []byte{}, Vout: -1}}
+	coinbase.Vout = []transaction.TXOutput{{Value: 10, PubKeyHash: []byte{1}}}
+	b.Transactions = append(b.Transactions, coinbase)
+
+	for i := 0; i < 4; i++ {
+		tx := &transaction.Transaction{}
+		tx.ID = []byte{byte(i + 1)}
+		tx.Vin = []transaction.TXInput{{Txid: []byte{50}, Vout: i}}
+		tx.Vout = []transaction.TXOutput{{Value: int64(i), PubKeyHash: []byte{byte(i)}}}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	b.MerkleRoot, _ = b.HashTransactions()
+
+	cb, err := NewCompactBlock(&b)
+
+	if err != nil {
+		t.Fatalf("Compact block error: %s", err.Error())
+	}
+
+	if len(cb.Prefilled) != 1 || len(cb.ShortIDs) != 4 {
+		t.Fatalf("Wrong compact block: %d prefilled, %d short IDs", len(cb.Prefilled), len(cb.ShortIDs))
+	}
+
+	data, err := cb.Serialize()
+
+	if err != nil {
+		t.Fatalf("Serialize error: %s", err.Error())
+	}
+
+	cb2 := CompactBlock{}
+
+	err = cb2.DeserializeBlock(data)
+
+	if err != nil {
+		t.Fatalf("Deserialize error: %s", err.Error())
+	}
+
+	// receiver knows 2 of 4 transactions
+	known := map[string]*transaction.Transaction{}
+
+	for _, i := range []int{1, 3} {
+		known[string(GetShortTransactionID(b.Hash, b.Transactions[i].ID))] = b.Transactions[i]
+	}
+
+	rb, missing, err := cb2.Rebuild(known)
+
+	if err != nil {
+		t.Fatalf("Rebuild error: %s", err.Error())
+	}
+
+	if len(missing) != 2 || missing[0] != 2 || missing[1] != 4 {
+		t.Fatalf("Wrong missing transactions %v", missing)
+	}
+
+	// other transaction can not be put to a missing place
+	err = cb2.FillMissing(rb, missing, []*transaction.Transaction{b.Transactions[4], b.Transactions[2]})
+
+	if err == nil {
+		t.Fatalf("Wrong transactions are accepted")
+	}
+
+	err = cb2.FillMissing(rb, missing, []*transaction.Transaction{b.Transactions[2], b.Transactions[4]})
+
+	if err != nil {
+		t.Fatalf("Fill error: %s", err.Error())
+	}
+
+	root, err := rb.HashTransactions()
+
+	if err != nil {
+		t.Fatalf("Hash error: %s", err.Error())
+	}
+
+	if !bytes.Equal(root, b.MerkleRoot) || !bytes.Equal(rb.GetHeader().Hash(), b.GetHeader().Hash()) {
+		t.Fatalf("Rebuilt block doesn't match the original")
+	}
+
+	// short IDs depend on a block
+	if bytes.Equal(GetShortTransactionID(b.Hash, []byte{1}), GetShortTransactionID([]byte{8}, []byte{1})) {
+		t.Fatalf("Short ID doesn't depend on a block hash")
+	}
+}
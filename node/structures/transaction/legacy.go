@@ -0,0 +1,120 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/NlaakStudios/democoin/lib"
+)
+
+// Transaction format used before amounts were stored as int64 smallest units.
+// Values were float64 number of coins. It is used only to read old data
+type LegacyTransaction struct {
+	ID   []byte
+	Vin  []TXInput
+	Vout []LegacyTXOutput
+	Time int64
+}
+
+type LegacyTXOutput struct {
+	Value      float64
+	PubKeyHash []byte
+}
+
+// Converts float amount of coins to smallest units
+func LegacyAmountToUnits(value float64) int64 {
+	return int64(math.Round(value * lib.CoinUnits))
+}
+
+// Converts old transaction to current format. ID and signatures are kept as they were.
+// Float values are kept too, they are needed to verify signatures and Merkle data
+func (ltx *LegacyTransaction) ToTransaction() *Transaction {
+	tx := &Transaction{ID: ltx.ID, Vin: ltx.Vin, Time: ltx.Time}
+
+	tx.Vout = []TXOutput{}
+	tx.LegacyValues = []float64{}
+
+	for _, o := range ltx.Vout {
+		tx.Vout = append(tx.Vout, TXOutput{LegacyAmountToUnits(o.Value), o.PubKeyHash})
+		tx.LegacyValues = append(tx.LegacyValues, o.Value)
+	}
+	return tx
+}
+
+// Decode transaction serialized in old format
+func (tx *Transaction) deserializeLegacyTransaction(data []byte) error {
+	ltx := LegacyTransaction{}
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&ltx)
+
+	if err != nil {
+		return err
+	}
+
+	*tx = *ltx.ToTransaction()
+
+	return nil
+}
+
+// Transaction was made before amounts became int64. Its hashes and signatures are calculated on old format
+func (tx *Transaction) IsLegacy() bool {
+	return len(tx.LegacyValues) > 0
+}
+
+func (tx *Transaction) copyLegacyValues() []float64 {
+	if !tx.IsLegacy() {
+		return nil
+	}
+	return append([]float64{}, tx.LegacyValues...)
+}
+
+// Legacy values must be for all outputs and must be same as int64 values
+func (tx *Transaction) verifyLegacyValues() error {
+	if !tx.IsLegacy() {
+		return nil
+	}
+
+	if len(tx.LegacyValues) != len(tx.Vout) {
+		return errors.New("Legacy values don't match outputs of transaction")
+	}
+
+	for i, v := range tx.LegacyValues {
+		if LegacyAmountToUnits(v) != tx.Vout[i].Value {
+			return errors.New(fmt.Sprintf("Legacy value of output %d is different from value", i))
+		}
+	}
+	return nil
+}
+
+// Returns the transaction in old format
+func (tx *Transaction) toLegacy() LegacyTransaction {
+	ltx := LegacyTransaction{ID: tx.ID, Vin: tx.Vin, Time: tx.Time}
+
+	ltx.Vout = []LegacyTXOutput{}
+
+	for i, o := range tx.Vout {
+		ltx.Vout = append(ltx.Vout, LegacyTXOutput{tx.LegacyValues[i], o.PubKeyHash})
+	}
+	return ltx
+}
+
+// Output bytes in old format. Value was float64
+func (output TXOutput) legacyToBytes(value float64) ([]byte, error) {
+	buff := new(bytes.Buffer)
+
+	err := binary.Write(buff, binary.BigEndian, value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(buff, binary.BigEndian, output.PubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
@@ -7,14 +7,12 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
-	"math"
 	"strings"
 	"time"
 
 	"encoding/gob"
 	"fmt"
 
-	"github.com/NlaakStudios/democoin/lib"
 	"github.com/NlaakStudios/democoin/lib/utils"
 )
 
@@ -25,6 +23,9 @@ type Transaction struct {
 	Vout []TXOutput
 	//Vprotocol []Protocol
 	Time int64
+	// float values of outputs of a transaction made before amounts became int64.
+	// Signatures and Merkle data of such transaction were calculated with them
+	LegacyValues []float64
 }
 
 // IsCoinbase checks whether the transaction is coinbase
@@ -41,6 +42,11 @@ func (tx *Transaction) TimeNow() {
 func (tx *Transaction) Hash() ([]byte, error) {
 	var hash [32]byte
 
+	if tx.IsLegacy() {
+		// old format can not be serialized again. ID is kept as it was
+		return tx.ID, nil
+	}
+
 	txCopy := *tx
 	txCopy.ID = []byte{}
 
@@ -62,7 +68,7 @@ func (tx Transaction) String() string {
 	from, _ := utils.PubKeyToAddres(tx.Vin[0].PubKey)
 	fromhash, _ := utils.HashPubKey(tx.Vin[0].PubKey)
 	to := ""
-	amount := 0.0
+	amount := int64(0)
 
 	for _, output := range tx.Vout {
 		if bytes.Compare(fromhash, output.PubKeyHash) != 0 {
@@ -73,7 +79,7 @@ func (tx Transaction) String() string {
 	}
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
-	lines = append(lines, fmt.Sprintf("    FROM %s TO %s VALUE %f", from, to, amount))
+	lines = append(lines, fmt.Sprintf("    FROM %s TO %s VALUE %s", from, to, utils.FormatAmount(amount)))
 	lines = append(lines, fmt.Sprintf("    Time %d (%s)", tx.Time, time.Unix(0, tx.Time)))
 
 	for i, input := range tx.Vin {
@@ -89,7 +95,7 @@ func (tx Transaction) String() string {
 	for i, output := range tx.Vout {
 		address, _ := utils.PubKeyHashToAddres(output.PubKeyHash)
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
-		lines = append(lines, fmt.Sprintf("       Value:  %f", output.Value))
+		lines = append(lines, fmt.Sprintf("       Value:  %s", utils.FormatAmount(output.Value)))
 		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
 		lines = append(lines, fmt.Sprintf("       Address: %s", address))
 	}
@@ -112,7 +118,7 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 		outputs = append(outputs, TXOutput{vout.Value, pkh})
 	}
 	txID := utils.CopyBytes(tx.ID)
-	txCopy := Transaction{txID, inputs, outputs, tx.Time}
+	txCopy := Transaction{ID: txID, Vin: inputs, Vout: outputs, Time: tx.Time, LegacyValues: tx.copyLegacyValues()}
 
 	return txCopy
 }
@@ -141,7 +147,7 @@ func (tx *Transaction) Copy() (Transaction, error) {
 
 	txID := utils.CopyBytes(tx.ID)
 
-	txCopy := Transaction{txID, inputs, outputs, tx.Time}
+	txCopy := Transaction{ID: txID, Vin: inputs, Vout: outputs, Time: tx.Time, LegacyValues: tx.copyLegacyValues()}
 
 	return txCopy, nil
 }
@@ -176,9 +182,7 @@ func (tx *Transaction) PrepareSignData(prevTXs map[int]*Transaction) ([][]byte,
 
 		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-
-		signdata[inID] = []byte(dataToSign)
+		signdata[inID] = txCopy.getSignData()
 
 		txCopy.Vin[inID].PubKey = nil
 	}
@@ -186,6 +190,22 @@ func (tx *Transaction) PrepareSignData(prevTXs map[int]*Transaction) ([][]byte,
 	return signdata, nil
 }
 
+// Returns data signed for an input. Legacy transactions were signed with float values of outputs
+func (tx *Transaction) getSignData() []byte {
+	if tx.IsLegacy() {
+		return []byte(fmt.Sprintf("%x\n", tx.toLegacy()))
+	}
+	// fields of the transaction as they were before legacy values were added
+	data := struct {
+		ID   []byte
+		Vin  []TXInput
+		Vout []TXOutput
+		Time int64
+	}{tx.ID, tx.Vin, tx.Vout, tx.Time}
+
+	return []byte(fmt.Sprintf("%x\n", data))
+}
+
 // Sign Inouts for transaction
 // DataToSign is output of the function PrepareSignData
 func (tx *Transaction) SignData(privKey ecdsa.PrivateKey, PubKey []byte, DataToSign [][]byte) error {
@@ -255,9 +275,16 @@ func (tx *Transaction) SetSignatures(signatures [][]byte) error {
 // Verify verifies signatures of Transaction inputs
 // And total amount of inputs and outputs
 func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
+	err := tx.verifyLegacyValues()
+
+	if err != nil {
+		return err
+	}
+
 	if tx.IsCoinbase() {
-		// coinbase has only 1 output and it must have value equal to constant
-		if tx.Vout[0].Value != lib.PaymentForBlockMade {
+		// coinbase has only 1 output. its value is checked agains block reward and fees
+		// when a block is verified. It can be 0 when subsidy is over and there are no fees
+		if tx.Vout[0].Value < 0 {
 			return errors.New("Value of coinbase transaction is wrong")
 		}
 		if len(tx.Vout) > 1 {
@@ -266,7 +293,7 @@ func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 		return nil
 	}
 	// calculate total input
-	totalinput := float64(0)
+	totalinput := int64(0)
 
 	for vind, vin := range tx.Vin {
 		if prevTXs[vind].ID == nil {
@@ -297,9 +324,9 @@ func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 		// replace pub key with its hash. same was done when signing
 		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
+		dataToVerify := txCopy.getSignData()
 
-		v, err := utils.VerifySignature(vin.Signature, []byte(dataToVerify), vin.PubKey)
+		v, err := utils.VerifySignature(vin.Signature, dataToVerify, vin.PubKey)
 
 		if err != nil {
 			return err
@@ -312,26 +339,56 @@ func (tx *Transaction) Verify(prevTXs map[int]*Transaction) error {
 	}
 
 	// calculate total output of transaction
-	totaloutput := float64(0)
+	totaloutput := int64(0)
 
 	for _, vout := range tx.Vout {
-		if vout.Value < lib.SmallestUnit {
-			return errors.New(fmt.Sprintf("Too small output value %f", vout.Value))
+		if vout.Value < 1 {
+			return errors.New(fmt.Sprintf("Too small output value %d", vout.Value))
 		}
 		totaloutput += vout.Value
 	}
 
-	if math.Abs(totalinput-totaloutput) >= lib.SmallestUnit {
-		return errors.New(fmt.Sprintf("Input and output values of a transaction are not same: %.10f vs %.10f . Diff %.10f", totalinput, totaloutput, totalinput-totaloutput))
+	// difference of input and output is a fee for a miner. it can not be negative
+	if totaloutput > totalinput {
+		return errors.New(fmt.Sprintf("Output value of a transaction is more than input: %d vs %d . Diff %d", totalinput, totaloutput, totalinput-totaloutput))
 	}
 
 	return nil
 }
 
+// Returns a fee of transaction. It is implicit. Difference between inputs and outputs
+// Coinbase transaction has no fee
+func (tx *Transaction) GetFee(prevTXs map[int]*Transaction) (int64, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	fee := int64(0)
+
+	for vind, vin := range tx.Vin {
+		prevTX, ok := prevTXs[vind]
+
+		if !ok || prevTX == nil || vin.Vout < 0 || vin.Vout >= len(prevTX.Vout) {
+			return 0, errors.New("Previous transaction is not correct")
+		}
+		fee += prevTX.Vout[vin.Vout].Value
+	}
+
+	for _, vout := range tx.Vout {
+		fee -= vout.Value
+	}
+
+	if fee < 0 {
+		fee = 0
+	}
+
+	return fee, nil
+}
+
 /*
-* Make a transaction to be coinbase.
+* Make a transaction to be coinbase. Value is a block reward plus fees of transactions in a block
  */
-func (tx *Transaction) MakeCoinbaseTX(to, data string) error {
+func (tx *Transaction) MakeCoinbaseTX(to, data string, value int64) error {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -344,7 +401,7 @@ func (tx *Transaction) MakeCoinbaseTX(to, data string) error {
 	}
 
 	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(lib.PaymentForBlockMade, to)
+	txout := NewTXOutput(value, to)
 	tx.Vin = []TXInput{txin}
 	tx.Vout = []TXOutput{*txout}
 
@@ -371,11 +428,15 @@ func (tx Transaction) Serialize() ([]byte, error) {
 }
 
 // DeserializeTransaction deserializes a transaction
+// Transactions saved before amounts became int64 are converted
 func (tx *Transaction) DeserializeTransaction(data []byte) error {
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(tx)
 
 	if err != nil {
+		if tx.deserializeLegacyTransaction(data) == nil {
+			return nil
+		}
 		return err
 	}
 
@@ -385,9 +446,15 @@ func (tx *Transaction) DeserializeTransaction(data []byte) error {
 // converts transaction to slice of bytes
 // this will be used to do a hash of transactions
 func (tx Transaction) ToBytes() ([]byte, error) {
+	err := tx.verifyLegacyValues()
+
+	if err != nil {
+		return nil, err
+	}
+
 	buff := new(bytes.Buffer)
 
-	err := binary.Write(buff, binary.BigEndian, tx.ID)
+	err = binary.Write(buff, binary.BigEndian, tx.ID)
 
 	if err != nil {
 		return nil, err
@@ -405,8 +472,13 @@ func (tx Transaction) ToBytes() ([]byte, error) {
 		}
 	}
 
-	for _, vout := range tx.Vout {
+	for i, vout := range tx.Vout {
 		b, err := vout.ToBytes()
+
+		if tx.IsLegacy() {
+			b, err = vout.legacyToBytes(tx.LegacyValues[i])
+		}
+
 		if err != nil {
 			return nil, err
 		}
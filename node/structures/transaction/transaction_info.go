@@ -1,9 +1,79 @@
 package transaction
 
+import (
+	"github.com/NlaakStudios/democoin/lib/utils"
+)
+
 // Structure to display extra info related to tranactions
 type TransactionsHistory struct {
 	IOType  bool
 	TXID    []byte
 	Address string
-	Value   float64
+	Value   int64
+	Height  int // height of a block with the transaction
+}
+
+// Returns history records of the transaction for given address. Empty list if the address
+// is not used in the transaction
+func (tx Transaction) GetHistory(pubKeyHash []byte, address string) []TransactionsHistory {
+	result := []TransactionsHistory{}
+
+	income := int64(0)
+
+	spent := false
+	spentaddress := ""
+
+	// we presume all inputs in tranaction are always from same wallet
+	for _, in := range tx.Vin {
+		spentaddress, _ = utils.PubKeyToAddres(in.PubKey)
+
+		if in.UsesKey(pubKeyHash) {
+			spent = true
+			break
+		}
+	}
+
+	if spent {
+		// find how many spent , part of out can be exchange to same address
+
+		spentvalue := int64(0)
+		totalvalue := int64(0) // we need to know total if wallet sent to himself
+
+		destaddress := ""
+
+		// we agree that there can be only one destination in transaction. we don't support scripts
+		for _, out := range tx.Vout {
+			if !out.IsLockedWithKey(pubKeyHash) {
+				spentvalue += out.Value
+				destaddress, _ = utils.PubKeyHashToAddres(out.PubKeyHash)
+			}
+		}
+
+		if spentvalue > 0 {
+			result = append(result, TransactionsHistory{IOType: false, TXID: tx.ID, Address: destaddress, Value: spentvalue})
+		} else {
+			// spent to himself. this should not be usual case
+			result = append(result, TransactionsHistory{IOType: false, TXID: tx.ID, Address: address, Value: totalvalue})
+			result = append(result, TransactionsHistory{IOType: true, TXID: tx.ID, Address: address, Value: totalvalue})
+		}
+	} else if tx.IsCoinbase() {
+
+		if tx.Vout[0].IsLockedWithKey(pubKeyHash) {
+			spentaddress = "Coin base"
+			income = tx.Vout[0].Value
+		}
+	} else {
+
+		for _, out := range tx.Vout {
+
+			if out.IsLockedWithKey(pubKeyHash) {
+				income += out.Value
+			}
+		}
+	}
+
+	if income > 0 {
+		result = append(result, TransactionsHistory{IOType: true, TXID: tx.ID, Address: spentaddress, Value: income})
+	}
+	return result
 }
@@ -23,7 +23,7 @@ import (
 // Any input bitcoins not redeemed in an output is considered a transaction fee; whoever generates
 // the block can claim it by inserting it into the coinbase transaction of that block.
 type TXOutput struct {
-	Value      float64
+	Value      int64 // number of smallest units
 	PubKeyHash []byte
 }
 
@@ -31,7 +31,7 @@ type TXOutput struct {
 // It has all info in human readable format
 // this can be used to display info about outputs wihout references to transaction object
 type TXOutputIndependent struct {
-	Value          float64
+	Value          int64
 	DestPubKeyHash []byte
 	SendPubKeyHash []byte
 	TXID           []byte
@@ -71,7 +71,7 @@ func (out *TXOutputIndependent) LoadFromSimple(sout TXOutput, txid []byte, ind i
 }
 
 // NewTXOutput create a new TXOutput
-func NewTXOutput(value float64, address string) *TXOutput {
+func NewTXOutput(value int64, address string) *TXOutput {
 	txo := &TXOutput{value, nil}
 	txo.Lock([]byte(address))
 
@@ -112,7 +112,7 @@ func DeserializeOutputs(data []byte) TXOutputs {
 func (output TXOutput) String() string {
 	lines := []string{}
 
-	lines = append(lines, fmt.Sprintf("       Value:  %f", output.Value))
+	lines = append(lines, fmt.Sprintf("       Value:  %s", utils.FormatAmount(output.Value)))
 	lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
 
 	return strings.Join(lines, "\n")
@@ -25,7 +25,7 @@ func TestHash(t *testing.T) {
 		TXOutput{2, PubKey},
 	}
 
-	newTX := Transaction{nil, inputs, outputs, 0}
+	newTX := Transaction{Vin: inputs, Vout: outputs}
 
 	layout := "2006-01-02T15:04:05.000Z"
 	str := "2014-11-12T11:45:26.371Z"
@@ -0,0 +1,200 @@
+package transactions
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/blockchain"
+	"github.com/NlaakStudios/democoin/node/database"
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+// Index of history of transactions for addresses. It contains only blocks of the primary chain
+// and is updated together with unspent outputs
+type addressTransactions struct {
+	DB     database.DBManager
+	Logger *utils.LoggerMan
+}
+
+func (at addressTransactions) serializeHistory(records []transaction.TransactionsHistory) ([]byte, error) {
+	var buff bytes.Buffer
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(records)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func (at addressTransactions) deserializeHistory(data []byte) ([]transaction.TransactionsHistory, error) {
+	var records []transaction.TransactionsHistory
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Returns hashes of public keys of all addresses used in a transaction
+func (at addressTransactions) getTransactionAddresses(tx *transaction.Transaction) [][]byte {
+	hashes := [][]byte{}
+	exists := map[string]bool{}
+
+	add := func(pubKeyHash []byte) {
+		if len(pubKeyHash) == 0 || exists[string(pubKeyHash)] {
+			return
+		}
+		exists[string(pubKeyHash)] = true
+		hashes = append(hashes, pubKeyHash)
+	}
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Vin {
+			pubKeyHash, err := utils.HashPubKey(in.PubKey)
+
+			if err == nil {
+				add(pubKeyHash)
+			}
+		}
+	}
+
+	for _, out := range tx.Vout {
+		add(out.PubKeyHash)
+	}
+	return hashes
+}
+
+// Block added to the top of the primary chain. Records for all addresses of its transactions are saved
+func (at addressTransactions) UpdateOnBlockAdd(block *structures.Block) error {
+	atdb, err := at.DB.GetAddressTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		for _, pubKeyHash := range at.getTransactionAddresses(tx) {
+			address, _ := utils.PubKeyHashToAddres(pubKeyHash)
+
+			records := tx.GetHistory(pubKeyHash, address)
+
+			if len(records) == 0 {
+				continue
+			}
+
+			for i := range records {
+				records[i].Height = block.Height
+			}
+
+			data, err := at.serializeHistory(records)
+
+			if err != nil {
+				return err
+			}
+
+			err = atdb.PutTransaction(pubKeyHash, block.Height, tx.ID, data)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Block removed from the primary chain. Records of its transactions are deleted
+func (at addressTransactions) UpdateOnBlockCancel(block *structures.Block) error {
+	atdb, err := at.DB.GetAddressTransactionsObject()
+
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions {
+		for _, pubKeyHash := range at.getTransactionAddresses(tx) {
+			err = atdb.DeleteTransaction(pubKeyHash, block.Height, tx.ID)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns history of an address from the top of the chain down. See GetTransactions of the DB object for paging
+func (at addressTransactions) GetHistory(pubKeyHash []byte, beforeHeight int, maxCount int) ([]transaction.TransactionsHistory, error) {
+	atdb, err := at.DB.GetAddressTransactionsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := atdb.GetTransactions(pubKeyHash, beforeHeight, maxCount)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []transaction.TransactionsHistory{}
+
+	for _, data := range list {
+		records, err := at.deserializeHistory(data)
+
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, records...)
+	}
+	return result, nil
+}
+
+// Rebuilds the index from blocks of the primary chain. Returns number of records
+func (at addressTransactions) Reindex() (int, error) {
+	at.Logger.Trace.Println("Reindex address transactions: Prepare")
+
+	atdb, err := at.DB.GetAddressTransactionsObject()
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = atdb.TruncateDB()
+
+	if err != nil {
+		return 0, err
+	}
+
+	bci, err := blockchain.NewBlockchainIterator(at.DB)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		block, err := bci.Next()
+
+		if err != nil {
+			return 0, err
+		}
+
+		err = at.UpdateOnBlockAdd(block)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+	at.Logger.Trace.Println("Reindex address transactions: Done")
+
+	return atdb.GetCount()
+}
@@ -7,6 +7,9 @@ import (
 )
 
 const TXVerifyErrorNoInput = "noinput"
+const TXVerifyErrorTime = "time"
+const TXVerifyErrorSignature = "signature"
+const TXVerifyErrorLegacy = "legacy"
 const TXNotFoundErrorUnspent = "inunspent"
 
 type TXVerifyError struct {
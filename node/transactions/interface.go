@@ -10,26 +10,29 @@ import (
 )
 
 type UnApprovedTransactionCallbackInterface func(txhash, txstr string) error
-type UnspentTransactionOutputCallbackInterface func(fromaddr string, value float64, txID []byte, output int, isbase bool) error
+type UnspentTransactionOutputCallbackInterface func(fromaddr string, value int64, txID []byte, output int, isbase bool) error
 
 type TransactionsManagerInterface interface {
 	GetAddressBalance(address string) (wallet.WalletBalance, error)
+	GetAddressHistory(address string, beforeHeight int, maxCount int) ([]transaction.TransactionsHistory, error)
 	GetUnapprovedCount() (int, error)
 	GetUnspentCount() (int, error)
-	GetUnapprovedTransactionsForNewBlock(number int) ([]*transaction.Transaction, error)
+	GetUnapprovedTransactionsForNewBlock(number int) ([]*transaction.Transaction, int64, error)
 	GetIfExists(txid []byte) (*transaction.Transaction, error)
 	GetIfUnapprovedExists(txid []byte) (*transaction.Transaction, error)
+	GetUnapprovedByShortIDs(blockHash []byte, shortIDs [][]byte) (map[string]*transaction.Transaction, error)
 
 	VerifyTransaction(tx *transaction.Transaction, prevtxs []*transaction.Transaction, tip []byte) (bool, error)
+	VerifyTransactionWithFee(tx *transaction.Transaction, prevtxs []*transaction.Transaction, tip []byte) (bool, int64, error)
 
 	ForEachUnspentOutput(address string, callback UnspentTransactionOutputCallbackInterface) error
 	ForEachUnapprovedTransaction(callback UnApprovedTransactionCallbackInterface) (int, error)
 
 	// Create transaction methods
-	CreateTransaction(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount float64) (*transaction.Transaction, error)
+	CreateTransaction(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount int64, fee int64) (*transaction.Transaction, error)
 	ReceivedNewTransaction(tx *transaction.Transaction) error
 	ReceivedNewTransactionData(txBytes []byte, Signatures [][]byte) (*transaction.Transaction, error)
-	PrepareNewTransaction(PubKey []byte, to string, amount float64) ([]byte, [][]byte, error)
+	PrepareNewTransaction(PubKey []byte, to string, amount int64, fee int64) ([]byte, [][]byte, error)
 
 	// new block was created in blockchain DB. It must not be on top of primary blockchain
 	BlockAdded(block *structures.Block, ontopofchain bool) error
@@ -5,12 +5,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"strconv"
+	"time"
 
 	"github.com/NlaakStudios/democoin/node/structures"
 	"github.com/NlaakStudios/democoin/node/structures/transaction"
 
-	"github.com/NlaakStudios/democoin/lib"
 	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/lib/wallet"
 	"github.com/NlaakStudios/democoin/node/blockchain"
@@ -36,11 +35,21 @@ func (n txManager) getUnapprovedTransactionsManager() *unApprovedTransactions {
 	return &unApprovedTransactions{n.DB, n.Logger}
 }
 
+// Create transactions pool object to choose transactions for new block
+func (n txManager) getMemPool() *memPool {
+	return &memPool{n.DB, n.Logger}
+}
+
 // Create unspent outputx manage object to use in this package
 func (n txManager) getUnspentOutputsManager() *unspentTransactions {
 	return &unspentTransactions{n.DB, n.Logger}
 }
 
+// Create address transactions manage object to use in this package
+func (n txManager) getAddressTransactionsManager() *addressTransactions {
+	return &addressTransactions{n.DB, n.Logger}
+}
+
 // Reindex caches
 func (n *txManager) ReindexData() (map[string]int, error) {
 	err := n.getIndexManager().Reindex()
@@ -55,7 +64,13 @@ func (n *txManager) ReindexData() (map[string]int, error) {
 		return nil, err
 	}
 
-	info := map[string]int{"unspentoutputs": count}
+	addrcount, err := n.getAddressTransactionsManager().Reindex()
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]int{"unspentoutputs": count, "addresstransactions": addrcount}
 
 	return info, nil
 }
@@ -90,6 +105,19 @@ func (n *txManager) GetAddressBalance(address string) (wallet.WalletBalance, err
 	return balance, nil
 }
 
+// Returns history of transactions of address in the primary chain, from the top down.
+// Only blocks below beforeHeight are used if it is not 0. Records of one block are not split,
+// so the list can be longer than maxCount. 0 maxCount means all records
+func (n *txManager) GetAddressHistory(address string, beforeHeight int, maxCount int) ([]transaction.TransactionsHistory, error) {
+	pubKeyHash, err := utils.AddresToPubKeyHash(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return n.getAddressTransactionsManager().GetHistory(pubKeyHash, beforeHeight, maxCount)
+}
+
 // return count of transactions in pool
 func (n *txManager) GetUnapprovedCount() (int, error) {
 	return n.getUnapprovedTransactionsManager().GetCount()
@@ -102,12 +130,19 @@ func (n *txManager) GetUnspentCount() (int, error) {
 
 // return number of unapproved transactions for new block. detect conflicts
 // if there are less, it returns less than requested
-func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transaction.Transaction, error) {
-	txlist, err := n.getUnapprovedTransactionsManager().GetTransactions(number)
+// Also returns total fee of returned transactions. Miner can add it to a coinbase
+func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transaction.Transaction, int64, error) {
+	// transactions with best fee rate go first. parents are always before children
+	txlist, err := n.getMemPool().GetTransactionsByPriority(number)
+
+	if err != nil {
+		return nil, 0, err
+	}
 
 	n.Logger.Trace.Printf("Found %d transaction to mine\n", len(txlist))
 
 	txs := []*transaction.Transaction{}
+	fees := make(map[string]int64)
 
 	for _, tx := range txlist {
 		n.Logger.Trace.Printf("Go to verify: %x\n", tx.ID)
@@ -115,7 +150,16 @@ func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transac
 		// we need to verify each transaction
 		// we will do full deep check of transaction
 		// also, a transaction can have input from other transaction from thi block
-		vtx, err := n.VerifyTransaction(tx, txs, []byte{})
+		err := CheckTransactionIsNotLegacy(tx)
+
+		if err != nil {
+			// can be returned to the pool from a canceled old block. new blocks can not have it
+			n.Logger.Trace.Printf("Ignore transaction %x. %s\n", tx.ID, err.Error())
+			n.CancelTransaction(tx.ID)
+			continue
+		}
+
+		vtx, fee, err := n.VerifyTransactionWithFee(tx, txs, []byte{})
 
 		if err != nil {
 			// this can be case when a transaction is based on other unapproved transaction
@@ -129,6 +173,7 @@ func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transac
 		if vtx {
 			// transaction is valid
 			txs = append(txs, tx)
+			fees[string(tx.ID)] = fee
 		} else {
 			// the transaction is invalid. some input was already used in other confirmed transaction
 			// or somethign wrong with signatures.
@@ -142,7 +187,7 @@ func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transac
 	n.Logger.Trace.Printf("After verification %d transaction are left\n", len(txs))
 
 	if len(txs) == 0 {
-		return nil, errors.New("All transactions are invalid! Waiting for new ones...")
+		return nil, 0, errors.New("All transactions are invalid! Waiting for new ones...")
 	}
 
 	// now it is needed to check if transactions don't conflict one to other
@@ -152,7 +197,7 @@ func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transac
 	n.Logger.Trace.Printf("After conflict detection %d - fine, %d - conflicts\n", len(txs), len(badtransactions))
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if len(badtransactions) > 0 {
@@ -162,7 +207,14 @@ func (n *txManager) GetUnapprovedTransactionsForNewBlock(number int) ([]*transac
 			n.CancelTransaction(tx.ID)
 		}
 	}
-	return txs, nil
+
+	totalFee := int64(0)
+
+	for _, tx := range txs {
+		totalFee += fees[string(tx.ID)]
+	}
+
+	return txs, totalFee, nil
 }
 
 /*
@@ -186,9 +238,17 @@ func (n *txManager) CancelTransaction(txid []byte) error {
 // NOTE Transaction can have outputs of other transactions that are not yet approved.
 // This must be considered as correct case
 func (n *txManager) VerifyTransaction(tx *transaction.Transaction, prevtxs []*transaction.Transaction, tip []byte) (bool, error) {
+	valid, _, err := n.VerifyTransactionWithFee(tx, prevtxs, tip)
+
+	return valid, err
+}
+
+// Same as VerifyTransaction but also returns a fee of the transaction.
+// Fee is difference between inputs and outputs of a transaction
+func (n *txManager) VerifyTransactionWithFee(tx *transaction.Transaction, prevtxs []*transaction.Transaction, tip []byte) (bool, int64, error) {
 	inputTXs, notFoundInputs, err := n.getInputTransactionsState(tx, tip)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 
 	if len(notFoundInputs) > 0 {
@@ -196,7 +256,7 @@ func (n *txManager) VerifyTransaction(tx *transaction.Transaction, prevtxs []*tr
 		inputTXs, err = n.getUnapprovedTransactionsManager().CheckInputsWereBefore(notFoundInputs, prevtxs, inputTXs)
 
 		if err != nil {
-			return false, err
+			return false, 0, err
 		}
 	}
 	// do final check against inputs
@@ -204,10 +264,21 @@ func (n *txManager) VerifyTransaction(tx *transaction.Transaction, prevtxs []*tr
 	err = tx.Verify(inputTXs)
 
 	if err != nil {
-		return false, err
+		return false, 0, NewTXVerifyError(err.Error(), TXVerifyErrorSignature, tx.ID)
 	}
 
-	return true, nil
+	fee, err := tx.GetFee(inputTXs)
+
+	if err != nil {
+		return false, 0, err
+	}
+
+	return true, fee, nil
+}
+
+// Returns unapproved transactions matching short IDs of a compact block
+func (n *txManager) GetUnapprovedByShortIDs(blockHash []byte, shortIDs [][]byte) (map[string]*transaction.Transaction, error) {
+	return n.getUnapprovedTransactionsManager().GetByShortIDs(blockHash, shortIDs)
 }
 
 // Iterate over unapproved transactions, for example to display them . Accepts callback as argument
@@ -226,13 +297,17 @@ func (n *txManager) CleanUnapprovedCache() error {
 }
 
 // to execute when new block added . the block must not be on top
+// Errors are returned, so a caller can cancel the whole change of the DB
 func (n *txManager) BlockAdded(block *structures.Block, ontopofchain bool) error {
 	// update caches
-	n.getIndexManager().BlockAdded(block)
+	err := n.getIndexManager().BlockAdded(block)
+
+	if err != nil {
+		return err
+	}
 
 	if ontopofchain {
-		n.getUnapprovedTransactionsManager().DeleteFromBlock(block)
-		n.getUnspentOutputsManager().UpdateOnBlockAdd(block)
+		return n.BlockAddedToPrimaryChain(block)
 	}
 
 	return nil
@@ -240,24 +315,44 @@ func (n *txManager) BlockAdded(block *structures.Block, ontopofchain bool) error
 
 // Block was removed from the top of primary blockchain branch
 func (n *txManager) BlockRemoved(block *structures.Block) error {
-	n.getUnapprovedTransactionsManager().AddFromCanceled(block.Transactions)
-	n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
-	n.getIndexManager().BlockRemoved(block)
-	return nil
+	err := n.BlockRemovedFromPrimaryChain(block)
+
+	if err != nil {
+		return err
+	}
+	return n.getIndexManager().BlockRemoved(block)
 }
 
 // block is now added to primary chain. it existed in DB before
 func (n *txManager) BlockAddedToPrimaryChain(block *structures.Block) error {
-	n.getUnapprovedTransactionsManager().DeleteFromBlock(block)
-	n.getUnspentOutputsManager().UpdateOnBlockAdd(block)
-	return nil
+	err := n.getUnapprovedTransactionsManager().DeleteFromBlock(block)
+
+	if err != nil {
+		return err
+	}
+
+	err = n.getUnspentOutputsManager().UpdateOnBlockAdd(block)
+
+	if err != nil {
+		return err
+	}
+	return n.getAddressTransactionsManager().UpdateOnBlockAdd(block)
 }
 
 // block is removed from primary chain. it continued to be in DB on side branch
 func (n *txManager) BlockRemovedFromPrimaryChain(block *structures.Block) error {
-	n.getUnapprovedTransactionsManager().AddFromCanceled(block.Transactions)
-	n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
-	return nil
+	err := n.getUnapprovedTransactionsManager().AddFromCanceled(block.Transactions)
+
+	if err != nil {
+		return err
+	}
+
+	err = n.getUnspentOutputsManager().UpdateOnBlockCancel(block)
+
+	if err != nil {
+		return err
+	}
+	return n.getAddressTransactionsManager().UpdateOnBlockCancel(block)
 }
 
 // Send amount of money if a node is not running.
@@ -266,16 +361,19 @@ func (n *txManager) BlockRemovedFromPrimaryChain(block *structures.Block) error
 //
 // Returns new transaction hash. This return can be used to try to send transaction
 // to other nodes or to try mining
-func (n *txManager) CreateTransaction(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount float64) (*transaction.Transaction, error) {
+func (n *txManager) CreateTransaction(PubKey []byte, privKey ecdsa.PrivateKey, to string, amount int64, fee int64) (*transaction.Transaction, error) {
 
 	if amount <= 0 {
 		return nil, errors.New("Amount must be positive value")
 	}
+	if fee < 0 {
+		return nil, errors.New("Fee can not be negative value")
+	}
 	if to == "" {
 		return nil, errors.New("Recipient address is not provided")
 	}
 
-	txBytes, DataToSign, err := n.PrepareNewTransaction(PubKey, to, amount)
+	txBytes, DataToSign, err := n.PrepareNewTransaction(PubKey, to, amount, fee)
 
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Prepare error: %s", err.Error()))
@@ -322,6 +420,17 @@ func (n *txManager) ReceivedNewTransactionData(txBytes []byte, Signatures [][]by
 
 // New transaction reveived from other node. We need to verify and add to cache of unapproved
 func (n *txManager) ReceivedNewTransaction(tx *transaction.Transaction) error {
+	err := CheckTransactionIsNotLegacy(tx)
+
+	if err != nil {
+		return err
+	}
+
+	err = CheckTransactionTime(tx, time.Now().UTC().UnixNano())
+
+	if err != nil {
+		return err
+	}
 	// verify this transaction
 	good, err := n.verifyTransactionQuick(tx)
 
@@ -338,26 +447,28 @@ func (n *txManager) ReceivedNewTransaction(tx *transaction.Transaction) error {
 // Request to make new transaction and prepare data to sign
 // This function should find good input transactions for this amount
 // Including inputs from unapproved transactions if no good approved transactions yet
-func (n *txManager) PrepareNewTransaction(PubKey []byte, to string, amount float64) ([]byte, [][]byte, error) {
-	amount, err := strconv.ParseFloat(fmt.Sprintf("%.8f", amount), 64)
-
-	if err != nil {
-		return nil, nil, err
+// Inputs must cover the amount and a fee. Fee is what is not returned as a change
+func (n *txManager) PrepareNewTransaction(PubKey []byte, to string, amount int64, fee int64) ([]byte, [][]byte, error) {
+	if fee < 0 {
+		return nil, nil, errors.New("Fee can not be negative value")
 	}
+	// inputs must cover both
+	needamount := amount + fee
+
 	PubKeyHash, _ := utils.HashPubKey(PubKey)
 	// get from pending transactions. find outputs used by this pubkey
 	pendinginputs, pendingoutputs, _, err := n.getUnapprovedTransactionsManager().GetPreparedBy(PubKeyHash)
 	n.Logger.Trace.Printf("Pending transactions state: %d- inputs, %d - unspent outputs", len(pendinginputs), len(pendingoutputs))
 
-	inputs, prevTXs, totalamount, err := n.getUnspentOutputsManager().GetNewTransactionInputs(PubKey, to, amount, pendinginputs)
+	inputs, prevTXs, totalamount, err := n.getUnspentOutputsManager().GetNewTransactionInputs(PubKey, to, needamount, pendinginputs)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	n.Logger.Trace.Printf("First step prepared amount %f of %f", totalamount, amount)
+	n.Logger.Trace.Printf("First step prepared amount %d of %d", totalamount, needamount)
 
-	if totalamount < amount {
+	if totalamount < needamount {
 		// no anough funds in confirmed transactions
 		// pending must be used
 
@@ -366,7 +477,7 @@ func (n *txManager) PrepareNewTransaction(PubKey []byte, to string, amount float
 			return nil, nil, errors.New("No enough funds for requested transaction")
 		}
 		inputs, prevTXs, totalamount, err =
-			n.getUnspentOutputsManager().ExtendNewTransactionInputs(PubKey, amount, totalamount,
+			n.getUnspentOutputsManager().ExtendNewTransactionInputs(PubKey, needamount, totalamount,
 				inputs, prevTXs, pendingoutputs)
 
 		if err != nil {
@@ -374,18 +485,18 @@ func (n *txManager) PrepareNewTransaction(PubKey []byte, to string, amount float
 		}
 	}
 
-	n.Logger.Trace.Printf("Second step prepared amount %f of %f", totalamount, amount)
+	n.Logger.Trace.Printf("Second step prepared amount %d of %d", totalamount, needamount)
 
-	if totalamount < amount {
+	if totalamount < needamount {
 		return nil, nil, errors.New("No anough funds to make new transaction")
 	}
 
-	return n.prepareNewTransactionComplete(PubKey, to, amount, inputs, totalamount, prevTXs)
+	return n.prepareNewTransactionComplete(PubKey, to, amount, fee, inputs, totalamount, prevTXs)
 }
 
 //
-func (n *txManager) prepareNewTransactionComplete(PubKey []byte, to string, amount float64,
-	inputs []transaction.TXInput, totalamount float64, prevTXs map[string]transaction.Transaction) ([]byte, [][]byte, error) {
+func (n *txManager) prepareNewTransactionComplete(PubKey []byte, to string, amount int64, fee int64,
+	inputs []transaction.TXInput, totalamount int64, prevTXs map[string]transaction.Transaction) ([]byte, [][]byte, error) {
 
 	var outputs []transaction.TXOutput
 
@@ -393,8 +504,11 @@ func (n *txManager) prepareNewTransactionComplete(PubKey []byte, to string, amou
 	from, _ := utils.PubKeyToAddres(PubKey)
 	outputs = append(outputs, *transaction.NewTXOutput(amount, to))
 
-	if totalamount > amount && totalamount-amount > lib.SmallestUnit {
-		outputs = append(outputs, *transaction.NewTXOutput(totalamount-amount, from)) // a change
+	// all what is not returned as a change is a fee
+	change := totalamount - amount - fee
+
+	if change > 0 {
+		outputs = append(outputs, *transaction.NewTXOutput(change, from)) // a change
 	}
 
 	inputTXs := make(map[int]*transaction.Transaction)
@@ -404,7 +518,7 @@ func (n *txManager) prepareNewTransactionComplete(PubKey []byte, to string, amou
 		inputTXs[vinInd] = &tx
 	}
 
-	tx := transaction.Transaction{nil, inputs, outputs, 0}
+	tx := transaction.Transaction{Vin: inputs, Vout: outputs}
 	tx.TimeNow()
 
 	signdata, err := tx.PrepareSignData(inputTXs)
@@ -449,7 +563,7 @@ func (n *txManager) GetIfUnapprovedExists(txid []byte) (*transaction.Transaction
 }
 
 // Calculates pending balance of address.
-func (n *txManager) getAddressPendingBalance(address string) (float64, error) {
+func (n *txManager) getAddressPendingBalance(address string) (int64, error) {
 	PubKeyHash, _ := utils.AddresToPubKeyHash(address)
 
 	// inputs this is what a wallet spent from his real approved balance
@@ -461,7 +575,7 @@ func (n *txManager) getAddressPendingBalance(address string) (float64, error) {
 		return 0, err
 	}
 
-	pendingbalance := float64(0)
+	pendingbalance := int64(0)
 
 	for _, o := range outputs {
 		// this is amount sent to this wallet and this
@@ -527,7 +641,7 @@ func (n *txManager) verifyTransactionQuick(tx *transaction.Transaction) (bool, e
 	err = tx.Verify(inputTXs)
 
 	if err != nil {
-		return false, err
+		return false, NewTXVerifyError(err.Error(), TXVerifyErrorSignature, tx.ID)
 	}
 	return true, nil
 }
@@ -0,0 +1,40 @@
+package transactions
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+func TestReceivedLegacyTransaction(t *testing.T) {
+	man := getTestDBManager(t)
+
+	defer os.RemoveAll(testFolderName)
+	defer man.CloseConnection()
+
+	txm := NewManager(man, utils.CreateLogger())
+
+	// hash of legacy transaction is its ID, so it could take ID of any other transaction
+	tx := makeTestTransaction(1, []transaction.TXInput{{Txid: []byte{2}, Vout: 0}}, 100)
+	tx.Time = time.Now().UTC().UnixNano()
+	tx.LegacyValues = []float64{0.000001}
+
+	err := txm.ReceivedNewTransaction(tx)
+
+	if verr, ok := err.(*TXVerifyError); !ok || verr.GetKind() != TXVerifyErrorLegacy {
+		t.Fatalf("Expected legacy error for new transaction, got: %v", err)
+	}
+
+	count, err := txm.GetUnapprovedCount()
+
+	if err != nil {
+		t.Fatalf("Can not count transactions in pool: %s", err.Error())
+	}
+
+	if count != 0 {
+		t.Fatalf("Legacy transaction is added to the pool")
+	}
+}
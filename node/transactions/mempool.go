@@ -0,0 +1,204 @@
+package transactions
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/database"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+// Pool of unapproved transactions ordered by priority for new blocks.
+// Priority is a fee rate. Fee per byte of serialized transaction
+type memPool struct {
+	DB     database.DBManager
+	Logger *utils.LoggerMan
+}
+
+// Transaction in the pool with info needed to order it
+type memPoolEntry struct {
+	tx      *transaction.Transaction
+	fee     int64
+	size    int
+	parents []string // IDs of unapproved transactions this transaction spends outputs of
+}
+
+// Returns up to number of transactions with best fee rate. Parent transactions are always
+// returned before child transactions. A child with high fee pulls own parents to a block
+func (m *memPool) GetTransactionsByPriority(number int) ([]*transaction.Transaction, error) {
+	entries, err := m.getEntries()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return selectTransactionsByPriority(entries, number), nil
+}
+
+// Loads all unapproved transactions and calculates fee and size for each
+func (m *memPool) getEntries() (map[string]*memPoolEntry, error) {
+	utdb, err := m.DB.GetUnapprovedTransactionsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*memPoolEntry)
+
+	err = utdb.ForEach(func(k, txBytes []byte) error {
+		tx := transaction.Transaction{}
+		err := tx.DeserializeTransaction(txBytes)
+
+		if err != nil {
+			return err
+		}
+
+		entries[hex.EncodeToString(tx.ID)] = &memPoolEntry{tx: &tx, size: len(txBytes)}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unspent := unspentTransactions{m.DB, m.Logger}
+
+	for _, e := range entries {
+		fee := int64(0)
+		feeKnown := true
+
+		for _, vin := range e.tx.Vin {
+			txstr := hex.EncodeToString(vin.Txid)
+
+			if parent, ok := entries[txstr]; ok {
+				// input from other unapproved transaction
+				e.parents = append(e.parents, txstr)
+
+				if vin.Vout >= 0 && vin.Vout < len(parent.tx.Vout) {
+					fee += parent.tx.Vout[vin.Vout].Value
+				}
+				continue
+			}
+
+			v, err := unspent.GetInputValue(vin)
+
+			if err != nil {
+				// input is not in unspent outputs. such transaction will be canceled
+				// on verification. no sense to give it priority
+				feeKnown = false
+				continue
+			}
+			fee += v
+		}
+
+		for _, vout := range e.tx.Vout {
+			fee -= vout.Value
+		}
+
+		if feeKnown && fee > 0 {
+			e.fee = fee
+		}
+	}
+
+	return entries, nil
+}
+
+// Order transactions by fee rate of a transaction with all its unapproved ancestors
+// and choose first number of transactions. Ancestors are added before a transaction
+func selectTransactionsByPriority(entries map[string]*memPoolEntry, number int) []*transaction.Transaction {
+	// all unapproved ancestors for each transaction
+	ancestors := make(map[string]map[string]bool)
+
+	var findAncestors func(id string) map[string]bool
+
+	findAncestors = func(id string) map[string]bool {
+		if a, ok := ancestors[id]; ok {
+			return a
+		}
+		a := make(map[string]bool)
+		// set before recursion. protects from loops in wrong data
+		ancestors[id] = a
+
+		for _, p := range entries[id].parents {
+			a[p] = true
+
+			for pa := range findAncestors(p) {
+				a[pa] = true
+			}
+		}
+		return a
+	}
+
+	ids := []string{}
+	scores := make(map[string]float64)
+
+	for id, e := range entries {
+		fee := float64(e.fee)
+		size := e.size
+
+		for a := range findAncestors(id) {
+			fee += float64(entries[a].fee)
+			size += entries[a].size
+		}
+
+		if size < 1 {
+			size = 1
+		}
+
+		scores[id] = fee / float64(size)
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		// older first if rate is same
+		if entries[ids[i]].tx.Time != entries[ids[j]].tx.Time {
+			return entries[ids[i]].tx.Time < entries[ids[j]].tx.Time
+		}
+		return ids[i] < ids[j]
+	})
+
+	selected := make(map[string]bool)
+	result := []*transaction.Transaction{}
+
+	// collects not selected ancestors of a transaction. parents go first
+	var addPackage func(id string, pkg []string, inpkg map[string]bool) []string
+
+	addPackage = func(id string, pkg []string, inpkg map[string]bool) []string {
+		if selected[id] || inpkg[id] {
+			return pkg
+		}
+		inpkg[id] = true
+
+		for _, p := range entries[id].parents {
+			pkg = addPackage(p, pkg, inpkg)
+		}
+		return append(pkg, id)
+	}
+
+	for _, id := range ids {
+		if len(result) >= number {
+			break
+		}
+		if selected[id] {
+			continue
+		}
+
+		pkg := addPackage(id, []string{}, make(map[string]bool))
+
+		if len(result)+len(pkg) > number {
+			// doesn't fit together with parents. maybe other smaller package fits
+			continue
+		}
+
+		for _, pid := range pkg {
+			selected[pid] = true
+			result = append(result, entries[pid].tx)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,49 @@
+package transactions
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+func makeTestPoolEntry(entries map[string]*memPoolEntry, id byte, fee int64, size int, parents ...byte) {
+	tx := &transaction.Transaction{ID: []byte{id}, Time: int64(id)}
+	e := &memPoolEntry{tx: tx, fee: fee, size: size}
+
+	for _, p := range parents {
+		e.parents = append(e.parents, hex.EncodeToString([]byte{p}))
+	}
+	entries[hex.EncodeToString(tx.ID)] = e
+}
+
+func TestSelectTransactionsByPriority(t *testing.T) {
+	entries := map[string]*memPoolEntry{}
+
+	makeTestPoolEntry(entries, 1, 1, 100)     // low rate
+	makeTestPoolEntry(entries, 2, 10, 100)    // high rate
+	makeTestPoolEntry(entries, 3, 0, 100)     // no fee parent
+	makeTestPoolEntry(entries, 4, 30, 100, 3) // high fee child pulls parent
+	makeTestPoolEntry(entries, 5, 5, 100)
+
+	txs := selectTransactionsByPriority(entries, 10)
+
+	expected := []byte{3, 4, 2, 5, 1}
+
+	if len(txs) != len(expected) {
+		t.Fatalf("Got %d transactions, expected %d", len(txs), len(expected))
+	}
+
+	for i, tx := range txs {
+		if tx.ID[0] != expected[i] {
+			t.Fatalf("Position %d has transaction %d, expected %d", i, tx.ID[0], expected[i])
+		}
+	}
+
+	// limit. package of 2 doesn't fit after first transaction
+	txs = selectTransactionsByPriority(entries, 1)
+
+	if len(txs) != 1 || txs[0].ID[0] != 2 {
+		t.Fatalf("Expected only transaction 2 with limit 1")
+	}
+}
@@ -205,7 +205,7 @@ func (u *unApprovedTransactions) GetPreparedBy(PubKeyHash []byte) ([]transaction
 }
 
 // Get input value for TX in the cache
-func (u *unApprovedTransactions) GetInputValue(input transaction.TXInput) (float64, error) {
+func (u *unApprovedTransactions) GetInputValue(input transaction.TXInput) (int64, error) {
 	u.Logger.Trace.Printf("Find TX %x in unapproved", input.Txid)
 	tx, err := u.GetIfExists(input.Txid)
 
@@ -249,6 +249,58 @@ func (u *unApprovedTransactions) GetIfExists(txid []byte) (*transaction.Transact
 
 }
 
+// Find transactions by short IDs of a compact block. Returns map of short IDs to transactions
+// Short IDs matching more than one transaction are not returned, such transactions must be requested
+func (u *unApprovedTransactions) GetByShortIDs(blockHash []byte, shortIDs [][]byte) (map[string]*transaction.Transaction, error) {
+	utdb, err := u.DB.GetUnapprovedTransactionsObject()
+
+	if err != nil {
+		return nil, err
+	}
+
+	needed := map[string]bool{}
+
+	for _, id := range shortIDs {
+		needed[string(id)] = true
+	}
+
+	found := map[string]*transaction.Transaction{}
+	ambiguous := map[string]bool{}
+
+	err = utdb.ForEach(func(txID, txBytes []byte) error {
+		shortID := string(structures.GetShortTransactionID(blockHash, txID))
+
+		if !needed[shortID] {
+			return nil
+		}
+
+		if _, ok := found[shortID]; ok {
+			ambiguous[shortID] = true
+			return nil
+		}
+
+		tx := transaction.Transaction{}
+		err := tx.DeserializeTransaction(txBytes)
+
+		if err != nil {
+			return err
+		}
+		found[shortID] = &tx
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for shortID := range ambiguous {
+		delete(found, shortID)
+	}
+
+	return found, nil
+}
+
 // Get all unapproved transactions
 func (u *unApprovedTransactions) GetTransactions(number int) ([]*transaction.Transaction, error) {
 	utdb, err := u.DB.GetUnapprovedTransactionsObject()
@@ -0,0 +1,39 @@
+package transactions
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NlaakStudios/democoin/node/config"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+// Checks time of a transaction is sane. It must be set and must not be ahead of given
+// time more than allowed drift. Time is in nanoseconds as it is in a transaction
+// Coinbase transactions don't have time
+func CheckTransactionTime(tx *transaction.Transaction, now int64) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	if tx.Time <= 0 {
+		return NewTXVerifyError("Transaction time is not set", TXVerifyErrorTime, tx.ID)
+	}
+
+	maxTime := now + int64(config.MaxFutureTransactionTime)*int64(time.Second)
+
+	if tx.Time > maxTime {
+		return NewTXVerifyError(fmt.Sprintf("Transaction time %d is too far in the future. Max %d", tx.Time, maxTime),
+			TXVerifyErrorTime, tx.ID)
+	}
+	return nil
+}
+
+// Checks a new transaction is in the current format. Legacy format is allowed only for transactions
+// of old blocks. Hash of a legacy transaction is its stored ID, so a new one could take ID of other transaction
+func CheckTransactionIsNotLegacy(tx *transaction.Transaction) error {
+	if tx.IsLegacy() {
+		return NewTXVerifyError("New transaction can not be in legacy format", TXVerifyErrorLegacy, tx.ID)
+	}
+	return nil
+}
@@ -58,7 +58,7 @@ func (u unspentTransactions) deserializeOutputs(data []byte) ([]transaction.TXOu
 /*
 * Calculates address balance using the cache of unspent transactions outputs
  */
-func (u unspentTransactions) GetAddressBalance(address string) (float64, error) {
+func (u unspentTransactions) GetAddressBalance(address string) (int64, error) {
 	if address == "" {
 		return 0, errors.New("Address is missed")
 	}
@@ -68,7 +68,7 @@ func (u unspentTransactions) GetAddressBalance(address string) (float64, error)
 		return 0, errors.New("Address is not valid")
 	}
 
-	balance := float64(0)
+	balance := int64(0)
 
 	UnspentTXs, err2 := u.GetunspentTransactionsOutputs(address)
 
@@ -83,7 +83,7 @@ func (u unspentTransactions) GetAddressBalance(address string) (float64, error)
 }
 
 // CGet input value. Input is unspent TX output
-func (u unspentTransactions) GetInputValue(input transaction.TXInput) (float64, error) {
+func (u unspentTransactions) GetInputValue(input transaction.TXInput) (int64, error) {
 
 	uodb, err := u.DB.GetUnspentOutputsObject()
 
@@ -118,8 +118,8 @@ func (u unspentTransactions) GetInputValue(input transaction.TXInput) (float64,
 }
 
 // Choose inputs for new transaction
-func (u unspentTransactions) ChooseSpendableOutputs(pubKeyHash []byte, amount float64,
-	pendinguse []transaction.TXInput) (float64, []transaction.TXOutputIndependent, error) {
+func (u unspentTransactions) ChooseSpendableOutputs(pubKeyHash []byte, amount int64,
+	pendinguse []transaction.TXInput) (int64, []transaction.TXOutputIndependent, error) {
 
 	uodb, err := u.DB.GetUnspentOutputsObject()
 
@@ -128,7 +128,7 @@ func (u unspentTransactions) ChooseSpendableOutputs(pubKeyHash []byte, amount fl
 	}
 
 	unspentOutputs := []transaction.TXOutputIndependent{}
-	accumulated := float64(0)
+	accumulated := int64(0)
 
 	err = uodb.ForEach(func(txID, txData []byte) error {
 		outs, err := u.deserializeOutputs(txData)
@@ -325,6 +325,7 @@ func (u unspentTransactions) Reindex() (int, error) {
 		return 0, err
 	}
 
+	// undo data of blocks is removed too. blocks connected before reindex are canceled with the index
 	err = uodb.TruncateDB()
 
 	if err != nil {
@@ -468,6 +469,9 @@ func (u unspentTransactions) UpdateOnBlockAdd(block *structures.Block) error {
 
 	u.Logger.Trace.Printf("UPdate UTXO on block add %x", block.Hash)
 
+	// outputs spent by the block. they are returned to unspent from this list when the block is disconnected
+	spentOuts := []transaction.TXOutputIndependent{}
+
 	for _, tx := range block.Transactions {
 		u.Logger.Trace.Printf("UpdateOnBlockAdd check tx %x", tx.ID)
 		sender := []byte{}
@@ -498,6 +502,8 @@ func (u unspentTransactions) UpdateOnBlockAdd(block *structures.Block) error {
 				for _, out := range outs {
 					if out.OIndex != vin.Vout {
 						updatedOuts = append(updatedOuts, out)
+					} else {
+						spentOuts = append(spentOuts, out)
 					}
 				}
 
@@ -541,7 +547,13 @@ func (u unspentTransactions) UpdateOnBlockAdd(block *structures.Block) error {
 		}
 	}
 
-	return nil
+	undoData, err := u.serializeOutputs(spentOuts)
+
+	if err != nil {
+		return err
+	}
+
+	return uodb.PutBlockUndo(block.Hash, undoData)
 }
 
 // This is executed when a block is canceled.
@@ -554,6 +566,34 @@ func (u unspentTransactions) UpdateOnBlockCancel(block *structures.Block) error
 		return err
 	}
 
+	undoData, err := uodb.GetBlockUndo(block.Hash)
+
+	if err != nil {
+		return err
+	}
+
+	if undoData != nil {
+		err = u.cancelBlockWithUndo(block, undoData)
+	} else {
+		// blocks connected before undo data was saved or before reindex
+		err = u.cancelBlockWithIndex(block)
+	}
+
+	if err != nil {
+		return err
+	}
+	// the block is on a side branch now. undo data is saved again if the block is connected again
+	return uodb.DeleteBlockUndo(block.Hash)
+}
+
+// Block is canceled and there is no undo data for it. Spent outputs are found with the transactions index
+func (u unspentTransactions) cancelBlockWithIndex(block *structures.Block) error {
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return err
+	}
+
 	//u.Logger.Trace.Printf("block cancel at unspent %x , prev hash %x", block.Hash, block.PrevBlockHash) //REM
 
 	for _, tx := range block.Transactions {
@@ -634,14 +674,93 @@ func (u unspentTransactions) UpdateOnBlockCancel(block *structures.Block) error
 	return nil
 }
 
+// Block is canceled and undo data exists for it. Outputs spent by the block are returned
+// to unspent as they were before the block, outputs created by the block are deleted
+func (u unspentTransactions) cancelBlockWithUndo(block *structures.Block, undoData []byte) error {
+	uodb, err := u.DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		return err
+	}
+
+	spentOuts, err := u.deserializeOutputs(undoData)
+
+	if err != nil {
+		return err
+	}
+
+	u.Logger.Trace.Printf("Cancel block %x with undo data, %d outputs to restore", block.Hash, len(spentOuts))
+
+	for _, spent := range spentOuts {
+		outs := []transaction.TXOutputIndependent{}
+
+		outsBytes, err := uodb.GetDataForTransaction(spent.TXID)
+
+		if err != nil {
+			return err
+		}
+
+		if outsBytes != nil {
+			outs, err = u.deserializeOutputs(outsBytes)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		exists := false
+
+		for _, out := range outs {
+			if out.OIndex == spent.OIndex {
+				exists = true
+				break
+			}
+		}
+
+		if exists {
+			continue
+		}
+
+		outs = append(outs, spent)
+
+		// keep order of outputs in a transaction
+		sort.Slice(outs, func(i, j int) bool {
+			return outs[i].OIndex < outs[j].OIndex
+		})
+
+		txData, err := u.serializeOutputs(outs)
+
+		if err != nil {
+			return err
+		}
+
+		err = uodb.PutDataForTransaction(spent.TXID, txData)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// outputs of transactions of the block. it is done after restoring, because transactions
+	// of the block can spend outputs of previous transactions in same block
+	for _, tx := range block.Transactions {
+		err = uodb.DeleteDataForTransaction(tx.ID)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Find inputs for new transaction. Receives list of pending inputs used in other
 // not yet confirmed transactions
 // Returns list of inputs prepared. Even if less then requested
 // Returns previous transactions. It later will be used to prepare data to sign
-func (u unspentTransactions) GetNewTransactionInputs(PubKey []byte, to string, amount float64,
-	pendinguse []transaction.TXInput) ([]transaction.TXInput, map[string]transaction.Transaction, float64, error) {
+func (u unspentTransactions) GetNewTransactionInputs(PubKey []byte, to string, amount int64,
+	pendinguse []transaction.TXInput) ([]transaction.TXInput, map[string]transaction.Transaction, int64, error) {
 
-	localError := func(err error) ([]transaction.TXInput, map[string]transaction.Transaction, float64, error) {
+	localError := func(err error) ([]transaction.TXInput, map[string]transaction.Transaction, int64, error) {
 		return nil, nil, 0, err
 	}
 
@@ -681,9 +800,9 @@ func (u unspentTransactions) GetNewTransactionInputs(PubKey []byte, to string, a
 }
 
 // Returns previous transactions. It later will be used to prepare data to sign
-func (u unspentTransactions) ExtendNewTransactionInputs(PubKey []byte, amount, totalamount float64,
+func (u unspentTransactions) ExtendNewTransactionInputs(PubKey []byte, amount, totalamount int64,
 	inputs []transaction.TXInput, prevTXs map[string]transaction.Transaction,
-	pendingoutputs []*transaction.TXOutputIndependent) ([]transaction.TXInput, map[string]transaction.Transaction, float64, error) {
+	pendingoutputs []*transaction.TXOutputIndependent) ([]transaction.TXInput, map[string]transaction.Transaction, int64, error) {
 
 	// Build a list of inputs
 	for _, out := range pendingoutputs {
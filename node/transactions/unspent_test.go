@@ -0,0 +1,189 @@
+package transactions
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/NlaakStudios/democoin/lib/utils"
+	"github.com/NlaakStudios/democoin/node/database"
+	"github.com/NlaakStudios/democoin/node/database/databasetest"
+	"github.com/NlaakStudios/democoin/node/structures"
+	"github.com/NlaakStudios/democoin/node/structures/transaction"
+)
+
+const testFolderName = "testdata"
+
+func getTestDBManager(t *testing.T) *database.KVDBManager {
+	os.RemoveAll(testFolderName)
+
+	obj, err := databasetest.NewDBManager(testFolderName+"/", "")
+
+	if err != nil {
+		t.Fatalf("Can not prepare DB: %s", err.Error())
+	}
+	return obj
+}
+
+func getTestUnspentSet(t *testing.T, DB database.DBManager) map[string][]byte {
+	uodb, err := DB.GetUnspentOutputsObject()
+
+	if err != nil {
+		t.Fatalf("Can not get unspent outputs object: %s", err.Error())
+	}
+
+	set := map[string][]byte{}
+
+	err = uodb.ForEach(func(txID, txData []byte) error {
+		set[string(txID)] = utils.CopyBytes(txData)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Can not read unspent outputs: %s", err.Error())
+	}
+	return set
+}
+
+func makeTestTransaction(id byte, inputs []transaction.TXInput, values ...int64) *transaction.Transaction {
+	tx := &transaction.Transaction{ID: []byte{id}, Vin: inputs}
+
+	for i, v := range values {
+		tx.Vout = append(tx.Vout, transaction.TXOutput{Value: v, PubKeyHash: []byte{id, byte(i)}})
+	}
+	return tx
+}
+
+func TestUnspentConnectDisconnect(t *testing.T) {
+	man := getTestDBManager(t)
+
+	defer os.RemoveAll(testFolderName)
+	defer man.CloseConnection()
+
+	u := unspentTransactions{man, utils.CreateLogger()}
+
+	coinbase := []transaction.TXInput{{Txid: []byte{}, Vout: -1}}
+
+	// first block has two transactions with outputs
+	block1 := &structures.Block{Hash: []byte{1}, Height: 0}
+	block1.Transactions = []*transaction.Transaction{
+		makeTestTransaction(10, coinbase, 10),
+		makeTestTransaction(11, coinbase, 5, 6, 7),
+	}
+
+	err := u.UpdateOnBlockAdd(block1)
+
+	if err != nil {
+		t.Fatalf("Connect block 1 error: %s", err.Error())
+	}
+
+	before := getTestUnspentSet(t, man)
+
+	// second block spends outputs of the first block and an output created in same block
+	block2 := &structures.Block{Hash: []byte{2}, PrevBlockHash: []byte{1}, Height: 1}
+	block2.Transactions = []*transaction.Transaction{
+		makeTestTransaction(20, coinbase, 10),
+		makeTestTransaction(21, []transaction.TXInput{
+			{Txid: []byte{10}, Vout: 0, PubKey: []byte{1}},
+			{Txid: []byte{11}, Vout: 1, PubKey: []byte{1}},
+		}, 15, 1),
+		makeTestTransaction(22, []transaction.TXInput{{Txid: []byte{21}, Vout: 0, PubKey: []byte{2}}}, 15),
+	}
+
+	err = man.ExecuteInTransaction(func() error {
+		return u.UpdateOnBlockAdd(block2)
+	})
+
+	if err != nil {
+		t.Fatalf("Connect block 2 error: %s", err.Error())
+	}
+
+	after := getTestUnspentSet(t, man)
+
+	if _, ok := after[string([]byte{10})]; ok {
+		t.Fatalf("Spent transaction is still in unspent set")
+	}
+
+	if len(after) != 4 {
+		t.Fatalf("Expected 4 transactions in unspent set after connect, got %d", len(after))
+	}
+
+	uodb, _ := man.GetUnspentOutputsObject()
+
+	undo, err := uodb.GetBlockUndo(block2.Hash)
+
+	if err != nil || undo == nil {
+		t.Fatalf("Undo data is not saved for a block")
+	}
+
+	err = man.ExecuteInTransaction(func() error {
+		return u.UpdateOnBlockCancel(block2)
+	})
+
+	if err != nil {
+		t.Fatalf("Disconnect block 2 error: %s", err.Error())
+	}
+
+	restored := getTestUnspentSet(t, man)
+
+	if len(restored) != len(before) {
+		t.Fatalf("Expected %d transactions in unspent set after disconnect, got %d", len(before), len(restored))
+	}
+
+	for txID, txData := range before {
+		if !bytes.Equal(restored[txID], txData) {
+			t.Fatalf("Unspent outputs of %x are not restored", txID)
+		}
+	}
+
+	undo, err = uodb.GetBlockUndo(block2.Hash)
+
+	if err != nil || undo != nil {
+		t.Fatalf("Undo data is not deleted after disconnect")
+	}
+}
+
+func TestUnspentTruncateRemovesUndo(t *testing.T) {
+	man := getTestDBManager(t)
+
+	defer os.RemoveAll(testFolderName)
+	defer man.CloseConnection()
+
+	u := unspentTransactions{man, utils.CreateLogger()}
+
+	coinbase := []transaction.TXInput{{Txid: []byte{}, Vout: -1}}
+
+	block := &structures.Block{Hash: []byte{1}, Height: 0}
+	block.Transactions = []*transaction.Transaction{makeTestTransaction(10, coinbase, 10)}
+
+	err := u.UpdateOnBlockAdd(block)
+
+	if err != nil {
+		t.Fatalf("Connect block error: %s", err.Error())
+	}
+
+	uodb, _ := man.GetUnspentOutputsObject()
+
+	err = uodb.TruncateDB()
+
+	if err != nil {
+		t.Fatalf("Truncate error: %s", err.Error())
+	}
+
+	undo, err := uodb.GetBlockUndo(block.Hash)
+
+	if err != nil || undo != nil {
+		t.Fatalf("Undo data is not removed on truncate")
+	}
+
+	if len(getTestUnspentSet(t, man)) != 0 {
+		t.Fatalf("Unspent outputs are not removed on truncate")
+	}
+
+	// undo data can be saved after truncate
+	err = u.UpdateOnBlockAdd(block)
+
+	if err != nil {
+		t.Fatalf("Connect block after truncate error: %s", err.Error())
+	}
+}
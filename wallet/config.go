@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 
+	"github.com/NlaakStudios/democoin/lib/utils"
 	"github.com/NlaakStudios/democoin/lib/wallet"
 )
 
@@ -26,8 +27,11 @@ func GetAppInput() (wallet.AppInput, error) {
 	cmd.StringVar(&input.ToAddress, "to", "", "Address to send money to")
 	cmd.IntVar(&input.NodePort, "nodeport", 0, "Node Server port")
 	cmd.StringVar(&input.NodeHost, "nodehost", "", "Node Server Host")
-	cmd.Float64Var(&input.Amount, "amount", 0, "Amount money to send")
+	// amounts are parsed from decimal format to smallest units
+	amountStr := cmd.String("amount", "", "Amount money to send")
+	feeStr := cmd.String("fee", "", "Fee for a miner")
 	cmd.StringVar(&input.LogDest, "logdest", "file", "Destination of logs. file or stdout")
+	cmd.BoolVar(&input.RequireEncryption, "requireencryption", false, "Connect to nodes only with encryption")
 
 	datadirPtr := cmd.String("datadir", "", "Location of data files, config")
 
@@ -37,6 +41,18 @@ func GetAppInput() (wallet.AppInput, error) {
 		log.Panic(err)
 	}
 
+	input.Amount, err = utils.ParseAmount(*amountStr)
+
+	if err != nil {
+		return input, err
+	}
+
+	input.Fee, err = utils.ParseAmount(*feeStr)
+
+	if err != nil {
+		return input, err
+	}
+
 	if *datadirPtr != "" {
 		input.DataDir = *datadirPtr
 		if input.DataDir[len(input.DataDir)-1:] != "/" {
@@ -78,6 +94,11 @@ func GetAppInput() (wallet.AppInput, error) {
 		if input.Address == "" && config.Address != "" {
 			input.Address = config.Address
 		}
+		input.Genesis = config.Genesis
+
+		if config.RequireEncryption {
+			input.RequireEncryption = true
+		}
 	}
 
 	return input, nil
@@ -110,7 +131,7 @@ func updateConfig(datadir string, c wallet.AppInput) error {
 	if errf == nil {
 		// we open a file only if it exists. in other case options can be set with command line
 		decoder := json.NewDecoder(file)
-		err := decoder.Decode(config)
+		err := decoder.Decode(&config)
 
 		file.Close()
 
@@ -122,6 +143,10 @@ func updateConfig(datadir string, c wallet.AppInput) error {
 	if c.Command == "setnode" {
 		config.NodeHost = c.NodeHost
 		config.NodePort = c.NodePort
+
+		if c.RequireEncryption {
+			config.RequireEncryption = true
+		}
 	}
 
 	// convert back to JSON and save to config file
@@ -153,6 +178,6 @@ func printUsage() {
 	fmt.Println("  getbalance -address ADDRESS\n\t- Get balance of ADDRESS")
 	fmt.Println("  listaddresses\n\t- Lists all addresses from the wallet file")
 	fmt.Println("  listbalances\n\t- Lists all addresses from the wallet file and show balance for each")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT\n\t- Send AMOUNT of coins from FROM address to TO. ")
-	fmt.Println("  setnode -nodehost HOST -nodeport PORT\n\t- Saves a node host and port to configfile. ")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT [-fee FEE]\n\t- Send AMOUNT of coins from FROM address to TO. FEE goes to a miner")
+	fmt.Println("  setnode -nodehost HOST -nodeport PORT [-requireencryption]\n\t- Saves a node host and port to configfile. -requireencryption allows only encrypted connections to nodes")
 }
@@ -39,11 +39,16 @@ func main() {
 	}
 
 	walletscli := wallet.WalletCLI{}
-	walletscli.Init(logger, input)
+	err := walletscli.Init(logger, input)
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
 
 	walletscli.NodeMode = false
 
-	err := walletscli.ExecuteCommand()
+	err = walletscli.ExecuteCommand()
 
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())